@@ -1,14 +1,18 @@
 package auth
 
 import (
+	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 )
 
 var (
 	// apiKeys stores the valid API keys
 	apiKeys map[string]bool
+	// allowQueryKey enables the api_key query parameter as a last-resort key source
+	allowQueryKey bool
 )
 
 // InitAPIKeys initializes the API keys from environment variables
@@ -23,12 +27,34 @@ func InitAPIKeys() {
 			apiKeys[strings.TrimSpace(key)] = true
 		}
 	}
+
+	allowQueryKey, _ = strconv.ParseBool(strings.TrimSpace(os.Getenv("ALLOW_QUERY_API_KEY")))
+}
+
+// RequestAPIKey returns the API key supplied with a request. Sources are checked in order
+// of precedence: the X-API-Key header, an Authorization: Bearer token, then (when
+// ALLOW_QUERY_API_KEY is set) the api_key query parameter. Only the first source present
+// is used.
+func RequestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if allowQueryKey {
+		if key := r.URL.Query().Get("api_key"); key != "" {
+			log.Printf("Warning: API key for %s supplied in the api_key query parameter; prefer the X-API-Key or Authorization header", r.URL.Path)
+			return key
+		}
+	}
+	return ""
 }
 
 // RequireAPIKey is a middleware that checks for a valid API key
 func RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		key := r.Header.Get("X-API-Key")
+		key := RequestAPIKey(r)
 		if key == "" {
 			http.Error(w, "API key is missing", http.StatusUnauthorized)
 			return
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeatureFlags holds deployment-wide processing defaults read from the environment.
+// Per-request form fields, when provided, override these defaults.
+type FeatureFlags struct {
+	// EnableStats adds per-field fill counts to the processing summary (ENABLE_STATS)
+	EnableStats bool
+	// DefaultOutputFormat is used when a request does not choose one (DEFAULT_OUTPUT_FORMAT)
+	DefaultOutputFormat string
+	// AllowedOutputFormats are the only output formats requests may choose, with "excel"
+	// listed as "xlsx"; empty allows every format (ALLOWED_OUTPUT_FORMATS)
+	AllowedOutputFormats []string
+	// TrimCells trims surrounding whitespace from mapped cell values (TRIM_CELLS)
+	TrimCells bool
+	// MaxMappingFields caps the number of mappings and output columns per request (MAX_MAPPING_FIELDS)
+	MaxMappingFields int
+	// ProcessingTimeout bounds how long a single file may take to process; zero means no
+	// limit (PROCESSING_TIMEOUT)
+	ProcessingTimeout time.Duration
+	// RequestTimeout bounds how long any request may take before a 503 is returned; zero
+	// means no limit (REQUEST_TIMEOUT)
+	RequestTimeout time.Duration
+	// MaxInputBytes caps the bytes read from an input after decompression, so small but
+	// explosive uploads cannot exhaust memory (MAX_INPUT_BYTES)
+	MaxInputBytes int64
+	// MaxUploadFilenameLength caps the length of the client's filename kept in the stored
+	// upload's name, extension included (MAX_UPLOAD_FILENAME_LENGTH)
+	MaxUploadFilenameLength int
+	// AccentInsensitiveHeaders ignores accents when matching headers, so "Número" matches
+	// "Numero"; on unless disabled (ACCENT_INSENSITIVE_HEADERS)
+	AccentInsensitiveHeaders bool
+	// RowWorkers is the number of goroutines mapping the rows of one file; 1 maps them
+	// sequentially (ROW_WORKERS)
+	RowWorkers int
+	// StreamFlushRecords is the number of streamed NDJSON records or Server-Sent Events
+	// written between flushes of the response; zero keeps the default of flushing every
+	// event and leaving NDJSON to the response buffer (STREAM_FLUSH_RECORDS)
+	StreamFlushRecords int
+	// ResultCacheTTL is how long /api/v1/process reuses the output of an identical upload
+	// with the same mappings and options; zero disables the cache (RESULT_CACHE_TTL)
+	ResultCacheTTL time.Duration
+	// PostProcessCommand is an external command run on the primary output file before it
+	// is returned, with {output} replaced by the file's path; the missing data output and
+	// reports are not passed to it. Empty disables it (POST_PROCESS_COMMAND). It runs
+	// arbitrary code, so it is never on by default.
+	PostProcessCommand string
+	// PostProcessTimeout bounds each run of PostProcessCommand (POST_PROCESS_TIMEOUT)
+	PostProcessTimeout time.Duration
+	// PostProcessMaxBytes caps the size of an output after PostProcessCommand has run
+	// (POST_PROCESS_MAX_BYTES)
+	PostProcessMaxBytes int64
+	// ReadRetries is how many times a read of an input failing with a transient error,
+	// such as a network timeout on a mounted upload directory, is retried (READ_RETRIES)
+	ReadRetries int
+	// ReadRetryBackoff is the wait before the first retry, doubled for each further one
+	// (READ_RETRY_BACKOFF)
+	ReadRetryBackoff time.Duration
+	// PreviewMaxRows caps the data rows one /api/v1/preview call returns (PREVIEW_MAX_ROWS)
+	PreviewMaxRows int
+	// MarkdownMaxRows caps the rows of each markdown table; the rest are left out with a
+	// note (MARKDOWN_MAX_ROWS)
+	MarkdownMaxRows int
+	// OutputPassword encrypts every processed and missing output unless a request sends
+	// its own (OUTPUT_PASSWORD). It is a secret and must never be logged.
+	OutputPassword string
+}
+
+// DefaultMaxMappingFields is used when MAX_MAPPING_FIELDS is not set
+const DefaultMaxMappingFields = 200
+
+// DefaultMaxInputBytes is used when MAX_INPUT_BYTES is not set
+const DefaultMaxInputBytes = 512 << 20
+
+// DefaultPostProcessTimeout is used when POST_PROCESS_TIMEOUT is not set
+const DefaultPostProcessTimeout = 30 * time.Second
+
+// DefaultPostProcessMaxBytes is used when POST_PROCESS_MAX_BYTES is not set
+const DefaultPostProcessMaxBytes = 512 << 20
+
+// DefaultReadRetries and DefaultReadRetryBackoff are used when READ_RETRIES and
+// READ_RETRY_BACKOFF are not set
+const (
+	DefaultReadRetries      = 2
+	DefaultReadRetryBackoff = 100 * time.Millisecond
+)
+
+// DefaultPreviewMaxRows is used when PREVIEW_MAX_ROWS is not set
+const DefaultPreviewMaxRows = 100
+
+// DefaultMarkdownMaxRows is used when MARKDOWN_MAX_ROWS is not set
+const DefaultMarkdownMaxRows = 10000
+
+// MaxReadRetries caps READ_RETRIES
+const MaxReadRetries = 10
+
+// MaxRowWorkers caps ROW_WORKERS and the rowWorkers form field
+const MaxRowWorkers = 64
+
+// DefaultMaxUploadFilenameLength is used when MAX_UPLOAD_FILENAME_LENGTH is not set
+const DefaultMaxUploadFilenameLength = 100
+
+// validOutputFormats lists the values accepted for DEFAULT_OUTPUT_FORMAT
+var validOutputFormats = []string{"xlsx", "excel", "csv", "markdown", "ndjson", "sql"}
+
+// LoadFeatureFlags reads the feature flags from the environment
+func LoadFeatureFlags() (FeatureFlags, error) {
+	var flags FeatureFlags
+	var err error
+
+	if flags.EnableStats, err = envBool("ENABLE_STATS", false); err != nil {
+		return flags, err
+	}
+	if flags.TrimCells, err = envBool("TRIM_CELLS", false); err != nil {
+		return flags, err
+	}
+	if flags.AccentInsensitiveHeaders, err = envBool("ACCENT_INSENSITIVE_HEADERS", true); err != nil {
+		return flags, err
+	}
+
+	flags.MaxMappingFields = DefaultMaxMappingFields
+	if value := strings.TrimSpace(os.Getenv("MAX_MAPPING_FIELDS")); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 1 {
+			return flags, fmt.Errorf("invalid MAX_MAPPING_FIELDS value %q: must be a positive integer", value)
+		}
+		flags.MaxMappingFields = max
+	}
+
+	flags.MaxInputBytes = DefaultMaxInputBytes
+	if value := strings.TrimSpace(os.Getenv("MAX_INPUT_BYTES")); value != "" {
+		max, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || max < 1 {
+			return flags, fmt.Errorf("invalid MAX_INPUT_BYTES value %q: must be a positive number of bytes", value)
+		}
+		flags.MaxInputBytes = max
+	}
+
+	flags.MaxUploadFilenameLength = DefaultMaxUploadFilenameLength
+	if value := strings.TrimSpace(os.Getenv("MAX_UPLOAD_FILENAME_LENGTH")); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 1 {
+			return flags, fmt.Errorf("invalid MAX_UPLOAD_FILENAME_LENGTH value %q: must be a positive integer", value)
+		}
+		flags.MaxUploadFilenameLength = max
+	}
+
+	if value := strings.TrimSpace(os.Getenv("STREAM_FLUSH_RECORDS")); value != "" {
+		records, err := strconv.Atoi(value)
+		if err != nil || records < 0 {
+			return flags, fmt.Errorf("invalid STREAM_FLUSH_RECORDS value %q: must be a non-negative integer", value)
+		}
+		flags.StreamFlushRecords = records
+	}
+
+	flags.RowWorkers = 1
+	if value := strings.TrimSpace(os.Getenv("ROW_WORKERS")); value != "" {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers < 1 || workers > MaxRowWorkers {
+			return flags, fmt.Errorf("invalid ROW_WORKERS value %q: must be an integer from 1 to %d", value, MaxRowWorkers)
+		}
+		flags.RowWorkers = workers
+	}
+
+	if value := strings.TrimSpace(os.Getenv("PROCESSING_TIMEOUT")); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return flags, fmt.Errorf("invalid PROCESSING_TIMEOUT value %q: must be a positive duration such as 30s", value)
+		}
+		flags.ProcessingTimeout = timeout
+	}
+
+	if value := strings.TrimSpace(os.Getenv("REQUEST_TIMEOUT")); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return flags, fmt.Errorf("invalid REQUEST_TIMEOUT value %q: must be a positive duration such as 60s", value)
+		}
+		flags.RequestTimeout = timeout
+	}
+
+	if value := strings.TrimSpace(os.Getenv("RESULT_CACHE_TTL")); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return flags, fmt.Errorf("invalid RESULT_CACHE_TTL value %q: must be a duration such as 10m", value)
+		}
+		flags.ResultCacheTTL = ttl
+	}
+
+	flags.ReadRetries = DefaultReadRetries
+	if value := strings.TrimSpace(os.Getenv("READ_RETRIES")); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 || retries > MaxReadRetries {
+			return flags, fmt.Errorf("invalid READ_RETRIES value %q: must be an integer from 0 to %d", value, MaxReadRetries)
+		}
+		flags.ReadRetries = retries
+	}
+	flags.ReadRetryBackoff = DefaultReadRetryBackoff
+	if value := strings.TrimSpace(os.Getenv("READ_RETRY_BACKOFF")); value != "" {
+		backoff, err := time.ParseDuration(value)
+		if err != nil || backoff <= 0 {
+			return flags, fmt.Errorf("invalid READ_RETRY_BACKOFF value %q: must be a positive duration such as 100ms", value)
+		}
+		flags.ReadRetryBackoff = backoff
+	}
+
+	flags.PreviewMaxRows = DefaultPreviewMaxRows
+	if value := strings.TrimSpace(os.Getenv("PREVIEW_MAX_ROWS")); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 1 {
+			return flags, fmt.Errorf("invalid PREVIEW_MAX_ROWS value %q: must be a positive integer", value)
+		}
+		flags.PreviewMaxRows = max
+	}
+
+	flags.MarkdownMaxRows = DefaultMarkdownMaxRows
+	if value := strings.TrimSpace(os.Getenv("MARKDOWN_MAX_ROWS")); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 1 {
+			return flags, fmt.Errorf("invalid MARKDOWN_MAX_ROWS value %q: must be a positive integer", value)
+		}
+		flags.MarkdownMaxRows = max
+	}
+
+	flags.OutputPassword = os.Getenv("OUTPUT_PASSWORD")
+
+	flags.PostProcessCommand = strings.TrimSpace(os.Getenv("POST_PROCESS_COMMAND"))
+	flags.PostProcessTimeout = DefaultPostProcessTimeout
+	if value := strings.TrimSpace(os.Getenv("POST_PROCESS_TIMEOUT")); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return flags, fmt.Errorf("invalid POST_PROCESS_TIMEOUT value %q: must be a positive duration such as 30s", value)
+		}
+		flags.PostProcessTimeout = timeout
+	}
+	flags.PostProcessMaxBytes = DefaultPostProcessMaxBytes
+	if value := strings.TrimSpace(os.Getenv("POST_PROCESS_MAX_BYTES")); value != "" {
+		max, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || max < 1 {
+			return flags, fmt.Errorf("invalid POST_PROCESS_MAX_BYTES value %q: must be a positive number of bytes", value)
+		}
+		flags.PostProcessMaxBytes = max
+	}
+
+	flags.DefaultOutputFormat = strings.ToLower(strings.TrimSpace(os.Getenv("DEFAULT_OUTPUT_FORMAT")))
+	if flags.DefaultOutputFormat != "" && !isValidOutputFormat(flags.DefaultOutputFormat) {
+		return flags, fmt.Errorf("invalid DEFAULT_OUTPUT_FORMAT %q: must be one of %s",
+			flags.DefaultOutputFormat, strings.Join(validOutputFormats, ", "))
+	}
+
+	for _, format := range strings.Split(os.Getenv("ALLOWED_OUTPUT_FORMATS"), ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+		if !isValidOutputFormat(format) {
+			return flags, fmt.Errorf("invalid ALLOWED_OUTPUT_FORMATS entry %q: must be one of %s",
+				format, strings.Join(validOutputFormats, ", "))
+		}
+		if format == "excel" {
+			format = "xlsx"
+		}
+		if !slices.Contains(flags.AllowedOutputFormats, format) {
+			flags.AllowedOutputFormats = append(flags.AllowedOutputFormats, format)
+		}
+	}
+	// Requests without an outputFormat must still get an allowed one
+	defaultFormat := flags.DefaultOutputFormat
+	if defaultFormat == "" || defaultFormat == "excel" {
+		defaultFormat = "xlsx"
+	}
+	if len(flags.AllowedOutputFormats) > 0 && !slices.Contains(flags.AllowedOutputFormats, defaultFormat) {
+		return flags, fmt.Errorf("DEFAULT_OUTPUT_FORMAT %q is not in ALLOWED_OUTPUT_FORMATS (%s)",
+			defaultFormat, strings.Join(flags.AllowedOutputFormats, ", "))
+	}
+	return flags, nil
+}
+
+func isValidOutputFormat(format string) bool {
+	for _, valid := range validOutputFormats {
+		if format == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// envBool parses an optional boolean environment variable, returning defaultValue when it is absent
+func envBool(name string, defaultValue bool) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: must be true or false", name, value)
+	}
+	return parsed, nil
+}
@@ -1,19 +1,256 @@
 package config
 
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
 type FieldConfig struct {
-	Fields          []Field  `json:"fields"`
-	MandatoryFields []string `json:"mandatoryFields"`
+	Fields []Field `json:"fields"`
+	// MandatoryFields lists fields, by Name or DisplayName, that are mandatory in addition
+	// to those with IsMandatory set; see IsFieldMandatory
+	MandatoryFields []string `json:"mandatoryFields,omitempty"`
+	// Rules are conditional validations applied to every mapped row
+	Rules []Rule `json:"rules,omitempty"`
 }
 
 type Field struct {
 	Name        string `json:"name"`
 	DisplayName string `json:"displayName"`
 	IsMandatory bool   `json:"isMandatory"`
+	// RequiredIf makes an optional field mandatory in the rows where its condition holds,
+	// e.g. Tax_ID only when Country is US
+	RequiredIf *RuleCondition `json:"requiredIf,omitempty"`
+	// OutputNumberFormat is an optional printf-style verb such as "%.2f" or "%d"
+	// applied to numeric values of this field when writing output
+	OutputNumberFormat string `json:"outputNumberFormat,omitempty"`
+	// CollapseWhitespace trims the value and replaces internal runs of whitespace,
+	// including tabs and non-breaking spaces, with a single space
+	CollapseWhitespace bool `json:"collapseWhitespace,omitempty"`
+	// Lookup, when set, populates the field from a reference table instead of a mapping
+	Lookup *Lookup `json:"lookup,omitempty"`
+	// Type optionally declares the kind of value the field holds; "date" converts Excel
+	// date serial numbers to dates and "number" writes numeric values unquoted in SQL output
+	Type string `json:"type,omitempty"`
+	// Min and Max bound the values of a number field, inclusively unless ExclusiveMin or
+	// ExclusiveMax is set. A value outside them fails validation with RangeSeverity,
+	// SeverityReject by default.
+	Min           *float64 `json:"min,omitempty"`
+	Max           *float64 `json:"max,omitempty"`
+	ExclusiveMin  bool     `json:"exclusiveMin,omitempty"`
+	ExclusiveMax  bool     `json:"exclusiveMax,omitempty"`
+	RangeSeverity string   `json:"rangeSeverity,omitempty"`
+	// AllowedValues, when set, are the only values the field may hold, compared after
+	// trimming and, with AllowedValuesIgnoreCase, ignoring case. Any other present value
+	// fails validation with AllowedValuesSeverity, SeverityReject by default.
+	AllowedValues           []string `json:"allowedValues,omitempty"`
+	AllowedValuesIgnoreCase bool     `json:"allowedValuesIgnoreCase,omitempty"`
+	AllowedValuesSeverity   string   `json:"allowedValuesSeverity,omitempty"`
+	// PhoneFormat, when set, normalizes the field's phone numbers, such as "(415) 555-2671",
+	// to one format, such as "+14155552671". Values that are not phone numbers are kept and
+	// fail validation with the PhoneFormat's Severity.
+	PhoneFormat *PhoneFormat `json:"phoneFormat,omitempty"`
+	// Mask, when set, hides most of the field's value in the outputs. It is applied as the
+	// value is written, so validation, deduplication and grouping see the real value.
+	Mask *Mask `json:"mask,omitempty"`
+	// DateFormat is the Go time layout used to write converted dates (default 2006-01-02)
+	DateFormat string `json:"dateFormat,omitempty"`
+	// MarkdownAlign sets the field's column alignment in markdown output: left, center or right
+	MarkdownAlign string `json:"markdownAlign,omitempty"`
+	// Transforms are named transforms (trim, upper, lower, collapseWhitespace) applied in
+	// order to every value of the field; requests may add to or replace them
+	Transforms []string `json:"transforms,omitempty"`
+	// StripEnclosing lists pairs of opening and closing characters, such as `""` or "[]",
+	// removed from values that both start and end with them
+	StripEnclosing []string `json:"stripEnclosing,omitempty"`
+	// Replace lists regular expression replacements applied in order to every value of the
+	// field, after StripEnclosing
+	Replace []Replacement `json:"replace,omitempty"`
+	// Order optionally positions the field in the output; see OrderedFieldList
+	Order *int `json:"order,omitempty"`
+	// MissingMarker replaces DefaultMissingMarker in the missing data output when the
+	// field has no value; MissingReason, when set, is written after it
+	MissingMarker string `json:"missingMarker,omitempty"`
+	MissingReason string `json:"missingReason,omitempty"`
+	// Aliases are other headers the field's column goes by, such as "Cust ID" for
+	// Customer_ID. A header matching one is mapped to the field when no mapping is given.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// DefaultMissingMarker marks a field without a value in the missing data output
+const DefaultMissingMarker = "MISSING"
+
+// MissingValue returns what the missing data output shows for the field when it has no
+// value: its MissingMarker, or DefaultMissingMarker, followed by ": " and its MissingReason
+func (f Field) MissingValue() string {
+	marker := f.MissingMarker
+	if marker == "" {
+		marker = DefaultMissingMarker
+	}
+	if f.MissingReason != "" {
+		return marker + ": " + f.MissingReason
+	}
+	return marker
+}
+
+// RequiredIfMissingValue returns what the missing data output shows for the field when
+// its RequiredIf condition holds and it has no value: its MissingValue followed by the
+// condition, such as "MISSING (required when Country is US)"
+func (f Field) RequiredIfMissingValue() string {
+	return fmt.Sprintf("%s (required when %s)", f.MissingValue(), f.RequiredIf)
+}
+
+// Markdown column alignments
+const (
+	AlignLeft   = "left"
+	AlignCenter = "center"
+	AlignRight  = "right"
+)
+
+// IsValidAlignment reports whether alignment is a supported markdown column alignment
+func IsValidAlignment(alignment string) bool {
+	return alignment == AlignLeft || alignment == AlignCenter || alignment == AlignRight
+}
+
+// Field types
+const (
+	// FieldTypeDate marks a field holding dates
+	FieldTypeDate = "date"
+	// FieldTypeNumber marks a field holding numbers
+	FieldTypeNumber = "number"
+)
+
+// DefaultDateFormat is the layout of converted dates when a field sets no DateFormat
+const DefaultDateFormat = "2006-01-02"
+
+// maxExcelDateSerial is the serial number of 9999-12-31, the last date Excel supports
+const maxExcelDateSerial = 2958465
+
+// ConvertDateSerial converts an Excel date serial number such as "44927" in a date field
+// to a date formatted with the field's DateFormat. date1904 selects the workbook's 1904
+// date system. Other values, and fields that are not dates, are returned unchanged.
+func (f Field) ConvertDateSerial(value string, date1904 bool) string {
+	if f.Type != FieldTypeDate {
+		return value
+	}
+	serial, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || serial < 1 || serial > maxExcelDateSerial {
+		return value
+	}
+	date, err := excelize.ExcelDateToTime(serial, date1904)
+	if err != nil {
+		return value
+	}
+	layout := f.DateFormat
+	if layout == "" {
+		layout = DefaultDateFormat
+	}
+	return date.Format(layout)
+}
+
+// Transform applies the field's output transforms to value in order: the named
+// Transforms, enclosing character stripping, Replace replacements, whitespace collapsing,
+// phone number normalization, then number formatting.
+func (f Field) Transform(value string) string {
+	value = ApplyTransforms(value, f.Transforms)
+	value = f.stripEnclosing(value)
+	value = f.applyReplacements(value)
+	if f.CollapseWhitespace {
+		value = collapseWhitespace(value)
+	}
+	if f.PhoneFormat != nil {
+		value, _ = f.PhoneFormat.Normalize(value)
+	}
+	return f.FormatNumber(value)
+}
+
+// stripEnclosing removes each of the field's StripEnclosing pairs, in order, from value
+// when it starts with the pair's first character and ends with its second. A value that
+// is only the pair's characters becomes empty.
+func (f Field) stripEnclosing(value string) string {
+	for _, pair := range f.StripEnclosing {
+		characters := []rune(pair)
+		open, close := string(characters[0]), string(characters[1])
+		if len(value) >= len(open)+len(close) && strings.HasPrefix(value, open) && strings.HasSuffix(value, close) {
+			value = value[len(open) : len(value)-len(close)]
+		}
+	}
+	return value
+}
+
+// collapseWhitespace trims value and joins its words with single spaces. strings.Fields
+// splits on unicode.IsSpace, which covers tabs, newlines and U+00A0.
+func collapseWhitespace(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// FormatNumber applies the field's OutputNumberFormat to value. Values that are not
+// numeric, or fields without a format, are returned unchanged. Output always uses '.'
+// as the decimal separator.
+func (f Field) FormatNumber(value string) string {
+	if f.OutputNumberFormat == "" {
+		return value
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+		return value
+	}
+	if strings.HasSuffix(f.OutputNumberFormat, "d") {
+		return fmt.Sprintf(f.OutputNumberFormat, int64(math.Round(number)))
+	}
+	return fmt.Sprintf(f.OutputNumberFormat, number)
+}
+
+// validateNumberFormat checks that format is a single integer or floating-point verb
+func validateNumberFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	if strings.Count(format, "%") != 1 || !strings.ContainsAny(format[len(format)-1:], "defgEG") {
+		return fmt.Errorf("output number format %q must be a single numeric verb such as %%.2f or %%d", format)
+	}
+	var sample string
+	if strings.HasSuffix(format, "d") {
+		sample = fmt.Sprintf(format, int64(1))
+	} else {
+		sample = fmt.Sprintf(format, 1.0)
+	}
+	if strings.Contains(sample, "%!") {
+		return fmt.Errorf("invalid output number format %q", format)
+	}
+	return nil
+}
+
+// OrderedFieldList returns the fields in output order. Without any Order attributes this
+// is array order. Otherwise fields with an Order come first, sorted by it, followed by
+// the fields without one; ties keep their array order.
+func (fc *FieldConfig) OrderedFieldList() []Field {
+	fields := append([]Field(nil), fc.Fields...)
+	sort.SliceStable(fields, func(i, j int) bool {
+		a, b := fields[i].Order, fields[j].Order
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a < *b
+	})
+	return fields
 }
 
 func (fc *FieldConfig) GetOrderedFields() []string {
-	order := make([]string, len(fc.Fields))
-	for i, field := range fc.Fields {
+	fields := fc.OrderedFieldList()
+	order := make([]string, len(fields))
+	for i, field := range fields {
 		order[i] = field.Name
 	}
 	return order
@@ -27,12 +264,300 @@ func (fc *FieldConfig) GetDisplayNames() map[string]string {
 	return displayNames
 }
 
+// IsFieldMandatory reports whether field must have a value in every row: its IsMandatory
+// flag is set or MandatoryFields lists its Name or DisplayName. Either makes it mandatory;
+// neither can make a field the other marks mandatory optional.
+func (fc *FieldConfig) IsFieldMandatory(field Field) bool {
+	return field.IsMandatory || slices.Contains(fc.MandatoryFields, field.Name) || slices.Contains(fc.MandatoryFields, field.DisplayName)
+}
+
 func (fc *FieldConfig) GetMandatoryFields() []string {
 	var mandatory []string
-	for _, field := range fc.Fields {
-		if field.IsMandatory {
+	for _, field := range fc.OrderedFieldList() {
+		if fc.IsFieldMandatory(field) {
 			mandatory = append(mandatory, field.DisplayName)
 		}
 	}
 	return mandatory
 }
+
+// GetMandatoryFieldNames returns the Names of the mandatory fields in output order
+func (fc *FieldConfig) GetMandatoryFieldNames() []string {
+	var mandatory []string
+	for _, field := range fc.OrderedFieldList() {
+		if fc.IsFieldMandatory(field) {
+			mandatory = append(mandatory, field.Name)
+		}
+	}
+	return mandatory
+}
+
+// Clone returns a deep copy of the configuration so it can be mutated safely
+func (fc *FieldConfig) Clone() *FieldConfig {
+	clone := &FieldConfig{
+		Fields:          make([]Field, len(fc.Fields)),
+		MandatoryFields: append([]string(nil), fc.MandatoryFields...),
+		Rules:           append([]Rule(nil), fc.Rules...),
+	}
+	copy(clone.Fields, fc.Fields)
+	return clone
+}
+
+// Validate checks that every field has a unique, non-empty Name and a non-empty DisplayName.
+// Because mappings may be keyed by either, a DisplayName must not match another field's
+// Name or DisplayName.
+func (fc *FieldConfig) Validate() error {
+	names := make(map[string]bool)
+	displayNames := make(map[string]bool)
+	aliasFields := make(map[string]string)
+	for _, field := range fc.Fields {
+		if field.Name == "" {
+			return fmt.Errorf("field name must not be empty")
+		}
+		if field.DisplayName == "" {
+			return fmt.Errorf("field %q must have a display name", field.Name)
+		}
+		if names[field.Name] {
+			return fmt.Errorf("duplicate field name %q", field.Name)
+		}
+		if err := validateNumberFormat(field.OutputNumberFormat); err != nil {
+			return fmt.Errorf("field %q: %v", field.Name, err)
+		}
+		if field.Type != "" && field.Type != FieldTypeDate && field.Type != FieldTypeNumber {
+			return fmt.Errorf("field %q has unknown type %q", field.Name, field.Type)
+		}
+		if field.DateFormat != "" && field.Type != FieldTypeDate {
+			return fmt.Errorf("field %q sets a date format but is not a date field", field.Name)
+		}
+		if err := field.validateRange(); err != nil {
+			return fmt.Errorf("field %q: %v", field.Name, err)
+		}
+		if err := field.validateAllowedValues(); err != nil {
+			return fmt.Errorf("field %q: %v", field.Name, err)
+		}
+		if field.PhoneFormat != nil {
+			if err := field.PhoneFormat.validate(); err != nil {
+				return fmt.Errorf("field %q: %v", field.Name, err)
+			}
+		}
+		if field.Mask != nil {
+			if err := field.Mask.validate(); err != nil {
+				return fmt.Errorf("field %q: %v", field.Name, err)
+			}
+		}
+		if err := ValidateTransforms(field.Transforms); err != nil {
+			return fmt.Errorf("field %q: %v", field.Name, err)
+		}
+		for _, pair := range field.StripEnclosing {
+			if utf8.RuneCountInString(pair) != 2 {
+				return fmt.Errorf("field %q: stripEnclosing entry %q must be an opening and a closing character", field.Name, pair)
+			}
+		}
+		if err := field.validateReplacements(); err != nil {
+			return fmt.Errorf("field %q: %v", field.Name, err)
+		}
+		if strings.ContainsAny(field.MissingMarker+field.MissingReason, "\r\n") {
+			return fmt.Errorf("field %q: missingMarker and missingReason must be a single line", field.Name)
+		}
+		if field.MarkdownAlign != "" && !IsValidAlignment(field.MarkdownAlign) {
+			return fmt.Errorf("field %q has invalid markdown alignment %q: must be left, center or right", field.Name, field.MarkdownAlign)
+		}
+		// Aliases are matched by MappingKey, so two aliases with the same key would be ambiguous
+		for _, alias := range field.Aliases {
+			if strings.TrimSpace(alias) == "" {
+				return fmt.Errorf("field %q has an empty alias", field.Name)
+			}
+			key := MappingKey(alias)
+			if key == "" {
+				return fmt.Errorf("alias %q of field %q has no letters or digits to match a header by", alias, field.Name)
+			}
+			if other, ok := aliasFields[key]; ok && other != field.Name {
+				return fmt.Errorf("alias %q of field %q is also an alias of field %q", alias, field.Name, other)
+			}
+			aliasFields[key] = field.Name
+		}
+		if displayNames[field.DisplayName] {
+			return fmt.Errorf("duplicate display name %q", field.DisplayName)
+		}
+		names[field.Name] = true
+		displayNames[field.DisplayName] = true
+	}
+	for _, field := range fc.Fields {
+		if field.DisplayName != field.Name && names[field.DisplayName] {
+			return fmt.Errorf("display name %q of field %q is ambiguous with another field's name", field.DisplayName, field.Name)
+		}
+		if field.Lookup != nil {
+			if err := field.Lookup.validate(fc, field.Name); err != nil {
+				return err
+			}
+		}
+		if field.RequiredIf != nil {
+			if field.RequiredIf.Field == field.Name || fc.indexOf(field.RequiredIf.Field) == -1 {
+				return fmt.Errorf("field %q: requiredIf must refer to another configured field, got %q", field.Name, field.RequiredIf.Field)
+			}
+		}
+	}
+	for _, key := range fc.MandatoryFields {
+		if _, ok := fc.ResolveFieldName(key); !ok {
+			return fmt.Errorf("mandatoryFields entry %q is not a configured field name or display name", key)
+		}
+	}
+	return fc.validateRules()
+}
+
+// MappingKey reduces a header, alias or field name to lower-case letters and digits, so
+// that "Customer ID", "customer_id" and "CustomerID" compare equal
+func MappingKey(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// ResolveFieldName returns the Name of the field identified by key, which may be either
+// its Name or its DisplayName
+func (fc *FieldConfig) ResolveFieldName(key string) (string, bool) {
+	for _, field := range fc.Fields {
+		if field.Name == key {
+			return field.Name, true
+		}
+	}
+	for _, field := range fc.Fields {
+		if field.DisplayName == key {
+			return field.Name, true
+		}
+	}
+	return "", false
+}
+
+// NormalizeMappings rekeys mappings, whatever their values, by fc's field Names, accepting
+// DisplayNames as keys. A key given by Name takes precedence over the same field given by
+// DisplayName, and keys that match no field are kept unchanged.
+func NormalizeMappings[V any](fc *FieldConfig, mappings map[string]V) map[string]V {
+	normalized := make(map[string]V, len(mappings))
+	for key, value := range mappings {
+		if fc.indexOf(key) != -1 {
+			normalized[key] = value
+		}
+	}
+	for key, value := range mappings {
+		if fc.indexOf(key) != -1 {
+			continue
+		}
+		name, ok := fc.ResolveFieldName(key)
+		if !ok {
+			normalized[key] = value
+			continue
+		}
+		if _, exists := normalized[name]; !exists {
+			normalized[name] = value
+		}
+	}
+	return normalized
+}
+
+// indexOf returns the position of the named field, or -1 if it does not exist
+func (fc *FieldConfig) indexOf(name string) int {
+	for i, field := range fc.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddField appends a new field to the end of the field order
+func (fc *FieldConfig) AddField(field Field) error {
+	if fc.indexOf(field.Name) != -1 {
+		return fmt.Errorf("field %q already exists", field.Name)
+	}
+	fc.Fields = append(fc.Fields, field)
+	return fc.Validate()
+}
+
+// UpdateField replaces the named field in place, allowing it to be renamed. MandatoryFields
+// entries naming the field by its old Name or DisplayName are renamed with it.
+func (fc *FieldConfig) UpdateField(name string, field Field) error {
+	i := fc.indexOf(name)
+	if i == -1 {
+		return fmt.Errorf("field %q not found", name)
+	}
+	for j, key := range fc.MandatoryFields {
+		if key == fc.Fields[i].Name || key == fc.Fields[i].DisplayName {
+			fc.MandatoryFields[j] = field.Name
+		}
+	}
+	fc.Fields[i] = field
+	return fc.Validate()
+}
+
+// DeleteField removes the named field and any MandatoryFields entries naming it
+func (fc *FieldConfig) DeleteField(name string) error {
+	i := fc.indexOf(name)
+	if i == -1 {
+		return fmt.Errorf("field %q not found", name)
+	}
+	deleted := fc.Fields[i]
+	fc.MandatoryFields = slices.DeleteFunc(fc.MandatoryFields, func(key string) bool {
+		return key == deleted.Name || key == deleted.DisplayName
+	})
+	fc.Fields = append(fc.Fields[:i], fc.Fields[i+1:]...)
+	return fc.Validate()
+}
+
+// ReorderFields rearranges the fields to match the given list of names, which must
+// contain every configured field exactly once. Order attributes are cleared so that
+// the new array order takes effect.
+func (fc *FieldConfig) ReorderFields(names []string) error {
+	if len(names) != len(fc.Fields) {
+		return fmt.Errorf("reorder must list all %d fields, got %d", len(fc.Fields), len(names))
+	}
+	reordered := make([]Field, 0, len(names))
+	used := make(map[string]bool)
+	for _, name := range names {
+		i := fc.indexOf(name)
+		if i == -1 {
+			return fmt.Errorf("field %q not found", name)
+		}
+		if used[name] {
+			return fmt.Errorf("field %q listed more than once", name)
+		}
+		used[name] = true
+		field := fc.Fields[i]
+		field.Order = nil
+		reordered = append(reordered, field)
+	}
+	fc.Fields = reordered
+	return nil
+}
+
+// Save writes the configuration to path atomically by writing a temporary file
+// in the same directory and renaming it over the original
+func (fc *FieldConfig) Save(path string) error {
+	data, err := json.MarshalIndent(fc, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error encoding config: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary config file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("error writing temporary config file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("error closing temporary config file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error replacing config file: %w", err)
+	}
+	return nil
+}
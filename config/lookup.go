@@ -0,0 +1,99 @@
+package config
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Lookup populates a field by looking up the value of another field in a reference table,
+// e.g. a country code resolved to a country name
+type Lookup struct {
+	// SourceField is the Name of the field whose value is looked up
+	SourceField string `json:"sourceField"`
+	// Table is the path of the reference table. A .csv table has a header row followed by
+	// code,value rows; a .json table is an object mapping codes to values.
+	Table string `json:"table"`
+	// Default is written when the source value is not in the table
+	Default string `json:"default,omitempty"`
+	// FlagUnmatched treats an unmatched source value as missing data for this field
+	FlagUnmatched bool `json:"flagUnmatched,omitempty"`
+
+	values map[string]string
+}
+
+// Resolve looks up value in the loaded reference table. Codes are matched after trimming
+// surrounding whitespace. Unmatched values resolve to Default and report false.
+func (l *Lookup) Resolve(value string) (string, bool) {
+	if resolved, ok := l.values[strings.TrimSpace(value)]; ok {
+		return resolved, true
+	}
+	return l.Default, false
+}
+
+// validate checks that the lookup names an existing source field and a supported table
+func (l *Lookup) validate(fc *FieldConfig, fieldName string) error {
+	if l.SourceField == "" || l.SourceField == fieldName {
+		return fmt.Errorf("lookup of field %q needs a different source field", fieldName)
+	}
+	if i := fc.indexOf(l.SourceField); i == -1 {
+		return fmt.Errorf("lookup source field %q of field %q does not exist", l.SourceField, fieldName)
+	} else if fc.Fields[i].Lookup != nil {
+		return fmt.Errorf("lookup source field %q of field %q is itself a lookup", l.SourceField, fieldName)
+	}
+	switch strings.ToLower(filepath.Ext(l.Table)) {
+	case ".csv", ".json":
+		return nil
+	default:
+		return fmt.Errorf("lookup table %q of field %q must be a .csv or .json file", l.Table, fieldName)
+	}
+}
+
+// LoadLookupTables reads the reference table of every lookup field. Each loaded lookup is a
+// fresh copy, so configurations sharing Field values with this one are left untouched.
+func (fc *FieldConfig) LoadLookupTables() error {
+	for i, field := range fc.Fields {
+		if field.Lookup == nil {
+			continue
+		}
+		values, err := readLookupTable(field.Lookup.Table)
+		if err != nil {
+			return fmt.Errorf("field %q: %v", field.Name, err)
+		}
+		lookup := *field.Lookup
+		lookup.values = values
+		fc.Fields[i].Lookup = &lookup
+	}
+	return nil
+}
+
+// readLookupTable reads a reference table from a CSV or JSON file
+func readLookupTable(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading lookup table: %v", err)
+	}
+
+	values := make(map[string]string)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("error parsing lookup table %q: %v", path, err)
+		}
+		return values, nil
+	}
+
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.FieldsPerRecord = 2
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing lookup table %q: %v", path, err)
+	}
+	// The first record is the header row
+	for _, record := range records[min(1, len(records)):] {
+		values[strings.TrimSpace(record[0])] = record[1]
+	}
+	return values, nil
+}
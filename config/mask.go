@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaskChar replaces the hidden characters of a masked value when a Mask sets no Char
+const DefaultMaskChar = "*"
+
+// Mask hides most of a sensitive field's output value, such as an account number written
+// as "****1234", while keeping a few characters at either end recognisable
+type Mask struct {
+	// KeepFirst and KeepLast are the number of characters left visible at the start and end
+	KeepFirst int `json:"keepFirst,omitempty"`
+	KeepLast  int `json:"keepLast,omitempty"`
+	// Char replaces every hidden character; DefaultMaskChar when empty
+	Char string `json:"char,omitempty"`
+}
+
+// Apply masks value. Values no longer than the characters kept are masked entirely, so a
+// short value is never shown in full. Empty values stay empty.
+func (m Mask) Apply(value string) string {
+	char := m.Char
+	if char == "" {
+		char = DefaultMaskChar
+	}
+	runes := []rune(value)
+	if len(runes) <= m.KeepFirst+m.KeepLast {
+		return strings.Repeat(char, len(runes))
+	}
+	hidden := len(runes) - m.KeepFirst - m.KeepLast
+	return string(runes[:m.KeepFirst]) + strings.Repeat(char, hidden) + string(runes[len(runes)-m.KeepLast:])
+}
+
+// validate checks that the characters kept are not negative and Char is a single character
+func (m Mask) validate() error {
+	if m.KeepFirst < 0 || m.KeepLast < 0 {
+		return fmt.Errorf("mask keepFirst and keepLast must not be negative")
+	}
+	if m.Char != "" && utf8.RuneCountInString(m.Char) != 1 {
+		return fmt.Errorf("mask char %q must be a single character", m.Char)
+	}
+	return nil
+}
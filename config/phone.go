@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Phone number output formats
+const (
+	// PhoneFormatE164 writes numbers as "+" then the country code and national number,
+	// e.g. "+14155552671"
+	PhoneFormatE164 = "e164"
+	// PhoneFormatDigits writes the E.164 digits without the "+", e.g. "14155552671", as
+	// some SMS gateways expect
+	PhoneFormatDigits = "digits"
+)
+
+// PhoneFormat normalizes a field's phone numbers, written in any of the usual national
+// or international styles, to a single format
+type PhoneFormat struct {
+	// Region is the ISO 3166 code, such as "US" or "GB", of the country whose calling
+	// code numbers written without one belong to
+	Region string `json:"region"`
+	// Format is one of the PhoneFormat constants; PhoneFormatE164 when empty
+	Format string `json:"format,omitempty"`
+	// Severity is SeverityReject, the default, or SeverityWarn for values that are not
+	// phone numbers. They are kept as they are either way.
+	Severity string `json:"severity,omitempty"`
+}
+
+// TODO: replace phoneCallingCodes, phoneNumberLengths and phoneKeepsTrunkZero with
+// github.com/nyaruka/phonenumbers (Parse, then Format with E164) once the module can be
+// added to go.mod; these tables only cover the regions below.
+
+// phoneCallingCodes are the country calling codes of the supported regions
+var phoneCallingCodes = map[string]string{
+	"US": "1", "CA": "1", "GB": "44", "IE": "353", "FR": "33", "DE": "49", "ES": "34",
+	"IT": "39", "PT": "351", "NL": "31", "BE": "32", "LU": "352", "CH": "41", "AT": "43",
+	"DK": "45", "SE": "46", "NO": "47", "FI": "358", "PL": "48", "CZ": "420", "GR": "30",
+	"AU": "61", "NZ": "64", "JP": "81", "KR": "82", "CN": "86", "HK": "852", "SG": "65",
+	"IN": "91", "ZA": "27", "BR": "55", "MX": "52", "AR": "54",
+}
+
+// phoneNumberLengths are the shortest and longest national significant numbers, without
+// the calling code or trunk prefix, in use behind each supported calling code
+var phoneNumberLengths = map[string][2]int{
+	"1": {10, 10}, "44": {9, 10}, "353": {7, 9}, "33": {9, 9}, "49": {6, 13}, "34": {9, 9},
+	"39": {6, 11}, "351": {9, 9}, "31": {9, 9}, "32": {8, 9}, "352": {4, 11}, "41": {9, 9},
+	"43": {4, 13}, "45": {8, 8}, "46": {7, 10}, "47": {8, 8}, "358": {5, 12}, "48": {9, 9},
+	"420": {9, 9}, "30": {10, 10}, "61": {9, 9}, "64": {8, 10}, "81": {9, 10}, "82": {8, 10},
+	"86": {9, 11}, "852": {8, 8}, "65": {8, 8}, "91": {10, 10}, "27": {9, 9}, "55": {10, 11},
+	"52": {10, 10}, "54": {10, 11},
+}
+
+// phoneKeepsTrunkZero are the regions whose national numbers keep their leading 0 in
+// international form
+var phoneKeepsTrunkZero = map[string]bool{"IT": true}
+
+// E.164 numbers have at most 15 digits, country code included; the shortest in use have 7
+const (
+	minPhoneDigits = 7
+	maxPhoneDigits = 15
+)
+
+// phoneSeparators are the characters allowed between the digits of a phone number
+const phoneSeparators = " -.()/"
+
+// phonePatterns match a normalized number in each format
+var phonePatterns = map[string]*regexp.Regexp{
+	PhoneFormatE164:   regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`),
+	PhoneFormatDigits: regexp.MustCompile(`^[1-9][0-9]{6,14}$`),
+}
+
+// Normalize parses value as a phone number and writes it in the format. Numbers starting
+// with "+" or the "00" international prefix (or "011" in North America) carry their country
+// code; others are national numbers of the Region, whose trunk prefix is dropped. Spaces,
+// dashes, dots, slashes and parentheses between the digits are ignored. Numbers for a
+// supported calling code must have a national number of a length in use there, and North
+// American ones a valid area code and exchange. Values that are not phone numbers are
+// returned unchanged with ok false.
+func (p PhoneFormat) Normalize(value string) (string, bool) {
+	trimmed := strings.TrimSpace(value)
+	var digits strings.Builder
+	international := false
+	for i, r := range trimmed {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			international = true
+		case strings.ContainsRune(phoneSeparators, r):
+		default:
+			return value, false
+		}
+	}
+
+	number := digits.String()
+	code := phoneCallingCodes[p.Region]
+	if !international {
+		switch {
+		case strings.HasPrefix(number, "00"):
+			number, international = number[2:], true
+		case code == "1" && strings.HasPrefix(number, "011"):
+			number, international = number[3:], true
+		}
+	}
+	if !international {
+		national, ok := nationalPhoneNumber(number, p.Region)
+		if !ok {
+			return value, false
+		}
+		number = code + national
+	}
+	if len(number) < minPhoneDigits || len(number) > maxPhoneDigits || number[0] == '0' || !validPhoneNumber(number) {
+		return value, false
+	}
+	if p.Format == PhoneFormatDigits {
+		return number, true
+	}
+	return "+" + number, true
+}
+
+// nationalPhoneNumber returns number, a national number of region, without its trunk
+// prefix: a leading 0, or an optional leading 1 before the ten digits of a North American
+// number
+func nationalPhoneNumber(number, region string) (string, bool) {
+	if phoneCallingCodes[region] == "1" {
+		if len(number) == 11 && number[0] == '1' {
+			number = number[1:]
+		}
+		return number, number != ""
+	}
+	if !phoneKeepsTrunkZero[region] {
+		number = strings.TrimPrefix(number, "0")
+	}
+	return number, number != ""
+}
+
+// validPhoneNumber checks number, the calling code followed by the national number, against
+// the lengths in phoneNumberLengths. North American area codes and exchanges do not start
+// with 0 or 1. Numbers for other calling codes are only checked against the E.164 limits.
+func validPhoneNumber(number string) bool {
+	// Calling codes are prefix-free, so at most one of the 1 to 3 digit prefixes matches
+	for size := 1; size <= 3 && size < len(number); size++ {
+		lengths, ok := phoneNumberLengths[number[:size]]
+		if !ok {
+			continue
+		}
+		national := number[size:]
+		if len(national) < lengths[0] || len(national) > lengths[1] {
+			return false
+		}
+		return number[:size] != "1" || (national[0] >= '2' && national[3] >= '2')
+	}
+	return true
+}
+
+// HasPhoneFormat reports whether the field normalizes phone numbers
+func (f Field) HasPhoneFormat() bool {
+	return f.PhoneFormat != nil
+}
+
+// PhoneWarns reports whether a value that is not a phone number is only a warning
+func (f Field) PhoneWarns() bool {
+	return f.PhoneFormat.Severity == SeverityWarn
+}
+
+// CheckPhone checks that value, already normalized by Transform, is a phone number in the
+// field's format and returns a message such as "Phone must be a valid phone number", or ""
+// when it is. Empty values are not checked.
+func (f Field) CheckPhone(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" || phonePatterns[f.PhoneFormat.format()].MatchString(value) {
+		return ""
+	}
+	return fmt.Sprintf("%s must be a valid phone number", f.Name)
+}
+
+// format returns the output format, defaulting to PhoneFormatE164
+func (p PhoneFormat) format() string {
+	if p.Format == "" {
+		return PhoneFormatE164
+	}
+	return p.Format
+}
+
+// validate checks the region is supported and the format and severity are known
+func (p PhoneFormat) validate() error {
+	if _, ok := phoneCallingCodes[p.Region]; !ok {
+		regions := make([]string, 0, len(phoneCallingCodes))
+		for region := range phoneCallingCodes {
+			regions = append(regions, region)
+		}
+		sort.Strings(regions)
+		return fmt.Errorf("phoneFormat region %q is not supported: must be one of %s", p.Region, strings.Join(regions, ", "))
+	}
+	if !slices.Contains([]string{"", PhoneFormatE164, PhoneFormatDigits}, p.Format) {
+		return fmt.Errorf("invalid phoneFormat format %q: must be e164 or digits", p.Format)
+	}
+	if p.Severity != "" && p.Severity != SeverityReject && p.Severity != SeverityWarn {
+		return fmt.Errorf("invalid phoneFormat severity %q: must be reject or warn", p.Severity)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rule is a conditional cross-field validation evaluated on each mapped row, e.g.
+// "when Country is US then Postal_Code matches ^\d{5}$"
+type Rule struct {
+	Name string        `json:"name"`
+	When RuleCondition `json:"when"`
+	Then RuleCheck     `json:"then"`
+	// Severity is SeverityReject (the default) or SeverityWarn
+	Severity string `json:"severity,omitempty"`
+}
+
+// Rule severities. A row failing a reject rule goes to the missing data output; a row
+// failing only warn rules stays in the processed output with a recorded warning.
+const (
+	SeverityReject = "reject"
+	SeverityWarn   = "warn"
+)
+
+// Warns reports whether a failure of the rule is only a warning
+func (r Rule) Warns() bool {
+	return r.Severity == SeverityWarn
+}
+
+// RuleCondition selects the rows a rule applies to
+type RuleCondition struct {
+	Field  string `json:"field"`
+	Equals string `json:"equals"`
+}
+
+// Holds reports whether value, the condition field's value in a row, meets the condition.
+// The value is compared after trimming surrounding whitespace.
+func (c RuleCondition) Holds(value string) bool {
+	return strings.TrimSpace(value) == c.Equals
+}
+
+// String describes the condition, such as "Country is US"
+func (c RuleCondition) String() string {
+	return fmt.Sprintf("%s is %s", c.Field, c.Equals)
+}
+
+// RuleCheck is the requirement a selected row must meet
+type RuleCheck struct {
+	Field   string `json:"field"`
+	Matches string `json:"matches"`
+
+	pattern *regexp.Regexp
+}
+
+// Passes reports whether the row, given as mapped values keyed by field Name, satisfies
+// the rule. Rows the rule does not apply to always pass. Values are compared after
+// trimming surrounding whitespace.
+func (r Rule) Passes(values map[string]string) bool {
+	if !r.When.Holds(values[r.When.Field]) {
+		return true
+	}
+	return r.Then.pattern.MatchString(strings.TrimSpace(values[r.Then.Field]))
+}
+
+// CompilePatterns compiles the pattern of every rule and field Replace entry so that rows
+// can be checked and transformed without recompiling them. It must be called before rules
+// are evaluated or values transformed.
+func (fc *FieldConfig) CompilePatterns() error {
+	if err := fc.compileReplacements(); err != nil {
+		return err
+	}
+	for i, rule := range fc.Rules {
+		pattern, err := regexp.Compile(rule.Then.Matches)
+		if err != nil {
+			return fmt.Errorf("rule %q: invalid pattern: %v", rule.Name, err)
+		}
+		fc.Rules[i].Then.pattern = pattern
+	}
+	return nil
+}
+
+// validateRules checks that rules are uniquely named, refer to configured fields and
+// have valid patterns and severities
+func (fc *FieldConfig) validateRules() error {
+	names := make(map[string]bool)
+	for _, rule := range fc.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule name must not be empty")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+		for _, field := range []string{rule.When.Field, rule.Then.Field} {
+			if fc.indexOf(field) == -1 {
+				return fmt.Errorf("rule %q refers to unknown field %q", rule.Name, field)
+			}
+		}
+		if _, err := regexp.Compile(rule.Then.Matches); err != nil {
+			return fmt.Errorf("rule %q: invalid pattern: %v", rule.Name, err)
+		}
+		if rule.Severity != "" && rule.Severity != SeverityReject && rule.Severity != SeverityWarn {
+			return fmt.Errorf("rule %q: invalid severity %q: must be reject or warn", rule.Name, rule.Severity)
+		}
+	}
+	return nil
+}
+
+// HasRange reports whether the field bounds its values with Min or Max
+func (f Field) HasRange() bool {
+	return f.Min != nil || f.Max != nil
+}
+
+// RangeWarns reports whether a value outside the field's range is only a warning
+func (f Field) RangeWarns() bool {
+	return f.RangeSeverity == SeverityWarn
+}
+
+// CheckRange checks value against the field's Min and Max and returns a message naming
+// the bound it breaks, such as "Age must be at most 120", or "" when it is within them.
+// Empty and non-numeric values are not checked.
+func (f Field) CheckRange(value string) string {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(number) {
+		return ""
+	}
+	if f.Min != nil && (number < *f.Min || f.ExclusiveMin && number == *f.Min) {
+		if f.ExclusiveMin {
+			return fmt.Sprintf("%s must be greater than %s", f.Name, formatBound(*f.Min))
+		}
+		return fmt.Sprintf("%s must be at least %s", f.Name, formatBound(*f.Min))
+	}
+	if f.Max != nil && (number > *f.Max || f.ExclusiveMax && number == *f.Max) {
+		if f.ExclusiveMax {
+			return fmt.Sprintf("%s must be less than %s", f.Name, formatBound(*f.Max))
+		}
+		return fmt.Sprintf("%s must be at most %s", f.Name, formatBound(*f.Max))
+	}
+	return ""
+}
+
+// formatBound writes a range bound as short as possible, e.g. "120" or "0.5"
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'f', -1, 64)
+}
+
+// validateRange checks that a range is only set on number fields, that its exclusivity
+// flags have a bound to apply to and that it is not empty
+func (f Field) validateRange() error {
+	if f.ExclusiveMin && f.Min == nil || f.ExclusiveMax && f.Max == nil {
+		return fmt.Errorf("exclusiveMin and exclusiveMax require min and max")
+	}
+	if f.RangeSeverity != "" && !f.HasRange() {
+		return fmt.Errorf("rangeSeverity requires min or max")
+	}
+	if !f.HasRange() {
+		return nil
+	}
+	if f.Type != FieldTypeNumber {
+		return fmt.Errorf("min and max only apply to number fields")
+	}
+	if f.Min != nil && f.Max != nil && (*f.Min > *f.Max || *f.Min == *f.Max && (f.ExclusiveMin || f.ExclusiveMax)) {
+		return fmt.Errorf("min %s and max %s leave no valid values", formatBound(*f.Min), formatBound(*f.Max))
+	}
+	if f.RangeSeverity != "" && f.RangeSeverity != SeverityReject && f.RangeSeverity != SeverityWarn {
+		return fmt.Errorf("invalid rangeSeverity %q: must be reject or warn", f.RangeSeverity)
+	}
+	return nil
+}
+
+// HasAllowedValues reports whether the field restricts its values to AllowedValues
+func (f Field) HasAllowedValues() bool {
+	return len(f.AllowedValues) > 0
+}
+
+// AllowedValuesWarn reports whether a value outside AllowedValues is only a warning
+func (f Field) AllowedValuesWarn() bool {
+	return f.AllowedValuesSeverity == SeverityWarn
+}
+
+// CheckAllowedValue checks value against the field's AllowedValues and returns a message
+// listing them, such as "Status must be one of Active, Inactive, Pending", or "" when it
+// is one of them. Empty values are not checked.
+func (f Field) CheckAllowedValue(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	for _, allowed := range f.AllowedValues {
+		if value == allowed || f.AllowedValuesIgnoreCase && strings.EqualFold(value, allowed) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s must be one of %s", f.Name, strings.Join(f.AllowedValues, ", "))
+}
+
+// validateAllowedValues checks that allowed values are non-empty, distinct as compared and
+// that the options qualifying them have values to apply to
+func (f Field) validateAllowedValues() error {
+	if !f.HasAllowedValues() {
+		if f.AllowedValuesIgnoreCase || f.AllowedValuesSeverity != "" {
+			return fmt.Errorf("allowedValuesIgnoreCase and allowedValuesSeverity require allowedValues")
+		}
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, allowed := range f.AllowedValues {
+		if strings.TrimSpace(allowed) != allowed || allowed == "" {
+			return fmt.Errorf("allowed value %q must not be empty or have surrounding whitespace", allowed)
+		}
+		key := allowed
+		if f.AllowedValuesIgnoreCase {
+			key = strings.ToLower(allowed)
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate allowed value %q", allowed)
+		}
+		seen[key] = true
+	}
+	if f.AllowedValuesSeverity != "" && f.AllowedValuesSeverity != SeverityReject && f.AllowedValuesSeverity != SeverityWarn {
+		return fmt.Errorf("invalid allowedValuesSeverity %q: must be reject or warn", f.AllowedValuesSeverity)
+	}
+	return nil
+}
+
+// HasValidations reports whether rows are validated beyond their mandatory fields, by
+// rules, field ranges, allowed values or phone formats
+func (fc *FieldConfig) HasValidations() bool {
+	if len(fc.Rules) > 0 {
+		return true
+	}
+	for _, field := range fc.Fields {
+		if field.HasRange() || field.HasAllowedValues() || field.HasPhoneFormat() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Named value transforms a field can apply by default and a request can add
+const (
+	TransformTrim               = "trim"
+	TransformUpper              = "upper"
+	TransformLower              = "lower"
+	TransformCollapseWhitespace = "collapseWhitespace"
+)
+
+// TransformNone at the start of a request's transforms drops the field's configured ones
+const TransformNone = "none"
+
+var valueTransforms = map[string]func(string) string{
+	TransformTrim:               strings.TrimSpace,
+	TransformUpper:              strings.ToUpper,
+	TransformLower:              strings.ToLower,
+	TransformCollapseWhitespace: collapseWhitespace,
+}
+
+// ValidateTransforms checks that every name is a known transform
+func ValidateTransforms(names []string) error {
+	for _, name := range names {
+		if _, ok := valueTransforms[name]; !ok {
+			return fmt.Errorf("unknown transform %q: must be trim, upper, lower or collapseWhitespace", name)
+		}
+	}
+	return nil
+}
+
+// ApplyTransforms applies the named transforms to value in order
+func ApplyTransforms(value string, names []string) string {
+	for _, name := range names {
+		if transform, ok := valueTransforms[name]; ok {
+			value = transform(value)
+		}
+	}
+	return value
+}
+
+// MergeTransforms returns the transforms a field applies for a request: its configured
+// defaults followed by the request's own. A request list starting with TransformNone
+// replaces the defaults instead.
+func MergeTransforms(defaults, requested []string) []string {
+	if len(requested) > 0 && requested[0] == TransformNone {
+		return append([]string(nil), requested[1:]...)
+	}
+	return append(append([]string(nil), defaults...), requested...)
+}
+
+// WithTransforms returns a copy of the configuration in which each field named in
+// transforms also applies the requested transforms, merged by MergeTransforms
+func (fc *FieldConfig) WithTransforms(transforms map[string][]string) *FieldConfig {
+	if len(transforms) == 0 {
+		return fc
+	}
+	merged := fc.Clone()
+	for i, field := range merged.Fields {
+		if requested, ok := transforms[field.Name]; ok {
+			merged.Fields[i].Transforms = MergeTransforms(field.Transforms, requested)
+		}
+	}
+	return merged
+}
+
+// Replacement replaces every match of a regular expression in a field's values, e.g.
+// Pattern "[^0-9]" with an empty Replacement strips everything but digits. Replacement
+// may refer to submatches as $1 or ${name}.
+type Replacement struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+
+	pattern *regexp.Regexp
+}
+
+// applyReplacements applies the field's Replace entries to value in order
+func (f Field) applyReplacements(value string) string {
+	for _, replacement := range f.Replace {
+		value = replacement.pattern.ReplaceAllString(value, replacement.Replacement)
+	}
+	return value
+}
+
+// compileReplacements compiles the pattern of every Replace entry of each field. The
+// compiled entries are written to new slices, as clones share them with the original.
+func (fc *FieldConfig) compileReplacements() error {
+	for i, field := range fc.Fields {
+		if len(field.Replace) == 0 {
+			continue
+		}
+		compiled := make([]Replacement, len(field.Replace))
+		for j, replacement := range field.Replace {
+			pattern, err := regexp.Compile(replacement.Pattern)
+			if err != nil {
+				return fmt.Errorf("field %q: invalid replace pattern %q: %v", field.Name, replacement.Pattern, err)
+			}
+			compiled[j] = Replacement{Pattern: replacement.Pattern, Replacement: replacement.Replacement, pattern: pattern}
+		}
+		fc.Fields[i].Replace = compiled
+	}
+	return nil
+}
+
+// validateReplacements checks that every Replace pattern is a non-empty, valid regular expression
+func (f Field) validateReplacements() error {
+	for _, replacement := range f.Replace {
+		if replacement.Pattern == "" {
+			return fmt.Errorf("replace pattern must not be empty")
+		}
+		if _, err := regexp.Compile(replacement.Pattern); err != nil {
+			return fmt.Errorf("invalid replace pattern %q: %v", replacement.Pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encrypted outputs are laid out as
+//
+//	magic (8 bytes) | salt (16 bytes) | nonce (12 bytes) | AES-256-GCM ciphertext and tag
+//
+// The key is derived from the password with PBKDF2-HMAC-SHA256 over the salt, and the
+// magic and salt are authenticated as additional data.
+const (
+	Magic      = "EXMAPv1\x00"
+	SaltSize   = 16
+	NonceSize  = 12
+	Iterations = 600000
+)
+
+// ErrDecrypt is returned when data is not an encrypted output or the password is wrong
+var ErrDecrypt = errors.New("cannot decrypt: not an encrypted output or wrong password")
+
+// Seal encrypts plaintext with a key derived from password under a fresh random salt and nonce
+func Seal(password string, plaintext []byte) ([]byte, error) {
+	header := make([]byte, len(Magic)+SaltSize+NonceSize)
+	copy(header, Magic)
+	if _, err := rand.Read(header[len(Magic):]); err != nil {
+		return nil, fmt.Errorf("error generating salt and nonce: %w", err)
+	}
+	salt := header[len(Magic) : len(Magic)+SaltSize]
+	nonce := header[len(Magic)+SaltSize:]
+
+	aead, err := newAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plaintext, header[:len(Magic)+SaltSize]), nil
+}
+
+// Open decrypts data produced by Seal with the same password
+func Open(password string, data []byte) ([]byte, error) {
+	if len(data) < len(Magic)+SaltSize+NonceSize || !bytes.HasPrefix(data, []byte(Magic)) {
+		return nil, ErrDecrypt
+	}
+	salt := data[len(Magic) : len(Magic)+SaltSize]
+	nonce := data[len(Magic)+SaltSize : len(Magic)+SaltSize+NonceSize]
+
+	aead, err := newAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, data[len(Magic)+SaltSize+NonceSize:], data[:len(Magic)+SaltSize])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// newAEAD returns AES-256-GCM keyed by the password and salt
+func newAEAD(password string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey([]byte(password), salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives the 32-byte AES-256 key from the password and salt with
+// PBKDF2-HMAC-SHA256 and Iterations rounds
+func deriveKey(password, salt []byte) []byte {
+	return pbkdf2.Key(password, salt, Iterations, 32, sha256.New)
+}
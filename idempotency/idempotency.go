@@ -0,0 +1,134 @@
+package idempotency
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInFlight is returned by Reserve when another request is still processing the key
+var ErrInFlight = errors.New("a request with this idempotency key is still being processed")
+
+// Result is the outcome of a processed request that can be replayed for retries
+type Result struct {
+	Summary     string
+	OutputPath  string
+	ContentType string
+	// SummaryPath is the summary sidecar file, if one was written
+	SummaryPath string
+	// DuplicatesPath is the dedupe report, if one was written
+	DuplicatesPath string
+	// ProvenancePath is the provenance record, if one was written
+	ProvenancePath string
+	// GroupsPath is the groups report, if one was written
+	GroupsPath string
+	// ManifestPath is the artifact manifest, if one was written
+	ManifestPath string
+	// InputPath is the retained upload, if the request asked to keep it
+	InputPath string
+	// Encrypted reports that the outputs were encrypted with a password
+	Encrypted bool
+	// StoredResponse is the JSON response listing outputs kept by a remote sink, replayed
+	// in place of the file at OutputPath
+	StoredResponse []byte
+}
+
+type entry struct {
+	result  Result
+	expires time.Time
+	// inFlight marks a key reserved by a request that has not stored its result yet
+	inFlight bool
+}
+
+// Cache stores results by idempotency key for a fixed time-to-live.
+// Keys are scoped so that different API keys cannot see each other's results.
+type Cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]entry
+	now     func() time.Time
+}
+
+// NewCache creates an empty cache whose entries expire after ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		entries: make(map[string]entry),
+		now:     time.Now,
+	}
+}
+
+func cacheKey(scope, key string) string {
+	return scope + "\x00" + key
+}
+
+// Get returns the cached result for key within scope if it has not expired
+func (c *Cache) Get(scope, key string) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[cacheKey(scope, key)]
+	if !ok || e.inFlight {
+		return Result{}, false
+	}
+	if c.now().After(e.expires) {
+		delete(c.entries, cacheKey(scope, key))
+		return Result{}, false
+	}
+	return e.result, true
+}
+
+// Set stores result for key within scope and drops any expired entries
+func (c *Cache) Set(scope, key string, result Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[cacheKey(scope, key)] = entry{result: result, expires: now.Add(c.ttl)}
+}
+
+// Reserve looks key up within scope for a request that is about to be processed, in one
+// step so that a concurrent request cannot store its result in between. It returns the
+// cached result and true when there is one that usable accepts, and ErrInFlight while
+// another request holds the key. Otherwise it marks the key in flight, replacing any
+// result usable rejected, and the caller stores its result with Set or gives the key up
+// with Release.
+func (c *Cache) Reserve(scope, key string, usable func(Result) bool) (Result, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if e, ok := c.entries[cacheKey(scope, key)]; ok && !now.After(e.expires) {
+		if e.inFlight {
+			return Result{}, false, ErrInFlight
+		}
+		if usable(e.result) {
+			return e.result, true, nil
+		}
+	}
+	c.entries[cacheKey(scope, key)] = entry{expires: now.Add(c.ttl), inFlight: true}
+	return Result{}, false, nil
+}
+
+// Release gives up a reservation made by Reserve. A result already stored with Set is kept.
+func (c *Cache) Release(scope, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[cacheKey(scope, key)]; ok && e.inFlight {
+		delete(c.entries, cacheKey(scope, key))
+	}
+}
+
+// Clear removes every entry
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
@@ -1,30 +1,79 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"import/auth"
 	"import/config"
+	"import/encryption"
+	"import/idempotency"
+	"import/storage"
 	"io"
 	"log"
+	"maps"
+	"math"
+	mathrand "math/rand/v2"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	_ "import/docs" // swagger docs
 
 	httpSwagger "github.com/swaggo/http-swagger"
 	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/unicode/norm"
 )
 
 var fieldConfig *config.FieldConfig
 
+// fieldConfigPath is the location the field configuration is loaded from and persisted to
+var fieldConfigPath = "config/field_config.json"
+
+// processResults caches /api/v1/process results by Idempotency-Key so that retried
+// requests return the original output instead of processing the file again
+var processResults = idempotency.NewCache(1 * time.Hour)
+
+// resultCache caches /api/v1/process results by the content of the upload and the
+// request's mappings and options, so an identical request reuses the earlier output. It is
+// nil unless RESULT_CACHE_TTL is set, and is cleared whenever the field configuration changes.
+var resultCache *idempotency.Cache
+
+// configMu guards fieldConfig. Mutations build a modified copy and swap the pointer
+// while holding the write lock, so readers can keep using a snapshot without locking.
+var configMu sync.RWMutex
+
+// outputDocTimestamp is written as the created and modified time of every
+// generated workbook so that identical inputs produce byte-identical output.
+var outputDocTimestamp = "2006-09-16T00:00:00Z"
+
 // @title           Field Mapping API
 // @version         1.0
 // @description     API for processing and mapping fields in CSV and XLSX files.
@@ -41,7 +90,7 @@ var fieldConfig *config.FieldConfig
 // @securityDefinitions.apikey ApiKeyAuth
 // @in header
 // @name X-API-Key
-// @description API key authentication required for all API endpoints
+// @description API key authentication required for all API endpoints. The key may instead be sent as "Authorization: Bearer <key>".
 
 // @accept multipart/form-data
 // @produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
@@ -49,24 +98,69 @@ var fieldConfig *config.FieldConfig
 // @produce text/markdown
 
 func InitConfig() error {
-	configFile, err := os.ReadFile("config/field_config.json")
+	configFile, err := os.ReadFile(fieldConfigPath)
 	if err != nil {
 		return fmt.Errorf("error reading config file: %v", err)
 	}
 
-	fieldConfig = &config.FieldConfig{}
-	if err := json.Unmarshal(configFile, fieldConfig); err != nil {
+	loaded := &config.FieldConfig{}
+	if err := json.Unmarshal(configFile, loaded); err != nil {
 		return fmt.Errorf("error parsing config file: %v", err)
 	}
+	if err := prepareFieldConfig(loaded); err != nil {
+		return fmt.Errorf("invalid config file: %v", err)
+	}
+
+	configMu.Lock()
+	fieldConfig = loaded
+	configMu.Unlock()
+	clearResultCache()
 	return nil
 }
 
+// prepareFieldConfig validates a complete configuration loaded from a file or an import,
+// loads its lookup tables and compiles its rule and replace patterns
+func prepareFieldConfig(fc *config.FieldConfig) error {
+	if err := fc.Validate(); err != nil {
+		return err
+	}
+	if err := fc.LoadLookupTables(); err != nil {
+		return err
+	}
+	return fc.CompilePatterns()
+}
+
+// currentFieldConfig returns the active field configuration snapshot
+func currentFieldConfig() *config.FieldConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return fieldConfig
+}
+
+// featureFlags holds the deployment-wide processing defaults loaded from the environment
+var featureFlags config.FeatureFlags
+
+// outputSink is where finished outputs are stored: local disk unless OUTPUT_SINK selects S3
+var outputSink storage.OutputSink = storage.LocalSink{}
+
 func init() {
 	// Call InitConfig in init, but handle the error appropriately for production
 	if err := InitConfig(); err != nil {
 		log.Fatalf("Failed to initialize configuration: %v", err)
 	}
 
+	// Load feature flags from the environment
+	var err error
+	if featureFlags, err = config.LoadFeatureFlags(); err != nil {
+		log.Fatalf("Failed to load feature flags: %v", err)
+	}
+	if outputSink, err = storage.NewSinkFromEnv(); err != nil {
+		log.Fatalf("Failed to configure output sink: %v", err)
+	}
+	if featureFlags.ResultCacheTTL > 0 {
+		resultCache = idempotency.NewCache(featureFlags.ResultCacheTTL)
+	}
+
 	// Initialize API keys
 	auth.InitAPIKeys()
 }
@@ -79,6 +173,46 @@ func generateUniqueID() string {
 	return fmt.Sprintf("%d_%s", timestamp, hex.EncodeToString(randomBytes))
 }
 
+// unsafeFilenameChars matches the characters replaced in stored upload names
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizeUploadFilename reduces a client-supplied filename to a safe name for storage.
+// Directory components (with either separator) are dropped, runs of characters other
+// than letters, digits, '.', '_' and '-' become '_', leading dots are removed and the
+// name is trimmed to MAX_UPLOAD_FILENAME_LENGTH. A supported input or zip extension is kept
+// intact so the stored file is still read as the same type.
+func sanitizeUploadFilename(filename string) string {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	extension := ""
+	for _, supported := range append([]string{zipUploadExtension}, supportedInputExtensions...) {
+		if strings.HasSuffix(filename, supported) && len(supported) > len(extension) {
+			extension = supported
+		}
+	}
+	stem := unsafeFilenameChars.ReplaceAllString(strings.TrimSuffix(filename, extension), "_")
+	stem = strings.TrimLeft(stem, ".")
+	if limit := featureFlags.MaxUploadFilenameLength - len(extension); limit >= 0 && len(stem) > limit {
+		stem = stem[:limit]
+	}
+	if stem == "" {
+		stem = "upload"
+	}
+	return stem + extension
+}
+
+// createUploadFile creates the file an upload is saved to in ./uploads, named by the
+// request's unique ID and the sanitized client filename. The file must not already
+// exist, so two requests can never write to the same path.
+func createUploadFile(uniqueID, filename string) (*os.File, string, error) {
+	tempDir := "./uploads"
+	os.MkdirAll(tempDir, os.ModePerm)
+	tempFilePath := filepath.Join(tempDir, fmt.Sprintf("%s_%s", uniqueID, sanitizeUploadFilename(filename)))
+	tempFile, err := os.OpenFile(tempFilePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	return tempFile, tempFilePath, err
+}
+
 // cleanupOldFiles removes files older than the specified duration from the uploads directory
 func cleanupOldFiles(maxAge time.Duration) {
 	uploadsDir := "./uploads"
@@ -150,10 +284,21 @@ func main() {
 	http.HandleFunc("/upload", handleUpload)
 	http.HandleFunc("/download", handleDownload)
 	http.HandleFunc("/config", getFieldConfig)
+	http.HandleFunc("/config/ui", handleAPIConfigUI)
 
 	// API routes with authentication
 	http.HandleFunc("/api/v1/config", auth.RequireAPIKey(handleAPIConfig))
+	http.HandleFunc("/api/v1/config/fields", auth.RequireAPIKey(handleAPIConfigFields))
+	http.HandleFunc("/api/v1/config/fields/reorder", auth.RequireAPIKey(handleAPIConfigFieldsReorder))
+	http.HandleFunc("/api/v1/config/ui", auth.RequireAPIKey(handleAPIConfigUI))
+	http.HandleFunc("/api/v1/config/export", auth.RequireAPIKey(handleAPIConfigExport))
+	http.HandleFunc("/api/v1/config/import", auth.RequireAPIKey(handleAPIConfigImport))
 	http.HandleFunc("/api/v1/process", auth.RequireAPIKey(handleAPIProcess))
+	http.HandleFunc("/api/v1/process-stream", auth.RequireAPIKey(handleAPIProcessStream))
+	http.HandleFunc("/api/v1/preview", auth.RequireAPIKey(handleAPIPreview))
+	http.HandleFunc("/api/v1/columns", auth.RequireAPIKey(handleAPIColumns))
+	http.HandleFunc("/api/v1/suggest-mappings", auth.RequireAPIKey(handleAPISuggestMappings))
+	http.HandleFunc("/api/v1/explain", auth.RequireAPIKey(handleAPIExplain))
 
 	// Serve swagger files
 	fs := http.FileServer(http.Dir("docs"))
@@ -168,11 +313,63 @@ func main() {
 	))
 
 	log.Printf("Server starting on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := http.ListenAndServe(":8080", recoverPanics(requestTimeout(http.DefaultServeMux, featureFlags.RequestTimeout))); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
+// recoverPanics converts a panic in any handler into a 500 response so that a single
+// bad request cannot take down the server
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				log.Printf("Recovered from panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unboundedPaths are left out of requestTimeout: their events must be flushed as they
+// happen, which the buffered timeout response cannot do. PROCESSING_TIMEOUT still applies.
+var unboundedPaths = map[string]bool{"/api/v1/process-stream": true}
+
+// requestTimeout answers any request still running after timeout with a 503 JSON error
+// and cancels its context. Responses are buffered until the handler returns so the
+// status can still change; a timeout of zero disables the limit.
+func requestTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	message, _ := json.Marshal(ErrorResponse{Error: fmt.Sprintf("Request timed out after %s", timeout)})
+	bounded := http.TimeoutHandler(next, timeout, string(message))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unboundedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		bounded.ServeHTTP(timeoutResponseWriter{w}, r)
+	})
+}
+
+// timeoutResponseWriter labels http.TimeoutHandler's timeout body as JSON, which it
+// otherwise sends without a content type
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutResponseWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
 func serveUI(w http.ResponseWriter, r *http.Request) {
 	tmpl, err := template.ParseFiles("ui/index.html")
 	if err != nil {
@@ -189,6 +386,7 @@ func serveUI(w http.ResponseWriter, r *http.Request) {
 }
 
 func getFieldConfig(w http.ResponseWriter, r *http.Request) {
+	fieldConfig := currentFieldConfig()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"fields":          fieldConfig.Fields,
@@ -217,8 +415,8 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// Check file type
-	if !strings.HasSuffix(handler.Filename, ".xlsx") && !strings.HasSuffix(handler.Filename, ".csv") {
-		http.Error(w, "Invalid file type. Only .csv and .xlsx files are allowed", http.StatusBadRequest)
+	if !isSupportedInputFile(handler.Filename) && !isZipUpload(handler.Filename) {
+		http.Error(w, invalidProcessFileTypeMessage, http.StatusBadRequest)
 		return
 	}
 
@@ -226,10 +424,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	uniqueID := generateUniqueID()
 
 	// Save the uploaded file temporarily
-	tempDir := "./uploads"
-	os.MkdirAll(tempDir, os.ModePerm)
-	tempFilePath := filepath.Join(tempDir, fmt.Sprintf("%s_%s", uniqueID, handler.Filename))
-	tempFile, err := os.Create(tempFilePath)
+	tempFile, tempFilePath, err := createUploadFile(uniqueID, handler.Filename)
 	if err != nil {
 		http.Error(w, "Unable to save file", http.StatusInternalServerError)
 		return
@@ -238,21 +433,33 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 
 	_, err = tempFile.ReadFrom(file)
 	if err != nil {
+		os.Remove(tempFilePath)
+		if isDiskFullError(err) {
+			http.Error(w, insufficientStorageMessage, http.StatusInsufficientStorage)
+			return
+		}
 		http.Error(w, "Unable to save file content", http.StatusInternalServerError)
 		return
 	}
 
 	// Extract field mappings from form
-	fieldMappings := make(map[string]string)
-	order := fieldConfig.GetOrderedFields()
+	fieldMappings := make(map[string]fieldMapping)
+	order := currentFieldConfig().GetOrderedFields()
 
 	// For multipart forms, use MultipartForm.Value instead of PostForm
+	// Iterate keys in sorted order so unknown fields are appended deterministically
 	formValues := r.MultipartForm.Value
-	for key, values := range formValues {
+	formKeys := make([]string, 0, len(formValues))
+	for key := range formValues {
+		formKeys = append(formKeys, key)
+	}
+	sort.Strings(formKeys)
+	for _, key := range formKeys {
+		values := formValues[key]
 		if strings.HasPrefix(key, "mapping_") {
 			expectedField := strings.TrimPrefix(key, "mapping_")
 			if len(values) > 0 && values[0] != "" {
-				fieldMappings[expectedField] = values[0]
+				fieldMappings[expectedField] = columnMapping(values[0])
 			}
 			if !contains(order, expectedField) {
 				order = append(order, expectedField)
@@ -260,15 +467,54 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get output format from multipart form
-	outputFormat := "excel"
-	if formats, ok := formValues["outputFormat"]; ok && len(formats) > 0 {
-		outputFormat = formats[0]
+	// Unknown fields are appended to the order, so it is limited like the mappings
+	if len(order) > featureFlags.MaxMappingFields {
+		os.Remove(tempFilePath)
+		http.Error(w, tooManyFieldsMessage(), http.StatusBadRequest)
+		return
+	}
+	request, err := checkProcessRequest(r, fieldMappings, "excel")
+	if err != nil {
+		os.Remove(tempFilePath)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fieldMappings, outputFormat, opts := request.fieldMappings, request.outputFormat, request.opts
+	// The upload is only kept past the request when the client asks to retain it
+	if !opts.retainInput {
+		defer os.Remove(tempFilePath)
 	}
 
 	// Process the uploaded file using the field mappings
-	summary, outputPath := processFile(tempFilePath, fieldMappings, order, outputFormat, uniqueID)
-
+	ctx, cancel := processingContext(r)
+	defer cancel()
+	summary, outputPath, err := processUpload(ctx, tempFilePath, fieldMappings, order, outputFormat, uniqueID, opts)
+	if status, message, ok := interruptedProcessingError(err); ok {
+		log.Printf("Stopped processing uploaded file %s: %v", handler.Filename, err)
+		http.Error(w, message, status)
+		return
+	}
+	if errors.Is(err, errInputTooLarge) {
+		log.Printf("Rejected uploaded file %s: %v", handler.Filename, err)
+		os.Remove(tempFilePath)
+		http.Error(w, inputTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, errErrorBudgetExceeded) {
+		log.Printf("Stopped processing uploaded file %s: %v", handler.Filename, err)
+		http.Error(w, describeInputError(err)+"\n\n"+summary, http.StatusUnprocessableEntity)
+		return
+	}
+	if message, ok := clientInputError(err); ok {
+		log.Printf("Rejected uploaded file %s: %v", handler.Filename, err)
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	if status, message, ok := outputWriteError(err); ok {
+		log.Printf("Failed to write outputs of %s: %v", handler.Filename, err)
+		http.Error(w, message, status)
+		return
+	}
 	// Extract filenames from paths for download links
 	outputFilename := filepath.Base(outputPath)
 
@@ -279,563 +525,6363 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		"outputFilename": outputFilename,
 	}
 
-	// Add missing data filename for CSV and markdown formats
-	if outputFormat == "csv" {
-		response["missingFilename"] = fmt.Sprintf("%s_missing_data.csv", uniqueID)
-	} else if outputFormat == "markdown" {
-		response["missingFilename"] = fmt.Sprintf("%s_missing_data.md", uniqueID)
+	if opts.summarySidecar {
+		response["summaryFilename"] = filepath.Base(summarySidecarPath(uniqueID))
+	}
+	if opts.dedupeReport {
+		response["duplicatesFilename"] = filepath.Base(duplicatesReportPath(uniqueID))
+	}
+	if opts.provenance {
+		response["provenanceFilename"] = filepath.Base(provenancePath(uniqueID))
+	}
+	if opts.groupBy != "" {
+		response["groupsFilename"] = filepath.Base(groupReportPath(uniqueID))
+	}
+	if opts.manifest {
+		response["manifestFilename"] = filepath.Base(manifestPath(uniqueID))
+	}
+	if opts.retainInput {
+		response["inputFilename"] = filepath.Base(tempFilePath)
+	}
+	manifest := uploadManifest(uniqueID, outputFormat)
+	response["manifest"] = manifest
+
+	// Remote outputs are downloaded from signed URLs rather than /download
+	if outputSink.Remote() {
+		os.Remove(manifestPath(uniqueID))
+		delete(response, "manifestFilename")
+		stored, err := storeOutputs(ctx, uniqueID, outputFormat)
+		if err != nil {
+			log.Printf("Failed to store outputs of %s: %v", handler.Filename, err)
+			message := storeOutputsFailedMessage
+			if len(stored) > 0 {
+				keys := make([]string, len(stored))
+				for i, output := range stored {
+					keys[i] = output.Key
+				}
+				message += "; already stored: " + strings.Join(keys, ", ")
+			}
+			http.Error(w, message, http.StatusInternalServerError)
+			return
+		}
+		for _, output := range stored {
+			response[output.Kind+"URL"] = output.URL
+		}
+		response["manifest"] = manifest.withStoredURLs(stored)
+	}
+
+	// Add missing data filename for CSV and markdown formats when both outputs were generated.
+	// A zip upload's missing data outputs are inside its archive.
+	if opts.outputScope == outputScopeBoth && !isZipUpload(handler.Filename) {
+		if outputFormat == "csv" || outputFormat == "markdown" || outputFormat == "ndjson" || outputFormat == "sql" {
+			_, missingPath := outputFilePaths(uniqueID, outputFormat)
+			response["missingFilename"] = filepath.Base(missingPath)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// readInputFile reads and parses the input file based on its extension
-func readInputFile(filePath string) ([][]string, error) {
-	if strings.HasSuffix(filePath, ".xlsx") {
-		return readXLSXFile(filePath)
-	} else if strings.HasSuffix(filePath, ".csv") {
-		return readCSVFile(filePath)
-	}
-	return nil, fmt.Errorf("unsupported file format")
+// rawUploadExtensions maps the Content-Types accepted for a raw request body upload to
+// the file extension that selects how it is read
+var rawUploadExtensions = map[string]string{
+	"text/csv":        ".csv",
+	"application/csv": ".csv",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": ".xlsx",
+	"application/gzip":   ".csv.gz",
+	"application/x-gzip": ".csv.gz",
+	"application/zip":    zipUploadExtension,
+}
+
+// errParseFile is returned when an uploaded file cannot be read or parsed
+var errParseFile = errors.New("failed to parse file")
+
+// errSheetHeaderMismatch is returned when mergeAllSheets finds a sheet whose header differs from the first
+var errSheetHeaderMismatch = errors.New("sheet headers do not match")
+
+// sheetRowCount records how many data rows a sheet contributed to a merged input
+type sheetRowCount struct {
+	name string
+	rows int
+}
+
+// inputInfo describes properties of an input file beyond its rows
+type inputInfo struct {
+	// sheetCounts lists the rows read from each sheet when mergeAllSheets is set
+	sheetCounts []sheetRowCount
+	// date1904 reports that the workbook uses the 1904 date system
+	date1904 bool
+	// decimalComma reports that the CSV dialect writes numbers with a decimal comma
+	decimalComma bool
+	// extraHeaderRows is the number of rows below the first header row that are not data:
+	// header rows folded into the header by combineHeaderRows and any units row
+	extraHeaderRows int
+	// units are the cells of the units row, one per header column, when unitsRow is set
+	units []string
 }
 
-func readXLSXFile(filePath string) ([][]string, error) {
-	f, err := excelize.OpenFile(filePath)
+// maxHeaderRows caps the headerRows form field
+const maxHeaderRows = 10
+
+// headerRowsOption is how many rows of an input form its header and how their names are joined
+type headerRowsOption struct {
+	// rows is the number of header rows; zero or one means a single header row
+	rows int
+	// separator joins the parts of composite names; empty means a space
+	separator string
+	// units reads the row below the header as the units of each column instead of data
+	units bool
+}
+
+// parseHeaderRows reads the headerRows and headerSeparator form fields
+func parseHeaderRows(r *http.Request) (headerRowsOption, error) {
+	rows, err := parseNonNegativeIntFormValue(r, "headerRows")
 	if err != nil {
-		return nil, fmt.Errorf("error opening xlsx file: %v", err)
+		return headerRowsOption{}, err
 	}
-	defer f.Close()
-
-	sheetName := f.GetSheetName(0)
-	rows, err := f.GetRows(sheetName)
+	if rows > maxHeaderRows {
+		return headerRowsOption{}, fmt.Errorf("invalid headerRows value %d: must be at most %d", rows, maxHeaderRows)
+	}
+	units, err := parseBoolFormValue(r, "unitsRow", false)
 	if err != nil {
-		return nil, fmt.Errorf("error reading sheet rows: %v", err)
+		return headerRowsOption{}, err
 	}
-	return rows, nil
+	return headerRowsOption{rows: rows, separator: r.FormValue("headerSeparator"), units: units}, nil
 }
 
-func readCSVFile(filePath string) ([][]string, error) {
-	csvFile, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("error opening CSV file: %v", err)
+// combineHeaderRows folds the first option.rows rows into a single header whose names join
+// each column's non-blank header cells with the separator, such as "Sales Q1" from "Sales"
+// above "Q1". A blank cell in an upper header row takes the value to its left, as a merged
+// cell spanning several columns is read. With option.units the row below the header is
+// then taken out of the data and kept in info.units. info records the rows taken so that
+// row numbers stay those of the file.
+func combineHeaderRows(rows [][]string, info inputInfo, option headerRowsOption) ([][]string, inputInfo) {
+	if headerRows := min(option.rows, len(rows)); headerRows > 1 {
+		rows = append([][]string{joinHeaderRows(rows[:headerRows], option.separator)}, rows[headerRows:]...)
+		info.extraHeaderRows = headerRows - 1
 	}
-	defer csvFile.Close()
+	if option.units && len(rows) > 1 {
+		info.units = make([]string, len(rows[0]))
+		for j := range info.units {
+			if j < len(rows[1]) {
+				info.units[j] = strings.TrimSpace(rows[1][j])
+			}
+		}
+		rows = append(rows[:1:1], rows[2:]...)
+		info.extraHeaderRows++
+	}
+	return rows, info
+}
 
-	var rows [][]string
-	reader := csv.NewReader(csvFile)
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+// joinHeaderRows joins each column's cells of headerRows into one composite header
+func joinHeaderRows(headerRows [][]string, separator string) []string {
+	if separator == "" {
+		separator = " "
+	}
+
+	width := 0
+	for _, row := range headerRows {
+		width = max(width, len(row))
+	}
+	header := make([]string, width)
+	for i, row := range headerRows {
+		inherited := ""
+		for j := range header {
+			cell := ""
+			if j < len(row) {
+				cell = strings.TrimSpace(row[j])
+			}
+			if i < len(headerRows)-1 {
+				if cell == "" {
+					cell = inherited
+				}
+				inherited = cell
+			}
+			if cell == "" {
+				continue
+			}
+			if header[j] != "" {
+				header[j] += separator
+			}
+			header[j] += cell
 		}
-		if err != nil {
-			return nil, fmt.Errorf("error reading CSV file: %v", err)
+	}
+	return header
+}
+
+// describeUnits lists the unit of each column that has one, such as "Mass (kg)"
+func describeUnits(header, units []string) string {
+	var described []string
+	for j, unit := range units {
+		if unit != "" && j < len(header) {
+			described = append(described, fmt.Sprintf("%s (%s)", header[j], unit))
 		}
-		rows = append(rows, record)
 	}
-	return rows, nil
+	if len(described) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Units: %s\n", strings.Join(described, ", "))
 }
 
-// normalizeHeaders converts headers to lowercase and trims whitespace
-func normalizeHeaders(headers []string) []string {
-	normalized := make([]string, len(headers))
-	for i, header := range headers {
-		normalized[i] = strings.TrimSpace(strings.ToLower(header))
+// csvDialect describes how the fields and numbers of a CSV input are written
+type csvDialect struct {
+	// comma separates the fields; zero means ','
+	comma rune
+	// decimalComma writes numbers with a decimal comma and '.' thousands separators, e.g. "1.234,56"
+	decimalComma bool
+}
+
+// csvDialects are the presets accepted by the csvDialect form field
+var csvDialects = map[string]csvDialect{
+	"standard": {comma: ','},
+	"european": {comma: ';', decimalComma: true},
+}
+
+// parseCSVDialect returns the named CSV dialect; empty means standard
+func parseCSVDialect(name string) (csvDialect, error) {
+	if name == "" {
+		return csvDialects["standard"], nil
 	}
-	return normalized
+	dialect, ok := csvDialects[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return csvDialect{}, fmt.Errorf("invalid csvDialect %q: must be standard or european", name)
+	}
+	return dialect, nil
 }
 
-// createOutputWorkbook creates a new Excel workbook with ProcessedData and MissingData sheets
-func createOutputWorkbook(headers []string) *excelize.File {
-	outputFile := excelize.NewFile()
-	outputFile.NewSheet("ProcessedData")
-	outputFile.NewSheet("MissingData")
-	outputFile.DeleteSheet("Sheet1")
-	outputFile.SetSheetRow("ProcessedData", "A1", &headers)
-	outputFile.SetSheetRow("MissingData", "A1", &headers)
-	return outputFile
+// readInputFile reads and parses the input file based on its extension. Any failure,
+// including a panic inside the parsers on a corrupt file, is reported as errParseFile.
+// With mergeAllSheets every sheet of an XLSX file is read and the per-sheet row counts
+// are returned. dialect applies to .csv and .csv.gz files; TSV is always tab-separated.
+// A read failing with a transient error is retried up to READ_RETRIES times.
+func readInputFile(ctx context.Context, filePath string, mergeAllSheets bool, dialect csvDialect) (rows [][]string, info inputInfo, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic reading %s: %v\n%s", filePath, r, debug.Stack())
+			rows, info, err = nil, inputInfo{}, errParseFile
+		}
+	}()
+
+	for attempt := 0; ; attempt++ {
+		rows, info, err = readInputAttempt(ctx, filePath, mergeAllSheets, dialect)
+		if err == nil || attempt >= featureFlags.ReadRetries || !isTransientReadError(err) || ctx.Err() != nil {
+			break
+		}
+		backoff := featureFlags.ReadRetryBackoff << attempt
+		log.Printf("Retrying read of %s in %s after a transient error: %v", filePath, backoff, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+	}
+	// Cancellation is reported as such rather than as a parse failure
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, inputInfo{}, ctxErr
+	}
+	if errors.Is(err, errSheetHeaderMismatch) || errors.Is(err, errInputTooLarge) {
+		return nil, inputInfo{}, err
+	}
+	if err != nil {
+		return nil, inputInfo{}, fmt.Errorf("%w: %v", errParseFile, err)
+	}
+	return rows, info, nil
 }
 
-// generateProcessingSummary creates a formatted summary of the processing results
-func generateProcessingSummary(totalRows, successfulRows, missingCount int, missingDetails string) string {
-	var summaryBuilder strings.Builder
-	summaryBuilder.WriteString("Data Mapping Summary:\n")
-	if missingDetails != "" {
-		summaryBuilder.WriteString(missingDetails)
+// readInputAttempt reads the input once, charging a fresh byte budget
+func readInputAttempt(ctx context.Context, filePath string, mergeAllSheets bool, dialect csvDialect) (rows [][]string, info inputInfo, err error) {
+	budget := &byteBudget{limit: featureFlags.MaxInputBytes}
+	switch {
+	case strings.HasSuffix(filePath, ".xlsx"):
+		rows, info, err = readXLSXFile(filePath, mergeAllSheets, budget)
+	case strings.HasSuffix(filePath, ".csv"), strings.HasSuffix(filePath, ".csv.gz"):
+		comma := dialect.comma
+		if comma == 0 {
+			comma = ','
+		}
+		rows, err = readCSVFile(ctx, filePath, comma, budget, 0)
+		info.decimalComma = dialect.decimalComma
+	case strings.HasSuffix(filePath, ".tsv.gz"):
+		rows, err = readCSVFile(ctx, filePath, '\t', budget, 0)
+	default:
+		err = fmt.Errorf("unsupported file format")
 	}
-	summaryBuilder.WriteString(fmt.Sprintf("\nTotal Rows Processed: %d\n", totalRows))
-	summaryBuilder.WriteString(fmt.Sprintf("Successful Rows: %d\n", successfulRows))
-	summaryBuilder.WriteString(fmt.Sprintf("Rows with Missing Data: %d\n", missingCount))
-	return summaryBuilder.String()
+	return rows, info, err
 }
 
-// saveAsXLSX saves the output file as an Excel workbook
-func saveAsXLSX(outputFile *excelize.File, outputPath string) (string, error) {
-	if err := outputFile.SaveAs(outputPath); err != nil {
-		return "", fmt.Errorf("error saving output file: %w", err)
+// openInput opens an input file for reading; tests replace it to simulate failing reads
+var openInput = func(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// isTransientReadError reports whether a failed read may succeed if retried: the call was
+// interrupted or would block, or the network behind the upload directory timed out or
+// dropped the connection. Missing files, permissions and malformed content are permanent.
+func isTransientReadError(err error) bool {
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ETIMEDOUT) || errors.Is(err, syscall.ECONNRESET) {
+		return true
 	}
-	return outputPath, nil
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
-// saveAsMarkdown saves the output file as Markdown with a report format
-func saveAsMarkdown(outputFile *excelize.File, order []string, outputRowCount, missingRowCount int, summary string, uniqueID string) (string, error) {
-	outputFilePath := fmt.Sprintf("./uploads/%s_processed_data.md", uniqueID)
-	mdFile, err := os.Create(outputFilePath)
+// readXLSXFile reads the first sheet, or with mergeAllSheets concatenates the data rows of
+// every non-empty sheet below a single header. Merged sheets must share the same header.
+func readXLSXFile(filePath string, mergeAllSheets bool, budget *byteBudget) ([][]string, inputInfo, error) {
+	if err := budget.chargeArchive(filePath); err != nil {
+		return nil, inputInfo{}, err
+	}
+	file, err := openInput(filePath)
+	if err != nil {
+		return nil, inputInfo{}, fmt.Errorf("error opening xlsx file: %w", err)
+	}
+	f, err := excelize.OpenReader(file)
+	file.Close()
 	if err != nil {
-		return "", fmt.Errorf("error creating markdown file: %w", err)
+		return nil, inputInfo{}, fmt.Errorf("error opening xlsx file: %w", err)
+	}
+	defer f.Close()
+
+	var info inputInfo
+	if props, err := f.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		info.date1904 = *props.Date1904
 	}
-	defer mdFile.Close()
 
-	var processedRows [][]string
-	processedRows = append(processedRows, order) // Add headers
-	for rowIndex := 2; rowIndex < outputRowCount; rowIndex++ {
-		row := make([]string, len(order))
-		for j := range row {
-			cell, _ := outputFile.GetCellValue("ProcessedData", fmt.Sprintf("%s%d", string(rune('A'+j)), rowIndex))
-			row[j] = cell
+	if !mergeAllSheets {
+		sheetName := f.GetSheetName(0)
+		rows, err := f.GetRows(sheetName)
+		if err != nil {
+			return nil, inputInfo{}, fmt.Errorf("error reading sheet rows: %v", err)
 		}
-		processedRows = append(processedRows, row)
+		return rows, info, nil
 	}
 
-	markdownContent := generateMarkdownTable(order, processedRows[1:])
+	var merged [][]string
+	var firstSheet string
+	for _, sheetName := range f.GetSheetList() {
+		rows, err := f.GetRows(sheetName)
+		if err != nil {
+			return nil, inputInfo{}, fmt.Errorf("error reading rows of sheet %q: %v", sheetName, err)
+		}
+		if len(rows) == 0 {
+			continue
+		}
 
-	// Add summary section to markdown
-	fullContent := fmt.Sprintf("# Data Processing Report\n\n## Summary\n\n```\n%s\n```\n\n## Processed Data\n\n%s",
-		summary, markdownContent)
+		if merged == nil {
+			firstSheet = sheetName
+			merged = append(merged, rows[0])
+		} else if !slices.Equal(normalizeHeaders(rows[0]), normalizeHeaders(merged[0])) {
+			return nil, inputInfo{}, fmt.Errorf("%w: sheet %q has a different header from sheet %q", errSheetHeaderMismatch, sheetName, firstSheet)
+		}
+		merged = append(merged, rows[1:]...)
+		info.sheetCounts = append(info.sheetCounts, sheetRowCount{name: sheetName, rows: len(rows) - 1})
+	}
+	return merged, info, nil
+}
 
-	_, err = mdFile.WriteString(fullContent)
+// readCSVFile reads a delimited text file, or only its first maxRows rows when maxRows is
+// positive. Gzip-compressed content is detected by its magic bytes, whatever the file's
+// extension, and decompressed while reading.
+func readCSVFile(ctx context.Context, filePath string, comma rune, budget *byteBudget, maxRows int) ([][]string, error) {
+	csvFile, err := openInput(filePath)
 	if err != nil {
-		return "", fmt.Errorf("error writing markdown content: %w", err)
+		return nil, fmt.Errorf("error opening CSV file: %w", err)
 	}
+	defer csvFile.Close()
 
-	// Save missing rows to separate markdown file
-	missingFilePath := fmt.Sprintf("./uploads/%s_missing_data.md", uniqueID)
-	missingMdFile, err := os.Create(missingFilePath)
+	input, err := decompressedReader(bufio.NewReader(csvFile))
 	if err != nil {
-		return outputFilePath, fmt.Errorf("error creating missing data markdown file: %w", err)
+		return nil, fmt.Errorf("error opening gzip stream: %w", err)
 	}
-	defer missingMdFile.Close()
 
-	var missingRows [][]string
-	missingRows = append(missingRows, order)
-	for rowIndex := 2; rowIndex < missingRowCount; rowIndex++ {
-		row := make([]string, len(order))
-		for j := range row {
-			cell, _ := outputFile.GetCellValue("MissingData", fmt.Sprintf("%s%d", string(rune('A'+j)), rowIndex))
-			row[j] = cell
+	var rows [][]string
+	reader := csv.NewReader(budget.reader(input))
+	reader.Comma = comma
+	for maxRows <= 0 || len(rows) < maxRows {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV file: %w", err)
 		}
-		missingRows = append(missingRows, row)
+		rows = append(rows, record)
 	}
+	return rows, nil
+}
 
-	missingMarkdownContent := generateMarkdownTable(order, missingRows[1:])
-	missingFullContent := fmt.Sprintf("# Missing Data Report\n\n## Missing Records\n\n%s", missingMarkdownContent)
+// errUnknownSheet is returned when a request names a sheet the workbook does not have
+var errUnknownSheet = errors.New("sheet not found")
+
+// readInputHeader reads only the first n rows of an input, for inspecting its header
+// without parsing every row. An XLSX file's named sheet is read, or its first sheet when
+// sheet is empty; the sheet read and every sheet name are returned with the rows.
+func readInputHeader(ctx context.Context, filePath string, dialect csvDialect, sheet string, n int) (rows [][]string, sheetName string, sheets []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic reading %s: %v\n%s", filePath, r, debug.Stack())
+			rows, sheetName, sheets, err = nil, "", nil, errParseFile
+		}
+	}()
 
-	_, err = missingMdFile.WriteString(missingFullContent)
+	budget := &byteBudget{limit: featureFlags.MaxInputBytes}
+	switch {
+	case strings.HasSuffix(filePath, ".xlsx"):
+		rows, sheetName, sheets, err = readXLSXHeader(filePath, sheet, n, budget)
+	case strings.HasSuffix(filePath, ".csv"), strings.HasSuffix(filePath, ".csv.gz"):
+		comma := dialect.comma
+		if comma == 0 {
+			comma = ','
+		}
+		rows, err = readCSVFile(ctx, filePath, comma, budget, n)
+	case strings.HasSuffix(filePath, ".tsv.gz"):
+		rows, err = readCSVFile(ctx, filePath, '\t', budget, n)
+	default:
+		err = fmt.Errorf("unsupported file format")
+	}
+	if errors.Is(err, errUnknownSheet) || errors.Is(err, errInputTooLarge) {
+		return nil, "", nil, err
+	}
 	if err != nil {
-		return outputFilePath, fmt.Errorf("error writing missing data markdown content: %w", err)
+		return nil, "", nil, fmt.Errorf("%w: %v", errParseFile, err)
 	}
-
-	return outputFilePath, nil
+	return rows, sheetName, sheets, nil
 }
 
-// saveAsCSV saves the output file as CSV with pipe delimiter
-func saveAsCSV(outputFile *excelize.File, order []string, outputRowCount, missingRowCount int, uniqueID string) (string, error) {
-	outputFilePath := fmt.Sprintf("./uploads/%s_processed_data.csv", uniqueID)
-	csvFile, err := os.Create(outputFilePath)
+// readXLSXHeader reads the first n rows of the named sheet, or of the first sheet, by
+// streaming the sheet rather than loading all of its rows
+func readXLSXHeader(filePath, sheet string, n int, budget *byteBudget) ([][]string, string, []string, error) {
+	if err := budget.chargeArchive(filePath); err != nil {
+		return nil, "", nil, err
+	}
+	file, err := openInput(filePath)
 	if err != nil {
-		return "", fmt.Errorf("error creating CSV file: %w", err)
+		return nil, "", nil, fmt.Errorf("error opening xlsx file: %w", err)
 	}
-	defer csvFile.Close()
+	f, err := excelize.OpenReader(file)
+	file.Close()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error opening xlsx file: %w", err)
+	}
+	defer f.Close()
 
-	csvWriter := csv.NewWriter(csvFile)
-	csvWriter.Comma = '|'
-	csvWriter.Write(order)
-	// Write processed rows
-	for rowIndex := 2; rowIndex < outputRowCount; rowIndex++ {
-		row := make([]string, len(order))
-		for j := range row {
-			cell, _ := outputFile.GetCellValue("ProcessedData", fmt.Sprintf("%s%d", string(rune('A'+j)), rowIndex))
-			row[j] = cell
+	sheets := f.GetSheetList()
+	sheetName := f.GetSheetName(0)
+	if sheet != "" {
+		if !slices.Contains(sheets, sheet) {
+			return nil, "", nil, fmt.Errorf("%w: %q", errUnknownSheet, sheet)
 		}
-		csvWriter.Write(row)
+		sheetName = sheet
 	}
-	csvWriter.Flush()
-
-	// Save missing rows to separate CSV
-	missingFilePath := fmt.Sprintf("./uploads/%s_missing_data.csv", uniqueID)
-	missingCsvFile, err := os.Create(missingFilePath)
+	sheetRows, err := f.Rows(sheetName)
 	if err != nil {
-		return outputFilePath, fmt.Errorf("error creating missing data CSV file: %w", err)
+		return nil, "", nil, fmt.Errorf("error reading rows of sheet %q: %v", sheetName, err)
 	}
-	defer missingCsvFile.Close()
-
-	missingCsvWriter := csv.NewWriter(missingCsvFile)
-	missingCsvWriter.Comma = '|'
-	missingCsvWriter.Write(order)
-	// Write missing rows
-	for rowIndex := 2; rowIndex < missingRowCount; rowIndex++ {
-		row := make([]string, len(order))
-		for j := range row {
-			cell, _ := outputFile.GetCellValue("MissingData", fmt.Sprintf("%s%d", string(rune('A'+j)), rowIndex))
-			row[j] = cell
+	defer sheetRows.Close()
+	var rows [][]string
+	for len(rows) < n && sheetRows.Next() {
+		columns, err := sheetRows.Columns()
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("error reading rows of sheet %q: %v", sheetName, err)
 		}
-		missingCsvWriter.Write(row)
+		rows = append(rows, columns)
 	}
-	missingCsvWriter.Flush()
-
-	return outputFilePath, nil
+	return rows, sheetName, sheets, nil
 }
 
-// processRow processes a single row and returns the processed data, missing data, missing fields, and success status
-func processRow(row []string, normalizedHeaders []string, fieldMappings map[string]string, order []string, fieldConfig *config.FieldConfig) (processedRow []string, missingRow []string, missingFields []string, isSuccess bool) {
-	processedRow = make([]string, len(order))
-	missingRow = make([]string, len(order))
-	missingFields = make([]string, 0, len(order))
-	isSuccess = true
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
 
-	for fieldIndex, expectedField := range order {
-		var isMandatory bool
-		for _, field := range fieldConfig.Fields {
-			if field.Name == expectedField {
-				isMandatory = field.IsMandatory
-				break
-			}
-		}
+// decompressedReader returns r itself, or a decompressing reader if r holds gzip data
+func decompressedReader(r *bufio.Reader) (io.Reader, error) {
+	if magic, err := r.Peek(len(gzipMagic)); err != nil || !bytes.Equal(magic, gzipMagic) {
+		return r, nil
+	}
+	return gzip.NewReader(r)
+}
 
-		mappedColumn := fieldMappings[expectedField]
+// errInputTooLarge is returned when reading an input exceeds the MAX_INPUT_BYTES budget
+var errInputTooLarge = errors.New("input exceeds the processing size limit")
 
-		// If the mapping is empty (no column selected) and not mandatory,
-		// just leave it blank without marking as MISSING
-		if mappedColumn == "" && !isMandatory {
-			processedRow[fieldIndex] = ""
-			missingRow[fieldIndex] = ""
-			continue
-		}
+// byteBudget tracks the bytes parsed from one input against MAX_INPUT_BYTES. Sizes are
+// counted after decompression, which is what a gzip or zip bomb inflates.
+type byteBudget struct {
+	limit int64
+	used  int64
+}
 
-		// Normalize column header for comparison
-		normalizedColumnHeader := strings.TrimSpace(strings.ToLower(mappedColumn))
+// charge records n more bytes, failing with errInputTooLarge once the limit is passed.
+// n is compared with what is left rather than added first, as a declared archive size can
+// be large enough to wrap the total around.
+func (b *byteBudget) charge(n int64) error {
+	if n > b.limit-b.used {
+		return fmt.Errorf("%w of %d bytes", errInputTooLarge, b.limit)
+	}
+	b.used += n
+	return nil
+}
 
-		// Find the column index for the current mapping
-		columnIndex := -1
-		for j, header := range normalizedHeaders {
-			if header == normalizedColumnHeader {
-				columnIndex = j
-				break
-			}
-		}
+// reader counts everything read through r against the budget
+func (b *byteBudget) reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, budget: b}
+}
 
-		if columnIndex != -1 && columnIndex < len(row) && strings.TrimSpace(row[columnIndex]) != "" {
-			processedRow[fieldIndex] = row[columnIndex]
-			missingRow[fieldIndex] = row[columnIndex]
-		} else {
-			// Only add to missing fields if it's mandatory
-			if isMandatory {
-				missingFields = append(missingFields, expectedField)
-				isSuccess = false
-				missingRow[fieldIndex] = "MISSING"
-			} else {
-				// For non-mandatory fields, only mark as MISSING if a mapping was selected
-				if mappedColumn != "" {
-					missingRow[fieldIndex] = "MISSING"
-				} else {
-					missingRow[fieldIndex] = ""
-				}
-			}
-			processedRow[fieldIndex] = ""
+// chargeArchive charges the uncompressed size of every part of a zip-based file such as
+// XLSX before it is opened. archive/zip rejects parts whose data exceeds their declared
+// size, so the declared sizes cannot understate the real ones.
+func (b *byteBudget) chargeArchive(path string) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		// Not a valid archive; leave reporting the failure to the parser
+		return nil
+	}
+	defer archive.Close()
+	for _, part := range archive.File {
+		if err := b.charge(int64(min(part.UncompressedSize64, math.MaxInt64))); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return processedRow, missingRow, missingFields, isSuccess
+// countingReader charges the bytes read from r to a byteBudget
+type countingReader struct {
+	r      io.Reader
+	budget *byteBudget
 }
 
-func processFile(filePath string, fieldMappings map[string]string, order []string, outputFormat string, uniqueID string) (string, string) {
-	rows, err := readInputFile(filePath)
-	if err != nil {
-		return fmt.Sprintf("Error opening file: %v", err), "Error opening file"
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if budgetErr := c.budget.charge(int64(n)); budgetErr != nil {
+		return n, budgetErr
 	}
+	return n, err
+}
 
-	if len(rows) == 0 {
-		return "No data found in the file.", "No data found in the file"
-	}
+// supportedInputExtensions are the upload file types readInputFile accepts
+var supportedInputExtensions = []string{".xlsx", ".csv", ".csv.gz", ".tsv.gz"}
 
-	// Proceed with processing the rows (common for both .xlsx and .csv)
-	var missingDetailsBuilder strings.Builder
+// invalidFileTypeMessage rejects uploads without a supported extension
+const invalidFileTypeMessage = "Invalid file type. Only .csv and .xlsx files are allowed, plus gzipped .csv.gz and .tsv.gz"
+
+// isSupportedInputFile reports whether filename has one of the supportedInputExtensions
+func isSupportedInputFile(filename string) bool {
+	return slices.ContainsFunc(supportedInputExtensions, func(extension string) bool {
+		return strings.HasSuffix(filename, extension)
+	})
+}
+
+// normalizeHeaders applies normalizeHeader to every header
+func normalizeHeaders(headers []string) []string {
+	normalized := make([]string, len(headers))
+	for i, header := range headers {
+		normalized[i] = normalizeHeader(header)
+	}
+	return normalized
+}
+
+// normalizeHeader prepares a file header or a mapped column name for comparison: it is
+// trimmed and lowercased and, with ACCENT_INSENSITIVE_HEADERS, stripped of accents. Both
+// sides of every header match go through here so they are normalized the same way.
+func normalizeHeader(header string) string {
+	header = strings.TrimSpace(strings.ToLower(header))
+	if featureFlags.AccentInsensitiveHeaders {
+		header = stripAccents(header)
+	}
+	return header
+}
+
+// stripAccents removes diacritics by NFKD decomposition and dropping the combining marks,
+// so "número" becomes "numero". Compatibility characters are decomposed too, e.g. "ﬁ" to "fi".
+func stripAccents(s string) string {
+	ascii := true
+	for i := 0; i < len(s) && ascii; i++ {
+		ascii = s[i] < utf8.RuneSelf
+	}
+	if ascii {
+		return s
+	}
+	var sb strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// createOutputWorkbook creates a new Excel workbook with ProcessedData and MissingData sheets
+func createOutputWorkbook(headers []string) *excelize.File {
+	outputFile := excelize.NewFile()
+	outputFile.NewSheet("ProcessedData")
+	outputFile.NewSheet("MissingData")
+	outputFile.DeleteSheet("Sheet1")
+	outputFile.SetSheetRow("ProcessedData", "A1", &headers)
+	outputFile.SetSheetRow("MissingData", "A1", &headers)
+	outputFile.SetDocProps(&excelize.DocProperties{
+		Creator:  "excel-mapper",
+		Created:  outputDocTimestamp,
+		Modified: outputDocTimestamp,
+	})
+	return outputFile
+}
+
+// Header styles select whether output field columns are headed by Name or DisplayName
+const (
+	headerStyleName    = "name"
+	headerStyleDisplay = "display"
+)
+
+// keyedOutputFormats are the formats whose column headers are keys read by programs, so
+// they keep field Names whatever the header style
+var keyedOutputFormats = map[string]bool{"ndjson": true, "sql": true}
+
+// fieldHeaders returns the header of each field column whose header differs from its
+// Name: its DisplayName with the display header style, unless format is keyed, and the
+// request's outputHeaders, which apply to every format
+func fieldHeaders(fieldConfig *config.FieldConfig, outputFormat string, opts processOptions) map[string]string {
+	headers := make(map[string]string)
+	if opts.displayHeaders && !keyedOutputFormats[outputFormat] {
+		headers = fieldConfig.GetDisplayNames()
+	}
+	maps.Copy(headers, opts.outputHeaders)
+	return headers
+}
+
+// useFieldHeaders heads the field columns of both sheets with headers instead of their
+// Names. order lists the field columns of MissingData and processedFields those of
+// ProcessedData, which may have lost some to dropEmptyColumns. Other columns, such as
+// passthrough and _errors, keep their headers. It returns alignments and numeric with
+// each field's entry also keyed by its new header.
+func useFieldHeaders(outputFile *excelize.File, order, processedFields []string, headers, alignments map[string]string, numeric map[string]bool) (map[string]string, map[string]bool) {
+	for sheet, fields := range map[string][]string{"ProcessedData": processedFields, "MissingData": order} {
+		for i, name := range fields {
+			if header, ok := headers[name]; ok {
+				cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+				outputFile.SetCellValue(sheet, cell, header)
+			}
+		}
+	}
+
+	headerAlignments := maps.Clone(alignments)
+	for name, alignment := range alignments {
+		if header, ok := headers[name]; ok {
+			headerAlignments[header] = alignment
+		}
+	}
+	headerNumeric := maps.Clone(numeric)
+	for name := range numeric {
+		if header, ok := headers[name]; ok {
+			headerNumeric[header] = true
+		}
+	}
+	return headerAlignments, headerNumeric
+}
+
+// styleMissingDataSheet bolds the first width header cells of MissingData and returns the
+// style for its missing marker cells: bold dark red text on a light red fill
+func styleMissingDataSheet(outputFile *excelize.File, width int) (int, error) {
+	headerStyle, err := outputFile.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return 0, fmt.Errorf("error creating header style: %w", err)
+	}
+	lastCell, _ := excelize.CoordinatesToCellName(width, 1)
+	if err := outputFile.SetCellStyle("MissingData", "A1", lastCell, headerStyle); err != nil {
+		return 0, fmt.Errorf("error styling missing data header: %w", err)
+	}
+	markerStyle, err := outputFile.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true, Color: "9C0006"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFC7CE"}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error creating missing marker style: %w", err)
+	}
+	return markerStyle, nil
+}
+
+// styleMissingMarkers applies style to the cells of MissingData row rowIndex that hold the
+// missing marker of one of missingFields
+func styleMissingMarkers(outputFile *excelize.File, rowIndex int, order, missingFields []string, style int) {
+	for _, name := range missingFields {
+		if i := slices.Index(order, name); i != -1 {
+			cell, _ := excelize.CoordinatesToCellName(i+1, rowIndex)
+			outputFile.SetCellStyle("MissingData", cell, cell, style)
+		}
+	}
+}
+
+// dropEmptyColumns removes from ProcessedData the columns of optional fields that are not
+// populated in any processed row and returns their names in output order. Mandatory
+// fields are always kept, and the missing data output keeps every column.
+func dropEmptyColumns(outputFile *excelize.File, order []string, populated []bool, fieldConfig *config.FieldConfig) []string {
+	var dropped []string
+	mandatory := fieldConfig.GetMandatoryFieldNames()
+	for i, name := range order {
+		if populated[i] || slices.Contains(mandatory, name) {
+			continue
+		}
+		dropped = append(dropped, name)
+	}
+	// Removing from the right keeps the indexes of the remaining columns valid
+	for i := len(order) - 1; i >= 0; i-- {
+		if slices.Contains(dropped, order[i]) {
+			column, _ := excelize.ColumnNumberToName(i + 1)
+			outputFile.RemoveCol("ProcessedData", column)
+		}
+	}
+	return dropped
+}
+
+// generateProcessingSummary creates a formatted summary of the processing results
+func generateProcessingSummary(totalRows, successfulRows, missingCount int, missingDetails string) string {
+	var summaryBuilder strings.Builder
+	summaryBuilder.WriteString("Data Mapping Summary:\n")
+	if missingDetails != "" {
+		summaryBuilder.WriteString(missingDetails)
+	}
+	summaryBuilder.WriteString(fmt.Sprintf("\nTotal Rows Processed: %d\n", totalRows))
+	summaryBuilder.WriteString(fmt.Sprintf("Successful Rows: %d\n", successfulRows))
+	summaryBuilder.WriteString(fmt.Sprintf("Rows with Missing Data: %d\n", missingCount))
+	return summaryBuilder.String()
+}
+
+// saveAsXLSX saves the output file as an Excel workbook
+func saveAsXLSX(outputFile *excelize.File, outputPath string, password string) (string, error) {
+	// An empty password writes an unencrypted workbook
+	write := func(w io.Writer) error { return outputFile.Write(w, excelize.Options{Password: password}) }
+	if err := writeOutputFile(outputPath, write); err != nil {
+		return "", fmt.Errorf("error saving output file: %w", err)
+	}
+	return outputPath, nil
+}
+
+// readSheet returns the header and data rows of a sheet in the output workbook.
+// Every data row is padded to the width of the header.
+func readSheet(outputFile *excelize.File, sheet string, rowCount int) ([]string, [][]string) {
+	sheetRows, _ := outputFile.GetRows(sheet)
+	if len(sheetRows) == 0 {
+		return nil, nil
+	}
+	header := sheetRows[0]
+
+	var rows [][]string
+	for rowIndex := 2; rowIndex < rowCount; rowIndex++ {
+		row := make([]string, len(header))
+		if rowIndex-1 < len(sheetRows) {
+			copy(row, sheetRows[rowIndex-1])
+		}
+		rows = append(rows, row)
+	}
+	return header, rows
+}
+
+// defaultMarkdownTitle is the heading of the processed data markdown report
+const defaultMarkdownTitle = "Data Processing Report"
+
+// saveAsMarkdown saves the output file as Markdown with a report format.
+// opts.outputScope controls which of the processed and missing reports are written;
+// the returned path is the missing report only when the scope is "missing". The title,
+// summary block and column alignment follow opts.
+func saveAsMarkdown(outputFile *excelize.File, outputRowCount, missingRowCount int, summary string, uniqueID string, opts processOptions) (string, error) {
+	outputFilePath, missingFilePath := outputFilePaths(uniqueID, "markdown")
+	scope := opts.outputScope
+	title := opts.markdownTitle
+	if title == "" {
+		title = defaultMarkdownTitle
+	}
+
+	if scope != outputScopeProcessed {
+		// Save missing rows to separate markdown file
+		missingMarkdownContent := truncatedMarkdownTable(outputFile, "MissingData", missingRowCount, opts)
+		missingTitle := "Missing Data Report"
+		if opts.markdownTitle != "" {
+			missingTitle = opts.markdownTitle + ": Missing Data"
+		}
+		missingFullContent := fmt.Sprintf("# %s\n\n## Missing Records\n\n%s", missingTitle, missingMarkdownContent)
+
+		if err := writeOutput(missingFilePath, scope == outputScopeMissing, opts, writeString(missingFullContent)); err != nil {
+			return "", fmt.Errorf("error writing missing data markdown content: %w", err)
+		}
+	}
+
+	if scope != outputScopeMissing {
+		markdownContent := truncatedMarkdownTable(outputFile, "ProcessedData", outputRowCount, opts)
+
+		// Add summary section to markdown
+		var fullContent string
+		if opts.markdownOmitSummary {
+			fullContent = fmt.Sprintf("# %s\n\n## Processed Data\n\n%s", title, markdownContent)
+		} else {
+			fullContent = fmt.Sprintf("# %s\n\n## Summary\n\n```\n%s\n```\n\n## Processed Data\n\n%s",
+				title, summary, markdownContent)
+		}
+
+		if err := writeOutput(outputFilePath, true, opts, writeString(fullContent)); err != nil {
+			return "", fmt.Errorf("error writing markdown content: %w", err)
+		}
+	}
+
+	if scope == outputScopeMissing {
+		return missingFilePath, nil
+	}
+	return outputFilePath, nil
+}
+
+// truncatedMarkdownTable renders a sheet of the output workbook as a markdown table of at
+// most opts.markdownMaxRows rows, followed by a note counting the rows left out. rowCount
+// is the sheet's next free row, as for readSheet. The summary still counts every row.
+func truncatedMarkdownTable(outputFile *excelize.File, sheet string, rowCount int, opts processOptions) string {
+	omitted := 0
+	if dataRows := rowCount - 2; opts.markdownMaxRows > 0 && dataRows > opts.markdownMaxRows {
+		omitted = dataRows - opts.markdownMaxRows
+		rowCount = opts.markdownMaxRows + 2
+	}
+	headers, rows := readSheet(outputFile, sheet, rowCount)
+	table := generateAlignedMarkdownTable(headers, rows, opts.markdownAlign)
+	if omitted > 0 {
+		table += fmt.Sprintf("\n_... %d more rows omitted; download CSV for full data_\n", omitted)
+	}
+	return table
+}
+
+// writeCSVFile writes the preamble lines, header and rows to path using a pipe delimiter
+func writeCSVFile(path string, preamble []string, header []string, rows [][]string) error {
+	return writeOutputFile(path, encodeCSV(preamble, header, rows, processOptions{}))
+}
+
+// defaultCSVDelimiter separates the values of CSV outputs unless outputDelimiter is set
+const defaultCSVDelimiter = '|'
+
+// Policies for values containing the CSV output delimiter
+const (
+	// delimiterPolicyQuote quotes such values, doubling any quotes inside them
+	delimiterPolicyQuote = "quote"
+	// delimiterPolicySubstitute replaces the delimiter inside values with delimiterSubstitute,
+	// for consumers that split lines on the delimiter without honouring quotes
+	delimiterPolicySubstitute = "substitute"
+)
+
+// defaultDelimiterSubstitute replaces in-value delimiters when no delimiterSubstitute is sent
+const defaultDelimiterSubstitute = " "
+
+// utf8BOM is the UTF-8 byte-order mark written at the start of CSV outputs with outputBOM
+const utf8BOM = "\uFEFF"
+
+// encodeCSV returns a write function for the preamble lines, header and rows, separated by
+// opts.csvDelimiter or a pipe. Values containing the delimiter are quoted, or with the
+// substitute delimiter policy have it replaced by opts.delimiterSubstitute. opts.outputBOM
+// starts the file with a UTF-8 byte-order mark and opts.outputCRLF ends every line,
+// including those inside quoted values, with CRLF instead of LF.
+func encodeCSV(preamble []string, header []string, rows [][]string, opts processOptions) func(io.Writer) error {
+	delimiter := cmp.Or(opts.csvDelimiter, defaultCSVDelimiter)
+	if opts.delimiterPolicy == delimiterPolicySubstitute {
+		replacer := strings.NewReplacer(string(delimiter), opts.delimiterSubstitute)
+		substitute := func(row []string) []string {
+			replaced := make([]string, len(row))
+			for i, value := range row {
+				replaced[i] = replacer.Replace(value)
+			}
+			return replaced
+		}
+		header = substitute(header)
+		substituted := make([][]string, len(rows))
+		for i, row := range rows {
+			substituted[i] = substitute(row)
+		}
+		rows = substituted
+	}
+	return func(w io.Writer) error {
+		if opts.outputBOM {
+			if _, err := io.WriteString(w, utf8BOM); err != nil {
+				return err
+			}
+		}
+		lineEnding := "\n"
+		if opts.outputCRLF {
+			lineEnding = "\r\n"
+		}
+		for _, line := range preamble {
+			if _, err := io.WriteString(w, line+lineEnding); err != nil {
+				return err
+			}
+		}
+
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Comma = delimiter
+		csvWriter.UseCRLF = opts.outputCRLF
+		csvWriter.Write(header)
+		csvWriter.WriteAll(rows)
+		return csvWriter.Error()
+	}
+}
+
+// csvPreambleLines builds the comment lines written before the CSV header when
+// opts.csvPreamble is set. They can be skipped by setting csv.Reader.Comment.
+func csvPreambleLines(opts processOptions, fileRows, totalRows int) []string {
+	if !opts.csvPreamble {
+		return nil
+	}
+	commentChar := opts.csvCommentChar
+	if commentChar == 0 {
+		commentChar = '#'
+	}
+	generatedAt := opts.generatedAt
+	if generatedAt.IsZero() {
+		generatedAt = time.Now()
+	}
+	return []string{
+		fmt.Sprintf("%c generated: %s", commentChar, generatedAt.UTC().Format(time.RFC3339)),
+		fmt.Sprintf("%c rows: %d", commentChar, fileRows),
+		fmt.Sprintf("%c total rows processed: %d", commentChar, totalRows),
+	}
+}
+
+// saveAsCSV saves the output file as CSV with pipe delimiter.
+// opts.outputScope controls which of the processed and missing files are written;
+// the returned path is the missing file only when the scope is "missing".
+func saveAsCSV(outputFile *excelize.File, outputRowCount, missingRowCount int, uniqueID string, opts processOptions) (string, error) {
+	outputFilePath, missingFilePath := outputFilePaths(uniqueID, "csv")
+	// Row counters start below the header row
+	totalRows := (outputRowCount - 2) + (missingRowCount - 2)
+
+	if opts.outputScope != outputScopeProcessed {
+		// Save missing rows to separate CSV
+		headers, missingRows := readSheet(outputFile, "MissingData", missingRowCount)
+		preamble := csvPreambleLines(opts, len(missingRows), totalRows)
+		if err := writeOutput(missingFilePath, opts.outputScope == outputScopeMissing, opts, encodeCSV(preamble, headers, missingRows, opts)); err != nil {
+			return "", fmt.Errorf("error creating missing data CSV file: %w", err)
+		}
+	}
+
+	if opts.outputScope != outputScopeMissing {
+		headers, processedRows := readSheet(outputFile, "ProcessedData", outputRowCount)
+		preamble := csvPreambleLines(opts, len(processedRows), totalRows)
+		if err := writeOutput(outputFilePath, true, opts, encodeCSV(preamble, headers, processedRows, opts)); err != nil {
+			return "", fmt.Errorf("error creating CSV file: %w", err)
+		}
+	}
+
+	if opts.outputScope == outputScopeMissing {
+		return missingFilePath, nil
+	}
+	return outputFilePath, nil
+}
+
+// encodeNDJSON writes one JSON object per row, one object per line, with keys in header
+// order. When omitEmpty is set, empty values are left out of the object; mandatory fields
+// are never empty in the output, so only optional fields are affected. Otherwise
+// emptyAsNull writes them as null rather than "".
+func encodeNDJSON(w io.Writer, header []string, rows [][]string, omitEmpty, emptyAsNull bool, flushEvery int) error {
+	writer := bufio.NewWriter(w)
+	// A streamed response is flushed after every flushEvery records
+	flusher, _ := w.(http.Flusher)
+	for k, row := range rows {
+		if flusher != nil && flushEvery > 0 && k > 0 && k%flushEvery == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		writer.WriteByte('{')
+		first := true
+		for i, key := range header {
+			if omitEmpty && row[i] == "" {
+				continue
+			}
+			if !first {
+				writer.WriteByte(',')
+			}
+			first = false
+			encodedKey, _ := json.Marshal(key)
+			writer.Write(encodedKey)
+			writer.WriteByte(':')
+			if emptyAsNull && row[i] == "" {
+				writer.WriteString("null")
+				continue
+			}
+			encodedValue, _ := json.Marshal(row[i])
+			writer.Write(encodedValue)
+		}
+		writer.WriteString("}\n")
+	}
+	return writer.Flush()
+}
+
+// createOutputFile creates an output file for writing. Tests replace it to simulate
+// write failures such as a full disk.
+var createOutputFile = func(path string) (io.WriteCloser, error) {
+	return os.Create(path)
+}
+
+// writeOutputFile creates path and fills it with write, returning the first error from
+// creating, writing or closing the file. Close errors matter here: a full disk may only
+// be reported when buffered data is flushed.
+func writeOutputFile(path string, write func(io.Writer) error) error {
+	file, err := createOutputFile(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeOutputBytes writes data to the output file at path
+func writeOutputBytes(path string, data []byte) error {
+	return writeOutputFile(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeString returns a write function for content
+func writeString(content string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	}
+}
+
+// writeOutput writes one output of an upload. The output returned to the client
+// (primary) goes to opts.stream when the response is streamed; everything else is
+// written to path. Writers save the missing data output before the processed one so
+// that file errors are reported before a streamed response starts.
+func writeOutput(path string, primary bool, opts processOptions, write func(io.Writer) error) error {
+	if opts.outputPassword != "" {
+		write = encryptedWrite(opts.outputPassword, write)
+	}
+	if primary && opts.stream != nil {
+		return write(opts.stream)
+	}
+	return writeOutputFile(path, write)
+}
+
+// encryptedWrite returns a write function writing the output of write sealed by
+// encryption.Seal with password. The whole output is buffered, as it is authenticated
+// as one message.
+func encryptedWrite(password string, write func(io.Writer) error) func(io.Writer) error {
+	return func(w io.Writer) error {
+		var plaintext bytes.Buffer
+		if err := write(&plaintext); err != nil {
+			return err
+		}
+		sealed, err := encryption.Seal(password, plaintext.Bytes())
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(sealed)
+		return err
+	}
+}
+
+// encryptedContentType is the content type of text outputs encrypted with outputPassword
+const encryptedContentType = "application/octet-stream"
+
+// streamableOutputFormats are the text formats written straight to the API response
+var streamableOutputFormats = map[string]bool{"csv": true, "markdown": true, "ndjson": true, "sql": true}
+
+// responseStream writes an output straight to the HTTP response instead of to a file.
+// The headers collected in header, including the processing summary, are sent with the
+// first write; once started, errors can no longer be reported with a status code.
+type responseStream struct {
+	w       http.ResponseWriter
+	header  http.Header
+	started bool
+}
+
+func newResponseStream(w http.ResponseWriter) *responseStream {
+	return &responseStream{w: w, header: make(http.Header)}
+}
+
+func (s *responseStream) Write(p []byte) (int, error) {
+	s.start()
+	return s.w.Write(p)
+}
+
+// Flush sends what has been written so far to the client
+func (s *responseStream) Flush() {
+	if flusher, ok := s.w.(http.Flusher); ok {
+		s.start()
+		flusher.Flush()
+	}
+}
+
+// start sends the headers and a 200 status if they have not been sent yet
+func (s *responseStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	for key, values := range s.header {
+		s.w.Header()[key] = values
+	}
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// saveAsNDJSON saves the output file as newline-delimited JSON, one object per row keyed
+// by field Name. opts.outputScope controls which of the processed and missing files are
+// written; the returned path is the missing file only when the scope is "missing".
+func saveAsNDJSON(outputFile *excelize.File, outputRowCount, missingRowCount int, uniqueID string, opts processOptions) (string, error) {
+	outputFilePath, missingFilePath := outputFilePaths(uniqueID, "ndjson")
+
+	if opts.outputScope != outputScopeProcessed {
+		headers, missingRows := readSheet(outputFile, "MissingData", missingRowCount)
+		err := writeOutput(missingFilePath, opts.outputScope == outputScopeMissing, opts, func(w io.Writer) error {
+			return encodeNDJSON(w, headers, missingRows, opts.ndjsonOmitEmpty, opts.emptyAsNull, opts.flushRecords)
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating missing data NDJSON file: %w", err)
+		}
+	}
+
+	if opts.outputScope != outputScopeMissing {
+		headers, processedRows := readSheet(outputFile, "ProcessedData", outputRowCount)
+		err := writeOutput(outputFilePath, true, opts, func(w io.Writer) error {
+			return encodeNDJSON(w, headers, processedRows, opts.ndjsonOmitEmpty, opts.emptyAsNull, opts.flushRecords)
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating NDJSON file: %w", err)
+		}
+	}
+
+	if opts.outputScope == outputScopeMissing {
+		return missingFilePath, nil
+	}
+	return outputFilePath, nil
+}
+
+// Defaults for SQL output
+const (
+	defaultSQLTable     = "processed_data"
+	defaultSQLBatchSize = 100
+)
+
+// sqlTablePattern matches a table name, optionally qualified by a schema
+var sqlTablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQL identifier styles select how output columns are named in SQL output
+const (
+	// sqlIdentifiersQuoted keeps each column's header as a double-quoted identifier
+	sqlIdentifiersQuoted = "quoted"
+	// sqlIdentifiersSnake rewrites each header as a bare snake_case identifier
+	sqlIdentifiersSnake = "snake"
+)
+
+// SQL dialects select how sql output quotes identifiers and escapes string literals
+const (
+	// sqlDialectANSI double-quotes identifiers and only doubles single quotes in literals,
+	// as standard SQL, PostgreSQL, SQLite and SQL Server expect
+	sqlDialectANSI = "ansi"
+	// sqlDialectMySQL backtick-quotes identifiers and also escapes backslashes in literals,
+	// which MySQL and MariaDB treat as escape characters by default
+	sqlDialectMySQL = "mysql"
+)
+
+// sqlReservedWords are keywords common to the major SQL dialects, which cannot be used as
+// bare column names
+var sqlReservedWords = map[string]bool{
+	"all": true, "and": true, "as": true, "by": true, "case": true, "check": true, "column": true,
+	"constraint": true, "create": true, "default": true, "delete": true, "distinct": true,
+	"drop": true, "else": true, "end": true, "from": true, "group": true, "having": true,
+	"in": true, "insert": true, "into": true, "is": true, "join": true, "key": true, "not": true,
+	"null": true, "on": true, "or": true, "order": true, "primary": true, "references": true,
+	"select": true, "table": true, "then": true, "to": true, "union": true, "unique": true,
+	"update": true, "user": true, "values": true, "when": true, "where": true, "with": true,
+}
+
+// snakeCaseIdentifier lowercases name and joins its runs of letters and digits with
+// underscores. A name starting with a digit is prefixed with an underscore, a reserved word
+// is suffixed with one and a name with no letters or digits becomes "column".
+func snakeCaseIdentifier(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	identifier := strings.Join(words, "_")
+	switch {
+	case identifier == "":
+		return "column"
+	case identifier[0] >= '0' && identifier[0] <= '9':
+		return "_" + identifier
+	case sqlReservedWords[identifier]:
+		return identifier + "_"
+	}
+	return identifier
+}
+
+// sqlColumnNames returns the SQL identifier of each header column in the given style, quoted
+// for dialect. Snake case names that collide with an earlier column are suffixed with _2, _3
+// and so on.
+func sqlColumnNames(header []string, style, dialect string) []string {
+	columns := make([]string, len(header))
+	if style != sqlIdentifiersSnake {
+		for i, column := range header {
+			columns[i] = quoteSQLIdentifier(column, dialect)
+		}
+		return columns
+	}
+	used := make(map[string]bool, len(header))
+	for i, column := range header {
+		identifier := snakeCaseIdentifier(column)
+		for n := 2; used[identifier]; n++ {
+			identifier = fmt.Sprintf("%s_%d", snakeCaseIdentifier(column), n)
+		}
+		used[identifier] = true
+		columns[i] = identifier
+	}
+	return columns
+}
+
+// describeSQLColumns lists the columns whose SQL identifier differs from their header
+func describeSQLColumns(header, columns []string) string {
+	var renamed []string
+	for i, column := range header {
+		if columns[i] != column {
+			renamed = append(renamed, fmt.Sprintf("%s -> %s", column, columns[i]))
+		}
+	}
+	if len(renamed) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("SQL Columns Renamed: %d (%s)\n", len(renamed), strings.Join(renamed, ", "))
+}
+
+// numericColumns returns the output columns holding numbers: fields typed "number" and the quality score
+func numericColumns(fieldConfig *config.FieldConfig) map[string]bool {
+	numeric := map[string]bool{qualityScoreColumn: true}
+	for _, field := range fieldConfig.Fields {
+		if field.Type == config.FieldTypeNumber {
+			numeric[field.Name] = true
+		}
+	}
+	return numeric
+}
+
+// saveAsSQL saves the output file as batched INSERT statements into opts.sqlTable, with
+// missing rows inserted into a "<table>_missing" table. opts.outputScope controls which
+// files are written; the returned path is the missing file only when the scope is "missing".
+func saveAsSQL(outputFile *excelize.File, outputRowCount, missingRowCount int, uniqueID string, opts processOptions) (string, error) {
+	outputFilePath, missingFilePath := outputFilePaths(uniqueID, "sql")
+	table := opts.sqlTable
+	if table == "" {
+		table = defaultSQLTable
+	}
+	batchSize := opts.sqlBatchSize
+	if batchSize == 0 {
+		batchSize = defaultSQLBatchSize
+	}
+
+	if opts.outputScope != outputScopeProcessed {
+		headers, missingRows := readSheet(outputFile, "MissingData", missingRowCount)
+		err := writeOutput(missingFilePath, opts.outputScope == outputScopeMissing, opts, func(w io.Writer) error {
+			return encodeSQLInserts(w, table+"_missing", headers, missingRows, batchSize, opts)
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating missing data SQL file: %w", err)
+		}
+	}
+
+	if opts.outputScope != outputScopeMissing {
+		headers, processedRows := readSheet(outputFile, "ProcessedData", outputRowCount)
+		err := writeOutput(outputFilePath, true, opts, func(w io.Writer) error {
+			return encodeSQLInserts(w, table, headers, processedRows, batchSize, opts)
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating SQL file: %w", err)
+		}
+	}
+
+	if opts.outputScope == outputScopeMissing {
+		return missingFilePath, nil
+	}
+	return outputFilePath, nil
+}
+
+// encodeSQLInserts writes rows as INSERT statements of at most batchSize rows each.
+// The table is quoted for opts.sqlDialect and the columns named in the opts.sqlIdentifiers
+// style, with a leading comment mapping each renamed header to its column. Values in
+// opts.numericColumns that parse as finite numbers are written bare and everything else as
+// a single-quoted string literal. With opts.emptyAsNull, empty values are written as NULL.
+func encodeSQLInserts(w io.Writer, table string, header []string, rows [][]string, batchSize int, opts processOptions) error {
+	writer := bufio.NewWriter(w)
+	quotedTable := make([]string, 0, 2)
+	for _, part := range strings.Split(table, ".") {
+		quotedTable = append(quotedTable, quoteSQLIdentifier(part, opts.sqlDialect))
+	}
+	columns := sqlColumnNames(header, opts.sqlIdentifiers, opts.sqlDialect)
+	for i, column := range header {
+		if quoted := quoteSQLIdentifier(column, opts.sqlDialect); columns[i] != quoted {
+			fmt.Fprintf(writer, "-- Column %s: %s\n", quoted, columns[i])
+		}
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", strings.Join(quotedTable, "."), strings.Join(columns, ", "))
+
+	for start := 0; start < len(rows); start += batchSize {
+		batch := rows[start:min(start+batchSize, len(rows))]
+		writer.WriteString(insert)
+		for i, row := range batch {
+			values := make([]string, len(header))
+			for j, column := range header {
+				if opts.emptyAsNull && row[j] == "" {
+					values[j] = "NULL"
+				} else {
+					values[j] = sqlLiteral(row[j], opts.numericColumns[column], opts.sqlDialect)
+				}
+			}
+			writer.WriteString("  (" + strings.Join(values, ", ") + ")")
+			if i < len(batch)-1 {
+				writer.WriteString(",\n")
+			}
+		}
+		writer.WriteString(";\n")
+	}
+	return writer.Flush()
+}
+
+// quoteSQLIdentifier quotes an identifier for dialect, with backticks for MySQL and double
+// quotes otherwise, doubling any embedded quote character
+func quoteSQLIdentifier(name, dialect string) string {
+	if dialect == sqlDialectMySQL {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlLiteral renders value as a SQL literal for dialect. Numbers in numeric columns are
+// written bare; everything else is a string literal with embedded single quotes doubled
+// and, for MySQL, backslashes doubled too.
+func sqlLiteral(value string, numeric bool, dialect string) string {
+	if numeric {
+		trimmed := strings.TrimSpace(value)
+		if number, err := strconv.ParseFloat(trimmed, 64); err == nil && !math.IsInf(number, 0) && !math.IsNaN(number) && !strings.ContainsAny(trimmed, "xXpP_") {
+			return trimmed
+		}
+	}
+	if dialect == sqlDialectMySQL {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// qualityScoreColumn is the header of the per-row quality score added by includeQualityScore
+const qualityScoreColumn = "_quality"
+
+// Weights used by qualityScore
+const (
+	mandatoryFieldWeight = 2
+	optionalFieldWeight  = 1
+)
+
+// qualityScore rates how complete a processed row is, from 0 to 100. Every field that is
+// mandatory or has a mapping contributes its weight (mandatoryFieldWeight or
+// optionalFieldWeight), and the score is the populated share of the total weight rounded
+// to the nearest integer. Unmapped optional fields are ignored; with nothing to score the
+// row rates 100.
+func qualityScore(processedRow []string, order []string, fieldMappings map[string]fieldMapping, fieldConfig *config.FieldConfig) int {
+	mandatory := make(map[string]bool)
+	for _, field := range fieldConfig.Fields {
+		mandatory[field.Name] = fieldConfig.IsFieldMandatory(field)
+	}
+
+	total, populated := 0, 0
+	for i, fieldName := range order {
+		weight := optionalFieldWeight
+		if mandatory[fieldName] {
+			weight = mandatoryFieldWeight
+		} else if fieldMappings[fieldName] == nil {
+			continue
+		}
+		total += weight
+		if strings.TrimSpace(processedRow[i]) != "" {
+			populated += weight
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return int(math.Round(100 * float64(populated) / float64(total)))
+}
+
+// ruleErrorsColumn is the header of the MissingData column naming the validation rules a row failed
+const ruleErrorsColumn = "_errors"
+
+// ruleWarningsColumn is the header of the ProcessedData column added by includeWarnings,
+// naming the warn-level rules a row failed
+const ruleWarningsColumn = "_warnings"
+
+// Columns added to ProcessedData by unifiedOutput: each row's status and why it is not OK
+const (
+	rowStatusColumn = "_status"
+	rowReasonColumn = "_reason"
+)
+
+// Row statuses written by unifiedOutput
+const (
+	rowStatusOK        = "OK"
+	rowStatusMissing   = "MISSING"
+	rowStatusInvalid   = "INVALID"
+	rowStatusDuplicate = "DUPLICATE"
+)
+
+// failedRowStatus returns the unifiedOutput status and reason of a row routed to the
+// missing data: MISSING when mandatory fields are missing, otherwise INVALID
+func failedRowStatus(missingFields, failedRules []string) (string, string) {
+	var reasons []string
+	if len(missingFields) > 0 {
+		reasons = append(reasons, "Missing mandatory fields - "+strings.Join(missingFields, ", "))
+	}
+	if len(failedRules) > 0 {
+		reasons = append(reasons, "Failed validation rules - "+strings.Join(failedRules, ", "))
+	}
+	if len(missingFields) > 0 {
+		return rowStatusMissing, strings.Join(reasons, "; ")
+	}
+	return rowStatusInvalid, strings.Join(reasons, "; ")
+}
+
+// fieldMasks returns the Mask of each field in order, or nil when no field is masked
+func fieldMasks(order []string, fieldConfig *config.FieldConfig) []*config.Mask {
+	var masks []*config.Mask
+	for _, field := range fieldConfig.Fields {
+		i := slices.Index(order, field.Name)
+		if field.Mask == nil || i == -1 {
+			continue
+		}
+		if masks == nil {
+			masks = make([]*config.Mask, len(order))
+		}
+		masks[i] = field.Mask
+	}
+	return masks
+}
+
+// maskRow returns a copy of row with the masked fields' values masked. Cells that no
+// longer hold the field's processed value, such as MISSING and INVALID markers, are kept.
+func maskRow(row, processedRow []string, masks []*config.Mask) []string {
+	masked := slices.Clone(row)
+	for i, mask := range masks {
+		if mask != nil && masked[i] == processedRow[i] {
+			masked[i] = mask.Apply(masked[i])
+		}
+	}
+	return masked
+}
+
+// applyRules checks a processed row against the configured validation rules, field ranges
+// and allowed values, and returns the names of the reject rules it fails, followed by the
+// messages of the reject field checks it breaks, and likewise for warnings. The checked field of each reject
+// failure is marked INVALID in missingRow.
+func applyRules(processedRow, missingRow []string, order []string, fieldConfig *config.FieldConfig) (failed, warnings []string) {
+	if !fieldConfig.HasValidations() {
+		return nil, nil
+	}
+	values := make(map[string]string, len(order))
+	for i, fieldName := range order {
+		values[fieldName] = processedRow[i]
+	}
+
+	for _, rule := range fieldConfig.Rules {
+		if rule.Passes(values) {
+			continue
+		}
+		if rule.Warns() {
+			warnings = append(warnings, rule.Name)
+			continue
+		}
+		failed = append(failed, rule.Name)
+		if i := slices.Index(order, rule.Then.Field); i != -1 {
+			missingRow[i] = "INVALID"
+		}
+	}
+
+	for _, field := range fieldConfig.Fields {
+		i := slices.Index(order, field.Name)
+		if i == -1 {
+			continue
+		}
+		check := func(message string, warns bool) {
+			switch {
+			case message == "":
+			case warns:
+				warnings = append(warnings, message)
+			default:
+				failed = append(failed, message)
+				missingRow[i] = "INVALID"
+			}
+		}
+		if field.HasRange() {
+			check(field.CheckRange(processedRow[i]), field.RangeWarns())
+		}
+		if field.HasAllowedValues() {
+			check(field.CheckAllowedValue(processedRow[i]), field.AllowedValuesWarn())
+		}
+		if field.HasPhoneFormat() {
+			check(field.CheckPhone(processedRow[i]), field.PhoneWarns())
+		}
+	}
+	return failed, warnings
+}
+
+// emptyMandatoryColumnWarnings reports mandatory fields with no mapping, processed only
+// with allowUnmappedMandatory, and those mapped to a column that exists but is empty in
+// every one of rows. Either sends the whole file to the missing data output, which is
+// almost always a sign that a mapping was forgotten or the wrong column picked.
+func emptyMandatoryColumnWarnings(rows [][]string, normalizedHeaders []string, fieldMappings map[string]fieldMapping, order []string, fieldConfig *config.FieldConfig) string {
+	mandatory := make(map[string]bool)
+	for _, field := range fieldConfig.Fields {
+		mandatory[field.Name] = fieldConfig.IsFieldMandatory(field)
+	}
+
+	var warnings strings.Builder
+	for _, field := range unmappedMandatoryFields(fieldConfig, fieldMappings) {
+		if contains(order, field.Name) {
+			warnings.WriteString(fmt.Sprintf("WARNING: mandatory field %s has no mapping, so every row is missing it\n", field.Name))
+		}
+	}
+	for _, fieldName := range order {
+		mapping := fieldMappings[fieldName]
+		if !mandatory[fieldName] || !mapping.found(normalizedHeaders) {
+			continue
+		}
+		empty := true
+		for _, row := range rows {
+			if mapping.value(row, normalizedHeaders) != "" {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			warnings.WriteString(fmt.Sprintf("WARNING: mandatory field %s maps to column %s which is entirely empty\n", fieldName, mapping))
+		}
+	}
+	return warnings.String()
+}
+
+// sourceCell returns the cell of row under header, matched case-insensitively, exactly as
+// it appears in the source. It returns "" when the column does not exist.
+func sourceCell(row []string, normalizedHeaders []string, header string) string {
+	if j := slices.Index(normalizedHeaders, normalizeHeader(header)); j != -1 && j < len(row) {
+		return row[j]
+	}
+	return ""
+}
+
+// fieldMapping is where a field's values are read from: its sources in the order they are
+// tried, one for a plain column and several for a coalesce mapping. A nil fieldMapping
+// maps nothing.
+type fieldMapping []mappingSource
+
+// mappingSource is one source column of a mapping
+type mappingSource struct {
+	column string
+	// path is the JSON path extracted from the column's cells, as written, and steps its
+	// parsed form; both are empty for a plain column
+	path  string
+	steps []any
+}
+
+// columnMapping returns the mapping of a plain column, or nil for an empty one
+func columnMapping(column string) fieldMapping {
+	if column == "" {
+		return nil
+	}
+	return fieldMapping{{column: column}}
+}
+
+// columnMappings returns mappings of plain columns keyed like columns, as the web form and
+// alias matching produce
+func columnMappings(columns map[string]string) map[string]fieldMapping {
+	mappings := make(map[string]fieldMapping, len(columns))
+	for field, column := range columns {
+		mappings[field] = columnMapping(column)
+	}
+	return mappings
+}
+
+// String formats the mapping for messages, listing coalesce candidates in order and JSON
+// paths after their column, e.g. "metadata $.address.city"
+func (m fieldMapping) String() string {
+	described := make([]string, len(m))
+	for i, source := range m {
+		described[i] = strings.TrimSpace(source.column + " " + source.path)
+	}
+	return strings.Join(described, ", ")
+}
+
+// MarshalJSON writes the mapping as a request would send it
+func (m fieldMapping) MarshalJSON() ([]byte, error) {
+	switch {
+	case len(m) == 1 && m[0].path != "":
+		return json.Marshal(map[string]string{"column": m[0].column, "path": m[0].path})
+	case len(m) == 1:
+		return json.Marshal(m[0].column)
+	case len(m) == 0:
+		return json.Marshal("")
+	}
+	columns := make([]string, len(m))
+	for i, source := range m {
+		columns[i] = source.column
+	}
+	return json.Marshal(map[string][]string{"coalesce": columns})
+}
+
+// found reports whether any source column of the mapping is one of the headers
+func (m fieldMapping) found(normalizedHeaders []string) bool {
+	return slices.ContainsFunc(m, func(source mappingSource) bool {
+		return slices.Contains(normalizedHeaders, normalizeHeader(source.column))
+	})
+}
+
+// value returns the cell of row in the mapped column, matching headers case-insensitively.
+// It returns "" when the column does not exist or the cell is blank. For a coalesce mapping
+// it returns the first candidate column with a non-blank cell, and for a JSON path mapping
+// the value extracted from the cell.
+func (m fieldMapping) value(row []string, normalizedHeaders []string) string {
+	for _, source := range m {
+		if source.column == "" {
+			continue
+		}
+		value := sourceCell(row, normalizedHeaders, source.column)
+		if source.path != "" {
+			value = extractJSONPath(value, source.steps)
+		}
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseFieldMappings decodes the JSON field mappings of a request. Each value is a column
+// name, {"coalesce": [columns...]}, which takes the first non-empty of the columns, or
+// {"column": name, "path": jsonPath}, which extracts a value from JSON held in the column.
+// JSON paths are parsed here, once per mapping.
+func parseFieldMappings(data string) (map[string]fieldMapping, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, err
+	}
+	fieldMappings := make(map[string]fieldMapping, len(raw))
+	for field, value := range raw {
+		var column string
+		if err := json.Unmarshal(value, &column); err == nil {
+			fieldMappings[field] = columnMapping(column)
+			continue
+		}
+		var mapping struct {
+			Coalesce []string `json:"coalesce"`
+			Column   string   `json:"column"`
+			Path     string   `json:"path"`
+		}
+		err := json.Unmarshal(value, &mapping)
+		switch {
+		case err == nil && len(mapping.Coalesce) > 0 && mapping.Column == "" && mapping.Path == "":
+			sources := make(fieldMapping, len(mapping.Coalesce))
+			for i, column := range mapping.Coalesce {
+				sources[i] = mappingSource{column: column}
+			}
+			fieldMappings[field] = sources
+		case err == nil && len(mapping.Coalesce) == 0 && mapping.Column != "" && mapping.Path != "":
+			steps, err := parseJSONPath(mapping.Path)
+			if err != nil {
+				return nil, fmt.Errorf("mapping for %q: %w", field, err)
+			}
+			fieldMappings[field] = fieldMapping{{column: mapping.Column, path: mapping.Path, steps: steps}}
+		default:
+			return nil, fmt.Errorf("mapping for %q must be a column name, {\"coalesce\": [columns...]} or {\"column\": name, \"path\": jsonPath}", field)
+		}
+	}
+	return fieldMappings, nil
+}
+
+// parseJSONPath parses the supported JSONPath subset: "$" followed by any number of
+// ".key", "[index]" and ["key"] steps. Keys are returned as strings and indexes as ints.
+func parseJSONPath(path string) ([]any, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, fmt.Errorf("invalid JSON path %q: must start with $", path)
+	}
+	var steps []any
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", path)
+			}
+			steps = append(steps, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSON path %q: unclosed [", path)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, inner[1:len(inner)-1])
+			} else if index, err := strconv.Atoi(inner); err == nil && index >= 0 {
+				steps = append(steps, index)
+			} else {
+				return nil, fmt.Errorf("invalid JSON path %q: %q is not an index or quoted key", path, inner)
+			}
+		default:
+			return nil, fmt.Errorf("invalid JSON path %q: expected . or [ at %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+// extractJSONPath returns the value at the path parsed into steps by parseJSONPath in the
+// JSON held by cell. Strings are returned as-is, numbers and booleans as written and
+// objects and arrays as compact JSON. Malformed JSON, a missing path and null all give "".
+func extractJSONPath(cell string, steps []any) string {
+	decoder := json.NewDecoder(strings.NewReader(cell))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return ""
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return ""
+	}
+	for _, step := range steps {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return ""
+			}
+			if value, ok = object[step]; !ok {
+				return ""
+			}
+		case int:
+			array, ok := value.([]any)
+			if !ok || step >= len(array) {
+				return ""
+			}
+			value = array[step]
+		}
+	}
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
+
+// rowMapper maps single rows with processRow and applyRules. Mapping a row depends only
+// on the row itself, so rows can be mapped concurrently.
+type rowMapper struct {
+	normalizedHeaders []string
+	fieldMappings     map[string]fieldMapping
+	order             []string
+	fieldConfig       *config.FieldConfig
+	date1904          bool
+	trimCells         bool
+	// decimalCommaColumns are the columns whose numbers are converted by decimalCommaNumber
+	decimalCommaColumns []int
+}
+
+// mappedRow is the outcome of mapping one input row
+type mappedRow struct {
+	// row is the input row, trimmed when trimCells is set
+	row           []string
+	processed     []string
+	missing       []string
+	missingFields []string
+	failedRules   []string
+	warnings      []string
+	success       bool
+}
+
+// mapRow maps one input row. A row failing a reject rule is unsuccessful, and when rules
+// are configured the missing row ends with the failed rule names.
+func (m rowMapper) mapRow(row []string) mappedRow {
+	if m.trimCells {
+		row = trimCells(row)
+	}
+	mapped := row
+	if len(m.decimalCommaColumns) > 0 {
+		mapped = decimalCommaRow(row, m.decimalCommaColumns)
+	}
+	processed, missing, missingFields, success := processRow(mapped, m.normalizedHeaders, m.fieldMappings, m.order, m.fieldConfig, m.date1904)
+	failedRules, warnings := applyRules(processed, missing, m.order, m.fieldConfig)
+	if len(failedRules) > 0 {
+		success = false
+	}
+	if m.fieldConfig.HasValidations() {
+		missing = append(missing, strings.Join(failedRules, ", "))
+	}
+	return mappedRow{row: row, processed: processed, missing: missing, missingFields: missingFields, failedRules: failedRules, warnings: warnings, success: success}
+}
+
+// decimalCommaNumbers matches a number written with a decimal comma and optional '.'
+// thousands separators, such as "1.234,56", "-0,5" or "1234"
+var decimalCommaNumbers = regexp.MustCompile(`^[+-]?(\d{1,3}(\.\d{3})+|\d+)(,\d+)?$`)
+
+// decimalCommaNumber rewrites a decimal comma number such as "1.234,56" as "1234.56".
+// Other values are returned unchanged.
+func decimalCommaNumber(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if !decimalCommaNumbers.MatchString(trimmed) {
+		return value
+	}
+	return strings.Replace(strings.ReplaceAll(trimmed, ".", ""), ",", ".", 1)
+}
+
+// decimalCommaColumns returns the indexes of the columns read by number fields. Cells
+// read through a JSON path are left alone.
+func decimalCommaColumns(normalizedHeaders []string, fieldMappings map[string]fieldMapping, fieldConfig *config.FieldConfig) []int {
+	var columns []int
+	for _, field := range fieldConfig.Fields {
+		if field.Type != config.FieldTypeNumber || field.Lookup != nil {
+			continue
+		}
+		for _, source := range fieldMappings[field.Name] {
+			j := slices.Index(normalizedHeaders, normalizeHeader(source.column))
+			if source.path == "" && j != -1 && !slices.Contains(columns, j) {
+				columns = append(columns, j)
+			}
+		}
+	}
+	return columns
+}
+
+// decimalCommaRow returns a copy of row with the cells in columns converted by decimalCommaNumber
+func decimalCommaRow(row []string, columns []int) []string {
+	converted := slices.Clone(row)
+	for _, j := range columns {
+		if j < len(converted) {
+			converted[j] = decimalCommaNumber(converted[j])
+		}
+	}
+	return converted
+}
+
+// rowChunkSize is the number of rows a worker maps at a time
+const rowChunkSize = 256
+
+// mapRows maps rows with mapRow on up to workers goroutines and passes each result to
+// route in input order as soon as it and every earlier row are mapped, so rows are routed
+// while later ones are still being mapped. Workers take chunks of rowChunkSize rows and
+// each result is written by exactly one of them, so no locking is needed. route is only
+// called from the calling goroutine; once it returns false no more rows are handed to the
+// workers or routed. It stops early with ctx's error when ctx is done.
+func mapRows(ctx context.Context, rows [][]string, workers int, mapRow func([]string) mappedRow, route func(offset int, mapped mappedRow) bool) error {
+	if workers <= 1 {
+		for i, row := range rows {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !route(i, mapRow(row)) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	results := make([]mappedRow, len(rows))
+	chunks := make(chan int)
+	// done receives the start of each chunk once its rows are mapped, and stop is closed
+	// when route wants no more rows
+	done := make(chan int)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range min(workers, (len(rows)+rowChunkSize-1)/rowChunkSize) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range chunks {
+				// A chunk handed out just before a stop is not needed
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+				for i := start; i < min(start+rowChunkSize, len(rows)); i++ {
+					results[i] = mapRow(rows[i])
+				}
+				done <- start
+			}
+		}()
+	}
+	go func() {
+		defer close(chunks)
+		for start := 0; start < len(rows); start += rowChunkSize {
+			// A stop takes priority over handing out the next chunk
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			select {
+			case chunks <- start:
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Chunks finish out of order; each is routed once every earlier one has been. After a
+	// stop or once ctx is done, the chunks the workers already took are drained without
+	// being routed.
+	finished := make(map[int]bool)
+	next := 0
+	stopped := false
+	for start := range done {
+		if stopped || ctx.Err() != nil {
+			continue
+		}
+		finished[start] = true
+		for ; finished[next] && !stopped; next += rowChunkSize {
+			delete(finished, next)
+			for i := next; i < min(next+rowChunkSize, len(rows)) && ctx.Err() == nil; i++ {
+				if !route(i, results[i]) {
+					stopped = true
+					close(stop)
+					break
+				}
+			}
+		}
+	}
+	if stopped {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// processRow processes a single row and returns the processed data, missing data, missing fields, and success status.
+// date1904 selects the workbook date system used to convert date serials in date fields.
+func processRow(row []string, normalizedHeaders []string, fieldMappings map[string]fieldMapping, order []string, fieldConfig *config.FieldConfig, date1904 bool) (processedRow []string, missingRow []string, missingFields []string, isSuccess bool) {
+	processedRow = make([]string, len(order))
+	missingRow = make([]string, len(order))
+	missingFields = make([]string, 0, len(order))
+	isSuccess = true
+
+	for fieldIndex, expectedField := range order {
+		var fieldDef config.Field
+		for _, field := range fieldConfig.Fields {
+			if field.Name == expectedField {
+				fieldDef = field
+				break
+			}
+		}
+		isMandatory := fieldConfig.IsFieldMandatory(fieldDef)
+		missingValue := fieldDef.MissingValue()
+		// A field required only under a condition is mandatory in the rows meeting it,
+		// and its missing value names the condition
+		if !isMandatory && fieldDef.RequiredIf != nil {
+			conditionValue := rowFieldValue(row, normalizedHeaders, fieldMappings, fieldConfig, fieldDef.RequiredIf.Field, date1904)
+			if fieldDef.RequiredIf.Holds(conditionValue) {
+				isMandatory = true
+				missingValue = fieldDef.RequiredIfMissingValue()
+			}
+		}
+
+		// Lookup fields are populated from their source field's value rather than a mapping
+		if fieldDef.Lookup != nil {
+			value, matched := "", false
+			sourceValue := fieldMappings[fieldDef.Lookup.SourceField].value(row, normalizedHeaders)
+			if sourceValue != "" {
+				value, matched = fieldDef.Lookup.Resolve(sourceValue)
+			}
+			value = fieldDef.Transform(value)
+			processedRow[fieldIndex] = value
+			missingRow[fieldIndex] = value
+			unmatched := sourceValue != "" && !matched && fieldDef.Lookup.FlagUnmatched
+			if unmatched || (isMandatory && strings.TrimSpace(value) == "") {
+				missingFields = append(missingFields, expectedField)
+				isSuccess = false
+				missingRow[fieldIndex] = missingValue
+			}
+			continue
+		}
+
+		mapping := fieldMappings[expectedField]
+
+		// If the mapping is empty (no column selected) and not mandatory,
+		// just leave it blank without marking it missing
+		if mapping == nil && !isMandatory {
+			processedRow[fieldIndex] = ""
+			missingRow[fieldIndex] = ""
+			continue
+		}
+
+		if rawValue := mapping.value(row, normalizedHeaders); rawValue != "" {
+			value := fieldDef.Transform(fieldDef.ConvertDateSerial(rawValue, date1904))
+			processedRow[fieldIndex] = value
+			missingRow[fieldIndex] = value
+		} else {
+			// Only add to missing fields if it's mandatory
+			if isMandatory {
+				missingFields = append(missingFields, expectedField)
+				isSuccess = false
+				missingRow[fieldIndex] = missingValue
+			} else {
+				// For non-mandatory fields, only mark as missing if a mapping was selected
+				if mapping != nil {
+					missingRow[fieldIndex] = missingValue
+				} else {
+					missingRow[fieldIndex] = ""
+				}
+			}
+			processedRow[fieldIndex] = ""
+		}
+	}
+
+	return processedRow, missingRow, missingFields, isSuccess
+}
+
+// rowFieldValue returns the output value of the named field in row: its mapped value, or
+// for a lookup field the looked up value, after the field's date conversion and transforms
+func rowFieldValue(row []string, normalizedHeaders []string, fieldMappings map[string]fieldMapping, fieldConfig *config.FieldConfig, name string, date1904 bool) string {
+	for _, field := range fieldConfig.Fields {
+		if field.Name != name {
+			continue
+		}
+		if field.Lookup != nil {
+			value := ""
+			if sourceValue := fieldMappings[field.Lookup.SourceField].value(row, normalizedHeaders); sourceValue != "" {
+				value, _ = field.Lookup.Resolve(sourceValue)
+			}
+			return field.Transform(value)
+		}
+		return field.Transform(field.ConvertDateSerial(fieldMappings[name].value(row, normalizedHeaders), date1904))
+	}
+	return ""
+}
+
+// outputFormatSpec describes the file extension and HTTP content type of an output format
+type outputFormatSpec struct {
+	extension   string
+	contentType string
+}
+
+// outputFormats is the single source of truth for output file extensions and content types
+var outputFormats = map[string]outputFormatSpec{
+	"xlsx":     {extension: ".xlsx", contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	"csv":      {extension: ".csv", contentType: "text/csv; charset=utf-8"},
+	"markdown": {extension: ".md", contentType: "text/markdown; charset=utf-8"},
+	"ndjson":   {extension: ".ndjson", contentType: "application/x-ndjson"},
+	"sql":      {extension: ".sql", contentType: "application/sql"},
+}
+
+// lookupOutputFormat returns the spec for an output format. "excel" and any unrecognised
+// format produce XLSX output.
+func lookupOutputFormat(format string) outputFormatSpec {
+	if spec, ok := outputFormats[format]; ok {
+		return spec
+	}
+	return outputFormats["xlsx"]
+}
+
+// checkOutputFormat returns an error when ALLOWED_OUTPUT_FORMATS is set and does not list
+// format. "excel" and unrecognised formats are checked as XLSX,
+// which is what they produce.
+func checkOutputFormat(format string) error {
+	allowed := featureFlags.AllowedOutputFormats
+	if len(allowed) == 0 {
+		return nil
+	}
+	if _, ok := outputFormats[format]; !ok {
+		format = "xlsx"
+	}
+	if slices.Contains(allowed, format) {
+		return nil
+	}
+	return fmt.Errorf("output format %q is not allowed: permitted formats are %s", format, strings.Join(allowed, ", "))
+}
+
+// outputFilePaths returns the processed and missing data paths for an upload in the given format
+func outputFilePaths(uniqueID, format string) (processedPath, missingPath string) {
+	extension := lookupOutputFormat(format).extension
+	return fmt.Sprintf("./uploads/%s_processed_data%s", uniqueID, extension),
+		fmt.Sprintf("./uploads/%s_missing_data%s", uniqueID, extension)
+}
+
+// Output scopes select which of the processed and missing outputs are generated
+const (
+	outputScopeProcessed = "processed"
+	outputScopeMissing   = "missing"
+	outputScopeBoth      = "both"
+)
+
+// processOptions holds optional per-request processing settings. The zero value
+// reproduces the default behaviour.
+type processOptions struct {
+	// outputScope is one of the outputScope constants; empty means both
+	outputScope string
+	// csvPreamble writes comment lines with generation details before the CSV header
+	csvPreamble bool
+	// generatedAt is the generation time written in the preamble; zero means the time the
+	// output is written. Setting it keeps preambled output deterministic.
+	generatedAt time.Time
+	// csvCommentChar prefixes preamble lines; zero means '#'
+	csvCommentChar rune
+	// outputBOM starts CSV outputs with a UTF-8 byte-order mark
+	outputBOM bool
+	// outputCRLF ends the lines of CSV outputs with CRLF instead of LF
+	outputCRLF bool
+	// csvDelimiter separates the values of CSV outputs; zero means defaultCSVDelimiter
+	csvDelimiter rune
+	// delimiterPolicy is one of the delimiterPolicy constants; empty means quote.
+	// delimiterSubstitute replaces in-value delimiters with the substitute policy.
+	delimiterPolicy     string
+	delimiterSubstitute string
+	// csvDialect is how a CSV input is written; the zero value is standard
+	csvDialect csvDialect
+	// headerRows folds several header rows into composite header names
+	headerRows headerRowsOption
+	// skipRows skips this many data rows below the header before processing
+	skipRows int
+	// limitRows processes at most this many data rows; zero means no limit
+	limitRows int
+	// maxErrors stops processing, without writing any output, once more rows than this go
+	// to the missing data output; zero means no limit
+	maxErrors int
+	// sampleSize processes a pseudo-random sample of this many rows of the window instead
+	// of all of them; the same sampleSeed always picks the same rows of a file
+	sampleSize int
+	sampleSeed int64
+	// randomSample is set when sampleSeed was drawn rather than sent, so the sample differs
+	// between otherwise identical requests
+	randomSample bool
+	// rowWorkers is the number of goroutines mapping rows; zero or one maps them sequentially
+	rowWorkers int
+	// flushRecords is the number of streamed NDJSON records or events between flushes of
+	// the response; zero flushes every event and leaves NDJSON to the response buffer
+	flushRecords int
+	// includeQualityScore appends a qualityScoreColumn to ProcessedData
+	includeQualityScore bool
+	// includeWarnings appends a ruleWarningsColumn to ProcessedData
+	includeWarnings bool
+	// activeSheet is the xlsx sheet, processed or missing, the workbook opens on; empty
+	// leaves the first sheet active. hiddenSheets are the xlsx sheets hidden from view.
+	activeSheet  string
+	hiddenSheets []string
+	// unifiedOutput writes every row, including missing, invalid and duplicate ones, to
+	// ProcessedData with a rowStatusColumn and rowReasonColumn, instead of splitting them
+	unifiedOutput bool
+	// includeStats adds per-field fill counts to the summary
+	includeStats bool
+	// trimCells trims surrounding whitespace from every input cell
+	trimCells bool
+	// mergeAllSheets concatenates the rows of every sheet of an XLSX input
+	mergeAllSheets bool
+	// outputMandatoryOnly restricts the output columns to the mandatory fields in config order
+	outputMandatoryOnly bool
+	// dropEmptyColumns removes optional field columns that are empty in every processed row
+	dropEmptyColumns bool
+	// ndjsonOmitEmpty leaves empty values out of ndjson objects instead of emitting ""
+	ndjsonOmitEmpty bool
+	// emptyAsNull writes empty values as null in ndjson and NULL in SQL instead of ""
+	emptyAsNull bool
+	// summarySidecar also writes the summary as JSON to <id>_summary.json
+	summarySidecar bool
+	// markdownTitle replaces the heading of markdown reports
+	markdownTitle string
+	// markdownOmitSummary leaves the summary block out of the markdown report
+	markdownOmitSummary bool
+	// markdownAlign sets markdown column alignment by field Name, overriding the field config
+	markdownAlign map[string]string
+	// markdownMaxRows caps the rows of each markdown table; zero means no limit
+	markdownMaxRows int
+	// outputPassword encrypts the processed and missing outputs: XLSX workbooks are
+	// password-protected and text outputs sealed by encryption.Seal, as are the duplicates,
+	// groups and provenance reports. It must never be logged.
+	outputPassword string
+	// passthroughColumns are source headers copied as-is after the field columns of processed rows
+	passthroughColumns []string
+	// unmappedColumns is one of the unmappedColumns constants; empty means ignore
+	unmappedColumns string
+	// displayHeaders heads the field columns of XLSX, CSV and markdown outputs with each
+	// field's DisplayName instead of its Name
+	displayHeaders bool
+	// transforms are request-level transforms by field Name, applied after the field's configured ones
+	transforms map[string][]string
+	// outputHeaders are request-level headers for field columns by field Name, overriding
+	// the header style in every output format
+	outputHeaders map[string]string
+	// sqlTable is the table SQL output inserts into; sqlBatchSize is the number of rows per INSERT
+	sqlTable     string
+	sqlBatchSize int
+	// sqlIdentifiers is one of the sqlIdentifiers constants; empty means quoted
+	sqlIdentifiers string
+	// sqlDialect is one of the SQL dialect constants; empty means ANSI
+	sqlDialect string
+	// numericColumns are the output columns written unquoted in SQL output
+	numericColumns map[string]bool
+	// dedupeBy lists the fields, by Name or DisplayName, whose values identify duplicate
+	// processed rows; only the first occurrence of each key is output
+	dedupeBy []string
+	// dedupeReport writes the dropped duplicates and the rows they repeat to <id>_duplicates.csv
+	dedupeReport bool
+	// manifest writes a Manifest of every artifact produced to <id>_manifest.json
+	manifest bool
+	// retainInput keeps the upload in ./uploads after processing so it can be downloaded
+	// by its returned name; otherwise the handler deletes it once processed
+	retainInput bool
+	// provenance writes the source of every output field to <id>_provenance.json
+	provenance bool
+	// groupBy names a field, by Name or DisplayName, whose values the processed rows are
+	// counted by in <id>_groups.json; aggregate optionally names a numeric field summed
+	// and averaged per group
+	groupBy   string
+	aggregate string
+	// expectedHeaders, when set, must match the file's headers or the file is rejected;
+	// expectedHeadersOrdered also requires them in the same order
+	expectedHeaders        []string
+	expectedHeadersOrdered bool
+	// dateField names a date field whose value must fall within dateFrom and dateTo for a
+	// row to be output; a zero bound is open
+	dateField        string
+	dateFrom, dateTo time.Time
+	// dateReportExcluded lists the rows excluded by the date filter in the summary
+	dateReportExcluded bool
+	// stream, when set, receives the output returned to the client instead of its file;
+	// the summary is added to its headers before anything is written
+	stream *responseStream
+	// events, when set, is told about progress and failing rows as rows are routed
+	events processEvents
+}
+
+// processEvents receives updates from processFileWithOptions while it routes mapped rows
+// to the outputs, for clients watching a long file being processed. Rows are routed on the
+// goroutine that called processFileWithOptions while later ones are still being mapped, so
+// a handler can write the updates to its response as they come.
+type processEvents interface {
+	// progress reports that done of the total rows to process have been routed
+	progress(done, total int)
+	// rowFailed reports a row routed to the missing data output
+	rowFailed(detail missingRowDetail)
+}
+
+// progressEventRows is the number of rows routed between two progress updates
+const progressEventRows = 500
+
+// parseProcessOptions reads the optional processing settings shared by the UI and API form
+func parseProcessOptions(r *http.Request) (processOptions, error) {
+	var opts processOptions
+	var err error
+
+	if opts.outputScope, err = parseOutputScope(r.FormValue("outputScope")); err != nil {
+		return opts, err
+	}
+	opts.outputPassword = r.FormValue("outputPassword")
+	if opts.outputPassword == "" {
+		opts.outputPassword = featureFlags.OutputPassword
+	}
+	if opts.csvDialect, err = parseCSVDialect(r.FormValue("csvDialect")); err != nil {
+		return opts, err
+	}
+	if opts.outputBOM, err = parseBoolFormValue(r, "outputBOM", false); err != nil {
+		return opts, err
+	}
+	if opts.outputCRLF, err = parseBoolFormValue(r, "outputCRLF", false); err != nil {
+		return opts, err
+	}
+	if opts.csvPreamble, err = parseBoolFormValue(r, "csvPreamble", false); err != nil {
+		return opts, err
+	}
+	if value := strings.TrimSpace(r.FormValue("generatedAt")); value != "" {
+		if opts.generatedAt, err = time.Parse(time.RFC3339, value); err != nil {
+			return opts, fmt.Errorf("invalid generatedAt value %q: must be an RFC 3339 time such as 2024-01-02T15:04:05Z", value)
+		}
+	}
+	if err = parseCSVDelimiter(r, &opts); err != nil {
+		return opts, err
+	}
+	if commentChar := r.FormValue("csvCommentChar"); commentChar != "" {
+		runes := []rune(commentChar)
+		if len(runes) != 1 || strings.ContainsRune("\"\r\n", runes[0]) || runes[0] == cmp.Or(opts.csvDelimiter, defaultCSVDelimiter) {
+			return opts, fmt.Errorf("invalid csvCommentChar %q: must be a single character other than the delimiter, quote or newline", commentChar)
+		}
+		opts.csvCommentChar = runes[0]
+	}
+	if opts.includeQualityScore, err = parseBoolFormValue(r, "includeQualityScore", false); err != nil {
+		return opts, err
+	}
+	if opts.includeWarnings, err = parseBoolFormValue(r, "includeWarnings", false); err != nil {
+		return opts, err
+	}
+	if opts.unifiedOutput, err = parseBoolFormValue(r, "unifiedOutput", false); err != nil {
+		return opts, err
+	}
+	// The single unified output takes the place of the processed one
+	if opts.unifiedOutput {
+		if opts.outputScope == outputScopeMissing {
+			return opts, fmt.Errorf("unifiedOutput cannot be combined with outputScope missing")
+		}
+		opts.outputScope = outputScopeProcessed
+	}
+	if err = parseSheetVisibility(r, &opts); err != nil {
+		return opts, err
+	}
+	if opts.includeStats, err = parseBoolFormValue(r, "includeStats", featureFlags.EnableStats); err != nil {
+		return opts, err
+	}
+	if opts.trimCells, err = parseBoolFormValue(r, "trimCells", featureFlags.TrimCells); err != nil {
+		return opts, err
+	}
+	if opts.mergeAllSheets, err = parseBoolFormValue(r, "mergeAllSheets", false); err != nil {
+		return opts, err
+	}
+	if opts.headerRows, err = parseHeaderRows(r); err != nil {
+		return opts, err
+	}
+	if opts.headerRows.rows > 1 && opts.mergeAllSheets {
+		return opts, fmt.Errorf("headerRows cannot be combined with mergeAllSheets")
+	}
+	if opts.headerRows.units && opts.mergeAllSheets {
+		return opts, fmt.Errorf("unitsRow cannot be combined with mergeAllSheets")
+	}
+	if opts.outputMandatoryOnly, err = parseBoolFormValue(r, "outputMandatoryOnly", false); err != nil {
+		return opts, err
+	}
+	if opts.dropEmptyColumns, err = parseBoolFormValue(r, "dropEmptyColumns", false); err != nil {
+		return opts, err
+	}
+	if opts.ndjsonOmitEmpty, err = parseBoolFormValue(r, "ndjsonOmitEmpty", false); err != nil {
+		return opts, err
+	}
+	if opts.emptyAsNull, err = parseBoolFormValue(r, "emptyAsNull", false); err != nil {
+		return opts, err
+	}
+	if opts.summarySidecar, err = parseBoolFormValue(r, "summarySidecar", false); err != nil {
+		return opts, err
+	}
+	opts.markdownTitle = strings.TrimSpace(r.FormValue("markdownTitle"))
+	includeSummary, err := parseBoolFormValue(r, "markdownSummary", true)
+	if err != nil {
+		return opts, err
+	}
+	opts.markdownOmitSummary = !includeSummary
+	if opts.markdownAlign, err = parseMarkdownAlign(r.FormValue("markdownAlign")); err != nil {
+		return opts, err
+	}
+	if opts.markdownMaxRows, err = parseNonNegativeIntFormValue(r, "markdownMaxRows"); err != nil {
+		return opts, err
+	}
+	if opts.markdownMaxRows == 0 {
+		opts.markdownMaxRows = featureFlags.MarkdownMaxRows
+	}
+	if opts.passthroughColumns, err = parseHeaderList(r, "passthroughColumns"); err != nil {
+		return opts, err
+	}
+	if opts.unmappedColumns, err = parseUnmappedColumnsPolicy(r.FormValue("unmappedColumns")); err != nil {
+		return opts, err
+	}
+	switch headerStyle := r.FormValue("headerStyle"); headerStyle {
+	case "", headerStyleName:
+	case headerStyleDisplay:
+		opts.displayHeaders = true
+	default:
+		return opts, fmt.Errorf("invalid headerStyle %q: must be name or display", headerStyle)
+	}
+	if opts.sqlTable = strings.TrimSpace(r.FormValue("sqlTable")); opts.sqlTable != "" && !sqlTablePattern.MatchString(opts.sqlTable) {
+		return opts, fmt.Errorf("invalid sqlTable %q: must be a table name, optionally schema-qualified, of letters, digits and underscores", opts.sqlTable)
+	}
+	if opts.sqlBatchSize, err = parseNonNegativeIntFormValue(r, "sqlBatchSize"); err != nil {
+		return opts, err
+	}
+	switch opts.sqlIdentifiers = strings.TrimSpace(r.FormValue("sqlIdentifiers")); opts.sqlIdentifiers {
+	case "", sqlIdentifiersQuoted, sqlIdentifiersSnake:
+	default:
+		return opts, fmt.Errorf("invalid sqlIdentifiers %q: must be quoted or snake", opts.sqlIdentifiers)
+	}
+	switch opts.sqlDialect = strings.TrimSpace(r.FormValue("sqlDialect")); opts.sqlDialect {
+	case "", sqlDialectANSI, sqlDialectMySQL:
+	default:
+		return opts, fmt.Errorf("invalid sqlDialect %q: must be ansi or mysql", opts.sqlDialect)
+	}
+	if opts.dedupeBy, err = parseHeaderList(r, "dedupeBy"); err != nil {
+		return opts, err
+	}
+	if opts.dedupeReport, err = parseBoolFormValue(r, "dedupeReport", false); err != nil {
+		return opts, err
+	}
+	if opts.manifest, err = parseBoolFormValue(r, "manifest", false); err != nil {
+		return opts, err
+	}
+	if opts.retainInput, err = parseBoolFormValue(r, "retainInput", false); err != nil {
+		return opts, err
+	}
+	if opts.retainInput && opts.outputPassword != "" {
+		return opts, fmt.Errorf("retainInput cannot be combined with outputPassword, as the retained upload is not encrypted")
+	}
+	if opts.provenance, err = parseBoolFormValue(r, "provenance", false); err != nil {
+		return opts, err
+	}
+	if opts.dedupeReport && len(opts.dedupeBy) == 0 {
+		return opts, fmt.Errorf("dedupeReport requires dedupeBy")
+	}
+	opts.groupBy = strings.TrimSpace(r.FormValue("groupBy"))
+	opts.aggregate = strings.TrimSpace(r.FormValue("aggregate"))
+	if opts.aggregate != "" && opts.groupBy == "" {
+		return opts, fmt.Errorf("aggregate requires groupBy")
+	}
+	if opts.expectedHeaders, err = parseHeaderList(r, "expectedHeaders"); err != nil {
+		return opts, err
+	}
+	if opts.expectedHeadersOrdered, err = parseBoolFormValue(r, "expectedHeadersOrdered", false); err != nil {
+		return opts, err
+	}
+	if opts.transforms, err = parseFieldTransforms(r.FormValue("transforms")); err != nil {
+		return opts, err
+	}
+	if opts.outputHeaders, err = parseOutputHeaders(r.FormValue("outputHeaders")); err != nil {
+		return opts, err
+	}
+	if err = parseDateFilter(r, &opts); err != nil {
+		return opts, err
+	}
+	if opts.skipRows, err = parseNonNegativeIntFormValue(r, "skipRows"); err != nil {
+		return opts, err
+	}
+	if opts.limitRows, err = parseNonNegativeIntFormValue(r, "limitRows"); err != nil {
+		return opts, err
+	}
+	if opts.maxErrors, err = parseNonNegativeIntFormValue(r, "maxErrors"); err != nil {
+		return opts, err
+	}
+	if opts.sampleSize, err = parseNonNegativeIntFormValue(r, "sampleSize"); err != nil {
+		return opts, err
+	}
+	// Without a seed each request draws a new sample; the summary reports the seed used
+	// so that it can be repeated
+	if value := strings.TrimSpace(r.FormValue("sampleSeed")); value != "" {
+		if opts.sampleSeed, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return opts, fmt.Errorf("invalid sampleSeed value %q: must be an integer", value)
+		}
+	} else if opts.sampleSize > 0 {
+		opts.sampleSeed = mathrand.Int64()
+		opts.randomSample = true
+	}
+	if opts.rowWorkers, err = parseNonNegativeIntFormValue(r, "rowWorkers"); err != nil {
+		return opts, err
+	}
+	if opts.rowWorkers > config.MaxRowWorkers {
+		return opts, fmt.Errorf("invalid rowWorkers value %d: must be at most %d", opts.rowWorkers, config.MaxRowWorkers)
+	}
+	if opts.rowWorkers == 0 {
+		opts.rowWorkers = featureFlags.RowWorkers
+	}
+	if opts.flushRecords, err = parseNonNegativeIntFormValue(r, "flushRecords"); err != nil {
+		return opts, err
+	}
+	if opts.flushRecords == 0 {
+		opts.flushRecords = featureFlags.StreamFlushRecords
+	}
+	return opts, nil
+}
+
+// parseDateFilter reads the dateField, dateFrom, dateTo and dateReportExcluded form fields.
+// Bounds are dates in the DefaultDateFormat layout and at least one is required with dateField.
+func parseDateFilter(r *http.Request, opts *processOptions) error {
+	opts.dateField = strings.TrimSpace(r.FormValue("dateField"))
+	for _, bound := range []struct {
+		name  string
+		value *time.Time
+	}{{"dateFrom", &opts.dateFrom}, {"dateTo", &opts.dateTo}} {
+		value := strings.TrimSpace(r.FormValue(bound.name))
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(config.DefaultDateFormat, value)
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q: must be a date such as 2024-01-31", bound.name, value)
+		}
+		*bound.value = parsed
+	}
+	hasBound := !opts.dateFrom.IsZero() || !opts.dateTo.IsZero()
+	if opts.dateField == "" && hasBound {
+		return fmt.Errorf("dateFrom and dateTo require dateField")
+	}
+	if opts.dateField != "" && !hasBound {
+		return fmt.Errorf("dateField requires dateFrom, dateTo or both")
+	}
+	if !opts.dateFrom.IsZero() && !opts.dateTo.IsZero() && opts.dateTo.Before(opts.dateFrom) {
+		return fmt.Errorf("dateTo must not be before dateFrom")
+	}
+	var err error
+	opts.dateReportExcluded, err = parseBoolFormValue(r, "dateReportExcluded", false)
+	return err
+}
+
+// errNoMappings is returned for a request without any field mappings
+var errNoMappings = errors.New("no field mappings provided: map at least one field, or set allowEmptyMappings to true")
+
+// checkEmptyMappings rejects requests whose mappings name no column, which would send
+// every row to the missing data output, unless they set allowEmptyMappings, as runs that
+// only pass columns through or rely on field aliases may
+func checkEmptyMappings(r *http.Request, fieldMappings map[string]fieldMapping) error {
+	if slices.ContainsFunc(slices.Collect(maps.Values(fieldMappings)), func(mapping fieldMapping) bool { return mapping != nil }) {
+		return nil
+	}
+	allow, err := parseBoolFormValue(r, "allowEmptyMappings", false)
+	if err != nil {
+		return err
+	}
+	if !allow {
+		return errNoMappings
+	}
+	return nil
+}
+
+// unmappedMandatoryFields returns the mandatory fields, in output order, that fieldMappings
+// gives no column. Lookup fields are populated without a mapping and are left out.
+func unmappedMandatoryFields(fieldConfig *config.FieldConfig, fieldMappings map[string]fieldMapping) []config.Field {
+	var unmapped []config.Field
+	for _, field := range fieldConfig.OrderedFieldList() {
+		if fieldConfig.IsFieldMandatory(field) && field.Lookup == nil && fieldMappings[field.Name] == nil {
+			unmapped = append(unmapped, field)
+		}
+	}
+	return unmapped
+}
+
+// checkMandatoryMappings rejects requests, before anything is processed, whose mappings
+// leave a mandatory field without a column, which would send every row to the missing data
+// output, unless they set allowUnmappedMandatory. fieldMappings must be keyed by field
+// Name. Fields with aliases may still be mapped from the file's headers, so only those
+// without are checked.
+func checkMandatoryMappings(r *http.Request, fieldMappings map[string]fieldMapping) error {
+	var names []string
+	for _, field := range unmappedMandatoryFields(currentFieldConfig(), fieldMappings) {
+		if len(field.Aliases) == 0 {
+			names = append(names, field.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	allow, err := parseBoolFormValue(r, "allowUnmappedMandatory", false)
+	if err != nil || allow {
+		return err
+	}
+	if len(names) == 1 {
+		return fmt.Errorf("mandatory field %s has no mapping: map it, or set allowUnmappedMandatory to true", names[0])
+	}
+	return fmt.Errorf("mandatory fields %s have no mapping: map them, or set allowUnmappedMandatory to true", strings.Join(names, ", "))
+}
+
+// tooManyFieldsMessage explains the MAX_MAPPING_FIELDS limit to the client
+func tooManyFieldsMessage() string {
+	return fmt.Sprintf("Too many mapping fields: at most %d are allowed", featureFlags.MaxMappingFields)
+}
+
+// processRequest is what a processing request asks for, once checked by
+// checkProcessRequest
+type processRequest struct {
+	fieldMappings map[string]fieldMapping
+	outputFormat  string
+	opts          processOptions
+}
+
+// parseProcessRequest decodes the JSON field mappings of an API processing request and
+// checks them, its output format and its options with checkProcessRequest
+func parseProcessRequest(r *http.Request, mappings string) (processRequest, error) {
+	fieldMappings, err := parseFieldMappings(mappings)
+	if err != nil {
+		return processRequest{}, errors.New("Invalid field mappings format")
+	}
+	return checkProcessRequest(r, fieldMappings, "xlsx")
+}
+
+// checkProcessRequest checks everything about a processing request that can be rejected
+// before its file is read: the number of mappings, which are then keyed by field Name,
+// empty and mandatory mappings, the output format, which defaults to DEFAULT_OUTPUT_FORMAT
+// or else defaultFormat, and the processing options. The error's message is meant for the
+// client, who gets it with a 400.
+func checkProcessRequest(r *http.Request, fieldMappings map[string]fieldMapping, defaultFormat string) (processRequest, error) {
+	if len(fieldMappings) > featureFlags.MaxMappingFields {
+		return processRequest{}, errors.New(tooManyFieldsMessage())
+	}
+	if err := checkEmptyMappings(r, fieldMappings); err != nil {
+		return processRequest{}, errors.New(describeInputError(err))
+	}
+	// Mappings may be keyed by DisplayName as well as Name
+	fieldMappings = config.NormalizeMappings(currentFieldConfig(), fieldMappings)
+	if err := checkMandatoryMappings(r, fieldMappings); err != nil {
+		return processRequest{}, errors.New(describeInputError(err))
+	}
+
+	outputFormat := r.FormValue("outputFormat")
+	if outputFormat == "" {
+		outputFormat = defaultFormat
+		if featureFlags.DefaultOutputFormat != "" {
+			outputFormat = featureFlags.DefaultOutputFormat
+		}
+	}
+	if err := checkOutputFormat(outputFormat); err != nil {
+		return processRequest{}, errors.New(describeInputError(err))
+	}
+
+	opts, err := parseProcessOptions(r)
+	if err != nil {
+		return processRequest{}, err
+	}
+	return processRequest{fieldMappings: fieldMappings, outputFormat: outputFormat, opts: opts}, nil
+}
+
+// parseNonNegativeIntFormValue parses an optional non-negative integer form field, treating absence as zero
+func parseNonNegativeIntFormValue(r *http.Request, name string) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid %s value %q: must be a non-negative integer", name, value)
+	}
+	return parsed, nil
+}
+
+// parseHeaderList parses a form field listing headers, either as a JSON array of header
+// names or a comma-separated list
+func parseHeaderList(r *http.Request, name string) ([]string, error) {
+	value := strings.TrimSpace(r.FormValue(name))
+	if value == "" {
+		return nil, nil
+	}
+	var columns []string
+	if strings.HasPrefix(value, "[") {
+		if err := json.Unmarshal([]byte(value), &columns); err != nil {
+			return nil, fmt.Errorf("invalid %s: must be a JSON array or comma-separated list of headers", name)
+		}
+	} else {
+		for _, column := range strings.Split(value, ",") {
+			columns = append(columns, strings.TrimSpace(column))
+		}
+	}
+	if len(columns) > featureFlags.MaxMappingFields {
+		return nil, errors.New(tooManyFieldsMessage())
+	}
+	return columns, nil
+}
+
+// parseMarkdownAlign parses the markdownAlign form field, a JSON object of alignments keyed
+// by field Name or DisplayName, into alignments keyed by field Name. Keys that match no
+// field, such as _quality, are kept so extra columns can be aligned too.
+func parseMarkdownAlign(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var alignments map[string]string
+	if err := json.Unmarshal([]byte(value), &alignments); err != nil {
+		return nil, fmt.Errorf("invalid markdownAlign: must be a JSON object of field alignments")
+	}
+	for key, alignment := range alignments {
+		if !config.IsValidAlignment(alignment) {
+			return nil, fmt.Errorf("invalid markdownAlign for %q: %q must be left, center or right", key, alignment)
+		}
+	}
+	return config.NormalizeMappings(currentFieldConfig(), alignments), nil
+}
+
+// parseFieldTransforms parses the transforms form field, a JSON object of transform lists
+// keyed by field Name or DisplayName, e.g. {"Client_Code":["upper"]}
+func parseFieldTransforms(value string) (map[string][]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var requested map[string][]string
+	if err := json.Unmarshal([]byte(value), &requested); err != nil {
+		return nil, fmt.Errorf("invalid transforms: must be a JSON object of transform lists keyed by field")
+	}
+	fieldConfig := currentFieldConfig()
+	transforms := make(map[string][]string, len(requested))
+	for key, names := range requested {
+		name, ok := fieldConfig.ResolveFieldName(key)
+		if !ok {
+			return nil, fmt.Errorf("invalid transforms: unknown field %q", key)
+		}
+		checked := names
+		if len(checked) > 0 && checked[0] == config.TransformNone {
+			checked = checked[1:]
+		}
+		if err := config.ValidateTransforms(checked); err != nil {
+			return nil, fmt.Errorf("invalid transforms for %q: %v", key, err)
+		}
+		transforms[name] = names
+	}
+	return transforms, nil
+}
+
+// parseOutputHeaders parses the outputHeaders form field, a JSON object of column headers
+// keyed by field Name or DisplayName, e.g. {"Client_Code":"client"}
+func parseOutputHeaders(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var requested map[string]string
+	if err := json.Unmarshal([]byte(value), &requested); err != nil {
+		return nil, fmt.Errorf("invalid outputHeaders: must be a JSON object of headers keyed by field")
+	}
+	fieldConfig := currentFieldConfig()
+	headers := make(map[string]string, len(requested))
+	for key, header := range requested {
+		name, ok := fieldConfig.ResolveFieldName(key)
+		if !ok {
+			return nil, fmt.Errorf("invalid outputHeaders: unknown field %q", key)
+		}
+		if header = strings.TrimSpace(header); header == "" {
+			return nil, fmt.Errorf("invalid outputHeaders for %q: header must not be empty", key)
+		}
+		headers[name] = header
+	}
+	return headers, nil
+}
+
+// markdownAlignments returns the markdown column alignments for a request: those set in
+// the field config, overridden by the request's own
+func markdownAlignments(fieldConfig *config.FieldConfig, overrides map[string]string) map[string]string {
+	alignments := make(map[string]string)
+	for _, field := range fieldConfig.Fields {
+		if field.MarkdownAlign != "" {
+			alignments[field.Name] = field.MarkdownAlign
+		}
+	}
+	for name, alignment := range overrides {
+		alignments[name] = alignment
+	}
+	return alignments
+}
+
+// parseBoolFormValue parses an optional boolean form field, returning defaultValue when it is absent
+func parseBoolFormValue(r *http.Request, name string, defaultValue bool) (bool, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: must be true or false", name, value)
+	}
+	return parsed, nil
+}
+
+// parseCSVDelimiter parses the outputDelimiter, delimiterPolicy and delimiterSubstitute
+// form fields. The delimiter must be a single character that is not a quote or line break,
+// and a substitute must not contain it.
+func parseCSVDelimiter(r *http.Request, opts *processOptions) error {
+	if delimiter := r.FormValue("outputDelimiter"); delimiter != "" {
+		if delimiter == `\t` {
+			delimiter = "\t"
+		}
+		runes := []rune(delimiter)
+		if len(runes) != 1 || strings.ContainsRune("\"\r\n\uFFFD", runes[0]) {
+			return fmt.Errorf("invalid outputDelimiter %q: must be a single character other than a quote or newline", delimiter)
+		}
+		opts.csvDelimiter = runes[0]
+	}
+	delimiter := cmp.Or(opts.csvDelimiter, defaultCSVDelimiter)
+
+	switch opts.delimiterPolicy = strings.TrimSpace(r.FormValue("delimiterPolicy")); opts.delimiterPolicy {
+	case "", delimiterPolicyQuote:
+		if r.Form.Has("delimiterSubstitute") {
+			return fmt.Errorf("delimiterSubstitute requires delimiterPolicy substitute")
+		}
+	case delimiterPolicySubstitute:
+		opts.delimiterSubstitute = defaultDelimiterSubstitute
+		if r.Form.Has("delimiterSubstitute") {
+			opts.delimiterSubstitute = r.FormValue("delimiterSubstitute")
+		}
+		if strings.ContainsRune(opts.delimiterSubstitute, delimiter) {
+			return fmt.Errorf("invalid delimiterSubstitute %q: must not contain the delimiter %q", opts.delimiterSubstitute, delimiter)
+		}
+	default:
+		return fmt.Errorf("invalid delimiterPolicy %q: must be quote or substitute", opts.delimiterPolicy)
+	}
+	return nil
+}
+
+// parseOutputScope validates the outputScope form value, defaulting to both
+func parseOutputScope(value string) (string, error) {
+	switch value {
+	case "", outputScopeBoth:
+		return outputScopeBoth, nil
+	case outputScopeProcessed, outputScopeMissing:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid output scope %q: must be processed, missing or both", value)
+}
+
+// Policies for source columns that no mapping or passthrough column uses
+const (
+	unmappedColumnsIgnore      = "ignore"
+	unmappedColumnsReport      = "report"
+	unmappedColumnsPassthrough = "passthrough"
+)
+
+// parseUnmappedColumnsPolicy validates the unmappedColumns form value, defaulting to ignore
+func parseUnmappedColumnsPolicy(value string) (string, error) {
+	switch value {
+	case "", unmappedColumnsIgnore:
+		return unmappedColumnsIgnore, nil
+	case unmappedColumnsReport, unmappedColumnsPassthrough:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid unmappedColumns %q: must be ignore, report or passthrough", value)
+}
+
+// unmappedSourceColumns returns the headers, in file order, of the source columns that no
+// mapping reads and that are not passthrough columns. Blank and repeated headers are left out.
+func unmappedSourceColumns(headers []string, fieldMappings map[string]fieldMapping, passthroughColumns []string) []string {
+	used := make(map[string]bool)
+	for _, mapping := range fieldMappings {
+		for _, source := range mapping {
+			used[normalizeHeader(source.column)] = true
+		}
+	}
+	for _, column := range passthroughColumns {
+		used[normalizeHeader(column)] = true
+	}
+
+	var unmapped []string
+	for _, header := range headers {
+		key := normalizeHeader(header)
+		if strings.TrimSpace(header) == "" || used[key] {
+			continue
+		}
+		used[key] = true
+		unmapped = append(unmapped, header)
+	}
+	return unmapped
+}
+
+// rowWindow returns the first and last row indexes (1-based, below the header) to process
+// after skipping skip data rows and taking at most limit rows. A limit of zero means no
+// limit. When nothing remains, end is start-1.
+func rowWindow(dataRowCount, skip, limit int) (start, end int) {
+	// skip and limit come from the request and may be as large as an int allows, so they
+	// are compared with the rows left rather than added to indexes
+	start = 1 + min(skip, dataRowCount)
+	end = dataRowCount
+	if limit > 0 && limit < end-start+1 {
+		end = start + limit - 1
+	}
+	return start, end
+}
+
+// describeRowWindow explains which data rows were processed when skipRows or limitRows is used
+func describeRowWindow(dataRowCount, start, end int, opts processOptions) string {
+	if start > dataRowCount {
+		return fmt.Sprintf("Note: skipRows (%d) is beyond the last data row (%d); no rows were processed.\n", opts.skipRows, dataRowCount)
+	}
+	if start > end {
+		return fmt.Sprintf("Note: no data rows were processed out of %d.\n", dataRowCount)
+	}
+	return fmt.Sprintf("Row Window: data rows %d-%d of %d (skipRows=%d, limitRows=%d)\n", start, end, dataRowCount, opts.skipRows, opts.limitRows)
+}
+
+// sampleRows picks size of the row indexes from start to end by reservoir sampling with a
+// generator seeded by seed, and returns them in input order. The same arguments always
+// pick the same indexes.
+func sampleRows(start, end, size int, seed int64) []int {
+	rng := mathrand.New(mathrand.NewPCG(uint64(seed), 0))
+	sample := make([]int, 0, size)
+	for i := start; i <= end; i++ {
+		if seen := i - start; seen < size {
+			sample = append(sample, i)
+		} else if j := rng.IntN(seen + 1); j < size {
+			sample[j] = i
+		}
+	}
+	slices.Sort(sample)
+	return sample
+}
+
+// rowLocation converts an index into the input rows to the 1-based row number a user sees
+// in their spreadsheet, where the header is row 1 and the first data row is row 2, or
+// lower when several header rows were combined or a units row follows the header. For a
+// merged workbook the number is relative to the sheet the row came from, which is also
+// returned; each sheet's header is its row 1.
+func rowLocation(index int, info inputInfo) (string, int) {
+	if info.sheetCounts == nil {
+		return "", index + 1 + info.extraHeaderRows
+	}
+	dataIndex := index - 1
+	for _, sheet := range info.sheetCounts {
+		if dataIndex < sheet.rows {
+			return sheet.name, dataIndex + 2
+		}
+		dataIndex -= sheet.rows
+	}
+	return "", index + 1
+}
+
+// describeRowLocation formats a spreadsheet row for the summary, naming its sheet if known
+func describeRowLocation(sheet string, rowNumber int) string {
+	if sheet == "" {
+		return fmt.Sprintf("Row %d", rowNumber)
+	}
+	return fmt.Sprintf("Row %d of sheet %q", rowNumber, sheet)
+}
+
+// trimCells returns a copy of row with surrounding whitespace removed from every cell
+func trimCells(row []string) []string {
+	trimmed := make([]string, len(row))
+	for i, cell := range row {
+		trimmed[i] = strings.TrimSpace(cell)
+	}
+	return trimmed
+}
+
+// describeFieldStats lists how many processed rows had a value for each field
+func describeFieldStats(order []string, populatedCounts []int, totalRows int) string {
+	var sb strings.Builder
+	sb.WriteString("\nField Statistics:\n")
+	for i, fieldName := range order {
+		sb.WriteString(fmt.Sprintf("  %s: %d/%d populated\n", fieldName, populatedCounts[i], totalRows))
+	}
+	return sb.String()
+}
+
+// describeSheetCounts lists the data rows read from each sheet of a merged workbook
+func describeSheetCounts(sheetCounts []sheetRowCount) string {
+	var sb strings.Builder
+	sb.WriteString("\nSheet Row Counts:\n")
+	for _, sheet := range sheetCounts {
+		sb.WriteString(fmt.Sprintf("  %s: %d\n", sheet.name, sheet.rows))
+	}
+	return sb.String()
+}
+
+// clientInputError returns the message to send for errors caused by the uploaded file
+// itself, or false when err is not the client's fault
+func clientInputError(err error) (string, bool) {
+	switch {
+	case isEmptyInputError(err), errors.Is(err, errSheetHeaderMismatch), errors.Is(err, errInvalidDateFilter), errors.Is(err, errUnexpectedHeaders), errors.Is(err, errInvalidDedupe), errors.Is(err, errInvalidGroupBy),
+		errors.Is(err, errUnsafeZipEntry), errors.Is(err, errNoZipInputs), errors.Is(err, errUnknownSheet):
+		return describeInputError(err), true
+	case errors.Is(err, errParseFile):
+		return "Failed to parse file", true
+	}
+	return "", false
+}
+
+// processingContext returns the context for processing a request: the request's own
+// context, so a client disconnect stops the work, bounded by PROCESSING_TIMEOUT if set
+func processingContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if featureFlags.ProcessingTimeout > 0 {
+		return context.WithTimeout(r.Context(), featureFlags.ProcessingTimeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// errOutputWrite is returned by processFileWithOptions when an output file cannot be written
+var errOutputWrite = errors.New("failed to write output")
+
+// isDiskFullError reports whether err was caused by the disk or the user's quota being full
+func isDiskFullError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// insufficientStorageMessage tells the client why a full disk failed their request
+const insufficientStorageMessage = "Insufficient storage: the server has no disk space left to write the output. Try again later or with a smaller file"
+
+// outputWriteError maps a failure writing the outputs to an HTTP status and message:
+// 507 when the disk is full and 500 otherwise
+func outputWriteError(err error) (int, string, bool) {
+	switch {
+	case !errors.Is(err, errOutputWrite):
+		return 0, "", false
+	case errors.Is(err, errPostProcess):
+		return http.StatusInternalServerError, "Post-processing of the output failed", true
+	case isDiskFullError(err):
+		return http.StatusInsufficientStorage, insufficientStorageMessage, true
+	}
+	return http.StatusInternalServerError, "Failed to write output file", true
+}
+
+// inputTooLargeMessage explains the MAX_INPUT_BYTES limit to the client
+func inputTooLargeMessage() string {
+	return fmt.Sprintf("Input too large: the file exceeds the %d byte processing limit once decompressed", featureFlags.MaxInputBytes)
+}
+
+// interruptedProcessingError maps a processing error caused by the context ending to an
+// HTTP status and message: 503 when the server-side deadline passed and 408 when the
+// client went away
+func interruptedProcessingError(err error) (int, string, bool) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, "Processing timed out", true
+	case errors.Is(err, context.Canceled):
+		return http.StatusRequestTimeout, "Request cancelled", true
+	}
+	return 0, "", false
+}
+
+// errErrorBudgetExceeded is returned by processFileWithOptions, along with the summary of
+// the rows routed so far, when more rows than maxErrors have missing or invalid data
+var errErrorBudgetExceeded = errors.New("too many rows with missing or invalid data")
+
+// sendErrorBudgetError answers a request stopped by maxErrors with a 422 carrying the
+// partial summary
+func sendErrorBudgetError(w http.ResponseWriter, err error, summary string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: describeInputError(err), Summary: summary})
+}
+
+// storeOutputsFailedMessage is the error a client gets when storeOutputs fails
+const storeOutputsFailedMessage = "Failed to store output file"
+
+// sendStoreOutputsError answers with a 500 when storeOutputs fails, listing the outputs it
+// had already stored
+func sendStoreOutputsError(w http.ResponseWriter, stored []StoredOutput) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: storeOutputsFailedMessage, StoredOutputs: stored})
+}
+
+// Errors returned by processFileWithOptions for inputs that contain nothing to process
+var (
+	errNoData     = errors.New("no data found in the file")
+	errNoDataRows = errors.New("file contains headers but no data rows")
+)
+
+// errUnexpectedHeaders is returned when a file's headers do not match expectedHeaders
+var errUnexpectedHeaders = errors.New("file headers do not match the expected headers")
+
+// checkExpectedHeaders compares the file's headers with the expected ones after the same
+// normalization used for mapping. Blank header cells are ignored. Unless ordered is set
+// only the sets of columns are compared.
+func checkExpectedHeaders(headers, expected []string, ordered bool) error {
+	actual := slices.DeleteFunc(normalizeHeaders(headers), func(h string) bool { return h == "" })
+	want := slices.DeleteFunc(normalizeHeaders(expected), func(h string) bool { return h == "" })
+
+	var problems []string
+	if missing := headerDifference(want, actual); len(missing) > 0 {
+		problems = append(problems, "missing columns: "+strings.Join(missing, ", "))
+	}
+	if extra := headerDifference(actual, want); len(extra) > 0 {
+		problems = append(problems, "extra columns: "+strings.Join(extra, ", "))
+	}
+	if len(problems) == 0 && ordered && !slices.Equal(actual, want) {
+		problems = append(problems, fmt.Sprintf("columns are out of order: expected %s, got %s", strings.Join(want, ", "), strings.Join(actual, ", ")))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", errUnexpectedHeaders, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// headerDifference returns the headers of a that b lacks, counting repeated headers
+func headerDifference(a, b []string) []string {
+	remaining := make(map[string]int, len(b))
+	for _, header := range b {
+		remaining[header]++
+	}
+	var difference []string
+	for _, header := range a {
+		if remaining[header] > 0 {
+			remaining[header]--
+			continue
+		}
+		difference = append(difference, header)
+	}
+	return difference
+}
+
+// errInvalidDateFilter is returned when dateField is not a mapped date field
+var errInvalidDateFilter = errors.New("invalid date filter")
+
+// isEmptyInputError reports whether err means the uploaded file had no data rows
+func isEmptyInputError(err error) bool {
+	return errors.Is(err, errNoData) || errors.Is(err, errNoDataRows)
+}
+
+// describeInputError formats an input error as a sentence for summaries
+func describeInputError(err error) string {
+	message := err.Error()
+	return strings.ToUpper(message[:1]) + message[1:]
+}
+
+// processFile processes the file with default options. If the input cannot be read the
+// returned summary and path both describe the error instead.
+func processFile(filePath string, fieldMappings map[string]fieldMapping, order []string, outputFormat string, uniqueID string) (string, string) {
+	summary, outputPath, err := processFileWithOptions(context.Background(), filePath, fieldMappings, order, outputFormat, uniqueID, processOptions{})
+	if err != nil && summary == "" {
+		message := describeInputError(err)
+		return message, message
+	}
+	return summary, outputPath
+}
+
+// processFileWithOptions maps the input file and writes the outputs selected by opts,
+// returning the summary and the path of the primary output file. Errors reading the
+// input are returned with an empty summary. If ctx is cancelled or its deadline passes,
+// processing stops, any output already written is removed and ctx's error is returned.
+func processFileWithOptions(ctx context.Context, filePath string, fieldMappings map[string]fieldMapping, order []string, outputFormat string, uniqueID string, opts processOptions) (string, string, error) {
+	if opts.outputScope == "" {
+		opts.outputScope = outputScopeBoth
+	}
+
+	rows, info, err := readInputFile(ctx, filePath, opts.mergeAllSheets, opts.csvDialect)
+	if err != nil {
+		return "", "", fmt.Errorf("error opening file: %w", err)
+	}
+	rows, info = combineHeaderRows(rows, info, opts.headerRows)
+
+	if len(rows) == 0 {
+		return "", "", errNoData
+	}
+
+	// A header row on its own would silently produce empty outputs
+	if len(rows) == 1 {
+		return "", "", errNoDataRows
+	}
+
+	if opts.expectedHeaders != nil {
+		if err := checkExpectedHeaders(rows[0], opts.expectedHeaders, opts.expectedHeadersOrdered); err != nil {
+			return "", "", err
+		}
+	}
+
+	// Request transforms are merged into a per-request copy of the configuration
+	fieldConfig := currentFieldConfig().WithTransforms(opts.transforms)
+	requestedMappings := fieldMappings
+	fieldMappings = aliasMappings(rows[0], fieldMappings, fieldConfig)
+	opts.markdownAlign = markdownAlignments(fieldConfig, opts.markdownAlign)
+	opts.numericColumns = numericColumns(fieldConfig)
+	var filter *dateFilter
+	if opts.dateField != "" {
+		if filter, err = newDateFilter(fieldConfig, fieldMappings, opts); err != nil {
+			return "", "", err
+		}
+	}
+	if opts.outputMandatoryOnly {
+		// Optional fields are dropped entirely, whatever was mapped
+		order = fieldConfig.GetMandatoryFieldNames()
+	}
+	// Dedupe keys and group columns are indexes into the final order, so they are resolved
+	// once it is known
+	var dedupe *deduplicator
+	if len(opts.dedupeBy) > 0 {
+		if dedupe, err = newDeduplicator(fieldConfig, order, opts.dedupeBy); err != nil {
+			return "", "", err
+		}
+	}
+	var groups *groupCounter
+	if opts.groupBy != "" {
+		if groups, err = newGroupCounter(fieldConfig, order, opts.groupBy, opts.aggregate); err != nil {
+			return "", "", err
+		}
+	}
+	// Columns nobody mapped are listed in the summary or copied to the output on request
+	var unmappedColumns []string
+	if opts.unmappedColumns == unmappedColumnsReport || opts.unmappedColumns == unmappedColumnsPassthrough {
+		unmappedColumns = unmappedSourceColumns(rows[0], fieldMappings, opts.passthroughColumns)
+	}
+	if opts.unmappedColumns == unmappedColumnsPassthrough {
+		opts.passthroughColumns = append(slices.Clone(opts.passthroughColumns), unmappedColumns...)
+	}
+
+	// Proceed with processing the rows (common for both .xlsx and .csv)
+	var missingDetailsBuilder strings.Builder
 	missingCount := 0
+	// Rows kept in ProcessedData despite failing warn-level rules
+	var warningDetailsBuilder strings.Builder
+	warningRows := 0
 	successfulRows := 0
+	var missingRowDetails []missingRowDetail
+
+	// Normalize headers in the first row
+	normalizedHeaders := normalizeHeaders(rows[0])
+
+	// Create a new file for successful rows and missing rows
+	outputFile := createOutputWorkbook(order)
+	for i, column := range opts.passthroughColumns {
+		cell, _ := excelize.CoordinatesToCellName(len(order)+i+1, 1)
+		outputFile.SetCellValue("ProcessedData", cell, column)
+	}
+	extraColumns := len(opts.passthroughColumns)
+	if opts.includeQualityScore {
+		extraColumns++
+		cell, _ := excelize.CoordinatesToCellName(len(order)+extraColumns, 1)
+		outputFile.SetCellValue("ProcessedData", cell, qualityScoreColumn)
+	}
+	if opts.includeWarnings {
+		extraColumns++
+		cell, _ := excelize.CoordinatesToCellName(len(order)+extraColumns, 1)
+		outputFile.SetCellValue("ProcessedData", cell, ruleWarningsColumn)
+	}
+	if opts.unifiedOutput {
+		for _, column := range []string{rowStatusColumn, rowReasonColumn} {
+			extraColumns++
+			cell, _ := excelize.CoordinatesToCellName(len(order)+extraColumns, 1)
+			outputFile.SetCellValue("ProcessedData", cell, column)
+		}
+	}
+	missingColumns := len(order)
+	if fieldConfig.HasValidations() {
+		missingColumns++
+		cell, _ := excelize.CoordinatesToCellName(len(order)+1, 1)
+		outputFile.SetCellValue("MissingData", cell, ruleErrorsColumn)
+	}
+	// Workbooks highlight the missing markers so reviewers can find what to fix
+	markerStyle := 0
+	if lookupOutputFormat(outputFormat) == outputFormats["xlsx"] {
+		if markerStyle, err = styleMissingDataSheet(outputFile, missingColumns); err != nil {
+			return "", "", err
+		}
+	}
+
+	masks := fieldMasks(order, fieldConfig)
+
+	outputRowIndex := 2
+	missingRowIndex := 2
+
+	// Restrict processing to the requested window of data rows below the header
+	dataRowCount := len(rows) - 1
+	start, end := rowWindow(dataRowCount, opts.skipRows, opts.limitRows)
+
+	// Count populated values per field for includeStats, and note the fields populated in
+	// a processed row for dropEmptyColumns
+	populatedCounts := make([]int, len(order))
+	outputPopulated := make([]bool, len(order))
+
+	// Map the rows, in parallel when rowWorkers allows, routing each in input order as soon
+	// as it is mapped. Filtering, deduplication and the counts depend on earlier rows, so
+	// they stay sequential.
+	mapper := rowMapper{normalizedHeaders: normalizedHeaders, fieldMappings: fieldMappings, order: order, fieldConfig: fieldConfig, date1904: info.date1904, trimCells: opts.trimCells}
+	if info.decimalComma {
+		mapper.decimalCommaColumns = decimalCommaColumns(normalizedHeaders, fieldMappings, fieldConfig)
+	}
+	var window [][]string
+	// sampled holds the input index of each window row when the window is sampled
+	var sampled []int
+	if start <= end {
+		window = rows[start : end+1]
+		if opts.sampleSize > 0 && opts.sampleSize < len(window) {
+			sampled = sampleRows(start, end, opts.sampleSize, opts.sampleSeed)
+			window = make([][]string, len(sampled))
+			for k, index := range sampled {
+				window[k] = rows[index]
+			}
+		}
+	}
+	// outputRow masks a row's field values and appends the columns that follow them in ProcessedData
+	outputRow := func(processedRow, row []string, ruleWarnings []string) []string {
+		if masks != nil {
+			processedRow = maskRow(processedRow, processedRow, masks)
+		}
+		for _, column := range opts.passthroughColumns {
+			processedRow = append(processedRow, sourceCell(row, normalizedHeaders, column))
+		}
+		if opts.includeQualityScore {
+			processedRow = append(processedRow, strconv.Itoa(qualityScore(processedRow, order, fieldMappings, fieldConfig)))
+		}
+		if opts.includeWarnings {
+			processedRow = append(processedRow, strings.Join(ruleWarnings, ", "))
+		}
+		return processedRow
+	}
+	// abortedAt is the location of the row that used up the maxErrors budget, and routed the
+	// number of window rows looked at up to it
+	var abortedAt string
+	routed := len(window)
+	// writeUnifiedRow writes a row that is not OK to the unified output
+	writeUnifiedRow := func(processedRow, row []string, ruleWarnings []string, status, reason string) {
+		for fieldIndex, value := range processedRow {
+			outputPopulated[fieldIndex] = outputPopulated[fieldIndex] || value != ""
+		}
+		unifiedRow := append(outputRow(processedRow, row, ruleWarnings), status, reason)
+		outputFile.SetSheetRow("ProcessedData", fmt.Sprintf("A%d", outputRowIndex), &unifiedRow)
+		outputRowIndex++
+	}
+	// routeRow sends a mapped row of the window to its output and updates the counts. Rows
+	// are routed in input order while later ones are still being mapped; it returns false
+	// once the maxErrors budget is used up, which stops the mapping.
+	routeRow := func(offset int, mapped mappedRow) bool {
+		if opts.events != nil && offset > 0 && offset%progressEventRows == 0 {
+			opts.events.progress(offset, len(window))
+		}
+		i := start + offset
+		if sampled != nil {
+			i = sampled[offset]
+		}
+		row := mapped.row
+		if filter != nil && !filter.includes(i, row, normalizedHeaders, info) {
+			return true
+		}
+		processedRow, missingRow, rowMissingFields, rowSuccess := mapped.processed, mapped.missing, mapped.missingFields, mapped.success
+		failedRules, ruleWarnings := mapped.failedRules, mapped.warnings
+		for fieldIndex, value := range processedRow {
+			if value != "" {
+				populatedCounts[fieldIndex]++
+			}
+		}
+
+		if rowSuccess && dedupe != nil && dedupe.isDuplicate(i, processedRow) {
+			if opts.unifiedOutput {
+				sheet, rowNumber := rowLocation(dedupe.dropped[len(dedupe.dropped)-1].originalIndex, info)
+				writeUnifiedRow(processedRow, row, ruleWarnings, rowStatusDuplicate, "Duplicate of "+describeRowLocation(sheet, rowNumber))
+			}
+			return true
+		}
+
+		if rowSuccess {
+			successfulRows++
+			if groups != nil {
+				groups.add(processedRow)
+			}
+			for fieldIndex, value := range processedRow {
+				outputPopulated[fieldIndex] = outputPopulated[fieldIndex] || value != ""
+			}
+			processedRow = outputRow(processedRow, row, ruleWarnings)
+			if opts.unifiedOutput {
+				processedRow = append(processedRow, rowStatusOK, "")
+			}
+			if len(ruleWarnings) > 0 {
+				warningRows++
+				sheet, rowNumber := rowLocation(i, info)
+				warningDetailsBuilder.WriteString(fmt.Sprintf("%s: Validation warnings - %s\n", describeRowLocation(sheet, rowNumber), strings.Join(ruleWarnings, ", ")))
+			}
+			outputFile.SetSheetRow("ProcessedData", fmt.Sprintf("A%d", outputRowIndex), &processedRow)
+			outputRowIndex++
+		} else {
+			missingCount++
+			if opts.unifiedOutput {
+				status, reason := failedRowStatus(rowMissingFields, failedRules)
+				writeUnifiedRow(processedRow, row, ruleWarnings, status, reason)
+			} else {
+				if masks != nil {
+					missingRow = maskRow(missingRow, processedRow, masks)
+				}
+				outputFile.SetSheetRow("MissingData", fmt.Sprintf("A%d", missingRowIndex), &missingRow)
+				if markerStyle != 0 {
+					styleMissingMarkers(outputFile, missingRowIndex, order, rowMissingFields, markerStyle)
+				}
+				missingRowIndex++
+			}
+			sheet, rowNumber := rowLocation(i, info)
+			location := describeRowLocation(sheet, rowNumber)
+			if len(rowMissingFields) > 0 {
+				missingDetailsBuilder.WriteString(fmt.Sprintf("%s: Missing mandatory fields - %s\n", location, strings.Join(rowMissingFields, ", ")))
+			}
+			if len(failedRules) > 0 {
+				missingDetailsBuilder.WriteString(fmt.Sprintf("%s: Failed validation rules - %s\n", location, strings.Join(failedRules, ", ")))
+			}
+			missingRowDetails = append(missingRowDetails, missingRowDetail{Row: rowNumber, Sheet: sheet, MissingFields: rowMissingFields, FailedRules: failedRules})
+			if opts.events != nil {
+				opts.events.rowFailed(missingRowDetails[len(missingRowDetails)-1])
+			}
+			if opts.maxErrors > 0 && missingCount > opts.maxErrors {
+				abortedAt, routed = location, offset+1
+				return false
+			}
+		}
+		return true
+	}
+	if err := mapRows(ctx, window, opts.rowWorkers, mapper.mapRow, routeRow); err != nil {
+		return "", "", err
+	}
+
+	// Rows left out of a sample or excluded by the date filter are not counted as processed
+	processedCount := routed
+	if filter != nil {
+		processedCount -= filter.excluded
+	}
+
+	// A file over the error budget is not worth finishing: only the rows routed so far are
+	// summarized, and no output is written
+	if abortedAt != "" {
+		summary := generateProcessingSummary(processedCount, successfulRows, missingCount, missingDetailsBuilder.String())
+		summary += fmt.Sprintf("Aborted: more than %d rows with missing or invalid data (maxErrors); processing stopped at %s\n", opts.maxErrors, abortedAt)
+		return summary, "", fmt.Errorf("%w: more than %d allowed by maxErrors, stopped at %s", errErrorBudgetExceeded, opts.maxErrors, abortedAt)
+	}
+	if opts.events != nil {
+		opts.events.progress(len(window), len(window))
+	}
+
+	// Generate and output summary, leading with any mapping that emptied every row
+	summary := generateProcessingSummary(processedCount, successfulRows, missingCount, missingDetailsBuilder.String())
+	if start <= end {
+		if warnings := emptyMandatoryColumnWarnings(rows[start:end+1], normalizedHeaders, fieldMappings, order, fieldConfig); warnings != "" {
+			summary = warnings + "\n" + summary
+		}
+	}
+	if opts.skipRows > 0 || opts.limitRows > 0 {
+		summary += describeRowWindow(dataRowCount, start, end, opts)
+	}
+	if opts.sampleSize > 0 {
+		summary += fmt.Sprintf("Sample: %d of %d data rows (sampleSeed=%d)\n", len(window), max(end-start+1, 0), opts.sampleSeed)
+	}
+	if filter != nil {
+		summary += filter.describe()
+	}
+	if dedupe != nil {
+		summary += dedupe.describe()
+	}
+	if groups != nil {
+		summary += groups.describe()
+	}
+	if warningRows > 0 {
+		summary += fmt.Sprintf("Rows with Warnings: %d\n%s", warningRows, warningDetailsBuilder.String())
+	}
+	if opts.includeStats {
+		summary += describeFieldStats(order, populatedCounts, processedCount)
+	}
+	if info.sheetCounts != nil {
+		summary += describeSheetCounts(info.sheetCounts)
+	}
+	if info.units != nil {
+		summary += describeUnits(rows[0], info.units)
+	}
+	if opts.unmappedColumns == unmappedColumnsReport && len(unmappedColumns) > 0 {
+		summary += fmt.Sprintf("Unmapped Columns: %d (%s)\n", len(unmappedColumns), strings.Join(unmappedColumns, ", "))
+	}
+	// The field columns left in the output, in order
+	outputFields := order
+	if opts.dropEmptyColumns {
+		if dropped := dropEmptyColumns(outputFile, order, outputPopulated, fieldConfig); len(dropped) > 0 {
+			summary += fmt.Sprintf("Empty Columns Dropped: %d (%s)\n", len(dropped), strings.Join(dropped, ", "))
+			outputFields = slices.DeleteFunc(slices.Clone(order), func(name string) bool { return slices.Contains(dropped, name) })
+		}
+	}
+	if headers := fieldHeaders(fieldConfig, outputFormat, opts); len(headers) > 0 {
+		opts.markdownAlign, opts.numericColumns = useFieldHeaders(outputFile, order, outputFields, headers, opts.markdownAlign, opts.numericColumns)
+	}
+	if outputFormat == "sql" && opts.sqlIdentifiers == sqlIdentifiersSnake {
+		header, _ := readSheet(outputFile, "ProcessedData", 0)
+		summary += describeSQLColumns(header, sqlColumnNames(header, opts.sqlIdentifiers, opts.sqlDialect))
+	}
+	fmt.Println(summary)
+	if opts.stream != nil {
+		opts.stream.header.Set("X-Processing-Summary", summaryDigest(summary))
+	}
+
+	if dedupe != nil && opts.dedupeReport {
+		if err := dedupe.writeReport(duplicatesReportPath(uniqueID), info, opts); err != nil {
+			removeOutputFiles(uniqueID, outputFormat)
+			return summary, "", fmt.Errorf("%w: %w", errOutputWrite, err)
+		}
+	}
+
+	if opts.summarySidecar {
+		report := processingReport{
+			TotalRows:           processedCount,
+			SuccessfulRows:      successfulRows,
+			RowsWithMissingData: missingCount,
+			MissingRows:         missingRowDetails,
+			Summary:             summary,
+		}
+		if err := writeSummarySidecar(summarySidecarPath(uniqueID), report); err != nil {
+			removeOutputFiles(uniqueID, outputFormat)
+			return summary, "", fmt.Errorf("%w: %w", errOutputWrite, err)
+		}
+	}
+
+	if opts.provenance {
+		provenance := buildProvenance(rows[0], requestedMappings, fieldMappings, order, fieldConfig)
+		if err := writeProvenance(provenancePath(uniqueID), provenance, opts); err != nil {
+			removeOutputFiles(uniqueID, outputFormat)
+			return summary, "", fmt.Errorf("%w: %w", errOutputWrite, err)
+		}
+	}
+
+	if groups != nil {
+		if err := writeGroupReport(groupReportPath(uniqueID), groups.report(), opts); err != nil {
+			removeOutputFiles(uniqueID, outputFormat)
+			return summary, "", fmt.Errorf("%w: %w", errOutputWrite, err)
+		}
+	}
+
+	// Save the output file based on user choice. It is written last so that a streamed
+	// response only starts once every file output has been written.
+	// A failed write may leave partial files behind, so every output of the upload is removed
+	outputFilePath, err := saveOutput(outputFile, outputFormat, outputRowIndex, missingRowIndex, summary, uniqueID, opts)
+	if err != nil {
+		removeOutputFiles(uniqueID, outputFormat)
+		return summary, "", fmt.Errorf("%w: %w", errOutputWrite, err)
+	}
+
+	if featureFlags.PostProcessCommand != "" && opts.stream == nil {
+		if err := runPostProcessCommand(ctx, outputFilePath); err != nil {
+			removeOutputFiles(uniqueID, outputFormat)
+			return summary, "", fmt.Errorf("%w: %w", errOutputWrite, err)
+		}
+	}
+
+	// The manifest lists the files written above, so it comes last
+	if opts.manifest {
+		if err := writeManifest(uniqueID, outputFormat); err != nil {
+			removeOutputFiles(uniqueID, outputFormat)
+			return summary, "", fmt.Errorf("%w: %w", errOutputWrite, err)
+		}
+	}
+
+	// Outputs finished after the deadline are discarded rather than left half-used
+	if err := ctx.Err(); err != nil {
+		removeOutputFiles(uniqueID, outputFormat)
+		return "", "", err
+	}
+	return summary, outputFilePath, nil
+}
+
+// zipUploadExtension marks an upload that is a zip of input files. Only the process
+// endpoints accept it; each supported file inside is processed with the same mappings.
+const zipUploadExtension = ".zip"
+
+// invalidProcessFileTypeMessage rejects process uploads that are neither an input file nor a zip of them
+const invalidProcessFileTypeMessage = invalidFileTypeMessage + ", or a .zip of such files"
+
+// isZipUpload reports whether filename is a zip of input files
+func isZipUpload(filename string) bool {
+	return strings.HasSuffix(filename, zipUploadExtension)
+}
+
+// Errors returned by processZipUpload for archives that cannot be processed
+var (
+	errUnsafeZipEntry = errors.New("zip entry has an unsafe path")
+	errNoZipInputs    = errors.New("zip contains no .csv or .xlsx files")
+)
+
+// zipInput is a supported input file inside an uploaded zip
+type zipInput struct {
+	// name is the entry's cleaned path inside the zip, which also names its folder of outputs
+	name  string
+	entry *zip.File
+}
+
+// zipInputs validates the entry names of an uploaded zip and returns the entries to
+// process: supported input files outside __MACOSX and not hidden. Any entry whose path is
+// absolute or climbs out of the archive rejects the whole zip, as does a total
+// uncompressed size of inputs over MAX_INPUT_BYTES.
+func zipInputs(archive *zip.Reader) ([]zipInput, error) {
+	budget := &byteBudget{limit: featureFlags.MaxInputBytes}
+	var inputs []zipInput
+	for _, entry := range archive.File {
+		name := strings.ReplaceAll(entry.Name, `\`, "/")
+		if !filepath.IsLocal(name) || strings.HasPrefix(name, "/") {
+			return nil, fmt.Errorf("%w: %q", errUnsafeZipEntry, entry.Name)
+		}
+		name = path.Clean(name)
+		if entry.FileInfo().IsDir() || !isSupportedInputFile(name) ||
+			strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		if err := budget.charge(int64(min(entry.UncompressedSize64, math.MaxInt64))); err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, zipInput{name: name, entry: entry})
+	}
+	if len(inputs) == 0 {
+		return nil, errNoZipInputs
+	}
+	return inputs, nil
+}
+
+// extractZipInput copies an input out of the zip to its own upload file and returns the path
+func extractZipInput(input zipInput, uniqueID string) (string, error) {
+	source, err := input.entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+	file, filePath, err := createUploadFile(uniqueID, input.name)
+	if err != nil {
+		return "", err
+	}
+	// archive/zip fails reads that go past the declared size charged by zipInputs
+	if _, err := io.Copy(file, source); err != nil {
+		file.Close()
+		os.Remove(filePath)
+		return "", err
+	}
+	return filePath, file.Close()
+}
+
+// zipOutputPath returns the path of the archive of per-file outputs written for a zip upload
+func zipOutputPath(uniqueID string) string {
+	return fmt.Sprintf("./uploads/%s_outputs.zip", uniqueID)
+}
+
+// processUpload processes an uploaded input file, or every input file in an uploaded zip
+func processUpload(ctx context.Context, filePath string, fieldMappings map[string]fieldMapping, order []string, outputFormat string, uniqueID string, opts processOptions) (string, string, error) {
+	if isZipUpload(filePath) {
+		return processZipUpload(ctx, filePath, fieldMappings, order, outputFormat, uniqueID, opts)
+	}
+	return processFileWithOptions(ctx, filePath, fieldMappings, order, outputFormat, uniqueID, opts)
+}
+
+// processZipUpload processes each input file of a zip upload with the same mappings and
+// options, as if uploaded on its own under the ID <uniqueID>_<n>. Their outputs are
+// collected into one archive, in a folder per input named by its path in the zip, next to
+// a summary.txt of the combined summary. An input that fails with a client input error is
+// reported in the summary and the others are still processed; other errors stop processing
+// and remove every output. The manifest, when requested, lists each input's outputs.
+func processZipUpload(ctx context.Context, zipPath string, fieldMappings map[string]fieldMapping, order []string, outputFormat string, uniqueID string, opts processOptions) (string, string, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", "", fmt.Errorf("error opening file: %w: %v", errParseFile, err)
+	}
+	defer archive.Close()
+	inputs, err := zipInputs(&archive.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Each input writes its own files; the combined manifest is written at the end
+	inputOpts := opts
+	inputOpts.stream = nil
+	inputOpts.manifest = false
+	inputIDs := make([]string, len(inputs))
+	removeAll := func() {
+		for _, inputID := range inputIDs {
+			if inputID != "" {
+				removeOutputFiles(inputID, outputFormat)
+			}
+		}
+		removeOutputFiles(uniqueID, outputFormat)
+	}
+
+	var summaryBuilder strings.Builder
+	manifest := Manifest{Artifacts: []ManifestArtifact{}}
+	var firstInputErr error
+	failed := 0
+	for i, input := range inputs {
+		inputIDs[i] = fmt.Sprintf("%s_%d", uniqueID, i+1)
+		inputPath, err := extractZipInput(input, inputIDs[i])
+		if err != nil {
+			removeAll()
+			if isDiskFullError(err) {
+				return "", "", fmt.Errorf("%w: extracting %s: %w", errOutputWrite, input.name, err)
+			}
+			return "", "", fmt.Errorf("error extracting %s: %w: %v", input.name, errParseFile, err)
+		}
+		summary, _, err := processFileWithOptions(ctx, inputPath, fieldMappings, order, outputFormat, inputIDs[i], inputOpts)
+		os.Remove(inputPath)
+		manifestInput := ManifestInput{Filename: input.name, Artifacts: buildManifest(inputIDs[i], outputFormat).Artifacts}
+		if message, ok := clientInputError(err); ok {
+			failed++
+			if firstInputErr == nil {
+				firstInputErr = fmt.Errorf("%s: %w", input.name, err)
+			}
+			manifestInput.Error = message
+			summary = message + "\n"
+		} else if err != nil {
+			removeAll()
+			// The summary of an input stopped by maxErrors is the partial one
+			return summary, "", err
+		}
+		manifest.Inputs = append(manifest.Inputs, manifestInput)
+		fmt.Fprintf(&summaryBuilder, "\n== %s ==\n%s", input.name, strings.TrimLeft(summary, "\n"))
+	}
+	if failed == len(inputs) {
+		removeAll()
+		return "", "", firstInputErr
+	}
+	summary := fmt.Sprintf("Files Processed: %d\nFiles Failed: %d\n%s", len(inputs), failed, summaryBuilder.String())
+
+	outputPath := zipOutputPath(uniqueID)
+	err = writeOutputFile(outputPath, func(w io.Writer) error {
+		return writeOutputArchive(w, summary, manifest.Inputs, inputIDs)
+	})
+	if err == nil && opts.manifest {
+		manifest.Artifacts = buildManifest(uniqueID, outputFormat).Artifacts
+		var data []byte
+		if data, err = json.MarshalIndent(manifest, "", "  "); err == nil {
+			err = writeOutputBytes(manifestPath(uniqueID), data)
+		}
+	}
+	if err != nil {
+		removeAll()
+		return summary, "", fmt.Errorf("%w: %w", errOutputWrite, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		removeAll()
+		return "", "", err
+	}
+	return summary, outputPath, nil
+}
+
+// outputArchiveTimestamp is the modified time of every entry of an output archive, fixed
+// for the same reason as outputDocTimestamp
+var outputArchiveTimestamp = time.Date(2006, 9, 16, 0, 0, 0, 0, time.UTC)
+
+// writeOutputArchive writes the zip returned for a zip upload: summary.txt, then every
+// file produced for each input, stored under inputIDs, in a folder named by the input's
+// path in the upload
+func writeOutputArchive(w io.Writer, summary string, inputs []ManifestInput, inputIDs []string) error {
+	archive := zip.NewWriter(w)
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: "summary.txt", Method: zip.Deflate, Modified: outputArchiveTimestamp})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(entry, summary); err != nil {
+		return err
+	}
+	for i, input := range inputs {
+		for _, artifact := range input.Artifacts {
+			// The folder takes the place of the input's ID in the stored name
+			name := input.Filename + "/" + strings.TrimPrefix(artifact.Filename, inputIDs[i]+"_")
+			entry, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: outputArchiveTimestamp})
+			if err != nil {
+				return err
+			}
+			file, err := os.Open(filepath.Join("./uploads", artifact.Filename))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(entry, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return archive.Close()
+}
+
+// dateFilter excludes rows whose date field falls outside a requested range
+type dateFilter struct {
+	field      config.Field
+	mapping    fieldMapping
+	from, to   time.Time
+	reportRows bool
+	// excluded counts the rows left out, and notes describes them for the summary
+	excluded int
+	notes    strings.Builder
+}
+
+// newDateFilter builds the filter for opts.dateField, which may be given by Name or
+// DisplayName and must be a mapped date field
+func newDateFilter(fieldConfig *config.FieldConfig, fieldMappings map[string]fieldMapping, opts processOptions) (*dateFilter, error) {
+	name, ok := fieldConfig.ResolveFieldName(opts.dateField)
+	if !ok {
+		return nil, fmt.Errorf("%w: field %q does not exist", errInvalidDateFilter, opts.dateField)
+	}
+	field := fieldConfig.Fields[slices.IndexFunc(fieldConfig.Fields, func(f config.Field) bool { return f.Name == name })]
+	if field.Type != config.FieldTypeDate {
+		return nil, fmt.Errorf("%w: field %q is not a date field", errInvalidDateFilter, name)
+	}
+	mapping := fieldMappings[name]
+	if mapping == nil {
+		return nil, fmt.Errorf("%w: field %q is not mapped", errInvalidDateFilter, name)
+	}
+	return &dateFilter{field: field, mapping: mapping, from: opts.dateFrom, to: opts.dateTo, reportRows: opts.dateReportExcluded}, nil
+}
+
+// includes reports whether the row at index has a date within range. Rows with a date
+// that cannot be parsed are excluded and always noted; rows outside the range are noted
+// only when reportRows is set.
+func (f *dateFilter) includes(index int, row []string, normalizedHeaders []string, info inputInfo) bool {
+	value := strings.TrimSpace(f.mapping.value(row, normalizedHeaders))
+	date, ok := f.parse(f.field.ConvertDateSerial(value, info.date1904))
+	var reason string
+	switch {
+	case !ok:
+		reason = fmt.Sprintf("unparseable date %q in %s", value, f.field.Name)
+	case !f.from.IsZero() && date.Before(f.from), !f.to.IsZero() && date.After(f.to):
+		if f.reportRows {
+			reason = fmt.Sprintf("date %s outside the requested range", date.Format(config.DefaultDateFormat))
+		}
+	default:
+		return true
+	}
+	f.excluded++
+	if reason != "" {
+		sheet, rowNumber := rowLocation(index, info)
+		f.notes.WriteString(fmt.Sprintf("%s: Excluded - %s\n", describeRowLocation(sheet, rowNumber), reason))
+	}
+	return false
+}
+
+// parse reads a date in the field's DateFormat, falling back to DefaultDateFormat for
+// text dates, and drops any time of day so bounds compare whole days
+func (f *dateFilter) parse(value string) (time.Time, bool) {
+	for _, layout := range []string{f.field.DateFormat, config.DefaultDateFormat} {
+		if layout == "" {
+			continue
+		}
+		if date, err := time.Parse(layout, value); err == nil {
+			return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// describe summarises the filter and the rows it excluded
+func (f *dateFilter) describe() string {
+	bound := func(t time.Time, open string) string {
+		if t.IsZero() {
+			return open
+		}
+		return t.Format(config.DefaultDateFormat)
+	}
+	return fmt.Sprintf("\nDate Filter: %s from %s to %s, %d rows excluded\n%s", f.field.Name, bound(f.from, "the start"), bound(f.to, "the end"), f.excluded, f.notes.String())
+}
+
+// errPostProcess is returned when POST_PROCESS_COMMAND fails on an output file
+var errPostProcess = errors.New("post-processing command failed")
+
+// postProcessPlaceholder in POST_PROCESS_COMMAND is replaced by the output file's path
+const postProcessPlaceholder = "{output}"
+
+// maxPostProcessStderr caps the error output of POST_PROCESS_COMMAND kept for the log
+const maxPostProcessStderr = 4 << 10
+
+// postProcessArgs splits the POST_PROCESS_COMMAND template on whitespace and replaces
+// {output} with path. A template without the placeholder gets path as its last argument.
+// No shell is involved, so quotes, pipes and variables have no special meaning.
+func postProcessArgs(template, path string) []string {
+	args := strings.Fields(template)
+	placeholder := false
+	for i, arg := range args {
+		if strings.Contains(arg, postProcessPlaceholder) {
+			args[i] = strings.ReplaceAll(arg, postProcessPlaceholder, path)
+			placeholder = true
+		}
+	}
+	if !placeholder {
+		args = append(args, path)
+	}
+	return args
+}
+
+// runPostProcessCommand runs POST_PROCESS_COMMAND on the output file at path, which it may
+// rewrite in place. The command gets an empty working directory of its own, an environment
+// holding only PATH and OUTPUT_FILE, and no input. It is killed after POST_PROCESS_TIMEOUT,
+// and the rewritten file must not exceed POST_PROCESS_MAX_BYTES.
+func runPostProcessCommand(ctx context.Context, path string) error {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPostProcess, err)
+	}
+	workDir, err := os.MkdirTemp("", "excel-mapper-postprocess-")
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPostProcess, err)
+	}
+	defer os.RemoveAll(workDir)
+
+	commandCtx, cancel := context.WithTimeout(ctx, featureFlags.PostProcessTimeout)
+	defer cancel()
+	args := postProcessArgs(featureFlags.PostProcessCommand, absolute)
+	cmd := exec.CommandContext(commandCtx, args[0], args[1:]...)
+	cmd.Dir = workDir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "OUTPUT_FILE=" + absolute}
+	stderr := &cappedBuffer{limit: maxPostProcessStderr}
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		// The request's own deadline or cancellation is reported as such
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if errors.Is(commandCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: timed out after %s", errPostProcess, featureFlags.PostProcessTimeout)
+		}
+		return fmt.Errorf("%w: %v: %s", errPostProcess, err, strings.TrimSpace(stderr.String()))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%w: output file is gone: %v", errPostProcess, err)
+	}
+	if info.Size() > featureFlags.PostProcessMaxBytes {
+		return fmt.Errorf("%w: output is %d bytes, over the %d byte limit", errPostProcess, info.Size(), featureFlags.PostProcessMaxBytes)
+	}
+	return nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// saveOutput writes the processed and missing outputs selected by opts.outputScope in the
+// given format and returns the path of the primary output file
+func saveOutput(outputFile *excelize.File, outputFormat string, outputRowIndex, missingRowIndex int, summary string, uniqueID string, opts processOptions) (string, error) {
+	switch outputFormat {
+	case "csv":
+		return saveAsCSV(outputFile, outputRowIndex, missingRowIndex, uniqueID, opts)
+	case "ndjson":
+		return saveAsNDJSON(outputFile, outputRowIndex, missingRowIndex, uniqueID, opts)
+	case "sql":
+		return saveAsSQL(outputFile, outputRowIndex, missingRowIndex, uniqueID, opts)
+	case "markdown":
+		return saveAsMarkdown(outputFile, outputRowIndex, missingRowIndex, summary, uniqueID, opts)
+	}
+
+	// A single-scope workbook only keeps the requested sheet
+	outputFilePath, missingFilePath := outputFilePaths(uniqueID, "xlsx")
+	switch opts.outputScope {
+	case outputScopeProcessed:
+		outputFile.DeleteSheet("MissingData")
+	case outputScopeMissing:
+		outputFile.DeleteSheet("ProcessedData")
+		outputFilePath = missingFilePath
+	}
+	if err := setSheetVisibility(outputFile, opts); err != nil {
+		return "", err
+	}
+	return saveAsXLSX(outputFile, outputFilePath, opts.outputPassword)
+}
+
+// workbookSheets maps the activeSheet and hiddenSheets values to the output workbook's sheets
+var workbookSheets = map[string]string{outputScopeProcessed: "ProcessedData", outputScopeMissing: "MissingData"}
+
+// setSheetVisibility makes opts.activeSheet the sheet the workbook opens on and hides
+// opts.hiddenSheets. parseProcessOptions has checked they are kept by the output scope.
+func setSheetVisibility(outputFile *excelize.File, opts processOptions) error {
+	if opts.activeSheet != "" {
+		index, err := outputFile.GetSheetIndex(workbookSheets[opts.activeSheet])
+		if err != nil || index == -1 {
+			return fmt.Errorf("error finding active sheet %s: %v", workbookSheets[opts.activeSheet], err)
+		}
+		outputFile.SetActiveSheet(index)
+	}
+	for _, sheet := range opts.hiddenSheets {
+		if err := outputFile.SetSheetVisible(workbookSheets[sheet], false); err != nil {
+			return fmt.Errorf("error hiding sheet %s: %w", workbookSheets[sheet], err)
+		}
+	}
+	return nil
+}
+
+// parseSheetVisibility parses the activeSheet and hiddenSheets form fields, which name
+// sheets as processed or missing. Both sheets must be kept by the output scope and the
+// active sheet, by default the first one kept, cannot be hidden.
+func parseSheetVisibility(r *http.Request, opts *processOptions) error {
+	kept := func(sheet string) bool {
+		return opts.outputScope == outputScopeBoth || opts.outputScope == sheet
+	}
+	opts.activeSheet = strings.TrimSpace(r.FormValue("activeSheet"))
+	if _, ok := workbookSheets[opts.activeSheet]; opts.activeSheet != "" && !ok {
+		return fmt.Errorf("invalid activeSheet %q: must be processed or missing", opts.activeSheet)
+	}
+	if opts.activeSheet != "" && !kept(opts.activeSheet) {
+		return fmt.Errorf("activeSheet %s is not in the output with outputScope %s", opts.activeSheet, opts.outputScope)
+	}
+	hiddenSheets, err := parseHeaderList(r, "hiddenSheets")
+	if err != nil {
+		return err
+	}
+	active := opts.activeSheet
+	if active == "" {
+		active = outputScopeProcessed
+		if opts.outputScope == outputScopeMissing {
+			active = outputScopeMissing
+		}
+	}
+	for _, sheet := range hiddenSheets {
+		if _, ok := workbookSheets[sheet]; !ok {
+			return fmt.Errorf("invalid hiddenSheets entry %q: must be processed or missing", sheet)
+		}
+		if !kept(sheet) {
+			return fmt.Errorf("hidden sheet %s is not in the output with outputScope %s", sheet, opts.outputScope)
+		}
+		if sheet == active {
+			return fmt.Errorf("hidden sheet %s is the active sheet: a workbook must open on a visible sheet", sheet)
+		}
+	}
+	opts.hiddenSheets = hiddenSheets
+	return nil
+}
+
+// removeOutputFiles deletes any processed and missing outputs, sidecars and reports written for an upload
+func removeOutputFiles(uniqueID, outputFormat string) {
+	outputFilePath, missingFilePath := outputFilePaths(uniqueID, outputFormat)
+	os.Remove(outputFilePath)
+	os.Remove(missingFilePath)
+	os.Remove(summarySidecarPath(uniqueID))
+	os.Remove(duplicatesReportPath(uniqueID))
+	os.Remove(provenancePath(uniqueID))
+	os.Remove(groupReportPath(uniqueID))
+	os.Remove(manifestPath(uniqueID))
+	os.Remove(zipOutputPath(uniqueID))
+}
+
+// errInvalidDedupe is returned when dedupeBy names a field that is not output
+var errInvalidDedupe = errors.New("invalid dedupeBy")
+
+// deduplicator drops processed rows whose key fields repeat those of an earlier processed row
+type deduplicator struct {
+	keyFields  []string
+	keyIndexes []int
+	// keyMasks hides the masked key fields' values in the report, as in the outputs
+	keyMasks []*config.Mask
+	// retained maps each key to the input row index of its first occurrence
+	retained map[string]int
+	dropped  []droppedDuplicate
+}
+
+// droppedDuplicate is a row left out as a duplicate of the row at originalIndex
+type droppedDuplicate struct {
+	index, originalIndex int
+	key                  string
+}
+
+// newDeduplicator resolves the dedupeBy fields, which must be output columns
+func newDeduplicator(fieldConfig *config.FieldConfig, order []string, dedupeBy []string) (*deduplicator, error) {
+	d := &deduplicator{retained: make(map[string]int)}
+	for _, key := range dedupeBy {
+		name, ok := fieldConfig.ResolveFieldName(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: field %q does not exist", errInvalidDedupe, key)
+		}
+		index := slices.Index(order, name)
+		if index == -1 {
+			return nil, fmt.Errorf("%w: field %q is not in the output", errInvalidDedupe, name)
+		}
+		d.keyFields = append(d.keyFields, name)
+		d.keyIndexes = append(d.keyIndexes, index)
+	}
+	if masks := fieldMasks(order, fieldConfig); masks != nil {
+		for _, index := range d.keyIndexes {
+			d.keyMasks = append(d.keyMasks, masks[index])
+		}
+	}
+	return d, nil
+}
+
+// isDuplicate reports whether processedRow, read from the input row at index, repeats the
+// key of an earlier row, recording it if so. Rows whose key fields are all empty are never
+// duplicates.
+func (d *deduplicator) isDuplicate(index int, processedRow []string) bool {
+	values := make([]string, len(d.keyIndexes))
+	empty := true
+	for i, keyIndex := range d.keyIndexes {
+		values[i] = processedRow[keyIndex]
+		empty = empty && values[i] == ""
+	}
+	if empty {
+		return false
+	}
+	// The unit separator cannot be confused with a character inside the values
+	key := strings.Join(values, "\x1f")
+	originalIndex, seen := d.retained[key]
+	if !seen {
+		d.retained[key] = index
+		return false
+	}
+	for i, mask := range d.keyMasks {
+		if mask != nil {
+			values[i] = mask.Apply(values[i])
+		}
+	}
+	d.dropped = append(d.dropped, droppedDuplicate{index: index, originalIndex: originalIndex, key: strings.Join(values, ", ")})
+	return true
+}
+
+// describe summarises the duplicates removed
+func (d *deduplicator) describe() string {
+	return fmt.Sprintf("Duplicates Removed: %d (by %s)\n", len(d.dropped), strings.Join(d.keyFields, ", "))
+}
+
+// duplicatesReportPath returns the path of the dedupe report for an upload
+func duplicatesReportPath(uniqueID string) string {
+	return fmt.Sprintf("./uploads/%s_duplicates.csv", uniqueID)
+}
+
+// writeReport writes each dropped duplicate with its key and the row it duplicated. Row
+// numbers are those a user sees in the spreadsheet; merged workbooks also name the sheet.
+// Like the outputs, it is encrypted when opts.outputPassword is set.
+func (d *deduplicator) writeReport(path string, info inputInfo, opts processOptions) error {
+	header := []string{"Row", "Key", "Duplicate Of Row"}
+	if info.sheetCounts != nil {
+		header = []string{"Sheet", "Row", "Key", "Duplicate Of Sheet", "Duplicate Of Row"}
+	}
+	rows := make([][]string, 0, len(d.dropped))
+	for _, duplicate := range d.dropped {
+		sheet, row := rowLocation(duplicate.index, info)
+		originalSheet, originalRow := rowLocation(duplicate.originalIndex, info)
+		if info.sheetCounts != nil {
+			rows = append(rows, []string{sheet, strconv.Itoa(row), duplicate.key, originalSheet, strconv.Itoa(originalRow)})
+		} else {
+			rows = append(rows, []string{strconv.Itoa(row), duplicate.key, strconv.Itoa(originalRow)})
+		}
+	}
+	if err := writeOutput(path, false, opts, encodeCSV(nil, header, rows, processOptions{})); err != nil {
+		return fmt.Errorf("error writing duplicates report: %w", err)
+	}
+	return nil
+}
+
+// processingReport is the structured processing summary written to the summary sidecar
+type processingReport struct {
+	TotalRows           int                `json:"totalRows"`
+	SuccessfulRows      int                `json:"successfulRows"`
+	RowsWithMissingData int                `json:"rowsWithMissingData"`
+	MissingRows         []missingRowDetail `json:"missingRows"`
+	// Summary is the full text summary, which the X-Processing-Summary header only digests
+	Summary string `json:"summary"`
+}
+
+// missingRowDetail lists the mandatory fields missing from, and validation rules failed by, one input row
+type missingRowDetail struct {
+	Row           int      `json:"row"`
+	Sheet         string   `json:"sheet,omitempty"`
+	MissingFields []string `json:"missingFields"`
+	FailedRules   []string `json:"failedRules,omitempty"`
+}
+
+// summarySidecarPath returns the path of the summary sidecar for an upload
+func summarySidecarPath(uniqueID string) string {
+	return fmt.Sprintf("./uploads/%s_summary.json", uniqueID)
+}
+
+// errInvalidGroupBy is returned when groupBy or aggregate names a field that is not output
+var errInvalidGroupBy = errors.New("invalid groupBy")
+
+// groupCounter counts processed rows by the value of one field, summing another numeric
+// field per group when aggregate is set
+type groupCounter struct {
+	field, aggregate           string
+	fieldIndex, aggregateIndex int
+	groups                     map[string]*GroupCount
+	// mask hides the groupBy field's values in the report when the field is masked
+	mask *config.Mask
+	// sums and numbers hold each group's total and count of numeric aggregate values
+	sums    map[string]float64
+	numbers map[string]int
+}
+
+// GroupCount is the number of processed rows with one value of the groupBy field, with
+// the sum and average of the aggregate field's numeric values when it is set
+type GroupCount struct {
+	Value string   `json:"value" example:"Active"`
+	Rows  int      `json:"rows" example:"42"`
+	Sum   *float64 `json:"sum,omitempty" example:"1250.5"`
+	Avg   *float64 `json:"avg,omitempty" example:"29.77"`
+}
+
+// GroupReport is the grouped count of processed rows written to the groups report,
+// largest groups first
+type GroupReport struct {
+	GroupBy   string       `json:"groupBy" example:"Status"`
+	Aggregate string       `json:"aggregate,omitempty" example:"Amount"`
+	Groups    []GroupCount `json:"groups"`
+}
+
+// newGroupCounter resolves the groupBy and aggregate fields, which must be output columns
+func newGroupCounter(fieldConfig *config.FieldConfig, order []string, groupBy, aggregate string) (*groupCounter, error) {
+	resolve := func(option, key string) (string, int, error) {
+		name, ok := fieldConfig.ResolveFieldName(key)
+		if !ok {
+			return "", 0, fmt.Errorf("%w: %s field %q does not exist", errInvalidGroupBy, option, key)
+		}
+		index := slices.Index(order, name)
+		if index == -1 {
+			return "", 0, fmt.Errorf("%w: %s field %q is not in the output", errInvalidGroupBy, option, name)
+		}
+		return name, index, nil
+	}
+	g := &groupCounter{groups: make(map[string]*GroupCount), aggregateIndex: -1}
+	var err error
+	if g.field, g.fieldIndex, err = resolve("groupBy", groupBy); err != nil {
+		return nil, err
+	}
+	if masks := fieldMasks(order, fieldConfig); masks != nil {
+		g.mask = masks[g.fieldIndex]
+	}
+	if aggregate != "" {
+		if g.aggregate, g.aggregateIndex, err = resolve("aggregate", aggregate); err != nil {
+			return nil, err
+		}
+		g.sums = make(map[string]float64)
+		g.numbers = make(map[string]int)
+	}
+	return g, nil
+}
+
+// add counts one processed row. Aggregate values that are empty or not numbers count
+// towards the group's rows but not its sum or average.
+func (g *groupCounter) add(processedRow []string) {
+	value := processedRow[g.fieldIndex]
+	group, ok := g.groups[value]
+	if !ok {
+		group = &GroupCount{Value: value}
+		g.groups[value] = group
+	}
+	group.Rows++
+	if g.aggregateIndex == -1 {
+		return
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(processedRow[g.aggregateIndex]), 64)
+	if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+		return
+	}
+	g.sums[value] += number
+	g.numbers[value]++
+}
+
+// report returns the counted groups, largest first and then by value. Groups are counted
+// and ordered by the real values; a masked groupBy field's values are masked afterwards.
+func (g *groupCounter) report() GroupReport {
+	report := GroupReport{GroupBy: g.field, Aggregate: g.aggregate, Groups: []GroupCount{}}
+	for value, group := range g.groups {
+		count := *group
+		if g.aggregateIndex != -1 {
+			sum := g.sums[value]
+			count.Sum = &sum
+			if n := g.numbers[value]; n > 0 {
+				avg := sum / float64(n)
+				count.Avg = &avg
+			}
+		}
+		report.Groups = append(report.Groups, count)
+	}
+	slices.SortFunc(report.Groups, func(a, b GroupCount) int {
+		if a.Rows != b.Rows {
+			return b.Rows - a.Rows
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+	if g.mask != nil {
+		for i := range report.Groups {
+			report.Groups[i].Value = g.mask.Apply(report.Groups[i].Value)
+		}
+	}
+	return report
+}
+
+// describe summarises the groups counted
+func (g *groupCounter) describe() string {
+	return fmt.Sprintf("Groups: %d (by %s)\n", len(g.groups), g.field)
+}
+
+// groupReportPath returns the path of the groups report for an upload
+func groupReportPath(uniqueID string) string {
+	return fmt.Sprintf("./uploads/%s_groups.json", uniqueID)
+}
+
+// writeGroupReport writes report as indented JSON to path, encrypted like the outputs when
+// opts.outputPassword is set
+func writeGroupReport(path string, report GroupReport, opts processOptions) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding groups report: %w", err)
+	}
+	if err := writeOutput(path, false, opts, writeString(string(data))); err != nil {
+		return fmt.Errorf("error writing groups report: %w", err)
+	}
+	return nil
+}
+
+// Provenance sources of an output field
+const (
+	// provenanceColumn fields are read from columns of the file
+	provenanceColumn = "column"
+	// provenanceComputed fields are resolved from another field by a lookup table
+	provenanceComputed = "computed"
+	// provenanceUnmapped fields have no mapping, or none of their mapped columns is in the file
+	provenanceUnmapped = "unmapped"
+)
+
+// FieldProvenance records where one output field's values came from in a run
+type FieldProvenance struct {
+	Field string `json:"field" example:"Customer_ID"`
+	// Source is "column", "computed" or "unmapped"
+	Source string `json:"source" example:"column"`
+	// Columns are the file headers the values are read from, as written in the file. A
+	// coalesce mapping lists them in the order they are tried and a JSON path mapping
+	// follows its column with the path.
+	Columns []string `json:"columns,omitempty" example:"Customer ID"`
+	// MatchedBy is "mapping" for a field mapped by the request and "alias" for one matched
+	// automatically by an alias
+	MatchedBy string `json:"matchedBy,omitempty" example:"mapping"`
+	// SourceField is the field a computed field looks its value up from
+	SourceField string `json:"sourceField,omitempty" example:"Country_Code"`
+}
+
+// Provenance describes how each output field was produced in a run
+type Provenance struct {
+	Fields []FieldProvenance `json:"fields"`
+}
+
+// buildProvenance records the source of each field in order. requested are the mappings
+// sent with the request and resolved those used after alias matching.
+func buildProvenance(headers []string, requested, resolved map[string]fieldMapping, order []string, fieldConfig *config.FieldConfig) Provenance {
+	normalizedHeaders := normalizeHeaders(headers)
+	provenance := Provenance{Fields: make([]FieldProvenance, 0, len(order))}
+	for _, name := range order {
+		record := FieldProvenance{Field: name}
+		mappingField := name
+		for _, field := range fieldConfig.Fields {
+			if field.Name == name && field.Lookup != nil {
+				record.SourceField = field.Lookup.SourceField
+				mappingField = field.Lookup.SourceField
+			}
+		}
+		record.Columns = provenanceColumns(headers, normalizedHeaders, resolved[mappingField])
+		switch {
+		case len(record.Columns) == 0:
+			record.Source = provenanceUnmapped
+			record.SourceField = ""
+		case record.SourceField != "":
+			record.Source = provenanceComputed
+		default:
+			record.Source = provenanceColumn
+			record.MatchedBy = "mapping"
+			if requested[name].String() != resolved[name].String() {
+				record.MatchedBy = "alias"
+			}
+		}
+		provenance.Fields = append(provenance.Fields, record)
+	}
+	return provenance
+}
+
+// provenanceColumns returns the headers of mapping's source columns that are in the file,
+// as written there, each followed by its JSON path if it has one
+func provenanceColumns(headers, normalizedHeaders []string, mapping fieldMapping) []string {
+	var columns []string
+	for _, source := range mapping {
+		j := slices.Index(normalizedHeaders, normalizeHeader(source.column))
+		if source.column == "" || j == -1 {
+			continue
+		}
+		column := headers[j]
+		if source.path != "" {
+			column += " " + source.path
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// provenancePath returns the path of the provenance record for an upload
+func provenancePath(uniqueID string) string {
+	return fmt.Sprintf("./uploads/%s_provenance.json", uniqueID)
+}
+
+// writeProvenance writes provenance as indented JSON to path, encrypted like the outputs
+// when opts.outputPassword is set
+func writeProvenance(path string, provenance Provenance, opts processOptions) error {
+	data, err := json.MarshalIndent(provenance, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding provenance: %w", err)
+	}
+	if err := writeOutput(path, false, opts, writeString(string(data))); err != nil {
+		return fmt.Errorf("error writing provenance: %w", err)
+	}
+	return nil
+}
+
+// writeSummarySidecar writes report as indented JSON to path
+func writeSummarySidecar(path string, report processingReport) error {
+	if report.MissingRows == nil {
+		report.MissingRows = []missingRowDetail{}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding summary sidecar: %w", err)
+	}
+	if err := writeOutputBytes(path, data); err != nil {
+		return fmt.Errorf("error writing summary sidecar: %w", err)
+	}
+	return nil
+}
+
+// markdownCellReplacer escapes pipes, backticks and backslashes and turns embedded line
+// breaks into <br> so that a cell cannot break out of its table row or open a code span
+var markdownCellReplacer = strings.NewReplacer("\\", "\\\\", "|", "\\|", "`", "\\`", "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+// escapeMarkdownCell escapes value for a markdown table cell with markdownCellReplacer.
+// Tabs become spaces, other control characters are dropped and invalid UTF-8 is replaced
+// with U+FFFD. Values are handled rune by rune, so multibyte characters and emoji stay whole.
+func escapeMarkdownCell(value string) string {
+	value = markdownCellReplacer.Replace(strings.ToValidUTF8(value, "\uFFFD"))
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, value)
+}
+
+func generateMarkdownTable(headers []string, rows [][]string) string {
+	return generateAlignedMarkdownTable(headers, rows, nil)
+}
+
+// markdownAlignmentRows maps each column alignment to its delimiter row cell
+var markdownAlignmentRows = map[string]string{
+	"":                 " --- |",
+	config.AlignLeft:   " :--- |",
+	config.AlignCenter: " :---: |",
+	config.AlignRight:  " ---: |",
+}
+
+// generateAlignedMarkdownTable renders a markdown table whose columns are aligned as given
+// by alignments, keyed by header. Columns without an alignment use the renderer's default.
+func generateAlignedMarkdownTable(headers []string, rows [][]string, alignments map[string]string) string {
+	var sb strings.Builder
+
+	sb.WriteString("| ")
+	for _, header := range headers {
+		sb.WriteString(escapeMarkdownCell(header) + " | ")
+	}
+	sb.WriteString("\n|")
+
+	for _, header := range headers {
+		sb.WriteString(markdownAlignmentRows[alignments[header]])
+	}
+	sb.WriteString("\n")
+
+	// Write data rows
+	for _, row := range rows {
+		sb.WriteString("| ")
+		for _, cell := range row {
+			escapedCell := escapeMarkdownCell(cell)
+			sb.WriteString(escapedCell + " | ")
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		http.Error(w, "Missing file parameter", http.StatusBadRequest)
+		return
+	}
+
+	if strings.Contains(file, "..") || strings.ContainsAny(file, `/\`) {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	filePath := filepath.Join("./uploads", file)
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	http.ServeFile(w, r, filePath)
+}
+
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+type FieldConfigResponse struct {
+	Fields []struct {
+		Name        string `json:"name" example:"Client_Code"`
+		DisplayName string `json:"displayName" example:"Client Code"`
+		IsMandatory bool   `json:"isMandatory" example:"true"`
+	} `json:"fields"`
+	MandatoryFields []string `json:"mandatoryFields" example:"Client_Code,Customer_ID,Account_ID"`
+}
+
+// @Summary     Get field configuration
+// @Description Get the configuration of all fields, including mandatory fields and field order
+// @Tags        configuration
+// @Accept      json
+// @Produce     json
+// @Security    ApiKeyAuth
+// @Success     200 {object} FieldConfigResponse
+// @Failure     401 {object} ErrorResponse "Unauthorized"
+// @Failure     405 {object} ErrorResponse "Method Not Allowed"
+// @Router      /config [get]
+func handleAPIConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeFieldConfigResponse(w, currentFieldConfig())
+}
+
+// UIField describes a field with everything the mapping form needs to render it
+type UIField struct {
+	Name        string `json:"name" example:"Start_Date"`
+	DisplayName string `json:"displayName" example:"Start Date"`
+	IsMandatory bool   `json:"isMandatory" example:"false"`
+	// Type is the field's declared type, or "text" if it has none
+	Type       string `json:"type" example:"date"`
+	DateFormat string `json:"dateFormat,omitempty" example:"2006-01-02"`
+	// LookupSource names the field a lookup field is populated from; such fields need no mapping
+	LookupSource string `json:"lookupSource,omitempty" example:"Country_Code"`
+	// Rules names the validation rules that check this field's value
+	Rules []string `json:"rules,omitempty" example:"us-zip"`
+}
+
+// UIConfigResponse is the consolidated field configuration for rendering the mapping form
+type UIConfigResponse struct {
+	Fields        []UIField `json:"fields"`
+	OrderedFields []string  `json:"orderedFields" example:"Client_Code,Customer_ID,Start_Date"`
+}
+
+// newUIConfigResponse builds the consolidated UI view of a field configuration
+func newUIConfigResponse(fc *config.FieldConfig) UIConfigResponse {
+	response := UIConfigResponse{
+		Fields:        make([]UIField, len(fc.Fields)),
+		OrderedFields: fc.GetOrderedFields(),
+	}
+	for i, field := range fc.OrderedFieldList() {
+		uiField := UIField{
+			Name:        field.Name,
+			DisplayName: field.DisplayName,
+			IsMandatory: fc.IsFieldMandatory(field),
+			Type:        field.Type,
+			DateFormat:  field.DateFormat,
+		}
+		if uiField.Type == "" {
+			uiField.Type = "text"
+		}
+		if uiField.Type == config.FieldTypeDate && uiField.DateFormat == "" {
+			uiField.DateFormat = config.DefaultDateFormat
+		}
+		if field.Lookup != nil {
+			uiField.LookupSource = field.Lookup.SourceField
+		}
+		for _, rule := range fc.Rules {
+			if rule.Then.Field == field.Name {
+				uiField.Rules = append(uiField.Rules, rule.Name)
+			}
+		}
+		response.Fields[i] = uiField
+	}
+	return response
+}
+
+// @Summary     Get the field configuration for the mapping UI
+// @Description Get every field with its display name, mandatory flag, type and validation metadata, plus the field order, in one call
+// @Tags        configuration
+// @Produce     json
+// @Security    ApiKeyAuth
+// @Success     200 {object} UIConfigResponse
+// @Failure     401 {object} ErrorResponse "Unauthorized"
+// @Failure     405 {object} ErrorResponse "Method Not Allowed"
+// @Router      /config/ui [get]
+func handleAPIConfigUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUIConfigResponse(currentFieldConfig()))
+}
+
+// FieldReorderRequest lists every configured field name in the desired order
+type FieldReorderRequest struct {
+	Order []string `json:"order" example:"Customer_ID,Client_Code,Account_ID"`
+}
+
+// mutateFieldConfig applies mutate to a copy of the current configuration, persists the
+// result to disk and only then makes it the active configuration
+func mutateFieldConfig(mutate func(*config.FieldConfig) error) (*config.FieldConfig, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	updated := fieldConfig.Clone()
+	if err := mutate(updated); err != nil {
+		return nil, err
+	}
+	if err := updated.LoadLookupTables(); err != nil {
+		return nil, err
+	}
+	if err := updated.CompilePatterns(); err != nil {
+		return nil, err
+	}
+	if err := updated.Save(fieldConfigPath); err != nil {
+		return nil, err
+	}
+	fieldConfig = updated
+	clearResultCache()
+	return updated, nil
+}
+
+// @Summary     Add, update or delete a configured field
+// @Description POST adds a field, PUT replaces the field named by the `name` query parameter (allowing a rename), DELETE removes it. Changes are persisted to field_config.json.
+// @Tags        configuration
+// @Accept      json
+// @Produce     json
+// @Security    ApiKeyAuth
+// @Param       name  query string       false "Name of the field to update or delete"
+// @Param       field body  config.Field false "Field definition (POST and PUT)"
+// @Success     200 {object} FieldConfigResponse
+// @Failure     400 {object} ErrorResponse "Bad Request"
+// @Failure     401 {object} ErrorResponse "Unauthorized"
+// @Failure     405 {object} ErrorResponse "Method Not Allowed"
+// @Router      /config/fields [post]
+// @Router      /config/fields [put]
+// @Router      /config/fields [delete]
+func handleAPIConfigFields(w http.ResponseWriter, r *http.Request) {
+	var mutate func(*config.FieldConfig) error
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		var field config.Field
+		if err := json.NewDecoder(r.Body).Decode(&field); err != nil {
+			sendJSONError(w, "Invalid field definition", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			mutate = func(fc *config.FieldConfig) error { return fc.AddField(field) }
+		} else {
+			name := r.URL.Query().Get("name")
+			if name == "" {
+				sendJSONError(w, "Missing name parameter", http.StatusBadRequest)
+				return
+			}
+			mutate = func(fc *config.FieldConfig) error { return fc.UpdateField(name, field) }
+		}
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			sendJSONError(w, "Missing name parameter", http.StatusBadRequest)
+			return
+		}
+		mutate = func(fc *config.FieldConfig) error { return fc.DeleteField(name) }
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	updated, err := mutateFieldConfig(mutate)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeFieldConfigResponse(w, updated)
+}
+
+// @Summary     Reorder configured fields
+// @Description Replace the field order. The request must list every configured field name exactly once.
+// @Tags        configuration
+// @Accept      json
+// @Produce     json
+// @Security    ApiKeyAuth
+// @Param       order body FieldReorderRequest true "New field order"
+// @Success     200 {object} FieldConfigResponse
+// @Failure     400 {object} ErrorResponse "Bad Request"
+// @Failure     401 {object} ErrorResponse "Unauthorized"
+// @Failure     405 {object} ErrorResponse "Method Not Allowed"
+// @Router      /config/fields/reorder [post]
+func handleAPIConfigFieldsReorder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FieldReorderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid reorder request", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := mutateFieldConfig(func(fc *config.FieldConfig) error { return fc.ReorderFields(req.Order) })
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeFieldConfigResponse(w, updated)
+}
+
+// @Summary     Export the field configuration
+// @Description Download the complete active configuration as field_config.json, in the form accepted by /config/import
+// @Tags        configuration
+// @Produce     json
+// @Security    ApiKeyAuth
+// @Success     200 {object} config.FieldConfig
+// @Failure     401 {object} ErrorResponse "Unauthorized"
+// @Failure     405 {object} ErrorResponse "Method Not Allowed"
+// @Router      /config/export [get]
+func handleAPIConfigExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.MarshalIndent(currentFieldConfig(), "", "    ")
+	if err != nil {
+		sendJSONError(w, "Failed to encode configuration", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="field_config.json"`)
+	w.Write(data)
+}
+
+// @Summary     Import a field configuration
+// @Description Validate a complete configuration, as produced by /config/export, and replace the active one with it. The new configuration is persisted to field_config.json before it takes effect, and an invalid one leaves the current configuration untouched. With dryRun only the validation is done.
+// @Tags        configuration
+// @Accept      json
+// @Produce     json
+// @Security    ApiKeyAuth
+// @Param       dryRun query bool               false "Validate the configuration without applying it" default(false)
+// @Param       config body  config.FieldConfig true  "Complete field configuration"
+// @Success     200 {object} FieldConfigResponse
+// @Failure     400 {object} ErrorResponse "Bad Request"
+// @Failure     401 {object} ErrorResponse "Unauthorized"
+// @Failure     405 {object} ErrorResponse "Method Not Allowed"
+// @Router      /config/import [post]
+func handleAPIConfigImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dryRun"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("invalid dryRun %q: must be true or false", value), http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
+	}
+
+	imported := &config.FieldConfig{}
+	if err := json.NewDecoder(r.Body).Decode(imported); err != nil {
+		sendJSONError(w, "Invalid configuration JSON", http.StatusBadRequest)
+		return
+	}
+	if err := prepareFieldConfig(imported); err != nil {
+		sendJSONError(w, "Invalid configuration: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if dryRun {
+		writeFieldConfigResponse(w, imported)
+		return
+	}
+
+	configMu.Lock()
+	err := imported.Save(fieldConfigPath)
+	if err == nil {
+		fieldConfig = imported
+	}
+	configMu.Unlock()
+	if err != nil {
+		log.Printf("Failed to save imported configuration: %v", err)
+		sendJSONError(w, "Failed to save configuration", http.StatusInternalServerError)
+		return
+	}
+	clearResultCache()
+	writeFieldConfigResponse(w, imported)
+}
+
+// writeFieldConfigResponse encodes the configuration in the same shape as GET /api/v1/config
+func writeFieldConfigResponse(w http.ResponseWriter, fc *config.FieldConfig) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"fields":          fc.Fields,
+		"mandatoryFields": fc.GetMandatoryFields(),
+		"orderedFields":   fc.GetOrderedFields(),
+	})
+}
+
+// StoredOutput is an output file handed to remote storage
+type StoredOutput struct {
+	// Kind is "output" for the primary output, "missing" for the missing data output, "summary" for the sidecar,
+	// "duplicates" for the dedupe report, "provenance" for the provenance record, "groups" for the groups report
+	// or "archive" for the outputs of a zip upload
+	Kind string `json:"kind" example:"output"`
+	Key  string `json:"key" example:"excel-mapper/1a2b3c_processed_data.xlsx"`
+	URL  string `json:"url" example:"https://s3.example.com/bucket/excel-mapper/1a2b3c_processed_data.xlsx?X-Amz-Signature=..."`
+}
+
+// StoredOutputResponse is returned by /process instead of the file content when outputs are stored remotely
+type StoredOutputResponse struct {
+	Summary string         `json:"summary" example:"Total Rows Processed: 1000 Successful Rows: 1000 Rows with Missing Data: 0"`
+	Outputs []StoredOutput `json:"outputs"`
+	// Manifest describes the stored outputs, with their signed URLs
+	Manifest Manifest `json:"manifest"`
+	// InputFilename names the retained upload, downloadable from /download, when retainInput is set
+	InputFilename string `json:"inputFilename,omitempty" example:"1700000000_ab12cd34_customers.csv"`
+}
+
+// outputArtifact is a file processing may produce for an upload
+type outputArtifact struct {
+	kind, path, format string
+}
+
+// outputArtifacts lists every file processing may produce for an upload, whether or not
+// it was written: the output and missing data files in outputFormat, the summary sidecar,
+// the dedupe report, the provenance record, the groups report and, for a zip upload, the archive of every input's outputs
+func outputArtifacts(uniqueID, outputFormat string) []outputArtifact {
+	format := outputFormat
+	if _, ok := outputFormats[format]; !ok {
+		format = "xlsx"
+	}
+	outputFilePath, missingFilePath := outputFilePaths(uniqueID, outputFormat)
+	return []outputArtifact{
+		{"output", outputFilePath, format},
+		{"missing", missingFilePath, format},
+		{"summary", summarySidecarPath(uniqueID), "json"},
+		{"duplicates", duplicatesReportPath(uniqueID), "csv"},
+		{"provenance", provenancePath(uniqueID), "json"},
+		{"groups", groupReportPath(uniqueID), "json"},
+		{"archive", zipOutputPath(uniqueID), "zip"},
+	}
+}
+
+// ManifestArtifact describes one file produced by processing an upload
+type ManifestArtifact struct {
+	// Kind is "output", "missing", "summary", "duplicates", "provenance", "groups" or "archive", as in StoredOutput
+	Kind        string `json:"kind" example:"missing"`
+	Filename    string `json:"filename" example:"1700000000_ab12cd34_missing_data.csv"`
+	Format      string `json:"format" example:"csv"`
+	ContentType string `json:"contentType" example:"text/csv; charset=utf-8"`
+	Size        int64  `json:"size" example:"2048"`
+	URL         string `json:"url" example:"/download?file=1700000000_ab12cd34_missing_data.csv"`
+}
+
+// Manifest lists every file produced by processing an upload, so a client can fetch them all
+type Manifest struct {
+	Artifacts []ManifestArtifact `json:"artifacts"`
+	// Inputs lists the outputs of each input file of a zip upload
+	Inputs []ManifestInput `json:"inputs,omitempty"`
+}
+
+// ManifestInput describes the files produced for one input file of a zip upload
+type ManifestInput struct {
+	// Filename is the input's path inside the zip
+	Filename string `json:"filename" example:"2024/january.csv"`
+	// Error explains why the input could not be processed
+	Error     string             `json:"error,omitempty" example:"File contains headers but no data rows"`
+	Artifacts []ManifestArtifact `json:"artifacts"`
+}
+
+// manifestContentTypes are the content types of the artifact formats that are not output formats
+var manifestContentTypes = map[string]string{"json": "application/json", "zip": "application/zip"}
+
+// buildManifest lists the artifacts written for an upload with their sizes and /download URLs
+func buildManifest(uniqueID, outputFormat string) Manifest {
+	manifest := Manifest{Artifacts: []ManifestArtifact{}}
+	for _, artifact := range outputArtifacts(uniqueID, outputFormat) {
+		info, err := os.Stat(artifact.path)
+		if err != nil {
+			continue
+		}
+		contentType, ok := manifestContentTypes[artifact.format]
+		if !ok {
+			contentType = lookupOutputFormat(artifact.format).contentType
+		}
+		filename := filepath.Base(artifact.path)
+		manifest.Artifacts = append(manifest.Artifacts, ManifestArtifact{
+			Kind:        artifact.kind,
+			Filename:    filename,
+			Format:      artifact.format,
+			ContentType: contentType,
+			Size:        info.Size(),
+			URL:         "/download?file=" + url.QueryEscape(filename),
+		})
+	}
+	return manifest
+}
+
+// withStoredURLs returns the manifest with each artifact's URL replaced by its signed URL
+func (m Manifest) withStoredURLs(stored []StoredOutput) Manifest {
+	artifacts := make([]ManifestArtifact, len(m.Artifacts))
+	for i, artifact := range m.Artifacts {
+		for _, output := range stored {
+			if output.Kind == artifact.Kind {
+				artifact.URL = output.URL
+			}
+		}
+		artifacts[i] = artifact
+	}
+	return Manifest{Artifacts: artifacts, Inputs: m.Inputs}
+}
+
+// uploadManifest returns the manifest written for an upload, which for a zip upload also
+// lists its inputs, or builds one from the files on disk when none was written
+func uploadManifest(uniqueID, outputFormat string) Manifest {
+	var manifest Manifest
+	if data, err := os.ReadFile(manifestPath(uniqueID)); err == nil && json.Unmarshal(data, &manifest) == nil {
+		return manifest
+	}
+	return buildManifest(uniqueID, outputFormat)
+}
 
-	// Normalize headers in the first row
-	normalizedHeaders := normalizeHeaders(rows[0])
+// manifestPath returns the path of the artifact manifest written for an upload
+func manifestPath(uniqueID string) string {
+	return fmt.Sprintf("./uploads/%s_manifest.json", uniqueID)
+}
 
-	// Create a new file for successful rows and missing rows
-	outputFile := createOutputWorkbook(order)
+// writeManifest writes the manifest of an upload's artifacts to manifestPath as indented JSON
+func writeManifest(uniqueID, outputFormat string) error {
+	data, err := json.MarshalIndent(buildManifest(uniqueID, outputFormat), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutputBytes(manifestPath(uniqueID), data)
+}
 
-	outputRowIndex := 2
-	missingRowIndex := 2
+// storeOutputs hands every output generated for an upload to the output sink. Outputs that
+// were not generated, such as the missing data file of a processed-only request, are skipped.
+// If one cannot be stored, the outputs still on local disk are removed and those already
+// stored are returned with the error, so the client can be told where they are.
+func storeOutputs(ctx context.Context, uniqueID, outputFormat string) ([]StoredOutput, error) {
+	var stored []StoredOutput
+	for _, candidate := range outputArtifacts(uniqueID, outputFormat) {
+		if _, err := os.Stat(candidate.path); err != nil {
+			continue
+		}
+		location, err := outputSink.Store(ctx, candidate.path)
+		if err != nil {
+			removeOutputFiles(uniqueID, outputFormat)
+			return stored, fmt.Errorf("error storing %s output: %w", candidate.kind, err)
+		}
+		stored = append(stored, StoredOutput{Kind: candidate.kind, Key: location.Key, URL: location.URL})
+	}
+	return stored, nil
+}
+
+// ProcessResponse represents the file processing response
+type ProcessResponse struct {
+	Summary     string `json:"summary" example:"Total Rows Processed: 1000 Successful Rows: 1000 Rows with Missing Data: 0"`
+	FileName    string `json:"fileName" example:"processed_data.xlsx"`
+	ContentType string `json:"contentType" example:"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"`
+}
+
+// @Summary      Process file with field mappings
+// @Description  Upload a file and process it according to provided field mappings. The file may instead be sent as the raw request body with Content-Type text/csv, application/gzip or the XLSX type, the mappings in the X-Mappings header or mappings query parameter and the other options as query parameters. CSV, markdown, NDJSON and SQL outputs are streamed without being stored, unless an Idempotency-Key is sent. When OUTPUT_SINK=s3 the outputs are uploaded to the bucket and a JSON StoredOutputResponse with object keys and signed URLs is returned instead of the file.
+// @Tags         processing
+// @Accept       multipart/form-data
+// @Accept       text/csv
+// @Accept       application/gzip
+// @Accept       application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Accept       application/zip
+// @Produce      application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Produce      text/csv; charset=utf-8
+// @Produce      text/markdown; charset=utf-8
+// @Produce      application/x-ndjson
+// @Produce      application/sql
+// @Produce      application/zip
+// @Security     ApiKeyAuth
+// @Param        Idempotency-Key header string false "Retries with the same key (per API key) return the original result without reprocessing; a retry sent while the first request is still running gets 409"
+// @Param        file formData file true "File to process (CSV, XLSX, or gzipped .csv.gz/.tsv.gz), or a .zip of such files processed together into a zip of per-file outputs"
+// @Param        csvDialect formData string false "How a CSV input is written: standard (comma-separated) or european (semicolon-separated, with decimal commas in number fields)" Enums(standard,european) default(standard)
+// @Param        headerRows formData integer false "Number of header rows joined per column into composite header names, such as Sales Q1 (at most 10)" default(1)
+// @Param        headerSeparator formData string false "Separator between the parts of composite header names" default( )
+// @Param        unitsRow formData boolean false "Read the row below the header as the units of each column rather than as data" default(false)
+// @Param        mappings formData string true "JSON string of field mappings, keyed by field Name or DisplayName. A value is a column name, {\"coalesce\":[columns...]} to take the first non-empty of several columns, or {\"column\":name,\"path\":\"$.a.b\"} to extract a value from JSON in the column" example:"{\"Client_Code\":\"Client Code\",\"Customer_ID\":\"Customer ID\",\"Account_ID\":\"Account Number\"}"
+// @Param        allowEmptyMappings formData boolean false "Process mappings that name no column, e.g. for passthrough-only runs; otherwise they are rejected" default(false)
+// @Param        allowUnmappedMandatory formData boolean false "Process mappings that leave a mandatory field without a column, sending every row to the missing data output with a warning in the summary; otherwise they are rejected" default(false)
+// @Param        outputFormat formData string false "Output format (default from DEFAULT_OUTPUT_FORMAT, otherwise xlsx)" Enums(xlsx,csv,markdown,ndjson,sql) default(xlsx)
+// @Param        outputPassword formData string false "Password encrypting the outputs and the duplicates, groups and provenance reports: XLSX workbooks are password-protected, other formats AES-256-GCM encrypted; cannot be combined with retainInput (default OUTPUT_PASSWORD)"
+// @Param        outputScope formData string false "Which rows to output; missing returns the missing-data file" Enums(processed,missing,both) default(both)
+// @Param        activeSheet formData string false "xlsx sheet the workbook opens on; defaults to the first sheet in the output" Enums(processed,missing)
+// @Param        hiddenSheets formData string false "Comma-separated xlsx sheets to hide (processed, missing); the active sheet cannot be hidden"
+// @Param        csvPreamble formData boolean false "Write comment lines with generation time and row counts before the CSV header" default(false)
+// @Param        generatedAt formData string false "RFC 3339 generation time written in the CSV preamble instead of the current time, for reproducible output"
+// @Param        csvCommentChar formData string false "Character prefixing CSV preamble lines" default(#)
+// @Param        outputDelimiter formData string false "Single character separating CSV output values, or \\t for a tab" default(|)
+// @Param        delimiterPolicy formData string false "Quote CSV values containing the delimiter, or replace the delimiter in them with delimiterSubstitute" Enums(quote,substitute) default(quote)
+// @Param        delimiterSubstitute formData string false "Replaces the delimiter inside values with delimiterPolicy substitute, a space by default; may be empty"
+// @Param        outputBOM formData boolean false "Start CSV outputs with a UTF-8 byte-order mark" default(false)
+// @Param        outputCRLF formData boolean false "End the lines of CSV outputs with CRLF instead of LF" default(false)
+// @Param        skipRows formData integer false "Number of data rows below the header to skip" default(0)
+// @Param        limitRows formData integer false "Maximum number of data rows to process after skipping (0 for no limit)" default(0)
+// @Param        maxErrors formData integer false "Stop with a 422 and the partial summary, writing no output, once more than this many rows have missing or invalid data (0 for no limit)" default(0)
+// @Param        sampleSize formData integer false "Process a pseudo-random sample of this many of the rows instead of all of them (0 for no sampling)" default(0)
+// @Param        sampleSeed formData integer false "Seed of the sample; the same seed picks the same rows of a file. Random when not set, and reported in the summary"
+// @Param        includeQualityScore formData boolean false "Append a _quality completeness score (0-100) to each processed row" default(false)
+// @Param        rowWorkers formData integer false "Goroutines mapping rows in parallel, 1-64 (default from ROW_WORKERS, otherwise 1)"
+// @Param        flushRecords formData integer false "Streamed NDJSON records written between flushes of the response (default from STREAM_FLUSH_RECORDS, otherwise left to the response buffer)"
+// @Param        includeWarnings formData boolean false "Append a _warnings column naming the warn-level rules each processed row failed" default(false)
+// @Param        unifiedOutput formData boolean false "Write every row to one processed output with _status (OK, MISSING, INVALID or DUPLICATE) and _reason columns instead of separate processed and missing outputs" default(false)
+// @Param        includeStats formData boolean false "Add per-field fill counts to the summary (default from ENABLE_STATS)"
+// @Param        trimCells formData boolean false "Trim surrounding whitespace from input cells (default from TRIM_CELLS)"
+// @Param        mergeAllSheets formData boolean false "Read every sheet of an XLSX file; all sheets must share the same header" default(false)
+// @Param        ndjsonOmitEmpty formData boolean false "Leave empty values out of ndjson objects instead of emitting \"\"" default(false)
+// @Param        summarySidecar formData boolean false "Also write the summary as JSON to a *_summary.json file, named in the X-Summary-File header and downloadable from /download" default(false)
+// @Param        markdownTitle formData string false "Heading of the markdown report (default \"Data Processing Report\")"
+// @Param        markdownSummary formData boolean false "Include the summary block in the markdown report" default(true)
+// @Param        markdownAlign formData string false "JSON object of markdown column alignments (left, center or right) keyed by field name, overriding the field config"
+// @Param        markdownMaxRows formData integer false "Maximum rows of each markdown table; further rows are left out with a note (default MARKDOWN_MAX_ROWS, 10000)"
+// @Param        passthroughColumns formData string false "Source headers copied as-is after the field columns of processed rows, as a JSON array or comma-separated list"
+// @Param        headerStyle formData string false "Head the field columns of XLSX, CSV and markdown outputs with field names or display names; ndjson and SQL keep names" Enums(name,display) default(name)
+// @Param        outputHeaders formData string false "JSON object of column headers for this run keyed by field Name or DisplayName, overriding headerStyle in every output format, e.g. {\"Client_Code\":\"client_ref\"}"
+// @Param        unmappedColumns formData string false "What to do with source columns no mapping uses: ignore them, report them in the summary or pass them through to the processed output" Enums(ignore,report,passthrough) default(ignore)
+// @Param        emptyAsNull formData boolean false "Write empty values as null in ndjson and NULL in sql output instead of empty strings" default(false)
+// @Param        sqlTable formData string false "Table the sql output inserts into; missing rows go to <table>_missing" default(processed_data)
+// @Param        sqlBatchSize formData integer false "Rows per INSERT statement in sql output" default(100)
+// @Param        sqlIdentifiers formData string false "Name sql output columns with their double-quoted headers, or as bare snake_case identifiers suffixed _2, _3 on collision" Enums(quoted,snake) default(quoted)
+// @Param        sqlDialect formData string false "SQL dialect of sql output: ansi (PostgreSQL, SQLite, SQL Server) double-quotes identifiers and only doubles single quotes; mysql backtick-quotes identifiers and also escapes backslashes" Enums(ansi,mysql) default(ansi)
+// @Param        dedupeBy formData string false "Fields whose values identify duplicate processed rows, as a JSON array or comma-separated list; later duplicates are dropped"
+// @Param        dedupeReport formData boolean false "Write the dropped duplicates and the rows they repeat to a *_duplicates.csv report" default(false)
+// @Param        manifest formData boolean false "Write a *_manifest.json listing every produced file with its format, content type, size and download URL, named in the X-Manifest-File header" default(false)
+// @Param        provenance formData boolean false "Write the source column of every output field (or computed/unmapped) to a *_provenance.json file, named in the X-Provenance-File header" default(false)
+// @Param        groupBy formData string false "Field (name or display name) to count processed rows by, written to a *_groups.json report named in the X-Groups-File header"
+// @Param        aggregate formData string false "Numeric field summed and averaged per groupBy group"
+// @Param        retainInput formData boolean false "Keep the uploaded file after processing, named in the X-Input-File header and downloadable from /download; otherwise it is deleted. Rejected together with outputPassword" default(false)
+// @Param        expectedHeaders formData string false "Headers the file must have, as a JSON array or comma-separated list; other files are rejected"
+// @Param        expectedHeadersOrdered formData boolean false "Also require expectedHeaders in the same order" default(false)
+// @Param        transforms formData string false "JSON object of transform lists (trim, upper, lower, collapseWhitespace) keyed by field, applied after the field's configured transforms; start a list with \"none\" to replace them"
+// @Param        dateField formData string false "Date field (name or display name) that must fall within dateFrom and dateTo for a row to be output"
+// @Param        dateFrom formData string false "First date to keep, inclusive (YYYY-MM-DD)"
+// @Param        dateTo formData string false "Last date to keep, inclusive (YYYY-MM-DD)"
+// @Param        dateReportExcluded formData boolean false "List rows outside the date range in the summary" default(false)
+// @Param        outputMandatoryOnly formData boolean false "Output only the mandatory fields, in config order" default(false)
+// @Param        dropEmptyColumns formData boolean false "Leave out optional field columns that are empty in every processed row" default(false)
+// @Success      200 {object} ProcessResponse
+// @Header       200 {string} X-Processing-Summary "Single-line digest of the summary counts, e.g. Total Rows Processed: 1000; Successful Rows: 1000; Rows with Missing Data: 0"
+// @Header       200 {string} Content-Type "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+// @Header       200 {string} Content-Disposition "attachment; filename=\"processed_data.xlsx\""
+// @Header       200 {string} X-Summary-File "Name of the summary sidecar file, when summarySidecar is set"
+// @Header       200 {string} X-Duplicates-File "Name of the duplicates report, when dedupeReport is set"
+// @Header       200 {string} X-Provenance-File "Name of the provenance record, when provenance is set"
+// @Header       200 {string} X-Groups-File "Name of the groups report, when groupBy is set"
+// @Header       200 {string} X-Manifest-File "Name of the artifact manifest, when manifest is set"
+// @Header       200 {string} X-Input-File "Name of the retained upload, when retainInput is set"
+// @Header       200 {string} X-Output-Encrypted "true when the outputs were encrypted with outputPassword"
+// @Header       200 {string} X-Results-Cache "hit when the output of an identical earlier request was reused, miss otherwise; only sent when RESULT_CACHE_TTL is set"
+// @Failure      400 {object} ErrorResponse "Bad Request"
+// @Failure      401 {object} ErrorResponse "Unauthorized"
+// @Failure      409 {object} ErrorResponse "A request with the same Idempotency-Key is still being processed"
+// @Failure      500 {object} ErrorResponse "Internal Server Error"
+// @Failure      413 {object} ErrorResponse "Input exceeds MAX_INPUT_BYTES once decompressed"
+// @Failure      422 {object} ErrorResponse "More rows than maxErrors have missing or invalid data; summary covers the rows processed"
+// @Failure      503 {object} ErrorResponse "Processing timed out"
+// @Failure      507 {object} ErrorResponse "Insufficient storage"
+// @Router       /process [post]
+func handleAPIProcess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Replay the cached result for a retried request, as long as its output still exists.
+	// Otherwise the key is reserved until this request is done, so a retry sent meanwhile
+	// is refused rather than processing the file a second time.
+	apiKey := auth.RequestAPIKey(r)
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		cached, ok, err := processResults.Reserve(apiKey, idempotencyKey, replayable)
+		if err != nil {
+			sendJSONError(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+			return
+		}
+		if ok {
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeProcessResult(w, cached)
+			return
+		}
+		defer processResults.Release(apiKey, idempotencyKey)
+	}
+
+	// A multipart form is the primary way to upload. Otherwise the body is the file itself,
+	// typed by its Content-Type, with the mappings in a header or the query string.
+	var source io.Reader
+	var filename, mappingsStr string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if extension, ok := rawUploadExtensions[mediaType]; ok {
+		source = r.Body
+		filename = "upload" + extension
+		mappingsStr = r.Header.Get("X-Mappings")
+		if mappingsStr == "" {
+			mappingsStr = r.URL.Query().Get("mappings")
+		}
+	} else {
+		// Parse multipart form
+		err := r.ParseMultipartForm(10 << 20) // 10MB limit
+		if err != nil {
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		// Get the file
+		file, handler, err := r.FormFile("file")
+		if err != nil {
+			sendJSONError(w, "No file uploaded", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		// Validate file type
+		if !isSupportedInputFile(handler.Filename) && !isZipUpload(handler.Filename) {
+			sendJSONError(w, invalidProcessFileTypeMessage, http.StatusBadRequest)
+			return
+		}
+		source = file
+		filename = handler.Filename
+		mappingsStr = r.FormValue("mappings")
+	}
+
+	// The request is checked before its file is saved
+	request, err := parseProcessRequest(r, mappingsStr)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fieldMappings, outputFormat, opts := request.fieldMappings, request.outputFormat, request.opts
+
+	// Generate unique ID for this upload to prevent race conditions
+	uniqueID := generateUniqueID()
+
+	// Save file temporarily
+	tempFile, tempFilePath, err := createUploadFile(uniqueID, filename)
+	if err != nil {
+		sendJSONError(w, "Unable to save file", http.StatusInternalServerError)
+		return
+	}
+	defer tempFile.Close()
+
+	// The content is hashed as it is saved, for the results cache
+	contentHash := sha256.New()
+	_, err = tempFile.ReadFrom(io.TeeReader(source, contentHash))
+	if err != nil {
+		os.Remove(tempFilePath)
+		if isDiskFullError(err) {
+			sendJSONError(w, insufficientStorageMessage, http.StatusInsufficientStorage)
+			return
+		}
+		sendJSONError(w, "Unable to save file content", http.StatusInternalServerError)
+		return
+	}
+
+	// The upload is only kept past the request when the client asks to retain it
+	if !opts.retainInput {
+		defer os.Remove(tempFilePath)
+	}
+
+	// Reuse the output of an earlier identical request while it is cached and still exists.
+	// A sample without a sampleSeed is meant to differ on every request, so it is not cached.
+	resultCacheKey := ""
+	if resultCache != nil && !outputSink.Remote() && !opts.randomSample {
+		resultCacheKey = resultCacheKeyFor(contentHash.Sum(nil), fieldMappings, outputFormat, r.Form)
+		if cached, ok := resultCache.Get(apiKey, resultCacheKey); ok {
+			if _, err := os.Stat(cached.OutputPath); err == nil {
+				if opts.retainInput {
+					cached.InputPath = tempFilePath
+				}
+				w.Header().Set("X-Results-Cache", "hit")
+				writeProcessResult(w, cached)
+				return
+			}
+		}
+		w.Header().Set("X-Results-Cache", "miss")
+	}
+
+	// Text outputs are streamed to the response instead of being written to disk and read
+	// back. Idempotent and cached requests, manifests, POST_PROCESS_COMMAND and remote sinks
+	// need the stored file, so they are not, and neither is the archive of a zip upload's
+	// outputs. Encrypted outputs are sealed as a whole, so they are not streamed either.
+	contentType := lookupOutputFormat(outputFormat).contentType
+	if isZipUpload(filename) {
+		contentType = manifestContentTypes["zip"]
+	} else if opts.outputPassword != "" && lookupOutputFormat(outputFormat) != outputFormats["xlsx"] {
+		contentType = encryptedContentType
+	}
+	result := idempotency.Result{ContentType: contentType, Encrypted: opts.outputPassword != ""}
+	if opts.summarySidecar {
+		result.SummaryPath = summarySidecarPath(uniqueID)
+	}
+	if opts.dedupeReport {
+		result.DuplicatesPath = duplicatesReportPath(uniqueID)
+	}
+	if opts.provenance {
+		result.ProvenancePath = provenancePath(uniqueID)
+	}
+	if opts.groupBy != "" {
+		result.GroupsPath = groupReportPath(uniqueID)
+	}
+	if opts.manifest {
+		result.ManifestPath = manifestPath(uniqueID)
+	}
+	if opts.retainInput {
+		result.InputPath = tempFilePath
+	}
+	if streamableOutputFormats[outputFormat] && idempotencyKey == "" && resultCacheKey == "" && featureFlags.PostProcessCommand == "" && opts.outputPassword == "" && !opts.manifest && !outputSink.Remote() && !isZipUpload(filename) {
+		processedPath, missingPath := outputFilePaths(uniqueID, outputFormat)
+		result.OutputPath = processedPath
+		if opts.outputScope == outputScopeMissing {
+			result.OutputPath = missingPath
+		}
+		opts.stream = newResponseStream(w)
+		setProcessResultHeaders(opts.stream.header, result)
+	}
+
+	// Process the file
+	order := currentFieldConfig().GetOrderedFields()
+	ctx, cancel := processingContext(r)
+	defer cancel()
+	summary, outputPath, err := processUpload(ctx, tempFilePath, fieldMappings, order, outputFormat, uniqueID, opts)
+	if opts.stream != nil && opts.stream.started {
+		// Part of the output has been sent, so a later failure can only be logged
+		if err != nil {
+			log.Printf("Failed after streaming the output of %s: %v", filename, err)
+		}
+		return
+	}
+	if status, message, ok := interruptedProcessingError(err); ok {
+		log.Printf("Stopped processing uploaded file %s: %v", filename, err)
+		sendJSONError(w, message, status)
+		return
+	}
+	if errors.Is(err, errInputTooLarge) {
+		log.Printf("Rejected uploaded file %s: %v", filename, err)
+		os.Remove(tempFilePath)
+		sendJSONError(w, inputTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, errErrorBudgetExceeded) {
+		log.Printf("Stopped processing uploaded file %s: %v", filename, err)
+		sendErrorBudgetError(w, err, summary)
+		return
+	}
+	if message, ok := clientInputError(err); ok {
+		log.Printf("Rejected uploaded file %s: %v", filename, err)
+		sendJSONError(w, message, http.StatusBadRequest)
+		return
+	}
+	if status, message, ok := outputWriteError(err); ok {
+		log.Printf("Failed to write outputs of %s: %v", filename, err)
+		sendJSONError(w, message, status)
+		return
+	}
+
+	// A streamed output may be empty, in which case only the headers remain to be sent
+	if opts.stream != nil {
+		opts.stream.start()
+		return
+	}
+
+	// Check if the output file exists
+	if _, err := os.Stat(outputPath); err != nil {
+		sendJSONError(w, "Failed to generate output file", http.StatusInternalServerError)
+		return
+	}
+
+	// Remote outputs are returned as object keys and signed URLs instead of file content
+	if outputSink.Remote() {
+		manifest := uploadManifest(uniqueID, outputFormat)
+		os.Remove(manifestPath(uniqueID))
+		stored, err := storeOutputs(ctx, uniqueID, outputFormat)
+		if err != nil {
+			log.Printf("Failed to store outputs of %s: %v", filename, err)
+			sendStoreOutputsError(w, stored)
+			return
+		}
+		response := StoredOutputResponse{Summary: summary, Outputs: stored, Manifest: manifest.withStoredURLs(stored)}
+		if opts.retainInput {
+			response.InputFilename = filepath.Base(tempFilePath)
+		}
+		storedResponse, err := json.Marshal(response)
+		if err != nil {
+			sendJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+		if idempotencyKey != "" {
+			processResults.Set(apiKey, idempotencyKey, idempotency.Result{Summary: summary, StoredResponse: storedResponse})
+		}
+		writeProcessResult(w, idempotency.Result{StoredResponse: storedResponse})
+		return
+	}
+
+	result.Summary = summary
+	result.OutputPath = outputPath
+	if idempotencyKey != "" {
+		processResults.Set(apiKey, idempotencyKey, result)
+	}
+	if resultCacheKey != "" {
+		resultCache.Set(apiKey, resultCacheKey, result)
+	}
+	writeProcessResult(w, result)
+}
+
+// resultCacheKeyFor identifies a /api/v1/process request for the results cache by the
+// SHA-256 of its upload, its normalized mappings, its output format and every other form
+// value, since any of the processing options can change the output
+func resultCacheKeyFor(contentHash []byte, fieldMappings map[string]fieldMapping, outputFormat string, form url.Values) string {
+	hash := sha256.New()
+	hash.Write(contentHash)
+	mappings, _ := json.Marshal(fieldMappings)
+	hash.Write(mappings)
+	fmt.Fprintf(hash, "\x00%s", outputFormat)
+	names := slices.Sorted(maps.Keys(form))
+	for _, name := range names {
+		if name == "mappings" || name == "outputFormat" {
+			continue
+		}
+		values, _ := json.Marshal(form[name])
+		fmt.Fprintf(hash, "\x00%s=%s", name, values)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// clearResultCache drops every cached result, as they were produced under the previous
+// field configuration
+func clearResultCache() {
+	if resultCache != nil {
+		resultCache.Clear()
+	}
+}
+
+// replayable reports whether a cached result can still be sent: its outputs are stored
+// remotely, or its output file has not been removed
+func replayable(result idempotency.Result) bool {
+	if result.StoredResponse != nil {
+		return true
+	}
+	_, err := os.Stat(result.OutputPath)
+	return err == nil
+}
+
+// writeProcessResult sends the processed output file along with the summary header, or
+// the JSON listing of outputs stored remotely
+func writeProcessResult(w http.ResponseWriter, result idempotency.Result) {
+	if result.StoredResponse != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(result.StoredResponse)
+		w.Write([]byte("\n"))
+		return
+	}
+	fileContent, err := os.ReadFile(result.OutputPath)
+	if err != nil {
+		sendJSONError(w, "Failed to read output file", http.StatusInternalServerError)
+		return
+	}
+
+	setProcessResultHeaders(w.Header(), result)
+	w.Write(fileContent)
+}
+
+// setProcessResultHeaders sets the response headers describing a processing result. The
+// summary is sent as its single-line digest; the full text is in the summary sidecar.
+func setProcessResultHeaders(header http.Header, result idempotency.Result) {
+	header.Set("Content-Type", result.ContentType)
+	header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, headerValue(filepath.Base(result.OutputPath))))
+	header.Set("X-Processing-Summary", summaryDigest(result.Summary))
+	if result.SummaryPath != "" {
+		header.Set("X-Summary-File", headerValue(filepath.Base(result.SummaryPath)))
+	}
+	if result.DuplicatesPath != "" {
+		header.Set("X-Duplicates-File", headerValue(filepath.Base(result.DuplicatesPath)))
+	}
+	if result.ProvenancePath != "" {
+		header.Set("X-Provenance-File", headerValue(filepath.Base(result.ProvenancePath)))
+	}
+	if result.GroupsPath != "" {
+		header.Set("X-Groups-File", headerValue(filepath.Base(result.GroupsPath)))
+	}
+	if result.ManifestPath != "" {
+		header.Set("X-Manifest-File", headerValue(filepath.Base(result.ManifestPath)))
+	}
+	if result.InputPath != "" {
+		header.Set("X-Input-File", headerValue(filepath.Base(result.InputPath)))
+	}
+	if result.Encrypted {
+		header.Set("X-Output-Encrypted", "true")
+	}
+}
+
+// headerLineBreaks replaces the line breaks a header value must not contain
+var headerLineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// headerValue makes value safe to send as a single-line HTTP header value
+func headerValue(value string) string {
+	return headerLineBreaks.Replace(value)
+}
+
+// summaryCountLine matches the count lines of a summary, such as "Successful Rows: 10"
+// or "Duplicates Removed: 2 (by Customer_ID)", but not indented or per-row lines
+var summaryCountLine = regexp.MustCompile(`^([A-Z][A-Za-z ]*): (\d+)(?: \(.*\))?$`)
+
+// maxSummaryDigestLength caps the length of the X-Processing-Summary header
+const maxSummaryDigestLength = 512
+
+// summaryDigest reduces a summary to one line of its counts for the X-Processing-Summary
+// header, e.g. "Total Rows Processed: 3; Successful Rows: 2; Rows with Missing Data: 1".
+// Per-row details, which can make the full summary arbitrarily long, are left out, and
+// counts repeated by the per-file summaries of a zip upload are added up.
+func summaryDigest(summary string) string {
+	var labels []string
+	totals := make(map[string]int)
+	for _, line := range strings.Split(summary, "\n") {
+		match := summaryCountLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		count, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		if _, seen := totals[match[1]]; !seen {
+			labels = append(labels, match[1])
+		}
+		totals[match[1]] += count
+	}
+	counts := make([]string, len(labels))
+	for i, label := range labels {
+		counts[i] = fmt.Sprintf("%s: %d", label, totals[label])
+	}
+	digest := headerValue(strings.Join(counts, "; "))
+	if len(digest) > maxSummaryDigestLength {
+		digest = digest[:maxSummaryDigestLength]
+	}
+	return digest
+}
+
+// ProgressEvent is the data of a progress event from /process-stream
+type ProgressEvent struct {
+	RowsProcessed int `json:"rowsProcessed" example:"500"`
+	TotalRows     int `json:"totalRows" example:"1000"`
+}
+
+// CompleteEvent is the data of the final complete event from /process-stream
+type CompleteEvent struct {
+	Summary        string `json:"summary" example:"Total Rows Processed: 1000 Successful Rows: 998 Rows with Missing Data: 2"`
+	OutputFilename string `json:"outputFilename" example:"1700000000_ab12cd34_processed_data.xlsx"`
+	// DownloadURL fetches the output: /download for local outputs, a signed URL when OUTPUT_SINK=s3
+	DownloadURL string `json:"downloadUrl" example:"/download?file=1700000000_ab12cd34_processed_data.xlsx"`
+	// InputFilename names the retained upload when retainInput is set
+	InputFilename string `json:"inputFilename,omitempty" example:"1700000000_ab12cd34_customers.csv"`
+}
+
+// eventStream writes processing updates to the response as Server-Sent Events, flushing
+// each one so the client sees it straight away, or every flushEvery events when that is
+// more than one. The final complete or error event is always flushed. The
+// text/event-stream headers are only sent with the first event, so failures before it
+// still get a normal JSON error.
+type eventStream struct {
+	w          http.ResponseWriter
+	flusher    http.Flusher
+	started    bool
+	flushEvery int
+	// pending is the number of events written since the last flush
+	pending int
+}
+
+// send writes one event with data encoded as JSON
+func (s *eventStream) send(event string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event, err)
+		return
+	}
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.Header().Set("Connection", "keep-alive")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload)
+	s.pending++
+	if s.pending >= s.flushEvery || event == "complete" || event == "error" {
+		s.flusher.Flush()
+		s.pending = 0
+	}
+}
+
+func (s *eventStream) progress(done, total int) {
+	s.send("progress", ProgressEvent{RowsProcessed: done, TotalRows: total})
+}
+
+func (s *eventStream) rowFailed(detail missingRowDetail) {
+	s.send("rowError", detail)
+}
+
+// fail reports an error as a JSON error response, or as an error event once events
+// have been sent and the status can no longer change
+func (s *eventStream) fail(message string, status int) {
+	if s.started {
+		s.send("error", ErrorResponse{Error: message})
+		return
+	}
+	sendJSONError(s.w, message, status)
+}
+
+// failBudget reports processing stopped by maxErrors, with the partial summary, as fail does
+func (s *eventStream) failBudget(err error, summary string) {
+	if s.started {
+		s.send("error", ErrorResponse{Error: describeInputError(err), Summary: summary})
+		return
+	}
+	sendErrorBudgetError(s.w, err, summary)
+}
+
+// failStore reports that storeOutputs failed, with the outputs it had stored, as fail does
+func (s *eventStream) failStore(stored []StoredOutput) {
+	if s.started {
+		s.send("error", ErrorResponse{Error: storeOutputsFailedMessage, StoredOutputs: stored})
+		return
+	}
+	sendStoreOutputsError(s.w, stored)
+}
+
+// @Summary      Process a file, streaming progress as Server-Sent Events
+// @Description  Takes the same form as /process but answers with a text/event-stream. progress events report rows routed so far and are sent while the rest of the file is still being mapped, a rowError event reports each row sent to the missing data output with the reasons, and a final complete event carries the summary and the output's download URL. Errors found before the first event are returned as JSON with an error status; later ones end the stream with an error event.
+// @Tags         processing
+// @Accept       multipart/form-data
+// @Produce      text/event-stream
+// @Security     ApiKeyAuth
+// @Param        file formData file true "File to process (CSV, XLSX, or gzipped .csv.gz/.tsv.gz)"
+// @Param        csvDialect formData string false "How a CSV input is written: standard (comma-separated) or european (semicolon-separated, with decimal commas in number fields)" Enums(standard,european) default(standard)
+// @Param        headerRows formData integer false "Number of header rows joined per column into composite header names, such as Sales Q1 (at most 10)" default(1)
+// @Param        headerSeparator formData string false "Separator between the parts of composite header names" default( )
+// @Param        unitsRow formData boolean false "Read the row below the header as the units of each column rather than as data" default(false)
+// @Param        mappings formData string true "JSON string of field mappings, as for /process"
+// @Param        allowEmptyMappings formData boolean false "Process mappings that name no column, as for /process" default(false)
+// @Param        allowUnmappedMandatory formData boolean false "Process mappings that leave a mandatory field without a column, as for /process" default(false)
+// @Param        flushRecords formData integer false "Events written between flushes of the stream (default from STREAM_FLUSH_RECORDS, otherwise every event); the complete and error events are always flushed"
+// @Param        outputFormat formData string false "Output format (default from DEFAULT_OUTPUT_FORMAT, otherwise xlsx)" Enums(xlsx,csv,markdown,ndjson,sql) default(xlsx)
+// @Success      200 {object} CompleteEvent "Stream of progress, rowError and complete events"
+// @Failure      400 {object} ErrorResponse "Bad Request"
+// @Failure      401 {object} ErrorResponse "Unauthorized"
+// @Failure      413 {object} ErrorResponse "Input exceeds MAX_INPUT_BYTES once decompressed"
+// @Failure      422 {object} ErrorResponse "More rows than maxErrors have missing or invalid data"
+// @Failure      500 {object} ErrorResponse "Internal Server Error"
+// @Router       /process-stream [post]
+func handleAPIProcessStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendJSONError(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filePath, ok := receiveUpload(w, r)
+	if !ok {
+		return
+	}
+
+	request, err := parseProcessRequest(r, r.FormValue("mappings"))
+	if err != nil {
+		os.Remove(filePath)
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fieldMappings, outputFormat, opts := request.fieldMappings, request.outputFormat, request.opts
+	// The upload is only kept past the request when the client asks to retain it
+	if !opts.retainInput {
+		defer os.Remove(filePath)
+	}
+
+	events := &eventStream{w: w, flusher: flusher, flushEvery: opts.flushRecords}
+	opts.events = events
+	uniqueID := generateUniqueID()
+	ctx, cancel := processingContext(r)
+	defer cancel()
+	summary, outputPath, err := processFileWithOptions(ctx, filePath, fieldMappings, currentFieldConfig().GetOrderedFields(), outputFormat, uniqueID, opts)
+	if err != nil {
+		log.Printf("Failed to process streamed upload %s: %v", filepath.Base(filePath), err)
+		if status, message, ok := interruptedProcessingError(err); ok {
+			events.fail(message, status)
+		} else if errors.Is(err, errInputTooLarge) {
+			events.fail(inputTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		} else if errors.Is(err, errErrorBudgetExceeded) {
+			events.failBudget(err, summary)
+		} else if message, ok := clientInputError(err); ok {
+			events.fail(message, http.StatusBadRequest)
+		} else if status, message, ok := outputWriteError(err); ok {
+			events.fail(message, status)
+		} else {
+			events.fail("Failed to process file", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	complete := CompleteEvent{Summary: summary, OutputFilename: filepath.Base(outputPath)}
+	complete.DownloadURL = "/download?file=" + url.QueryEscape(complete.OutputFilename)
+	if opts.retainInput {
+		complete.InputFilename = filepath.Base(filePath)
+	}
+	if outputSink.Remote() {
+		stored, err := storeOutputs(ctx, uniqueID, outputFormat)
+		if err != nil {
+			log.Printf("Failed to store outputs of %s: %v", filepath.Base(filePath), err)
+			events.failStore(stored)
+			return
+		}
+		kind := "output"
+		if opts.outputScope == outputScopeMissing {
+			kind = "missing"
+		}
+		for _, output := range stored {
+			if output.Kind == kind {
+				complete.DownloadURL = output.URL
+			}
+		}
+	}
+	events.send("complete", complete)
+}
+
+// receiveUpload saves the multipart "file" field to ./uploads under a unique name and
+// returns its path. On failure it writes a JSON error response and returns false.
+func receiveUpload(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB limit
+		sendJSONError(w, "Unable to parse form", http.StatusBadRequest)
+		return "", false
+	}
+
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		sendJSONError(w, "No file uploaded", http.StatusBadRequest)
+		return "", false
+	}
+	defer file.Close()
+
+	if !isSupportedInputFile(handler.Filename) {
+		sendJSONError(w, invalidFileTypeMessage, http.StatusBadRequest)
+		return "", false
+	}
+
+	tempFile, tempFilePath, err := createUploadFile(generateUniqueID(), handler.Filename)
+	if err != nil {
+		sendJSONError(w, "Unable to save file", http.StatusInternalServerError)
+		return "", false
+	}
+	defer tempFile.Close()
 
-	// Process rows based on the field mappings
-	for i, row := range rows {
-		// Skip header row
-		if i == 0 {
-			continue
+	if _, err := tempFile.ReadFrom(file); err != nil {
+		os.Remove(tempFilePath)
+		if isDiskFullError(err) {
+			sendJSONError(w, insufficientStorageMessage, http.StatusInsufficientStorage)
+			return "", false
 		}
+		sendJSONError(w, "Unable to save file content", http.StatusInternalServerError)
+		return "", false
+	}
+	return tempFilePath, true
+}
 
-		processedRow, missingRow, rowMissingFields, rowSuccess := processRow(row, normalizedHeaders, fieldMappings, order, fieldConfig)
-
-		if rowSuccess {
-			successfulRows++
-			outputFile.SetSheetRow("ProcessedData", fmt.Sprintf("A%d", outputRowIndex), &processedRow)
-			outputRowIndex++
+// readUploadedRows reads an uploaded file for the preview and suggestion endpoints. On
+// failure it writes a JSON error response and returns false.
+func readUploadedRows(w http.ResponseWriter, r *http.Request, filePath string) ([][]string, inputInfo, bool) {
+	dialect, err := parseCSVDialect(r.FormValue("csvDialect"))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return nil, inputInfo{}, false
+	}
+	headerRows, err := parseHeaderRows(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return nil, inputInfo{}, false
+	}
+	rows, info, err := readInputFile(r.Context(), filePath, false, dialect)
+	rows, info = combineHeaderRows(rows, info, headerRows)
+	if err == nil && len(rows) == 0 {
+		err = errNoData
+	}
+	if err != nil {
+		if errors.Is(err, errInputTooLarge) {
+			sendJSONError(w, inputTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		} else if message, ok := clientInputError(err); ok {
+			sendJSONError(w, message, http.StatusBadRequest)
 		} else {
-			missingCount++
-			outputFile.SetSheetRow("MissingData", fmt.Sprintf("A%d", missingRowIndex), &missingRow)
-			missingRowIndex++
-			if len(rowMissingFields) > 0 {
-				missingDetailsBuilder.WriteString(fmt.Sprintf("Row %d: Missing mandatory fields - %s\n", i+1, strings.Join(rowMissingFields, ", ")))
-			}
+			sendJSONError(w, "Failed to read file", http.StatusInternalServerError)
 		}
+		return nil, info, false
 	}
+	return rows, info, true
+}
 
-	// Generate and output summary
-	summary := generateProcessingSummary(len(rows)-1, successfulRows, missingCount, missingDetailsBuilder.String())
-	fmt.Println(summary)
+// defaultPreviewRows is the number of rows /preview returns when the request sets no limit.
+// PREVIEW_MAX_ROWS caps the number a request may ask for.
+const defaultPreviewRows = 10
+
+// PreviewResponse shows the header and one page of data rows of an uploaded file
+type PreviewResponse struct {
+	Headers []string `json:"headers" example:"Client Code,Customer ID,Account Number,Customer Name"`
+	// Rows are the data rows of the page, each padded or truncated to the width of the header
+	Rows [][]string `json:"rows"`
+	// TotalRows is the number of data rows in the file, excluding the header
+	TotalRows int `json:"totalRows" example:"1250"`
+	// Offset is the number of data rows before the page
+	Offset int `json:"offset" example:"0"`
+	// NextOffset is the offset of the next page; omitted on the last page
+	NextOffset int `json:"nextOffset,omitempty" example:"10"`
+	// Units are the cells of the units row, one per header, when unitsRow is set
+	Units []string `json:"units,omitempty" example:",kg,°C"`
+}
 
-	// Save the output file based on user choice
-	if outputFormat == "csv" {
-		outputFilePath, err := saveAsCSV(outputFile, order, outputRowIndex, missingRowIndex, uniqueID)
-		if err != nil {
-			fmt.Println(err)
-			return summary, ""
-		}
-		return summary, outputFilePath
+// @Summary      Preview an uploaded file
+// @Description  Return the header and a page of data rows of a file, to check it was read correctly before mapping it. Page through the rows with offset and limit, following nextOffset until it is omitted. Nothing is stored.
+// @Tags         processing
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        file formData file true "Input file (XLSX, CSV, or gzipped .csv.gz/.tsv.gz)"
+// @Param        csvDialect formData string false "How a CSV input is written: standard (comma-separated) or european (semicolon-separated, with decimal commas in number fields)" Enums(standard,european) default(standard)
+// @Param        headerRows formData integer false "Number of header rows joined per column into composite header names, such as Sales Q1 (at most 10)" default(1)
+// @Param        headerSeparator formData string false "Separator between the parts of composite header names" default( )
+// @Param        unitsRow formData boolean false "Read the row below the header as the units of each column rather than as data" default(false)
+// @Param        offset query integer false "Number of data rows to skip" default(0)
+// @Param        limit query integer false "Number of data rows to return (at most PREVIEW_MAX_ROWS, default 100)" default(10)
+// @Param        rows formData integer false "Deprecated name for limit, used when limit is not set" default(10)
+// @Success      200 {object} PreviewResponse
+// @Failure      400 {object} ErrorResponse "Bad Request"
+// @Failure      401 {object} ErrorResponse "Unauthorized"
+// @Failure      405 {object} ErrorResponse "Method Not Allowed"
+// @Router       /preview [post]
+func handleAPIPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	if outputFormat == "markdown" {
-		outputFilePath, err := saveAsMarkdown(outputFile, order, outputRowIndex, missingRowIndex, summary, uniqueID)
-		if err != nil {
-			fmt.Println(err)
-			return summary, ""
-		}
-		return summary, outputFilePath
+	filePath, ok := receiveUpload(w, r)
+	if !ok {
+		return
 	}
+	defer os.Remove(filePath)
 
-	outputFilePath := fmt.Sprintf("./uploads/%s_processed_data.xlsx", uniqueID)
-	outputFilePath, err = saveAsXLSX(outputFile, outputFilePath)
+	offset, err := parseNonNegativeIntFormValue(r, "offset")
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseNonNegativeIntFormValue(r, "limit")
+	if err == nil && limit == 0 {
+		limit, err = parseNonNegativeIntFormValue(r, "rows")
+	}
 	if err != nil {
-		fmt.Println(err)
-		return summary, ""
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit == 0 {
+		limit = defaultPreviewRows
 	}
+	limit = min(limit, featureFlags.PreviewMaxRows)
 
-	return summary, outputFilePath
+	rows, info, ok := readUploadedRows(w, r, filePath)
+	if !ok {
+		return
+	}
+
+	dataRows := rows[1:]
+	start := min(offset, len(dataRows))
+	end := min(start+limit, len(dataRows))
+	response := PreviewResponse{Headers: rows[0], Rows: [][]string{}, TotalRows: len(dataRows), Offset: offset, Units: info.units}
+	for _, row := range dataRows[start:end] {
+		padded := make([]string, len(response.Headers))
+		copy(padded, row)
+		response.Rows = append(response.Rows, padded)
+	}
+	if end < len(dataRows) {
+		response.NextOffset = end
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-func generateMarkdownTable(headers []string, rows [][]string) string {
-	var sb strings.Builder
+// ColumnInfo describes one column of an uploaded file's header
+type ColumnInfo struct {
+	// Index is the column's 0-based position
+	Index int    `json:"index" example:"2"`
+	Name  string `json:"name" example:"Account Number"`
+	// Normalized is the name as mappings are matched against it
+	Normalized string `json:"normalized" example:"account number"`
+	// UniqueName tells columns apart: a repeated name gets its occurrence number, such
+	// as "Amount (2)", and a blank one its position, such as "Column 5"
+	UniqueName string `json:"uniqueName" example:"Account Number"`
+	// Duplicate marks a repeat of an earlier column's normalized name. Mappings read
+	// the first of the columns sharing a name.
+	Duplicate bool `json:"duplicate,omitempty" example:"false"`
+}
 
-	sb.WriteString("| ")
-	for _, header := range headers {
-		sb.WriteString(header + " | ")
-	}
-	sb.WriteString("\n|")
+// ColumnsResponse lists the header of an uploaded file
+type ColumnsResponse struct {
+	Columns []ColumnInfo `json:"columns"`
+	// Sheet is the sheet read and Sheets every sheet of an XLSX workbook
+	Sheet  string   `json:"sheet,omitempty" example:"Customers"`
+	Sheets []string `json:"sheets,omitempty" example:"Customers,Accounts"`
+}
 
-	for range headers {
-		sb.WriteString(" --- |")
+// describeColumns lists header's columns with their normalized and unique names
+func describeColumns(header []string) []ColumnInfo {
+	columns := make([]ColumnInfo, 0, len(header))
+	taken := make(map[string]bool)
+	for _, name := range header {
+		taken[normalizeHeader(name)] = true
 	}
-	sb.WriteString("\n")
-
-	// Write data rows
-	for _, row := range rows {
-		sb.WriteString("| ")
-		for _, cell := range row {
-			escapedCell := strings.ReplaceAll(cell, "|", "\\|")
-			sb.WriteString(escapedCell + " | ")
+	occurrences := make(map[string]int)
+	for i, name := range header {
+		column := ColumnInfo{Index: i, Name: name, Normalized: normalizeHeader(name), UniqueName: name}
+		switch {
+		case column.Normalized == "":
+			column.UniqueName = fmt.Sprintf("Column %d", i+1)
+		case occurrences[column.Normalized] > 0:
+			column.Duplicate = true
+			for n := occurrences[column.Normalized] + 1; ; n++ {
+				column.UniqueName = fmt.Sprintf("%s (%d)", strings.TrimSpace(name), n)
+				if !taken[normalizeHeader(column.UniqueName)] {
+					break
+				}
+			}
+			taken[normalizeHeader(column.UniqueName)] = true
 		}
-		sb.WriteString("\n")
+		occurrences[column.Normalized]++
+		columns = append(columns, column)
 	}
-
-	return sb.String()
+	return columns
 }
 
-func handleDownload(w http.ResponseWriter, r *http.Request) {
-	file := r.URL.Query().Get("file")
-	if file == "" {
-		http.Error(w, "Missing file parameter", http.StatusBadRequest)
+// @Summary      List the columns of an uploaded file
+// @Description  Return the header of a file with each column's normalized name, as mappings are matched against it, and a unique name telling repeated and blank headers apart. Only the header rows are read, so this is much cheaper than /preview on large files. Nothing is stored.
+// @Tags         processing
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        file formData file true "Input file (XLSX, CSV, or gzipped .csv.gz/.tsv.gz)"
+// @Param        csvDialect formData string false "How a CSV input is written: standard (comma-separated) or european (semicolon-separated, with decimal commas in number fields)" Enums(standard,european) default(standard)
+// @Param        headerRows formData integer false "Number of header rows joined per column into composite header names, such as Sales Q1 (at most 10)" default(1)
+// @Param        headerSeparator formData string false "Separator between the parts of composite header names" default( )
+// @Param        sheet formData string false "Sheet of an XLSX workbook to read (default the first)"
+// @Success      200 {object} ColumnsResponse
+// @Failure      400 {object} ErrorResponse "Bad Request"
+// @Failure      401 {object} ErrorResponse "Unauthorized"
+// @Failure      405 {object} ErrorResponse "Method Not Allowed"
+// @Failure      413 {object} ErrorResponse "Input exceeds MAX_INPUT_BYTES once decompressed"
+// @Router       /columns [post]
+func handleAPIColumns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if strings.Contains(file, "..") || strings.ContainsAny(file, `/\`) {
-		http.Error(w, "Invalid file path", http.StatusBadRequest)
+	filePath, ok := receiveUpload(w, r)
+	if !ok {
 		return
 	}
+	defer os.Remove(filePath)
 
-	filePath := filepath.Join("./uploads", file)
+	dialect, err := parseCSVDialect(r.FormValue("csvDialect"))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	headerRows, err := parseHeaderRows(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Only the header is read, so a units row below it is of no interest
+	headerRows.units = false
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
+	rows, sheet, sheets, err := readInputHeader(r.Context(), filePath, dialect, r.FormValue("sheet"), max(headerRows.rows, 1))
+	if err == nil && len(rows) == 0 {
+		err = errNoData
+	}
+	if err != nil {
+		if errors.Is(err, errInputTooLarge) {
+			sendJSONError(w, inputTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		} else if message, ok := clientInputError(err); ok {
+			sendJSONError(w, message, http.StatusBadRequest)
+		} else {
+			sendJSONError(w, "Failed to read file", http.StatusInternalServerError)
+		}
 		return
 	}
+	rows, _ = combineHeaderRows(rows, inputInfo{}, headerRows)
 
-	http.ServeFile(w, r, filePath)
+	response := ColumnsResponse{Columns: describeColumns(rows[0]), Sheet: sheet, Sheets: sheets}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
+// MappingSuggestion is the source column proposed for one configured field
+type MappingSuggestion struct {
+	Field       string `json:"field" example:"Customer_ID"`
+	DisplayName string `json:"displayName" example:"Customer ID"`
+	IsMandatory bool   `json:"isMandatory" example:"true"`
+	// Column is the suggested source header; empty when no header matched
+	Column string `json:"column,omitempty" example:"customer id"`
+	// MatchedBy is "name" when Column matched the field's Name or DisplayName and "alias"
+	// when it matched one of its configured aliases. Both are exact matches.
+	MatchedBy string `json:"matchedBy,omitempty" example:"name"`
+	// InferredType is the type inferred for Column from its values; see ColumnType
+	InferredType string `json:"inferredType,omitempty" example:"int"`
+}
+
+// SuggestMappingsResponse proposes mappings for an uploaded file's headers
+type SuggestMappingsResponse struct {
+	// Suggestions has one entry per configured field, in field order
+	Suggestions []MappingSuggestion `json:"suggestions"`
+	// Mappings holds the matched suggestions in the form accepted by /process
+	Mappings map[string]string `json:"mappings" example:"Client_Code:Client Code,Customer_ID:customer id"`
+	// UnmappedColumns are source headers no field was matched to
+	UnmappedColumns []string `json:"unmappedColumns" example:"Region,Notes"`
+	// MissingMandatory lists mandatory fields without a suggestion
+	MissingMandatory []string `json:"missingMandatory" example:"Account_ID"`
+	// ColumnTypes has the type inferred for every source header, in file order
+	ColumnTypes []ColumnType `json:"columnTypes"`
+}
+
+// mappingKey is config.MappingKey of the normalized header, so that with
+// ACCENT_INSENSITIVE_HEADERS accents are ignored too
+func mappingKey(name string) string {
+	return config.MappingKey(normalizeHeader(name))
+}
+
+// aliasMappings returns fieldMappings with every field that has no mapping, or whose
+// mapped column is not in the file, mapped to the first header equal to one of its
+// Aliases, ignoring case, spacing and punctuation. Lookup fields are never mapped.
+func aliasMappings(headers []string, fieldMappings map[string]fieldMapping, fieldConfig *config.FieldConfig) map[string]fieldMapping {
+	normalizedHeaders := normalizeHeaders(headers)
+	resolved := make(map[string]fieldMapping, len(fieldMappings))
+	maps.Copy(resolved, fieldMappings)
+	for _, field := range fieldConfig.Fields {
+		if len(field.Aliases) == 0 || field.Lookup != nil || fieldMappings[field.Name].found(normalizedHeaders) {
+			continue
+		}
+		if header, ok := matchHeader(headers, nil, field.Aliases); ok {
+			resolved[field.Name] = columnMapping(header)
 		}
 	}
-	return false
+	return resolved
 }
 
-type FieldConfigResponse struct {
-	Fields []struct {
-		Name        string `json:"name" example:"Client_Code"`
-		DisplayName string `json:"displayName" example:"Client Code"`
-		IsMandatory bool   `json:"isMandatory" example:"true"`
-	} `json:"fields"`
-	MandatoryFields []string `json:"mandatoryFields" example:"Client_Code,Customer_ID,Account_ID"`
+// matchHeader returns the first header, skipping those marked used, equal to one of
+// names ignoring case, spacing and punctuation. Earlier names take precedence.
+func matchHeader(headers []string, used []bool, names []string) (string, bool) {
+	for _, name := range names {
+		key := mappingKey(name)
+		if key == "" {
+			continue
+		}
+		for i, header := range headers {
+			if (used == nil || !used[i]) && mappingKey(header) == key {
+				if used != nil {
+					used[i] = true
+				}
+				return header, true
+			}
+		}
+	}
+	return "", false
 }
 
-// @Summary     Get field configuration
-// @Description Get the configuration of all fields, including mandatory fields and field order
-// @Tags        configuration
-// @Accept      json
-// @Produce     json
-// @Security    ApiKeyAuth
-// @Success     200 {object} FieldConfigResponse
-// @Failure     401 {object} ErrorResponse "Unauthorized"
-// @Failure     405 {object} ErrorResponse "Method Not Allowed"
-// @Router      /config [get]
-func handleAPIConfig(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// suggestMappings matches each configured field to the first unused header equal to its
+// Name or DisplayName, or failing that one of its Aliases, ignoring case, spacing and
+// punctuation
+func suggestMappings(headers []string, fieldConfig *config.FieldConfig) SuggestMappingsResponse {
+	response := SuggestMappingsResponse{
+		Suggestions:      make([]MappingSuggestion, 0, len(fieldConfig.Fields)),
+		Mappings:         make(map[string]string),
+		UnmappedColumns:  []string{},
+		MissingMandatory: []string{},
+	}
+	used := make([]bool, len(headers))
+	for _, field := range fieldConfig.OrderedFieldList() {
+		suggestion := MappingSuggestion{Field: field.Name, DisplayName: field.DisplayName, IsMandatory: fieldConfig.IsFieldMandatory(field)}
+		if header, ok := matchHeader(headers, used, []string{field.Name, field.DisplayName}); ok {
+			suggestion.Column, suggestion.MatchedBy = header, "name"
+		} else if header, ok := matchHeader(headers, used, field.Aliases); ok {
+			suggestion.Column, suggestion.MatchedBy = header, "alias"
+		}
+		if suggestion.Column != "" {
+			response.Mappings[field.Name] = suggestion.Column
+		}
+		if suggestion.Column == "" && suggestion.IsMandatory {
+			response.MissingMandatory = append(response.MissingMandatory, field.Name)
+		}
+		response.Suggestions = append(response.Suggestions, suggestion)
+	}
+	for i, header := range headers {
+		if !used[i] && strings.TrimSpace(header) != "" {
+			response.UnmappedColumns = append(response.UnmappedColumns, header)
+		}
 	}
+	return response
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"fields":          fieldConfig.Fields,
-		"mandatoryFields": fieldConfig.GetMandatoryFields(),
-		"orderedFields":   fieldConfig.GetOrderedFields(),
-	})
+// Types inferred for source columns by inferColumnTypes
+const (
+	inferredInt    = "int"
+	inferredFloat  = "float"
+	inferredDate   = "date"
+	inferredBool   = "bool"
+	inferredString = "string"
+)
+
+// defaultSampleRows and maxSampleRows bound the data rows sampled to infer column types
+const (
+	defaultSampleRows = 100
+	maxSampleRows     = 1000
+)
+
+// typeInferenceThreshold is the share of a column's sampled values that must parse as a
+// type for the column to be inferred as that type rather than string
+const typeInferenceThreshold = 0.9
+
+// inferredDateLayouts are the date layouts a value may use to count as a date
+var inferredDateLayouts = []string{
+	"2006-01-02", "2006/01/02", "01/02/2006", "02/01/2006", "1/2/2006", "02.01.2006",
+	"02-Jan-2006", "Jan 2, 2006", "2 Jan 2006", time.RFC3339, "2006-01-02 15:04:05",
 }
 
-// ProcessResponse represents the file processing response
-type ProcessResponse struct {
-	Summary     string `json:"summary" example:"Total Rows Processed: 1000 Successful Rows: 1000 Rows with Missing Data: 0"`
-	FileName    string `json:"fileName" example:"processed_data.xlsx"`
-	ContentType string `json:"contentType" example:"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"`
+// inferredBoolValues are the values, compared case-insensitively, that count as booleans
+var inferredBoolValues = []string{"true", "false", "yes", "no", "y", "n"}
+
+// typeCheckers test a trimmed value against each inferable type, most specific first
+var typeCheckers = []struct {
+	name  string
+	check func(string) bool
+}{
+	{inferredBool, func(value string) bool {
+		return slices.Contains(inferredBoolValues, strings.ToLower(value))
+	}},
+	{inferredInt, func(value string) bool {
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	}},
+	{inferredFloat, func(value string) bool {
+		number, err := strconv.ParseFloat(value, 64)
+		return err == nil && !math.IsInf(number, 0) && !math.IsNaN(number)
+	}},
+	{inferredDate, func(value string) bool {
+		return slices.ContainsFunc(inferredDateLayouts, func(layout string) bool {
+			_, err := time.Parse(layout, value)
+			return err == nil
+		})
+	}},
 }
 
-// @Summary      Process file with field mappings
-// @Description  Upload a file and process it according to provided field mappings
+// ColumnType is the type inferred for a source column from a sample of its values
+type ColumnType struct {
+	Column string `json:"column" example:"Start Date"`
+	// Type is int, float, date, bool or string
+	Type string `json:"type" example:"date"`
+	// Confidence is the share of the sampled non-empty values that parse as Type
+	Confidence float64 `json:"confidence" example:"0.97"`
+	// Sampled is the number of non-empty values the inference is based on
+	Sampled int `json:"sampled" example:"100"`
+}
+
+// inferColumnTypes infers the type of every column from its non-empty values in the
+// first sampleRows data rows. A column is given the first of bool, int, float and date
+// that at least typeInferenceThreshold of its values parse as, so a column of whole
+// numbers is int even though they also parse as floats. Columns without such a type,
+// or without values, are string.
+func inferColumnTypes(rows [][]string, sampleRows int) []ColumnType {
+	headers := rows[0]
+	sample := rows[1:min(len(rows), sampleRows+1)]
+	columnTypes := make([]ColumnType, len(headers))
+	for j, header := range headers {
+		columnType := ColumnType{Column: header, Type: inferredString}
+		matches := make([]int, len(typeCheckers))
+		for _, row := range sample {
+			if j >= len(row) || strings.TrimSpace(row[j]) == "" {
+				continue
+			}
+			columnType.Sampled++
+			for k, checker := range typeCheckers {
+				if checker.check(strings.TrimSpace(row[j])) {
+					matches[k]++
+				}
+			}
+		}
+		if columnType.Sampled > 0 {
+			columnType.Confidence = 1
+			for k, checker := range typeCheckers {
+				if share := float64(matches[k]) / float64(columnType.Sampled); share >= typeInferenceThreshold {
+					columnType.Type, columnType.Confidence = checker.name, math.Round(share*100)/100
+					break
+				}
+			}
+		}
+		columnTypes[j] = columnType
+	}
+	return columnTypes
+}
+
+// @Summary      Suggest field mappings for an uploaded file
+// @Description  Match the file's headers to the configured fields by name or display name, then by configured alias, ignoring case, spacing and punctuation, and infer each column's type (int, float, date, bool or string) from a sample of its values. The returned mappings can be sent as-is to /process. Nothing is stored.
 // @Tags         processing
 // @Accept       multipart/form-data
-// @Produce      application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
-// @Produce      text/csv
-// @Produce      text/markdown
+// @Produce      json
 // @Security     ApiKeyAuth
-// @Param        file formData file true "File to process (CSV or XLSX)"
-// @Param        mappings formData string true "JSON string of field mappings" example:"{\"Client_Code\":\"Client Code\",\"Customer_ID\":\"Customer ID\",\"Account_ID\":\"Account Number\"}"
-// @Param        outputFormat formData string false "Output format" Enums(xlsx,csv,markdown) default(xlsx)
-// @Success      200 {object} ProcessResponse
-// @Header       200 {string} X-Processing-Summary "Total Rows Processed: 1000 Successful Rows: 1000 Rows with Missing Data: 0"
-// @Header       200 {string} Content-Type "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
-// @Header       200 {string} Content-Disposition "attachment; filename=\"processed_data.xlsx\""
+// @Param        file formData file true "Input file (XLSX, CSV, or gzipped .csv.gz/.tsv.gz)"
+// @Param        csvDialect formData string false "How a CSV input is written: standard (comma-separated) or european (semicolon-separated, with decimal commas in number fields)" Enums(standard,european) default(standard)
+// @Param        headerRows formData integer false "Number of header rows joined per column into composite header names, such as Sales Q1 (at most 10)" default(1)
+// @Param        headerSeparator formData string false "Separator between the parts of composite header names" default( )
+// @Param        unitsRow formData boolean false "Read the row below the header as the units of each column rather than as data" default(false)
+// @Param        sampleRows formData integer false "Data rows sampled to infer column types (max 1000)" default(100)
+// @Success      200 {object} SuggestMappingsResponse
 // @Failure      400 {object} ErrorResponse "Bad Request"
 // @Failure      401 {object} ErrorResponse "Unauthorized"
-// @Failure      500 {object} ErrorResponse "Internal Server Error"
-// @Router       /process [post]
-func handleAPIProcess(w http.ResponseWriter, r *http.Request) {
+// @Failure      405 {object} ErrorResponse "Method Not Allowed"
+// @Router       /suggest-mappings [post]
+func handleAPISuggestMappings(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parse multipart form
-	err := r.ParseMultipartForm(10 << 20) // 10MB limit
-	if err != nil {
-		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+	filePath, ok := receiveUpload(w, r)
+	if !ok {
 		return
 	}
+	defer os.Remove(filePath)
 
-	// Get the file
-	file, handler, err := r.FormFile("file")
+	sampleRows, err := parseNonNegativeIntFormValue(r, "sampleRows")
 	if err != nil {
-		sendJSONError(w, "No file uploaded", http.StatusBadRequest)
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	if sampleRows == 0 {
+		sampleRows = defaultSampleRows
+	}
+	sampleRows = min(sampleRows, maxSampleRows)
 
-	// Validate file type
-	if !strings.HasSuffix(handler.Filename, ".xlsx") && !strings.HasSuffix(handler.Filename, ".csv") {
-		sendJSONError(w, "Invalid file type. Only .csv and .xlsx files are allowed", http.StatusBadRequest)
+	rows, _, ok := readUploadedRows(w, r, filePath)
+	if !ok {
 		return
 	}
 
-	// Get field mappings from JSON
-	var fieldMappings map[string]string
-	mappingsStr := r.FormValue("mappings")
-	if err := json.Unmarshal([]byte(mappingsStr), &fieldMappings); err != nil {
-		sendJSONError(w, "Invalid field mappings format", http.StatusBadRequest)
-		return
+	response := suggestMappings(rows[0], currentFieldConfig())
+	response.ColumnTypes = inferColumnTypes(rows, sampleRows)
+	for i, suggestion := range response.Suggestions {
+		if j := slices.Index(rows[0], suggestion.Column); suggestion.Column != "" && j != -1 {
+			response.Suggestions[i].InferredType = response.ColumnTypes[j].Type
+		}
 	}
 
-	// Generate unique ID for this upload to prevent race conditions
-	uniqueID := generateUniqueID()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Save file temporarily
-	tempDir := "./uploads"
-	os.MkdirAll(tempDir, os.ModePerm)
-	tempFilePath := filepath.Join(tempDir, fmt.Sprintf("%s_%s", uniqueID, handler.Filename))
-	tempFile, err := os.Create(tempFilePath)
-	if err != nil {
-		sendJSONError(w, "Unable to save file", http.StatusInternalServerError)
-		return
+// Field outcomes reported by /explain
+const (
+	explainOK      = "ok"
+	explainMissing = "missing"
+	explainEmpty   = "empty"
+	explainSkipped = "skipped"
+	explainInvalid = "invalid"
+)
+
+// FieldExplanation describes how one field of a row was mapped
+type FieldExplanation struct {
+	Field       string `json:"field" example:"Customer_ID"`
+	DisplayName string `json:"displayName" example:"Customer ID"`
+	IsMandatory bool   `json:"isMandatory" example:"true"`
+	// SourceColumn is the mapped column, or the source field's column for a lookup field.
+	// A coalesce mapping lists its candidate columns in order.
+	SourceColumn string `json:"sourceColumn,omitempty" example:"Customer ID"`
+	// ColumnFound reports whether SourceColumn is one of the file's headers
+	ColumnFound bool `json:"columnFound" example:"true"`
+	// RawValue is the cell as read from the file
+	RawValue string `json:"rawValue" example:"  "`
+	// Value is the output value after conversions and transforms
+	Value string `json:"value" example:""`
+	// Present reports whether the value counted as populated
+	Present bool `json:"present" example:"false"`
+	// Status is ok, missing, empty (optional and blank), skipped (optional and unmapped) or invalid
+	Status string `json:"status" example:"missing"`
+	Reason string `json:"reason" example:"mandatory field is empty in this row"`
+}
+
+// ExplainResponse breaks down how a single row was mapped
+type ExplainResponse struct {
+	Row int `json:"row" example:"7"`
+	// Success reports whether the row goes to the processed output rather than the missing data report
+	Success     bool               `json:"success" example:"false"`
+	Fields      []FieldExplanation `json:"fields"`
+	FailedRules []string           `json:"failedRules" example:"us-zip"`
+	// Warnings names the warn-level rules the row fails, which do not affect Success
+	Warnings []string `json:"warnings,omitempty" example:"phone-format"`
+}
+
+// explainRow maps the row at index the same way processing does and describes the outcome for each field
+func explainRow(rows [][]string, index int, fieldMappings map[string]fieldMapping, fieldConfig *config.FieldConfig, info inputInfo) ExplainResponse {
+	order := fieldConfig.GetOrderedFields()
+	normalizedHeaders := normalizeHeaders(rows[0])
+	row := rows[index]
+	mapped := row
+	if info.decimalComma {
+		mapped = decimalCommaRow(row, decimalCommaColumns(normalizedHeaders, fieldMappings, fieldConfig))
 	}
-	defer tempFile.Close()
+	processedRow, missingRow, _, success := processRow(mapped, normalizedHeaders, fieldMappings, order, fieldConfig, info.date1904)
+	failedRules, warnings := applyRules(processedRow, missingRow, order, fieldConfig)
 
-	_, err = tempFile.ReadFrom(file)
-	if err != nil {
-		sendJSONError(w, "Unable to save file content", http.StatusInternalServerError)
-		return
+	_, rowNumber := rowLocation(index, info)
+	response := ExplainResponse{Row: rowNumber, Success: success && len(failedRules) == 0, FailedRules: failedRules, Warnings: warnings}
+	if response.FailedRules == nil {
+		response.FailedRules = []string{}
+	}
+	masks := make(map[string]*config.Mask)
+	for _, field := range fieldConfig.Fields {
+		masks[field.Name] = field.Mask
+	}
+	for i, field := range fieldConfig.OrderedFieldList() {
+		mapping := fieldMappings[field.Name]
+		if field.Lookup != nil {
+			mapping = fieldMappings[field.Lookup.SourceField]
+		}
+		explanation := FieldExplanation{
+			Field:        field.Name,
+			DisplayName:  field.DisplayName,
+			IsMandatory:  fieldConfig.IsFieldMandatory(field),
+			SourceColumn: mapping.String(),
+			ColumnFound:  mapping.found(normalizedHeaders),
+			RawValue:     mapping.value(row, normalizedHeaders),
+			Value:        processedRow[i],
+		}
+		if explanation.RawValue == "" && mapping != nil {
+			explanation.RawValue = sourceCell(row, normalizedHeaders, mapping[0].column)
+		}
+		explanation.Present = strings.TrimSpace(processedRow[i]) != ""
+		// Masked values are hidden as in the outputs; a lookup's raw value is its source field's
+		rawMask := masks[field.Name]
+		if field.Lookup != nil {
+			rawMask = masks[field.Lookup.SourceField]
+		}
+		explanation.RawValue = maskNonBlank(explanation.RawValue, rawMask)
+		explanation.Value = maskNonBlank(explanation.Value, masks[field.Name])
+		explanation.Status, explanation.Reason = explainField(field, explanation, missingRow[i])
+		response.Fields = append(response.Fields, explanation)
 	}
+	return response
+}
 
-	// Get output format
-	outputFormat := r.FormValue("outputFormat")
-	if outputFormat == "" {
-		outputFormat = "xlsx" // Default format
+// maskNonBlank masks value with mask, if there is one. Blank values are kept so that they
+// still read as blank.
+func maskNonBlank(value string, mask *config.Mask) string {
+	if mask == nil || strings.TrimSpace(value) == "" {
+		return value
 	}
+	return mask.Apply(value)
+}
 
-	// Process the file
-	order := fieldConfig.GetOrderedFields()
-	summary, outputPath := processFile(tempFilePath, fieldMappings, order, outputFormat, uniqueID)
+// explainField returns the status and reason for one field given its explanation so far
+// and the value processing wrote to the missing data report
+func explainField(field config.Field, explanation FieldExplanation, missingValue string) (string, string) {
+	switch {
+	case missingValue == "INVALID":
+		return explainInvalid, "value failed a validation rule"
+	case field.RequiredIf != nil && missingValue == field.RequiredIfMissingValue():
+		return explainMissing, fmt.Sprintf("field is required when %s but has no value in this row", field.RequiredIf)
+	case field.Lookup != nil && missingValue == field.MissingValue():
+		if strings.TrimSpace(explanation.RawValue) != "" {
+			return explainMissing, fmt.Sprintf("lookup source value %q is not in the lookup table", explanation.RawValue)
+		}
+		return explainMissing, fmt.Sprintf("mandatory lookup has no value from source field %s", field.Lookup.SourceField)
+	case field.Lookup != nil:
+		return explainOK, fmt.Sprintf("looked up from source field %s", field.Lookup.SourceField)
+	case explanation.SourceColumn == "" && explanation.IsMandatory:
+		return explainMissing, "mandatory field is not mapped"
+	case explanation.SourceColumn == "":
+		return explainSkipped, "optional field is not mapped"
+	case !explanation.ColumnFound && explanation.IsMandatory:
+		return explainMissing, fmt.Sprintf("mapped column %q is not in the file's headers", explanation.SourceColumn)
+	case !explanation.ColumnFound:
+		return explainEmpty, fmt.Sprintf("mapped column %q is not in the file's headers", explanation.SourceColumn)
+	case missingValue == field.MissingValue() && explanation.IsMandatory:
+		return explainMissing, "mandatory field is empty in this row"
+	case missingValue == field.MissingValue():
+		return explainEmpty, "optional field is empty in this row"
+	}
+	return explainOK, "value present"
+}
 
-	// Check if the output file exists
-	if _, err := os.Stat(outputPath); err != nil {
-		sendJSONError(w, "Failed to generate output file", http.StatusInternalServerError)
+// @Summary      Explain how one row is mapped
+// @Description  Map a single row of the file and report, for every configured field, the source column, the raw and output values, whether the value counted as present and why the field passed or failed. Nothing is stored.
+// @Tags         processing
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        file formData file true "Input file (XLSX, CSV, or gzipped .csv.gz/.tsv.gz)"
+// @Param        csvDialect formData string false "How a CSV input is written: standard (comma-separated) or european (semicolon-separated, with decimal commas in number fields)" Enums(standard,european) default(standard)
+// @Param        headerRows formData integer false "Number of header rows joined per column into composite header names, such as Sales Q1 (at most 10)" default(1)
+// @Param        headerSeparator formData string false "Separator between the parts of composite header names" default( )
+// @Param        unitsRow formData boolean false "Read the row below the header as the units of each column rather than as data" default(false)
+// @Param        mappings formData string true "JSON string of field mappings, keyed by field name or display name"
+// @Param        row formData integer true "Spreadsheet row number to explain, as reported in summaries (the header is row 1)"
+// @Success      200 {object} ExplainResponse
+// @Failure      400 {object} ErrorResponse "Bad Request"
+// @Failure      401 {object} ErrorResponse "Unauthorized"
+// @Failure      405 {object} ErrorResponse "Method Not Allowed"
+// @Router       /explain [post]
+func handleAPIExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filePath, ok := receiveUpload(w, r)
+	if !ok {
 		return
 	}
+	defer os.Remove(filePath)
 
-	// Read the file
-	fileContent, err := os.ReadFile(outputPath)
+	fieldMappings, err := parseFieldMappings(r.FormValue("mappings"))
 	if err != nil {
-		sendJSONError(w, "Failed to read output file", http.StatusInternalServerError)
+		sendJSONError(w, "Invalid field mappings format", http.StatusBadRequest)
+		return
+	}
+	if len(fieldMappings) > featureFlags.MaxMappingFields {
+		sendJSONError(w, tooManyFieldsMessage(), http.StatusBadRequest)
 		return
 	}
+	fieldConfig := currentFieldConfig()
+	fieldMappings = config.NormalizeMappings(fieldConfig, fieldMappings)
 
-	// Set appropriate headers based on output format
-	contentType := "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
-	if outputFormat == "csv" {
-		contentType = "text/csv"
-	} else if outputFormat == "markdown" {
-		contentType = "text/markdown"
+	rowNumber, err := strconv.Atoi(r.FormValue("row"))
+	if err != nil {
+		sendJSONError(w, "Invalid row: must be a spreadsheet row number", http.StatusBadRequest)
+		return
 	}
 
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(outputPath)))
-	w.Header().Set("X-Processing-Summary", summary)
-	w.Write(fileContent)
+	rows, info, ok := readUploadedRows(w, r, filePath)
+	if !ok {
+		return
+	}
+	if len(rows) == 1 {
+		sendJSONError(w, describeInputError(errNoDataRows), http.StatusBadRequest)
+		return
+	}
+	// The header takes row 1, or rows 1 to headerRows, then any units row, and the data rows follow
+	firstDataRow := 2 + info.extraHeaderRows
+	lastDataRow := len(rows) + info.extraHeaderRows
+	if rowNumber < firstDataRow || rowNumber > lastDataRow {
+		sendJSONError(w, fmt.Sprintf("Row %d is out of range: data rows are numbered %d to %d", rowNumber, firstDataRow, lastDataRow), http.StatusBadRequest)
+		return
+	}
+
+	// Fields are matched by alias here just as when the file is processed
+	fieldMappings = aliasMappings(rows[0], fieldMappings, fieldConfig)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(explainRow(rows, rowNumber-1-info.extraHeaderRows, fieldMappings, fieldConfig, info))
 }
 
 func sendJSONError(w http.ResponseWriter, message string, status int) {
@@ -846,4 +6892,9 @@ func sendJSONError(w http.ResponseWriter, message string, status int) {
 
 type ErrorResponse struct {
 	Error string `json:"error" example:"Invalid field mappings format"`
+	// Summary describes the rows processed before maxErrors stopped processing
+	Summary string `json:"summary,omitempty"`
+	// StoredOutputs are the outputs stored remotely before storing another one failed; the
+	// outputs not stored are discarded
+	StoredOutputs []StoredOutput `json:"storedOutputs,omitempty"`
 }
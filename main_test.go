@@ -1,20 +1,39 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"maps"
+	"math"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"import/auth"
+	"import/config"
+	"import/encryption"
+	"import/idempotency"
+	"import/storage"
 
 	"github.com/xuri/excelize/v2"
 )
@@ -92,6 +111,8 @@ func TestHandleUploadCSVFile(t *testing.T) {
 	_ = writer.WriteField("mapping_Account Active", "Account Active")
 	_ = writer.WriteField("mapping_Customer Name", "Customer Name")
 	_ = writer.WriteField("mapping_Customer ID", "Customer ID")
+	// None of the mappings name a configured field
+	_ = writer.WriteField("allowUnmappedMandatory", "true")
 
 	writer.Close()
 
@@ -306,7 +327,7 @@ func TestProcessFileSuccess(t *testing.T) {
 	order := []string{"Client Code", "Customer ID", "Account Number"}
 	outputFormat := "excel"
 	uniqueID := "test_" + generateUniqueID()
-	summary, errStr := processFile(tempFile.Name(), fieldMappings, order, outputFormat, uniqueID)
+	summary, errStr := processFile(tempFile.Name(), columnMappings(fieldMappings), order, outputFormat, uniqueID)
 
 	if errStr != "" && !strings.Contains(errStr, "processed_data.xlsx") {
 		t.Errorf("unexpected error string: got %v", errStr)
@@ -328,7 +349,7 @@ func TestProcessFileInvalidFile(t *testing.T) {
 	order := []string{"Client Code", "Customer ID", "Account Number"}
 	outputFormat := "excel"
 	uniqueID := "test_" + generateUniqueID()
-	_, errStr := processFile(invalidFilePath, fieldMappings, order, outputFormat, uniqueID)
+	_, errStr := processFile(invalidFilePath, columnMappings(fieldMappings), order, outputFormat, uniqueID)
 
 	if errStr == "" || !strings.Contains(errStr, "Error opening file") {
 		t.Errorf("expected error string for invalid file path: got %v", errStr)
@@ -359,7 +380,7 @@ func TestProcessFileCSVOutput(t *testing.T) {
 	outputFormat := "csv"
 	uniqueID := "test_" + generateUniqueID()
 
-	summary, processedFilePath := processFile(tempFile.Name(), fieldMappings, order, outputFormat, uniqueID)
+	summary, processedFilePath := processFile(tempFile.Name(), columnMappings(fieldMappings), order, outputFormat, uniqueID)
 
 	if summary == "" {
 		t.Errorf("unexpected empty summary")
@@ -536,6 +557,80 @@ func TestGenerateMarkdownTable(t *testing.T) {
 	}
 }
 
+// TestEscapeMarkdownCell verifies markdown syntax and control characters in values are neutralised
+// without splitting multibyte characters
+func TestEscapeMarkdownCell(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"backticks", "run `rm -rf`", "run \\`rm -rf\\`"},
+		{"backslash", `C:\temp\`, `C:\\temp\\`},
+		{"escaped pipe stays escaped", `a\|b`, `a\\\|b`},
+		{"control character dropped", "bell\x07 here\x00", "bell here"},
+		{"tab becomes space", "a\tb", "a b"},
+		{"line breaks", "one\r\ntwo", "one<br>two"},
+		{"emoji and accents kept", "café 👩‍💻 | ok", "café 👩‍💻 \\| ok"},
+		{"invalid UTF-8 replaced", "bad\xffbyte", "bad\uFFFDbyte"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := escapeMarkdownCell(tc.value); result != tc.expected {
+				t.Errorf("escapeMarkdownCell(%q) = %q, want %q", tc.value, result, tc.expected)
+			}
+		})
+	}
+
+	result := generateMarkdownTable([]string{"Code`"}, [][]string{{"x\x1by"}})
+	if result != "| Code\\` | \n| --- |\n| xy | \n" {
+		t.Errorf("Unexpected markdown table:\n%s", result)
+	}
+}
+
+// TestMarkdownMaxRows verifies markdown tables stop at markdownMaxRows with a note while the summary counts every row
+func TestMarkdownMaxRows(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	var content strings.Builder
+	content.WriteString("Client Code,Customer ID,Account Number\n")
+	for i := 1; i <= 12; i++ {
+		fmt.Fprintf(&content, "C%d,%d,A%d\n", i, 1000+i, i)
+	}
+	content.WriteString("C13,,A13\n")
+	inputPath := writeTempCSV(t, content.String())
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Customer_ID": "Customer ID", "Account_ID": "Account Number"}
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "markdown", uniqueID, processOptions{markdownMaxRows: 5})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "markdown")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	if !strings.Contains(summary, "Successful Rows: 12") {
+		t.Errorf("Expected the summary to count every row, got:\n%s", summary)
+	}
+	output, _ := os.ReadFile(outputPath)
+	markdown := string(output)
+	if !strings.Contains(markdown, "| C5 |") || strings.Contains(markdown, "| C6 |") {
+		t.Errorf("Expected the table to stop after 5 rows, got:\n%s", markdown)
+	}
+	if !strings.HasSuffix(markdown, "\n_... 7 more rows omitted; download CSV for full data_\n") {
+		t.Errorf("Expected a note counting the omitted rows, got:\n%s", markdown)
+	}
+
+	// A table within the limit has no note
+	missing, _ := os.ReadFile(missingPath)
+	if !strings.Contains(string(missing), "| C13 |") || strings.Contains(string(missing), "omitted") {
+		t.Errorf("Expected the complete missing data table, got:\n%s", missing)
+	}
+}
+
 func TestProcessFileMarkdownOutput(t *testing.T) {
 	tempFile, err := os.CreateTemp("./uploads", "test_process_*.xlsx")
 	if err != nil {
@@ -577,7 +672,7 @@ func TestProcessFileMarkdownOutput(t *testing.T) {
 	order := []string{"Account Number", "Account Active", "Customer Name"}
 	uniqueID := "test_" + generateUniqueID()
 
-	summary, outputPath := processFile(tempFile.Name(), fieldMappings, order, "markdown", uniqueID)
+	summary, outputPath := processFile(tempFile.Name(), columnMappings(fieldMappings), order, "markdown", uniqueID)
 
 	if !strings.Contains(summary, "Total Rows Processed") {
 		t.Error("Summary missing expected content")
@@ -772,6 +867,9 @@ func TestHandleAPIProcess(t *testing.T) {
 			if err := writer.WriteField("mappings", string(mappingsJSON)); err != nil {
 				t.Fatal(err)
 			}
+			if err := writer.WriteField("allowUnmappedMandatory", "true"); err != nil {
+				t.Fatal(err)
+			}
 
 			if err := writer.Close(); err != nil {
 				t.Fatal(err)
@@ -1015,8 +1113,8 @@ func TestHandleAPIProcessDifferentOutputFormats(t *testing.T) {
 		contentType string
 	}{
 		{"xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
-		{"csv", "text/csv"},
-		{"markdown", "text/markdown"},
+		{"csv", "text/csv; charset=utf-8"},
+		{"markdown", "text/markdown; charset=utf-8"},
 	}
 
 	for _, of := range outputFormats {
@@ -1053,6 +1151,9 @@ func TestHandleAPIProcessDifferentOutputFormats(t *testing.T) {
 			if err := writer.WriteField("mappings", string(mappingsJSON)); err != nil {
 				t.Fatal(err)
 			}
+			if err := writer.WriteField("allowUnmappedMandatory", "true"); err != nil {
+				t.Fatal(err)
+			}
 
 			// Add output format
 			if err := writer.WriteField("outputFormat", of.format); err != nil {
@@ -1506,3 +1607,6027 @@ func TestGenerateUniqueID(t *testing.T) {
 
 	t.Logf("✅ Unique ID test passed: generated %d unique IDs with correct format", len(ids))
 }
+
+// TestProcessFileDeterministicOutput verifies that processing the same input twice produces byte-identical files
+func TestProcessFileDeterministicOutput(t *testing.T) {
+	tempFile, err := os.CreateTemp("./uploads", "test_determinism_*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	fileContent := "Account Number,Customer Name,Customer ID\n1234,John Doe,1001\n2345,Jane Smith,\n"
+	if _, err := tempFile.WriteString(fileContent); err != nil {
+		t.Fatal(err)
+	}
+	tempFile.Close()
+
+	fieldMappings := map[string]string{
+		"Customer_ID":   "Customer ID",
+		"Customer_Name": "Customer Name",
+		"Account_ID":    "Account Number",
+	}
+	order := []string{"Customer_ID", "Customer_Name", "Account_ID"}
+
+	for _, outputFormat := range []string{"csv", "markdown", "xlsx"} {
+		t.Run(outputFormat, func(t *testing.T) {
+			firstID, secondID := "test_"+generateUniqueID(), "test_"+generateUniqueID()
+			_, firstPath := processFile(tempFile.Name(), columnMappings(fieldMappings), order, outputFormat, firstID)
+			_, secondPath := processFile(tempFile.Name(), columnMappings(fieldMappings), order, outputFormat, secondID)
+			for _, uniqueID := range []string{firstID, secondID} {
+				processedPath, missingPath := outputFilePaths(uniqueID, outputFormat)
+				defer os.Remove(processedPath)
+				defer os.Remove(missingPath)
+			}
+
+			first, err := os.ReadFile(firstPath)
+			if err != nil {
+				t.Fatalf("Failed to read first output: %v", err)
+			}
+			second, err := os.ReadFile(secondPath)
+			if err != nil {
+				t.Fatalf("Failed to read second output: %v", err)
+			}
+
+			if !bytes.Equal(first, second) {
+				t.Errorf("Expected identical %s output for identical input", outputFormat)
+			}
+		})
+	}
+
+	// A preamble carries the generation time, so it is only reproducible with generatedAt
+	t.Run("csv preamble", func(t *testing.T) {
+		opts := processOptions{csvPreamble: true, generatedAt: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)}
+		var outputs [][]byte
+		for run := range 2 {
+			// Processing takes well under a second, so the clock is moved on between runs
+			if run > 0 {
+				time.Sleep(time.Second)
+			}
+			uniqueID := "test_" + generateUniqueID()
+			_, outputPath, err := processFileWithOptions(context.Background(), tempFile.Name(), columnMappings(fieldMappings), order, "csv", uniqueID, opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			processedPath, missingPath := outputFilePaths(uniqueID, "csv")
+			defer os.Remove(processedPath)
+			defer os.Remove(missingPath)
+			output, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			outputs = append(outputs, output)
+		}
+		if !strings.HasPrefix(string(outputs[0]), "# generated: 2024-01-02T15:04:05Z\n") {
+			t.Errorf("Expected the preamble to carry generatedAt, got:\n%s", outputs[0])
+		}
+		if !bytes.Equal(outputs[0], outputs[1]) {
+			t.Errorf("Expected identical preambled output, got:\n%s\nand:\n%s", outputs[0], outputs[1])
+		}
+	})
+}
+
+// useTempFieldConfig points the service at a temporary copy of the field config for the duration of a test
+func useTempFieldConfig(t testing.TB, content string) string {
+	t.Helper()
+	tempConfigFile := filepath.Join(t.TempDir(), "field_config.json")
+	if err := os.WriteFile(tempConfigFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalPath := fieldConfigPath
+	fieldConfigPath = tempConfigFile
+	if err := InitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		fieldConfigPath = originalPath
+		InitConfig()
+	})
+	return tempConfigFile
+}
+
+// TestHandleAPIConfigFields verifies adding, renaming, deleting and reordering fields through the API
+func TestHandleAPIConfigFields(t *testing.T) {
+	configPath := useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true}
+        ]
+    }`)
+
+	testCases := []struct {
+		name          string
+		method        string
+		target        string
+		body          string
+		handler       http.HandlerFunc
+		expectedCode  int
+		expectedOrder []string
+	}{
+		{
+			name:          "Add field",
+			method:        "POST",
+			target:        "/api/v1/config/fields",
+			body:          `{"name": "Account_ID", "displayName": "Account ID", "isMandatory": false}`,
+			handler:       handleAPIConfigFields,
+			expectedCode:  http.StatusOK,
+			expectedOrder: []string{"Client_Code", "Customer_ID", "Account_ID"},
+		},
+		{
+			name:          "Add duplicate field",
+			method:        "POST",
+			target:        "/api/v1/config/fields",
+			body:          `{"name": "Account_ID", "displayName": "Account ID"}`,
+			handler:       handleAPIConfigFields,
+			expectedCode:  http.StatusBadRequest,
+			expectedOrder: []string{"Client_Code", "Customer_ID", "Account_ID"},
+		},
+		{
+			name:          "Add field without display name",
+			method:        "POST",
+			target:        "/api/v1/config/fields",
+			body:          `{"name": "LE_ID"}`,
+			handler:       handleAPIConfigFields,
+			expectedCode:  http.StatusBadRequest,
+			expectedOrder: []string{"Client_Code", "Customer_ID", "Account_ID"},
+		},
+		{
+			name:          "Rename field",
+			method:        "PUT",
+			target:        "/api/v1/config/fields?name=Account_ID",
+			body:          `{"name": "Account_Number", "displayName": "Account Number", "isMandatory": true}`,
+			handler:       handleAPIConfigFields,
+			expectedCode:  http.StatusOK,
+			expectedOrder: []string{"Client_Code", "Customer_ID", "Account_Number"},
+		},
+		{
+			name:          "Rename onto existing name",
+			method:        "PUT",
+			target:        "/api/v1/config/fields?name=Account_Number",
+			body:          `{"name": "Client_Code", "displayName": "Account Number"}`,
+			handler:       handleAPIConfigFields,
+			expectedCode:  http.StatusBadRequest,
+			expectedOrder: []string{"Client_Code", "Customer_ID", "Account_Number"},
+		},
+		{
+			name:          "Reorder fields",
+			method:        "POST",
+			target:        "/api/v1/config/fields/reorder",
+			body:          `{"order": ["Account_Number", "Client_Code", "Customer_ID"]}`,
+			handler:       handleAPIConfigFieldsReorder,
+			expectedCode:  http.StatusOK,
+			expectedOrder: []string{"Account_Number", "Client_Code", "Customer_ID"},
+		},
+		{
+			name:          "Reorder with missing field",
+			method:        "POST",
+			target:        "/api/v1/config/fields/reorder",
+			body:          `{"order": ["Account_Number", "Client_Code"]}`,
+			handler:       handleAPIConfigFieldsReorder,
+			expectedCode:  http.StatusBadRequest,
+			expectedOrder: []string{"Account_Number", "Client_Code", "Customer_ID"},
+		},
+		{
+			name:          "Delete field",
+			method:        "DELETE",
+			target:        "/api/v1/config/fields?name=Client_Code",
+			handler:       handleAPIConfigFields,
+			expectedCode:  http.StatusOK,
+			expectedOrder: []string{"Account_Number", "Customer_ID"},
+		},
+		{
+			name:          "Delete unknown field",
+			method:        "DELETE",
+			target:        "/api/v1/config/fields?name=Client_Code",
+			handler:       handleAPIConfigFields,
+			expectedCode:  http.StatusBadRequest,
+			expectedOrder: []string{"Account_Number", "Customer_ID"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			tc.handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d, got %d: %s", tc.expectedCode, recorder.Code, recorder.Body.String())
+			}
+
+			if order := currentFieldConfig().GetOrderedFields(); strings.Join(order, ",") != strings.Join(tc.expectedOrder, ",") {
+				t.Errorf("Expected in-memory order %v, got %v", tc.expectedOrder, order)
+			}
+
+			// The persisted file must match the in-memory configuration
+			if err := InitConfig(); err != nil {
+				t.Fatalf("Failed to reload persisted config from %s: %v", configPath, err)
+			}
+			if order := currentFieldConfig().GetOrderedFields(); strings.Join(order, ",") != strings.Join(tc.expectedOrder, ",") {
+				t.Errorf("Expected persisted order %v, got %v", tc.expectedOrder, order)
+			}
+		})
+	}
+}
+
+// TestHandleAPIConfigFieldsInvalidMethod verifies unsupported methods are rejected
+// TestHandleAPIConfigExportImport verifies an exported configuration imports back unchanged,
+// a dry run changes nothing and an invalid import is rejected
+func TestHandleAPIConfigExportImport(t *testing.T) {
+	configPath := useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true, "aliases": ["Client"]},
+            {"name": "Amount", "displayName": "Amount", "type": "number", "outputNumberFormat": "%.2f"}
+        ],
+        "rules": [
+            {"name": "positive", "when": {"field": "Client_Code", "equals": "C1"}, "then": {"field": "Amount", "matches": "^[0-9.]+$"}}
+        ]
+    }`)
+
+	export := func() string {
+		recorder := httptest.NewRecorder()
+		handleAPIConfigExport(recorder, httptest.NewRequest("GET", "/api/v1/config/export", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected export status 200, got %d", recorder.Code)
+		}
+		return recorder.Body.String()
+	}
+	importConfig := func(target, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handleAPIConfigImport(recorder, httptest.NewRequest("POST", target, strings.NewReader(body)))
+		return recorder
+	}
+
+	exported := export()
+	if recorder := importConfig("/api/v1/config/import", exported); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the exported configuration to import, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if reexported := export(); reexported != exported {
+		t.Errorf("Expected the import to round-trip, got:\n%s\nwant:\n%s", reexported, exported)
+	}
+
+	renamed := strings.ReplaceAll(exported, "Amount", "Total")
+	if recorder := importConfig("/api/v1/config/import?dryRun=true", renamed); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the dry run to succeed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if export() != exported {
+		t.Error("Expected a dry run to leave the configuration unchanged")
+	}
+
+	duplicate := `{"fields": [
+        {"name": "Client_Code", "displayName": "Client Code"},
+        {"name": "Client_Code", "displayName": "Client"}
+    ]}`
+	recorder := importConfig("/api/v1/config/import", duplicate)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "duplicate field name") {
+		t.Errorf("Expected a duplicate field name to be rejected, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	saved, _ := os.ReadFile(configPath)
+	if export() != exported || string(saved) != exported {
+		t.Error("Expected a rejected import to leave the configuration untouched")
+	}
+}
+
+func TestHandleAPIConfigFieldsInvalidMethod(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/config/fields/reorder", nil)
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(handleAPIConfigFieldsReorder).ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code %d, got %d", http.StatusMethodNotAllowed, recorder.Code)
+	}
+}
+
+// newAPIProcessRequest builds an authenticated multipart request for /api/v1/process
+func newAPIProcessRequest(t *testing.T, filename, fileContent string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write([]byte(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range fields {
+		if err := writer.WriteField(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/process", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-API-Key", "test-api-key-1")
+	return req
+}
+
+// TestHandleAPIProcessOutputScope verifies the outputScope field selects which file is returned
+func TestHandleAPIProcessOutputScope(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+
+	testCases := []struct {
+		scope            string
+		expectedCode     int
+		expectedFile     string
+		expectedContains string
+		expectedMissing  string
+	}{
+		{scope: "", expectedCode: http.StatusOK, expectedFile: "_processed_data.csv", expectedContains: "C1", expectedMissing: "C2"},
+		{scope: "both", expectedCode: http.StatusOK, expectedFile: "_processed_data.csv", expectedContains: "C1", expectedMissing: "C2"},
+		{scope: "processed", expectedCode: http.StatusOK, expectedFile: "_processed_data.csv", expectedContains: "C1", expectedMissing: "C2"},
+		{scope: "missing", expectedCode: http.StatusOK, expectedFile: "_missing_data.csv", expectedContains: "C2", expectedMissing: "C1"},
+		{scope: "everything", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run("scope="+tc.scope, func(t *testing.T) {
+			req := newAPIProcessRequest(t, "scope.csv", fileContent, map[string]string{
+				"mappings":     mappings,
+				"outputFormat": "csv",
+				"outputScope":  tc.scope,
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedCode {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedCode, rr.Code, rr.Body.String())
+			}
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			disposition := rr.Header().Get("Content-Disposition")
+			if !strings.Contains(disposition, tc.expectedFile) {
+				t.Errorf("Expected returned file %s, got %s", tc.expectedFile, disposition)
+			}
+
+			content := rr.Body.String()
+			if !strings.Contains(content, tc.expectedContains) {
+				t.Errorf("Expected output to contain %q, got:\n%s", tc.expectedContains, content)
+			}
+			if strings.Contains(content, tc.expectedMissing) {
+				t.Errorf("Expected output not to contain %q, got:\n%s", tc.expectedMissing, content)
+			}
+
+			// Only the requested files should have been generated
+			uniqueID := strings.TrimSuffix(strings.TrimPrefix(disposition, `attachment; filename="`), tc.expectedFile+`"`)
+			_, missingErr := os.Stat(filepath.Join("./uploads", uniqueID+"_missing_data.csv"))
+			_, processedErr := os.Stat(filepath.Join("./uploads", uniqueID+"_processed_data.csv"))
+			if tc.scope == "processed" && missingErr == nil {
+				t.Error("Expected no missing data file for processed scope")
+			}
+			if tc.scope == "missing" && processedErr == nil {
+				t.Error("Expected no processed data file for missing scope")
+			}
+		})
+	}
+}
+
+// TestHandleAPIProcessIdempotencyKey verifies a retried request with the same key is not processed again
+func TestHandleAPIProcessIdempotencyKey(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\n"
+	fields := map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat": "csv",
+	}
+
+	send := func(apiKey, idempotencyKey string) *httptest.ResponseRecorder {
+		req := newAPIProcessRequest(t, "idempotent.csv", fileContent, fields)
+		req.Header.Set("X-API-Key", apiKey)
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+
+	idempotencyKey := "retry-" + generateUniqueID()
+	first := send("test-api-key-1", idempotencyKey)
+	second := send("test-api-key-1", idempotencyKey)
+
+	// Every run of processFile writes a new uniquely named file, so a shared filename means it ran once
+	if first.Header().Get("Content-Disposition") != second.Header().Get("Content-Disposition") {
+		t.Errorf("Expected retried request to return the original output, got %s and %s",
+			first.Header().Get("Content-Disposition"), second.Header().Get("Content-Disposition"))
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Expected retried request to be marked as replayed")
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Error("Expected retried request to return identical content")
+	}
+
+	// The same key from a different API key must not see the cached result
+	other := send("test-api-key-2", idempotencyKey)
+	if other.Header().Get("Content-Disposition") == first.Header().Get("Content-Disposition") {
+		t.Error("Expected idempotency keys to be scoped per API key")
+	}
+}
+
+// TestHandleAPIProcessConcurrentIdempotencyKey verifies a retry sent while the first request
+// is still running is refused instead of processing the file again
+func TestHandleAPIProcessConcurrentIdempotencyKey(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\n"
+	fields := map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat": "csv",
+	}
+	send := func(idempotencyKey string) *httptest.ResponseRecorder {
+		req := newAPIProcessRequest(t, "concurrent.csv", fileContent, fields)
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// A key held by a request in flight is refused, and usable again once it is released
+	idempotencyKey := "held-" + generateUniqueID()
+	if _, _, err := processResults.Reserve("test-api-key-1", idempotencyKey, replayable); err != nil {
+		t.Fatalf("Failed to reserve key: %v", err)
+	}
+	if rr := send(idempotencyKey); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 while the key is in flight, got %d: %s", rr.Code, rr.Body.String())
+	}
+	processResults.Release("test-api-key-1", idempotencyKey)
+	if rr := send(idempotencyKey); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 once the key is released, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Reserving finds a result stored since the key was last looked at instead of replacing it
+	cache := idempotency.NewCache(time.Minute)
+	cache.Set("scope", "stored", idempotency.Result{StoredResponse: []byte(`{}`)})
+	if _, ok, err := cache.Reserve("scope", "stored", replayable); !ok || err != nil {
+		t.Errorf("Expected the stored result to be returned, got %v (%v)", ok, err)
+	}
+	if _, ok := cache.Get("scope", "stored"); !ok {
+		t.Error("Expected the stored result to be kept")
+	}
+
+	// Two requests racing with one key process the file at most once: the loser either
+	// gets 409 or replays the winner's output
+	for range 10 {
+		idempotencyKey := "race-" + generateUniqueID()
+		responses := make([]*httptest.ResponseRecorder, 2)
+		var wg sync.WaitGroup
+		for i := range responses {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				responses[i] = send(idempotencyKey)
+			}()
+		}
+		wg.Wait()
+
+		var outputs []string
+		for _, rr := range responses {
+			switch rr.Code {
+			case http.StatusOK:
+				outputs = append(outputs, rr.Header().Get("Content-Disposition"))
+			case http.StatusConflict:
+			default:
+				t.Fatalf("Expected status 200 or 409, got %d: %s", rr.Code, rr.Body.String())
+			}
+		}
+		if len(outputs) == 0 {
+			t.Fatal("Expected one of the concurrent requests to be processed")
+		}
+		if len(outputs) == 2 && outputs[0] != outputs[1] {
+			t.Fatalf("Expected the file to be processed once, got outputs %s and %s", outputs[0], outputs[1])
+		}
+	}
+}
+
+// TestHandleAPIProcessResultCache verifies an identical upload reuses the earlier output
+// until the options or the configuration change
+func TestHandleAPIProcessResultCache(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	resultCache = idempotency.NewCache(time.Minute)
+	t.Cleanup(func() { resultCache = nil })
+
+	fileContent := "Client Code,Customer ID,Account Number\nC" + generateUniqueID() + ",1001,A1\n"
+	sendOptions := func(outputFormat string, options map[string]string) *httptest.ResponseRecorder {
+		fields := map[string]string{
+			"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+			"outputFormat": outputFormat,
+		}
+		maps.Copy(fields, options)
+		req := newAPIProcessRequest(t, "cached.csv", fileContent, fields)
+		req.Header.Set("X-API-Key", "test-api-key-1")
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+	send := func(outputFormat string) *httptest.ResponseRecorder {
+		return sendOptions(outputFormat, nil)
+	}
+
+	first := send("csv")
+	second := send("csv")
+	if first.Header().Get("X-Results-Cache") != "miss" || second.Header().Get("X-Results-Cache") != "hit" {
+		t.Errorf("Expected a miss then a hit, got %q and %q", first.Header().Get("X-Results-Cache"), second.Header().Get("X-Results-Cache"))
+	}
+	// Every run writes a new uniquely named file, so a shared filename means it ran once
+	if first.Header().Get("Content-Disposition") != second.Header().Get("Content-Disposition") {
+		t.Errorf("Expected the cached output, got %s and %s", first.Header().Get("Content-Disposition"), second.Header().Get("Content-Disposition"))
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Error("Expected the cached output to be identical")
+	}
+
+	if other := send("markdown"); other.Header().Get("X-Results-Cache") != "miss" {
+		t.Error("Expected a different output format to be processed again")
+	}
+
+	// A sample without a seed is drawn afresh each time, so it bypasses the cache, while a
+	// seeded one is cached like any other request
+	for range 2 {
+		if sampled := sendOptions("csv", map[string]string{"sampleSize": "1"}); sampled.Header().Get("X-Results-Cache") != "" {
+			t.Errorf("Expected an unseeded sample not to use the cache, got %q", sampled.Header().Get("X-Results-Cache"))
+		}
+	}
+	seeded := map[string]string{"sampleSize": "1", "sampleSeed": "42"}
+	if first, second := sendOptions("csv", seeded), sendOptions("csv", seeded); first.Header().Get("X-Results-Cache") != "miss" || second.Header().Get("X-Results-Cache") != "hit" {
+		t.Errorf("Expected a seeded sample to be cached, got %q and %q", first.Header().Get("X-Results-Cache"), second.Header().Get("X-Results-Cache"))
+	}
+
+	if err := InitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded := send("csv"); reloaded.Header().Get("X-Results-Cache") != "miss" {
+		t.Error("Expected a configuration reload to invalidate the cache")
+	}
+}
+
+// TestHeaderOnlyFile verifies a file with a header row but no data rows is rejected with a distinct message
+func TestHeaderOnlyFile(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	headerOnlyXLSX := excelize.NewFile()
+	headerOnlyXLSX.SetSheetRow("Sheet1", "A1", &[]string{"Client Code", "Customer ID", "Account Number"})
+	xlsxBuffer, err := headerOnlyXLSX.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []struct {
+		filename string
+		content  string
+	}{
+		{"header_only.csv", "Client Code,Customer ID,Account Number\n"},
+		{"header_only.xlsx", xlsxBuffer.String()},
+	}
+	expectedMessage := "File contains headers but no data rows"
+
+	for _, input := range inputs {
+		t.Run("API "+input.filename, func(t *testing.T) {
+			req := newAPIProcessRequest(t, input.filename, input.content, map[string]string{
+				"mappings": `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+			var response ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Expected JSON error response: %v", err)
+			}
+			if response.Error != expectedMessage {
+				t.Errorf("Expected error %q, got %q", expectedMessage, response.Error)
+			}
+		})
+
+		t.Run("UI "+input.filename, func(t *testing.T) {
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			part, err := writer.CreateFormFile("fileInput", input.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write([]byte(input.content))
+			writer.WriteField("mapping_Client_Code", "Client Code")
+			writer.WriteField("mapping_Customer_ID", "Customer ID")
+			writer.WriteField("mapping_Account_ID", "Account Number")
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/upload", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(handleUpload).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), expectedMessage) {
+				t.Errorf("Expected body to contain %q, got %q", expectedMessage, rr.Body.String())
+			}
+		})
+	}
+
+	summary, _ := processFile(writeTempCSV(t, "Client Code\n"), columnMappings(map[string]string{}), []string{"Client_Code"}, "csv", "test_"+generateUniqueID())
+	if summary != expectedMessage {
+		t.Errorf("Expected processFile summary %q, got %q", expectedMessage, summary)
+	}
+}
+
+// writeTempCSV writes content to a temporary CSV file in the uploads directory and returns its path
+func writeTempCSV(t testing.TB, content string) string {
+	t.Helper()
+	tempFile, err := os.CreateTemp("./uploads", "test_input_*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tempFile.Close()
+	t.Cleanup(func() { os.Remove(tempFile.Name()) })
+
+	if _, err := tempFile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return tempFile.Name()
+}
+
+// TestHandleAPIProcessDisplayNameMappings verifies mappings keyed by DisplayName resolve to the configured fields
+func TestHandleAPIProcessDisplayNameMappings(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	req := newAPIProcessRequest(t, "display_names.csv", "Code,Cust,Acct\nC1,1001,A1\n", map[string]string{
+		"mappings":     `{"Client Code":"Code","Customer ID":"Cust","Account_ID":"Acct"}`,
+		"outputFormat": "csv",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if summary := rr.Header().Get("X-Processing-Summary"); !strings.Contains(summary, "Successful Rows: 1") {
+		t.Errorf("Expected the row to be processed successfully, got summary: %s", summary)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 || lines[1] != "C1||1001|||A1||" {
+		t.Errorf("Expected mapped values in configured field order, got:\n%s", rr.Body.String())
+	}
+}
+
+// TestConfigAmbiguousDisplayName verifies a DisplayName that collides with another field's Name is rejected at load time
+func TestConfigAmbiguousDisplayName(t *testing.T) {
+	tempConfigFile := filepath.Join(t.TempDir(), "field_config.json")
+	ambiguousConfig := `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Customer_ID", "isMandatory": true},
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true}
+        ]
+    }`
+	if err := os.WriteFile(tempConfigFile, []byte(ambiguousConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalPath := fieldConfigPath
+	fieldConfigPath = tempConfigFile
+	defer func() {
+		fieldConfigPath = originalPath
+		InitConfig()
+	}()
+
+	err := InitConfig()
+	if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("Expected ambiguous display name error, got %v", err)
+	}
+}
+
+// TestOutputNumberFormat verifies per-field number formatting for currency and integer fields
+func TestOutputNumberFormat(t *testing.T) {
+	testCases := []struct {
+		name     string
+		format   string
+		value    string
+		expected string
+	}{
+		{"currency from integer", "%.2f", "12", "12.00"},
+		{"currency rounds", "%.2f", "12.345", "12.35"},
+		{"currency negative", "%.2f", " -3.5 ", "-3.50"},
+		{"integer from decimal", "%d", "41.6", "42"},
+		{"integer unchanged", "%d", "1001", "1001"},
+		{"non-numeric bypasses", "%.2f", "N/A", "N/A"},
+		{"locale decimal comma bypasses", "%.2f", "12,50", "12,50"},
+		{"no format", "", "12.345", "12.345"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			field := config.Field{Name: "Amount", DisplayName: "Amount", OutputNumberFormat: tc.format}
+			if result := field.FormatNumber(tc.value); result != tc.expected {
+				t.Errorf("FormatNumber(%q) with %q = %q, want %q", tc.value, tc.format, result, tc.expected)
+			}
+		})
+	}
+
+	fc := &config.FieldConfig{Fields: []config.Field{
+		{Name: "Amount", DisplayName: "Amount", IsMandatory: true, OutputNumberFormat: "%.2f"},
+		{Name: "Quantity", DisplayName: "Quantity", OutputNumberFormat: "%d"},
+	}}
+	processedRow, _, _, ok := processRow([]string{"9.5", "3.0"}, []string{"amount", "quantity"},
+		columnMappings(map[string]string{"Amount": "Amount", "Quantity": "Quantity"}), []string{"Amount", "Quantity"}, fc, false)
+	if !ok || processedRow[0] != "9.50" || processedRow[1] != "3" {
+		t.Errorf("Expected formatted row [9.50 3], got %v", processedRow)
+	}
+
+	fc.Fields[0].OutputNumberFormat = "%s"
+	if err := fc.Validate(); err == nil {
+		t.Error("Expected non-numeric output number format to be rejected")
+	}
+}
+
+// TestHandleAPIProcessCSVPreamble verifies preamble comment lines precede the CSV header only when requested
+func TestHandleAPIProcessCSVPreamble(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+
+	testCases := []struct {
+		name           string
+		fields         map[string]string
+		expectedPrefix string
+		expectedCode   int
+	}{
+		{"off by default", map[string]string{}, "", http.StatusOK},
+		{"default comment char", map[string]string{"csvPreamble": "true"}, "#", http.StatusOK},
+		{"custom comment char", map[string]string{"csvPreamble": "true", "csvCommentChar": ";"}, ";", http.StatusOK},
+		{"invalid flag", map[string]string{"csvPreamble": "sometimes"}, "", http.StatusBadRequest},
+		{"delimiter as comment char", map[string]string{"csvPreamble": "true", "csvCommentChar": "|"}, "", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fields["mappings"] = mappings
+			tc.fields["outputFormat"] = "csv"
+			req := newAPIProcessRequest(t, "preamble.csv", fileContent, tc.fields)
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedCode {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedCode, rr.Code, rr.Body.String())
+			}
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			lines := strings.Split(rr.Body.String(), "\n")
+			if tc.expectedPrefix == "" {
+				if !strings.HasPrefix(lines[0], "Client_Code|") {
+					t.Errorf("Expected header on the first line without a preamble, got %q", lines[0])
+				}
+				return
+			}
+
+			expectedPreamble := []string{tc.expectedPrefix + " generated: ", tc.expectedPrefix + " rows: 1", tc.expectedPrefix + " total rows processed: 2"}
+			for i, expected := range expectedPreamble {
+				if !strings.HasPrefix(lines[i], expected) {
+					t.Errorf("Expected preamble line %d to start with %q, got %q", i, expected, lines[i])
+				}
+			}
+			if !strings.HasPrefix(lines[len(expectedPreamble)], "Client_Code|") {
+				t.Errorf("Expected header after the preamble, got %q", lines[len(expectedPreamble)])
+			}
+		})
+	}
+}
+
+// TestCSVDelimiterPolicy verifies values containing the output delimiter are quoted or have it substituted
+func TestCSVDelimiterPolicy(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true},
+            {"name": "Account_ID", "displayName": "Account ID", "isMandatory": true}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	fileContent := "Client Code,Customer ID,Account Number\n\"C1|A;B\",\"say \"\"hi\"\"\",A1\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+
+	testCases := []struct {
+		name     string
+		fields   map[string]string
+		expected string
+	}{
+		{"pipe quoted", map[string]string{}, "Client_Code|Customer_ID|Account_ID\n\"C1|A;B\"|\"say \"\"hi\"\"\"|A1\n"},
+		{"semicolon quoted", map[string]string{"outputDelimiter": ";"}, "Client_Code;Customer_ID;Account_ID\n\"C1|A;B\";\"say \"\"hi\"\"\";A1\n"},
+		{"pipe substituted", map[string]string{"delimiterPolicy": "substitute", "delimiterSubstitute": "/"}, "Client_Code|Customer_ID|Account_ID\nC1/A;B|\"say \"\"hi\"\"\"|A1\n"},
+		{"semicolon substituted", map[string]string{"outputDelimiter": ";", "delimiterPolicy": "substitute"}, "Client_Code;Customer_ID;Account_ID\nC1|A B;\"say \"\"hi\"\"\";A1\n"},
+		{"tab", map[string]string{"outputDelimiter": `\t`}, "Client_Code\tCustomer_ID\tAccount_ID\nC1|A;B\t\"say \"\"hi\"\"\"\tA1\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fields["mappings"] = mappings
+			tc.fields["outputFormat"] = "csv"
+			tc.fields["outputScope"] = "processed"
+			req := newAPIProcessRequest(t, "delimiter.csv", fileContent, tc.fields)
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if rr.Body.String() != tc.expected {
+				t.Errorf("Expected:\n%q\ngot:\n%q", tc.expected, rr.Body.String())
+			}
+		})
+	}
+
+	for _, fields := range []map[string]string{
+		{"outputDelimiter": "||"},
+		{"outputDelimiter": `"`},
+		{"delimiterPolicy": "escape"},
+		{"delimiterPolicy": "substitute", "delimiterSubstitute": "a|b"},
+		{"delimiterSubstitute": "/"},
+		{"outputDelimiter": ";", "csvPreamble": "true", "csvCommentChar": ";"},
+	} {
+		fields["mappings"] = mappings
+		req := newAPIProcessRequest(t, "delimiter.csv", fileContent, fields)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %v, got %d", fields, rr.Code)
+		}
+	}
+}
+
+// TestHandleAPIProcessBOMAndCRLF verifies outputBOM and outputCRLF control the byte-order mark and line endings of CSV output
+func TestHandleAPIProcessBOMAndCRLF(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,1002,A2\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+
+	testCases := []struct {
+		name         string
+		fields       map[string]string
+		expectedBOM  bool
+		expectedCRLF bool
+	}{
+		{"defaults", map[string]string{}, false, false},
+		{"bom only", map[string]string{"outputBOM": "true"}, true, false},
+		{"crlf with preamble", map[string]string{"outputCRLF": "true", "csvPreamble": "true"}, false, true},
+		{"bom and crlf", map[string]string{"outputBOM": "true", "outputCRLF": "true"}, true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fields["mappings"] = mappings
+			tc.fields["outputFormat"] = "csv"
+			req := newAPIProcessRequest(t, "endings.csv", fileContent, tc.fields)
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			body := rr.Body.String()
+			if hasBOM := strings.HasPrefix(body, "\xEF\xBB\xBF"); hasBOM != tc.expectedBOM {
+				t.Errorf("Expected BOM %v, got %v in %q", tc.expectedBOM, hasBOM, body)
+			}
+			body = strings.TrimPrefix(body, "\xEF\xBB\xBF")
+			if !strings.HasPrefix(body, "Client_Code|") && !strings.HasPrefix(body, "#") {
+				t.Errorf("Expected the output to start right after the BOM, got %q", body)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+			for _, line := range lines {
+				if crlf := strings.HasSuffix(line, "\r"); crlf != tc.expectedCRLF {
+					t.Errorf("Expected CRLF %v, got line %q", tc.expectedCRLF, line)
+				}
+			}
+		})
+	}
+}
+
+// TestEncryptionFormatVector verifies an output sealed by an earlier release still opens,
+// so the key derivation and on-disk layout stay stable
+func TestEncryptionFormatVector(t *testing.T) {
+	sealed, err := hex.DecodeString("45584d4150763100c3130488659c06cd628bcfbd9fe938dee559637f7605e5909b98e896bac87955eb95978646e52144c05dbea556fd131ee8874491e87ec7fa0d147d5175732c8d7f0e1beeb84ad180dd9b370e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := encryption.Open("correct horse battery staple", sealed)
+	if err != nil {
+		t.Fatalf("Expected the vector to decrypt: %v", err)
+	}
+	if expected := "Client_Code|Customer_ID\nC1|1001\n"; string(plaintext) != expected {
+		t.Errorf("Expected %q, got %q", expected, plaintext)
+	}
+	if _, err := encryption.Open("wrong", sealed); !errors.Is(err, encryption.ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for a wrong password, got %v", err)
+	}
+}
+
+// TestHandleAPIProcessOutputPassword verifies encrypted outputs can only be read with the supplied password
+func TestHandleAPIProcessOutputPassword(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+	process := func(t *testing.T, outputFormat string) *httptest.ResponseRecorder {
+		t.Helper()
+		fields := map[string]string{"mappings": mappings, "outputFormat": outputFormat, "outputPassword": "s3cret"}
+		req := newAPIProcessRequest(t, "encrypted.csv", fileContent, fields)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("X-Output-Encrypted") != "true" {
+			t.Errorf("Expected X-Output-Encrypted: true, got %q", rr.Header().Get("X-Output-Encrypted"))
+		}
+		return rr
+	}
+
+	t.Run("xlsx", func(t *testing.T) {
+		rr := process(t, "xlsx")
+		workbookPath := filepath.Join(t.TempDir(), "encrypted.xlsx")
+		if err := os.WriteFile(workbookPath, rr.Body.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if f, err := excelize.OpenFile(workbookPath); err == nil {
+			f.Close()
+			t.Fatal("Expected the workbook not to open without a password")
+		}
+		if f, err := excelize.OpenFile(workbookPath, excelize.Options{Password: "wrong"}); err == nil {
+			f.Close()
+			t.Fatal("Expected the workbook not to open with the wrong password")
+		}
+		f, err := excelize.OpenFile(workbookPath, excelize.Options{Password: "s3cret"})
+		if err != nil {
+			t.Fatalf("Expected the workbook to open with its password: %v", err)
+		}
+		defer f.Close()
+		rows, _ := f.GetRows("ProcessedData")
+		if len(rows) != 2 || strings.Join(rows[1][:3], "|") != "C1||1001" {
+			t.Errorf("Unexpected processed rows %q", rows)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		rr := process(t, "csv")
+		if ct := rr.Header().Get("Content-Type"); ct != "application/octet-stream" {
+			t.Errorf("Expected an octet-stream content type, got %q", ct)
+		}
+		if strings.Contains(rr.Body.String(), "C1") {
+			t.Fatal("Expected the CSV output to be encrypted")
+		}
+		if _, err := encryption.Open("wrong", rr.Body.Bytes()); !errors.Is(err, encryption.ErrDecrypt) {
+			t.Errorf("Expected the wrong password to fail, got %v", err)
+		}
+		plaintext, err := encryption.Open("s3cret", rr.Body.Bytes())
+		if err != nil {
+			t.Fatalf("Expected the output to decrypt: %v", err)
+		}
+		if !strings.HasPrefix(string(plaintext), "Client_Code|") || !strings.Contains(string(plaintext), "C1||1001") {
+			t.Errorf("Unexpected decrypted output %q", plaintext)
+		}
+	})
+
+	t.Run("reports", func(t *testing.T) {
+		inputPath := writeTempCSV(t, fileContent+"C1,1003,A3\n")
+		uniqueID := "test_" + generateUniqueID()
+		opts := processOptions{outputPassword: "s3cret", dedupeBy: []string{"Client_Code"}, dedupeReport: true, groupBy: "Client_Code", provenance: true}
+		fieldMappings := columnMappings(map[string]string{"Client_Code": "Client Code", "Customer_ID": "Customer ID", "Account_ID": "Account Number"})
+		_, outputPath, err := processFileWithOptions(context.Background(), inputPath, fieldMappings, currentFieldConfig().GetOrderedFields(), "csv", uniqueID, opts)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, missingPath := outputFilePaths(uniqueID, "csv")
+		for _, path := range []string{outputPath, missingPath, duplicatesReportPath(uniqueID), groupReportPath(uniqueID), provenancePath(uniqueID)} {
+			defer os.Remove(path)
+		}
+
+		for _, path := range []string{duplicatesReportPath(uniqueID), groupReportPath(uniqueID), provenancePath(uniqueID)} {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Expected report %s: %v", path, err)
+			}
+			if strings.Contains(string(data), "C1") || strings.Contains(string(data), "Client Code") {
+				t.Errorf("Expected %s to be encrypted, got %q", path, data)
+			}
+			plaintext, err := encryption.Open("s3cret", data)
+			if err != nil {
+				t.Fatalf("Expected %s to decrypt: %v", path, err)
+			}
+			if !strings.Contains(string(plaintext), "C1") && !strings.Contains(string(plaintext), "Client Code") {
+				t.Errorf("Unexpected decrypted report %s: %q", path, plaintext)
+			}
+		}
+	})
+
+	t.Run("retainInput rejected", func(t *testing.T) {
+		fields := map[string]string{"mappings": mappings, "outputFormat": "csv", "outputPassword": "s3cret", "retainInput": "true"}
+		req := newAPIProcessRequest(t, "encrypted.csv", fileContent, fields)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "retainInput cannot be combined with outputPassword") {
+			t.Errorf("Expected 400 for retainInput with outputPassword, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+// TestCorruptXLSXFile verifies a truncated xlsx upload is rejected cleanly instead of failing the server
+func TestCorruptXLSXFile(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	validXLSX := excelize.NewFile()
+	validXLSX.SetSheetRow("Sheet1", "A1", &[]string{"Client Code"})
+	validXLSX.SetSheetRow("Sheet1", "A2", &[]string{"C1"})
+	buffer, err := validXLSX.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := buffer.String()[:buffer.Len()/2]
+
+	req := newAPIProcessRequest(t, "corrupt.xlsx", truncated, map[string]string{
+		"mappings":               `{"Client_Code":"Client Code"}`,
+		"allowUnmappedMandatory": "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Failed to parse file") {
+		t.Errorf("Expected generic parse error, got %s", rr.Body.String())
+	}
+}
+
+// TestEmptyMappingsRejected verifies mappings naming no column get a 400 unless
+// allowEmptyMappings is set
+func TestEmptyMappingsRejected(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	content := "Client Code,Customer ID,Account Number\nC1,1001,A1\n"
+
+	for _, mappings := range []string{`{}`, `{"Client_Code":""}`} {
+		req := newAPIProcessRequest(t, "empty.csv", content, map[string]string{"mappings": mappings})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "No field mappings provided") {
+			t.Errorf("Expected 400 for mappings %s, got %d: %s", mappings, rr.Code, rr.Body.String())
+		}
+	}
+
+	req := newAPIProcessRequest(t, "empty.csv", content, map[string]string{"mappings": `{}`, "allowEmptyMappings": "true", "allowUnmappedMandatory": "true", "outputFormat": "csv"})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected allowEmptyMappings to process the file, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The web upload sends each mapping as its own form field
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("fileInput", "empty.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	writer.WriteField("mapping_Client_Code", "")
+	writer.Close()
+	req = httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr = httptest.NewRecorder()
+	handleUpload(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "No field mappings provided") {
+		t.Errorf("Expected 400 from the web upload, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// TestRecoverPanics verifies the recovery middleware turns a handler panic into a 500 response
+func TestRecoverPanics(t *testing.T) {
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("corrupt input")
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+// TestRequestTimeout verifies slow requests get a 503 JSON error while fast and exempt
+// ones are served normally
+func TestRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("done"))
+	})
+	handler := requestTimeout(slow, 20*time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/config", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Expected JSON content type, got %q", got)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode timeout response %q: %v", rr.Body.String(), err)
+	}
+	if resp.Error != "Request timed out after 20ms" {
+		t.Errorf("Unexpected timeout message %q", resp.Error)
+	}
+
+	fast := requestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}), time.Second)
+	rr = httptest.NewRecorder()
+	fast.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok" || rr.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected fast request to pass through, got %d %q %q", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	exempt := requestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("Expected the stream endpoint to keep a flushable writer")
+		}
+		time.Sleep(40 * time.Millisecond)
+		w.Write([]byte("streamed"))
+	}), 20*time.Millisecond)
+	rr = httptest.NewRecorder()
+	exempt.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/process-stream", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "streamed" {
+		t.Errorf("Expected process-stream to be exempt, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+// TestRowSampling verifies the same sampleSeed picks the same rows of a file, in input order
+func TestRowSampling(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	var content strings.Builder
+	content.WriteString("Client Code,Customer ID,Account Number\n")
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&content, "C%d,%d,A%d\n", i, 1000+i, i)
+	}
+	inputPath := writeTempCSV(t, content.String())
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Customer_ID": "Customer ID", "Account_ID": "Account Number"}
+	order := []string{"Client_Code", "Customer_ID", "Account_ID"}
+
+	sample := func(seed int64) (string, string) {
+		t.Helper()
+		uniqueID := "test_" + generateUniqueID()
+		summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", uniqueID, processOptions{sampleSize: 5, sampleSeed: seed})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, missingPath := outputFilePaths(uniqueID, "csv")
+		defer os.Remove(outputPath)
+		defer os.Remove(missingPath)
+		output, _ := os.ReadFile(outputPath)
+		return summary, string(output)
+	}
+
+	summary, first := sample(7)
+	_, second := sample(7)
+	if first != second {
+		t.Errorf("Expected the same sample for the same seed, got:\n%s\nand:\n%s", first, second)
+	}
+	lines := strings.Split(strings.TrimSpace(first), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected a header and 5 sampled rows, got:\n%s", first)
+	}
+	previous := 0
+	for _, line := range lines[1:] {
+		number, _ := strconv.Atoi(strings.TrimPrefix(strings.Split(line, "|")[0], "C"))
+		if number <= previous {
+			t.Errorf("Expected sampled rows in input order, got:\n%s", first)
+		}
+		previous = number
+	}
+	if !strings.Contains(summary, "Total Rows Processed: 5\n") || !strings.Contains(summary, "Sample: 5 of 50 data rows (sampleSeed=7)\n") {
+		t.Errorf("Expected the summary to note the sample, got:\n%s", summary)
+	}
+	if _, other := sample(8); other == first {
+		t.Errorf("Expected another seed to pick another sample")
+	}
+}
+
+// TestProcessFileRowWindow verifies skipRows and limitRows restrict which data rows are processed
+func TestProcessFileRowWindow(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	inputPath := writeTempCSV(t, "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,1002,A2\nC3,1003,A3\nC4,1004,A4\nC5,1005,A5\n")
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Customer_ID": "Customer ID", "Account_ID": "Account Number"}
+	order := []string{"Client_Code", "Customer_ID", "Account_ID"}
+
+	testCases := []struct {
+		name            string
+		skip, limit     int
+		expectedClients []string
+		expectedSummary string
+	}{
+		{"no window", 0, 0, []string{"C1", "C2", "C3", "C4", "C5"}, "Total Rows Processed: 5"},
+		{"skip only", 2, 0, []string{"C3", "C4", "C5"}, "Row Window: data rows 3-5 of 5"},
+		{"limit only", 0, 2, []string{"C1", "C2"}, "Row Window: data rows 1-2 of 5"},
+		{"skip and limit", 1, 3, []string{"C2", "C3", "C4"}, "Total Rows Processed: 3"},
+		{"limit past end", 3, 10, []string{"C4", "C5"}, "Row Window: data rows 4-5 of 5"},
+		{"skip to end", 5, 0, nil, "skipRows (5) is beyond the last data row (5)"},
+		{"skip beyond end", 8, 2, nil, "Total Rows Processed: 0"},
+		{"largest skip", math.MaxInt, 0, nil, fmt.Sprintf("skipRows (%d) is beyond the last data row (5)", math.MaxInt)},
+		{"largest limit", 1, math.MaxInt, []string{"C2", "C3", "C4", "C5"}, "Row Window: data rows 2-5 of 5"},
+		{"largest skip and limit", math.MaxInt, math.MaxInt, nil, "Total Rows Processed: 0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := processOptions{skipRows: tc.skip, limitRows: tc.limit}
+			summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID(), opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer os.Remove(outputPath)
+
+			if !strings.Contains(summary, tc.expectedSummary) {
+				t.Errorf("Expected summary to contain %q, got:\n%s", tc.expectedSummary, summary)
+			}
+
+			content, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")[1:]
+			var clients []string
+			for _, line := range lines {
+				clients = append(clients, strings.Split(line, "|")[0])
+			}
+			if strings.Join(clients, ",") != strings.Join(tc.expectedClients, ",") {
+				t.Errorf("Expected rows %v, got %v", tc.expectedClients, clients)
+			}
+		})
+	}
+}
+
+// TestProcessFileQualityScore verifies the _quality column scores complete rows 100 and partial rows lower
+func TestProcessFileQualityScore(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	inputPath := writeTempCSV(t, "Client Code,Customer ID,Account Number,Customer Name\nC1,1001,A1,John Doe\nC2,1002,A2,\n")
+	fieldMappings := map[string]string{
+		"Client_Code":   "Client Code",
+		"Customer_ID":   "Customer ID",
+		"Account_ID":    "Account Number",
+		"Customer_Name": "Customer Name",
+	}
+	order := []string{"Client_Code", "Customer_ID", "Account_ID", "Customer_Name", "Account_Name"}
+
+	opts := processOptions{includeQualityScore: true}
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID(), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Remove(outputPath)
+
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+
+	if lines[0] != "Client_Code|Customer_ID|Account_ID|Customer_Name|Account_Name|_quality" {
+		t.Errorf("Expected _quality header column, got %q", lines[0])
+	}
+	// Three mandatory fields (weight 2) and one mapped optional field (weight 1); Account_Name is unmapped
+	if !strings.HasSuffix(lines[1], "|100") {
+		t.Errorf("Expected fully populated row to score 100, got %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "|86") {
+		t.Errorf("Expected row missing an optional field to score 86, got %q", lines[2])
+	}
+}
+
+// TestFeatureFlagDefaults verifies environment defaults apply when form fields are absent and can be overridden
+func TestFeatureFlagDefaults(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	t.Setenv("DEFAULT_OUTPUT_FORMAT", "csv")
+	t.Setenv("TRIM_CELLS", "true")
+	t.Setenv("ENABLE_STATS", "true")
+	originalFlags := featureFlags
+	defer func() { featureFlags = originalFlags }()
+
+	var err error
+	if featureFlags, err = config.LoadFeatureFlags(); err != nil {
+		t.Fatalf("Failed to load feature flags: %v", err)
+	}
+
+	fileContent := "Client Code,Customer ID,Account Number\n  C1  ,1001,A1\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+
+	// Env defaults apply when the form fields are absent
+	req := newAPIProcessRequest(t, "flags.csv", fileContent, map[string]string{"mappings": mappings, "summarySidecar": "true"})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/csv; charset=utf-8" {
+		t.Errorf("Expected DEFAULT_OUTPUT_FORMAT to select text/csv, got %s", contentType)
+	}
+	if !strings.Contains(rr.Body.String(), "\nC1|") {
+		t.Errorf("Expected TRIM_CELLS to trim cell values, got:\n%s", rr.Body.String())
+	}
+	if summary := sidecarSummary(t, rr); !strings.Contains(summary, "Client_Code: 1/1 populated") {
+		t.Errorf("Expected ENABLE_STATS to add field statistics, got:\n%s", summary)
+	}
+
+	// Per-request fields override the env defaults
+	req = newAPIProcessRequest(t, "flags.csv", fileContent, map[string]string{
+		"mappings":     mappings,
+		"outputFormat": "markdown",
+		"trimCells":      "false",
+		"includeStats":   "false",
+		"summarySidecar": "true",
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/markdown; charset=utf-8" {
+		t.Errorf("Expected form outputFormat to override the default, got %s", contentType)
+	}
+	if !strings.Contains(rr.Body.String(), "|   C1   |") {
+		t.Errorf("Expected trimCells=false to keep whitespace, got:\n%s", rr.Body.String())
+	}
+	if strings.Contains(sidecarSummary(t, rr), "Field Statistics") {
+		t.Error("Expected includeStats=false to omit field statistics")
+	}
+
+	t.Setenv("DEFAULT_OUTPUT_FORMAT", "pdf")
+	if _, err := config.LoadFeatureFlags(); err == nil {
+		t.Error("Expected an invalid DEFAULT_OUTPUT_FORMAT to be rejected")
+	}
+}
+
+// TestAllowedOutputFormats verifies ALLOWED_OUTPUT_FORMATS rejects other formats with the permitted list
+func TestAllowedOutputFormats(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	t.Setenv("ALLOWED_OUTPUT_FORMATS", "csv, excel")
+	originalFlags := featureFlags
+	defer func() { featureFlags = originalFlags }()
+
+	var err error
+	if featureFlags, err = config.LoadFeatureFlags(); err != nil {
+		t.Fatalf("Failed to load feature flags: %v", err)
+	}
+	if strings.Join(featureFlags.AllowedOutputFormats, ",") != "csv,xlsx" {
+		t.Errorf("Expected csv and xlsx to be allowed, got %v", featureFlags.AllowedOutputFormats)
+	}
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+	for format, expected := range map[string]int{"csv": http.StatusOK, "": http.StatusOK, "sql": http.StatusBadRequest, "markdown": http.StatusBadRequest} {
+		req := newAPIProcessRequest(t, "allowed.csv", fileContent, map[string]string{"mappings": mappings, "outputFormat": format})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("outputFormat %q: expected status %d, got %d: %s", format, expected, rr.Code, rr.Body.String())
+		}
+		if expected == http.StatusBadRequest && !strings.Contains(rr.Body.String(), "permitted formats are csv, xlsx") {
+			t.Errorf("outputFormat %q: expected the permitted formats to be listed, got %s", format, rr.Body.String())
+		}
+	}
+
+	t.Setenv("DEFAULT_OUTPUT_FORMAT", "sql")
+	if _, err := config.LoadFeatureFlags(); err == nil {
+		t.Error("Expected a DEFAULT_OUTPUT_FORMAT outside ALLOWED_OUTPUT_FORMATS to be rejected")
+	}
+	t.Setenv("DEFAULT_OUTPUT_FORMAT", "")
+	t.Setenv("ALLOWED_OUTPUT_FORMATS", "csv,pdf")
+	if _, err := config.LoadFeatureFlags(); err == nil {
+		t.Error("Expected an unknown format in ALLOWED_OUTPUT_FORMATS to be rejected")
+	}
+}
+
+// TestHandleAPIProcessMergeAllSheets verifies rows from every sheet are merged and mismatched headers are rejected
+func TestHandleAPIProcessMergeAllSheets(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	buildWorkbook := func(sheets map[string][][]string, names []string) string {
+		workbook := excelize.NewFile()
+		for i, name := range names {
+			if i == 0 {
+				workbook.SetSheetName("Sheet1", name)
+			} else {
+				workbook.NewSheet(name)
+			}
+			for rowIndex, row := range sheets[name] {
+				cell, _ := excelize.CoordinatesToCellName(1, rowIndex+1)
+				workbook.SetSheetRow(name, cell, &row)
+			}
+		}
+		buffer, err := workbook.WriteToBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buffer.String()
+	}
+
+	header := []string{"Client Code", "Customer ID", "Account Number"}
+	names := []string{"North", "South", "West"}
+	sheets := map[string][][]string{
+		"North": {header, {"N1", "1001", "A1"}, {"N2", "1002", "A2"}},
+		"South": {header, {"S1", "2001", "B1"}},
+		"West":  {{"client code ", "Customer ID", "ACCOUNT NUMBER"}, {"W1", "3001", "C1"}, {"W2", "3002", "C2"}, {"W3", "3003", "C3"}},
+	}
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+
+	req := newAPIProcessRequest(t, "regions.xlsx", buildWorkbook(sheets, names), map[string]string{
+		"mappings":       mappings,
+		"outputFormat":   "csv",
+		"mergeAllSheets": "true",
+		"summarySidecar": "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	summary := sidecarSummary(t, rr)
+	for _, expected := range []string{"Total Rows Processed: 6", "North: 2", "South: 1", "West: 3"} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", expected, summary)
+		}
+	}
+	for _, client := range []string{"N1", "N2", "S1", "W1", "W2", "W3"} {
+		if !strings.Contains(rr.Body.String(), "\n"+client+"|") {
+			t.Errorf("Expected merged output to contain row %s", client)
+		}
+	}
+
+	// Without the option only the first sheet is read
+	req = newAPIProcessRequest(t, "regions.xlsx", buildWorkbook(sheets, names), map[string]string{
+		"mappings":     mappings,
+		"outputFormat": "csv",
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if summary := rr.Header().Get("X-Processing-Summary"); !strings.Contains(summary, "Total Rows Processed: 2") {
+		t.Errorf("Expected only the first sheet to be processed, got:\n%s", summary)
+	}
+
+	// A sheet with a different header is rejected by name
+	sheets["South"] = [][]string{{"Client Code", "Region"}, {"S1", "South"}}
+	req = newAPIProcessRequest(t, "regions.xlsx", buildWorkbook(sheets, names), map[string]string{
+		"mappings":       mappings,
+		"mergeAllSheets": "true",
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for mismatched headers, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `sheet \"South\"`) {
+		t.Errorf("Expected error to name the offending sheet, got %s", rr.Body.String())
+	}
+}
+
+// TestMaxMappingFields verifies requests with more mappings than MAX_MAPPING_FIELDS are rejected
+func TestMaxMappingFields(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	t.Setenv("MAX_MAPPING_FIELDS", "10")
+	originalFlags := featureFlags
+	defer func() { featureFlags = originalFlags }()
+	var err error
+	if featureFlags, err = config.LoadFeatureFlags(); err != nil {
+		t.Fatalf("Failed to load feature flags: %v", err)
+	}
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\n"
+
+	// API path
+	mappings := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		mappings[fmt.Sprintf("Extra_%d", i)] = "Client Code"
+	}
+	mappingsJSON, _ := json.Marshal(mappings)
+	req := newAPIProcessRequest(t, "wide.csv", fileContent, map[string]string{"mappings": string(mappingsJSON)})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Too many mapping fields") {
+		t.Errorf("Expected API to reject too many mappings, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// UI path: the 8 configured fields plus 3 unknown mapping fields exceed 10 output columns
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("fileInput", "wide.csv")
+	part.Write([]byte(fileContent))
+	for i := 0; i < 3; i++ {
+		writer.WriteField(fmt.Sprintf("mapping_Extra_%d", i), "Client Code")
+	}
+	writer.Close()
+
+	uploadReq := httptest.NewRequest("POST", "/upload", &body)
+	uploadReq.Header.Set("Content-Type", writer.FormDataContentType())
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(handleUpload).ServeHTTP(rr, uploadReq)
+
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Too many mapping fields") {
+		t.Errorf("Expected upload to reject too many output columns, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	t.Setenv("MAX_MAPPING_FIELDS", "0")
+	if _, err := config.LoadFeatureFlags(); err == nil {
+		t.Error("Expected MAX_MAPPING_FIELDS=0 to be rejected")
+	}
+}
+
+// TestEmbeddedNewlines verifies quoted cells containing newlines stay intact in CSV and become <br> in markdown
+func TestEmbeddedNewlines(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	inputPath := writeTempCSV(t, "Client Code,Customer ID,Account Number,Customer Name\nC1,1001,A1,\"Line one\nLine two | more\"\n")
+	fieldMappings := map[string]string{
+		"Client_Code":   "Client Code",
+		"Customer_ID":   "Customer ID",
+		"Account_ID":    "Account Number",
+		"Customer_Name": "Customer Name",
+	}
+	order := []string{"Client_Code", "Customer_ID", "Account_ID", "Customer_Name"}
+
+	_, csvPath := processFile(inputPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID())
+	defer os.Remove(csvPath)
+	csvContent, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(csvContent), "C1|1001|A1|\"Line one\nLine two | more\"\n") {
+		t.Errorf("Expected the multi-line cell to stay quoted in CSV output, got:\n%s", csvContent)
+	}
+
+	_, mdPath := processFile(inputPath, columnMappings(fieldMappings), order, "markdown", "test_"+generateUniqueID())
+	defer os.Remove(mdPath)
+	mdContent, err := os.ReadFile(mdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(mdContent), "| C1 | 1001 | A1 | Line one<br>Line two \\| more | \n") {
+		t.Errorf("Expected the multi-line cell on a single markdown row, got:\n%s", mdContent)
+	}
+}
+
+// TestOutputFormatContentTypes verifies each format is served with its charset-qualified content type and matching extension
+func TestOutputFormatContentTypes(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	testCases := []struct {
+		format      string
+		contentType string
+		extension   string
+	}{
+		{"xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+		{"excel", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+		{"csv", "text/csv; charset=utf-8", ".csv"},
+		{"markdown", "text/markdown; charset=utf-8", ".md"},
+		{"ndjson", "application/x-ndjson", ".ndjson"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			req := newAPIProcessRequest(t, "types.csv", "Client Code\nC1\n", map[string]string{
+				"mappings":               `{"Client_Code":"Client Code"}`,
+				"outputFormat":           tc.format,
+				"allowUnmappedMandatory": "true",
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+			if contentType := rr.Header().Get("Content-Type"); contentType != tc.contentType {
+				t.Errorf("Expected content type %q, got %q", tc.contentType, contentType)
+			}
+			if disposition := rr.Header().Get("Content-Disposition"); !strings.HasSuffix(disposition, tc.extension+`"`) {
+				t.Errorf("Expected %s file, got %s", tc.extension, disposition)
+			}
+		})
+	}
+}
+
+// TestHandleAPIProcessOutputMandatoryOnly verifies only mandatory columns appear in the output
+func TestHandleAPIProcessOutputMandatoryOnly(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	req := newAPIProcessRequest(t, "mandatory.csv", "Client Code,Customer ID,Account Number,Customer Name\nC1,1001,A1,John Doe\n", map[string]string{
+		"mappings":            `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number","Customer_Name":"Customer Name"}`,
+		"outputFormat":        "csv",
+		"outputMandatoryOnly": "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	expected := "Client_Code|Customer_ID|Account_ID\nC1|1001|A1\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected only mandatory columns:\n%s\ngot:\n%s", expected, rr.Body.String())
+	}
+}
+
+// TestCollapseWhitespace verifies per-field collapsing of tabs, repeated and non-breaking spaces
+func TestCollapseWhitespace(t *testing.T) {
+	fc := &config.FieldConfig{Fields: []config.Field{
+		{Name: "Customer_Name", DisplayName: "Customer Name", IsMandatory: true, CollapseWhitespace: true},
+		{Name: "Notes", DisplayName: "Notes"},
+		{Name: "Amount", DisplayName: "Amount", CollapseWhitespace: true, OutputNumberFormat: "%.2f"},
+	}}
+	if err := fc.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	processedRow, _, _, ok := processRow(
+		[]string{" John \t  Doe\u00a0\u00a0Jr ", "a  b", "\t12 "},
+		[]string{"customer name", "notes", "amount"},
+		columnMappings(map[string]string{"Customer_Name": "Customer Name", "Notes": "Notes", "Amount": "Amount"}),
+		[]string{"Customer_Name", "Notes", "Amount"}, fc, false)
+	if !ok {
+		t.Fatal("Expected row to be processed")
+	}
+	expected := []string{"John Doe Jr", "a  b", "12.00"}
+	if strings.Join(processedRow, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %q, got %q", expected, processedRow)
+	}
+}
+
+// TestHandleAPIProcessNDJSON verifies each line of ndjson output is a JSON object keyed by field name
+func TestHandleAPIProcessNDJSON(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	content := "Client Code,Customer ID,Account Number,Customer Name\nC1,1001,A1,\"Doe, \"\"JD\"\"\"\nC2,,A2,Jane\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number","Customer_Name":"Customer Name"}`
+
+	for _, omitEmpty := range []bool{false, true} {
+		t.Run(fmt.Sprintf("omitEmpty=%v", omitEmpty), func(t *testing.T) {
+			req := newAPIProcessRequest(t, "rows.csv", content, map[string]string{
+				"mappings":        mappings,
+				"outputFormat":    "ndjson",
+				"ndjsonOmitEmpty": strconv.FormatBool(omitEmpty),
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			lines := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("Expected 1 processed row, got %d lines: %q", len(lines), rr.Body.String())
+			}
+			var record map[string]string
+			if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+				t.Fatalf("Line %q is not valid JSON: %v", lines[0], err)
+			}
+			if record["Client_Code"] != "C1" || record["Customer_Name"] != `Doe, "JD"` {
+				t.Errorf("Unexpected record %v", record)
+			}
+			if _, ok := record["LE_ID"]; ok == omitEmpty {
+				t.Errorf("Expected empty LE_ID present=%v, got record %v", !omitEmpty, record)
+			}
+			if !strings.HasPrefix(lines[0], `{"Client_Code":`) {
+				t.Errorf("Expected keys in field order, got %s", lines[0])
+			}
+		})
+	}
+}
+
+// TestLookupField verifies a field populated from a reference table resolves codes to names
+func TestLookupField(t *testing.T) {
+	dir := t.TempDir()
+	csvTable := filepath.Join(dir, "countries.csv")
+	if err := os.WriteFile(csvTable, []byte("code,name\nGB,United Kingdom\nFR,France\n"), 0644); err != nil {
+		t.Fatalf("Failed to write lookup table: %v", err)
+	}
+	jsonTable := filepath.Join(dir, "regions.json")
+	if err := os.WriteFile(jsonTable, []byte(`{"GB":"EMEA"}`), 0644); err != nil {
+		t.Fatalf("Failed to write lookup table: %v", err)
+	}
+
+	fc := &config.FieldConfig{Fields: []config.Field{
+		{Name: "Country_Code", DisplayName: "Country Code", IsMandatory: true},
+		{Name: "Country_Name", DisplayName: "Country Name", Lookup: &config.Lookup{SourceField: "Country_Code", Table: csvTable, Default: "Unknown"}},
+		{Name: "Region", DisplayName: "Region", Lookup: &config.Lookup{SourceField: "Country_Code", Table: jsonTable, FlagUnmatched: true}},
+	}}
+	if err := fc.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	if err := fc.LoadLookupTables(); err != nil {
+		t.Fatalf("Failed to load lookup tables: %v", err)
+	}
+
+	order := fc.GetOrderedFields()
+	mappings := map[string]string{"Country_Code": "Country"}
+	headers := []string{"country"}
+
+	processedRow, _, _, ok := processRow([]string{" GB "}, headers, columnMappings(mappings), order, fc, false)
+	if !ok || strings.Join(processedRow, "|") != " GB |United Kingdom|EMEA" {
+		t.Errorf("Expected GB to resolve, got %q (ok=%v)", processedRow, ok)
+	}
+
+	_, missingRow, missingFields, ok := processRow([]string{"FR"}, headers, columnMappings(mappings), order, fc, false)
+	if ok || strings.Join(missingRow, "|") != "FR|France|MISSING" || strings.Join(missingFields, ",") != "Region" {
+		t.Errorf("Expected flagged unmatched Region, got %q %q (ok=%v)", missingRow, missingFields, ok)
+	}
+
+	processedRow, _, _, ok = processRow([]string{"XX"}, headers, columnMappings(mappings), order[:2], fc, false)
+	if !ok || processedRow[1] != "Unknown" {
+		t.Errorf("Expected unmatched code to use the default, got %q (ok=%v)", processedRow, ok)
+	}
+
+	fc.Fields[1].Lookup = &config.Lookup{SourceField: "Missing", Table: csvTable}
+	if err := fc.Validate(); err == nil {
+		t.Error("Expected lookup with an unknown source field to be rejected")
+	}
+}
+
+// TestExcelDateSerials verifies date serials in date fields are converted using the workbook's date system
+func TestExcelDateSerials(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Start_Date", "displayName": "Start Date", "type": "date"},
+            {"name": "End_Date", "displayName": "End Date", "type": "date", "dateFormat": "02/01/2006"},
+            {"name": "Amount", "displayName": "Amount"}
+        ]
+    }`)
+
+	testCases := []struct {
+		name     string
+		date1904 bool
+		expected string
+	}{
+		{"1900 date system", false, "C1|2023-01-01|15/06/2023|44927"},
+		{"1904 date system", true, "C1|2027-01-02|16/06/2027|44927"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := excelize.NewFile()
+			if err := f.SetWorkbookProps(&excelize.WorkbookPropsOptions{Date1904: &tc.date1904}); err != nil {
+				t.Fatal(err)
+			}
+			f.SetSheetRow("Sheet1", "A1", &[]interface{}{"Client Code", "Start Date", "End Date", "Amount"})
+			f.SetSheetRow("Sheet1", "A2", &[]interface{}{"C1", 44927, "45092", 44927})
+			inputPath := filepath.Join(t.TempDir(), "dates.xlsx")
+			if err := f.SaveAs(inputPath); err != nil {
+				t.Fatal(err)
+			}
+
+			fieldMappings := map[string]string{"Client_Code": "Client Code", "Start_Date": "Start Date", "End_Date": "End Date", "Amount": "Amount"}
+			_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", "test_"+generateUniqueID(), processOptions{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer os.Remove(outputPath)
+
+			content, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			if len(lines) != 2 || lines[1] != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, lines)
+			}
+		})
+	}
+}
+
+// outputWrittenContext reports itself cancelled once the processed output for uniqueID exists,
+// simulating a client disconnecting while the outputs are being written
+type outputWrittenContext struct {
+	context.Context
+	outputPath string
+}
+
+func (c outputWrittenContext) Err() error {
+	if _, err := os.Stat(c.outputPath); err == nil {
+		return context.Canceled
+	}
+	return nil
+}
+
+// TestProcessingCancellation verifies cancelled or timed-out processing stops and removes partial outputs
+func TestProcessingCancellation(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	var content strings.Builder
+	content.WriteString("Client Code,Customer ID,Account Number\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&content, "C%d,%d,A%d\n", i, i, i)
+	}
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Customer_ID": "Customer ID", "Account_ID": "Account Number"}
+	order := currentFieldConfig().GetOrderedFields()
+
+	t.Run("cancelled before processing", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		uniqueID := "test_" + generateUniqueID()
+		_, _, err := processFileWithOptions(ctx, writeTempCSV(t, content.String()), columnMappings(fieldMappings), order, "csv", uniqueID, processOptions{})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+		assertNoOutputFiles(t, uniqueID)
+	})
+
+	t.Run("cancelled while writing outputs", func(t *testing.T) {
+		uniqueID := "test_" + generateUniqueID()
+		outputPath, _ := outputFilePaths(uniqueID, "csv")
+		ctx := outputWrittenContext{Context: context.Background(), outputPath: outputPath}
+		_, _, err := processFileWithOptions(ctx, writeTempCSV(t, content.String()), columnMappings(fieldMappings), order, "csv", uniqueID, processOptions{})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+		assertNoOutputFiles(t, uniqueID)
+	})
+
+	t.Run("server deadline returns 503", func(t *testing.T) {
+		original := featureFlags.ProcessingTimeout
+		featureFlags.ProcessingTimeout = time.Nanosecond
+		defer func() { featureFlags.ProcessingTimeout = original }()
+
+		before, _ := filepath.Glob("./uploads/*_processed_data*")
+		req := newAPIProcessRequest(t, "large.csv", content.String(), map[string]string{
+			"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+			"outputFormat": "csv",
+		})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if after, _ := filepath.Glob("./uploads/*_processed_data*"); len(after) != len(before) {
+			t.Errorf("Expected no new output files, found %d before and %d after", len(before), len(after))
+		}
+	})
+}
+
+// assertNoOutputFiles fails the test if any processed or missing output exists for uniqueID
+func assertNoOutputFiles(t *testing.T, uniqueID string) {
+	t.Helper()
+	for _, format := range []string{"csv", "markdown", "ndjson", "xlsx"} {
+		processedPath, missingPath := outputFilePaths(uniqueID, format)
+		for _, path := range []string{processedPath, missingPath} {
+			if _, err := os.Stat(path); err == nil {
+				t.Errorf("Expected partial output %s to be removed", path)
+				os.Remove(path)
+			}
+		}
+	}
+}
+
+// TestAPIKeySources verifies keys are accepted from the X-API-Key header, a bearer token and,
+// when enabled, the api_key query parameter, with headers taking precedence
+func TestAPIKeySources(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	os.Setenv("ALLOW_QUERY_API_KEY", "true")
+	auth.InitAPIKeys()
+	defer func() {
+		os.Unsetenv("ALLOW_QUERY_API_KEY")
+		auth.InitAPIKeys()
+	}()
+
+	testCases := []struct {
+		name          string
+		headers       map[string]string
+		query         string
+		expectedCode  int
+		expectedError string
+	}{
+		{"Bearer token", map[string]string{"Authorization": "Bearer test-api-key-1"}, "", http.StatusOK, ""},
+		{"Lowercase bearer scheme", map[string]string{"Authorization": "bearer test-api-key-2"}, "", http.StatusOK, ""},
+		{"Invalid bearer token", map[string]string{"Authorization": "Bearer invalid-key"}, "", http.StatusUnauthorized, "Invalid API key"},
+		{"Non-bearer authorization", map[string]string{"Authorization": "Basic dGVzdA=="}, "", http.StatusUnauthorized, "API key is missing"},
+		{"Query parameter", nil, "?api_key=test-api-key-1", http.StatusOK, ""},
+		{"Invalid query parameter", nil, "?api_key=invalid-key", http.StatusUnauthorized, "Invalid API key"},
+		{"Header over bearer", map[string]string{"X-API-Key": "invalid-key", "Authorization": "Bearer test-api-key-1"}, "", http.StatusUnauthorized, "Invalid API key"},
+		{"Header over query", map[string]string{"X-API-Key": "test-api-key-1"}, "?api_key=invalid-key", http.StatusOK, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/config"+tc.query, nil)
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIConfig).ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Expected status %d, got %d", tc.expectedCode, rr.Code)
+			}
+			if tc.expectedError != "" && !strings.Contains(rr.Body.String(), tc.expectedError) {
+				t.Errorf("Expected error %q, got %q", tc.expectedError, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("Query parameter disabled by default", func(t *testing.T) {
+		os.Unsetenv("ALLOW_QUERY_API_KEY")
+		auth.InitAPIKeys()
+		req := httptest.NewRequest("GET", "/api/v1/config?api_key=test-api-key-1", nil)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIConfig).ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", rr.Code)
+		}
+	})
+}
+
+// TestEmptyMandatoryColumnWarning verifies the summary flags a mandatory field mapped to an all-empty column
+func TestEmptyMandatoryColumnWarning(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	inputPath := writeTempCSV(t, "Client Code,Customer ID,Account Number,Legacy ID\nC1,1001,A1,\nC2,1002,A2,  \n")
+	fieldMappings := map[string]string{
+		"Client_Code": "Client Code",
+		"Customer_ID": "Legacy ID",
+		"Account_ID":  "Account Number",
+	}
+	summary, _, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", "test_"+generateUniqueID(), processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "WARNING: mandatory field Customer_ID maps to column Legacy ID which is entirely empty\n"
+	if !strings.HasPrefix(summary, expected) {
+		t.Errorf("Expected summary to start with %q, got:\n%s", expected, summary)
+	}
+	if strings.Count(summary, "WARNING") != 1 {
+		t.Errorf("Expected a single warning, got:\n%s", summary)
+	}
+}
+
+// TestHandleAPIProcessSummarySidecar verifies the summary sidecar parses, matches the counts and can be downloaded
+func TestHandleAPIProcessSummarySidecar(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	req := newAPIProcessRequest(t, "sidecar.csv", "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n,1003,\n", map[string]string{
+		"mappings":       `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat":   "csv",
+		"summarySidecar": "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	sidecar := rr.Header().Get("X-Summary-File")
+	if !strings.HasSuffix(sidecar, "_summary.json") {
+		t.Fatalf("Expected X-Summary-File header naming the sidecar, got %q", sidecar)
+	}
+
+	downloadReq := httptest.NewRequest("GET", "/download?file="+sidecar, nil)
+	downloadRR := httptest.NewRecorder()
+	handleDownload(downloadRR, downloadReq)
+	if downloadRR.Code != http.StatusOK {
+		t.Fatalf("Expected sidecar download to succeed, got %d", downloadRR.Code)
+	}
+
+	var report processingReport
+	if err := json.Unmarshal(downloadRR.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Sidecar is not valid JSON: %v", err)
+	}
+	if report.TotalRows != 3 || report.SuccessfulRows != 1 || report.RowsWithMissingData != 2 {
+		t.Errorf("Unexpected counts %+v", report)
+	}
+	if len(report.MissingRows) != 2 || report.MissingRows[0].Row != 3 || strings.Join(report.MissingRows[1].MissingFields, ",") != "Client_Code,Account_ID" {
+		t.Errorf("Unexpected missing row details %+v", report.MissingRows)
+	}
+}
+
+// TestValidationRules verifies a US row with an invalid ZIP is routed to MissingData with the rule name
+func TestValidationRules(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Country", "displayName": "Country"},
+            {"name": "Postal_Code", "displayName": "Postal Code"}
+        ],
+        "rules": [
+            {"name": "us-zip", "when": {"field": "Country", "equals": "US"}, "then": {"field": "Postal_Code", "matches": "^\\d{5}$"}}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client Code,Country,Postal Code\nC1,US,90210\nC2,US,9021A\nC3,GB,SW1A 1AA\n")
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Country": "Country", "Postal_Code": "Postal Code"}
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	if !strings.Contains(summary, "Row 3: Failed validation rules - us-zip") {
+		t.Errorf("Expected the failed rule in the summary, got:\n%s", summary)
+	}
+
+	processed, _ := os.ReadFile(outputPath)
+	if string(processed) != "Client_Code|Country|Postal_Code\nC1|US|90210\nC3|GB|SW1A 1AA\n" {
+		t.Errorf("Unexpected processed output:\n%s", processed)
+	}
+	missing, _ := os.ReadFile(missingPath)
+	if string(missing) != "Client_Code|Country|Postal_Code|_errors\nC2|US|INVALID|us-zip\n" {
+		t.Errorf("Unexpected missing output:\n%s", missing)
+	}
+
+	invalid := &config.FieldConfig{
+		Fields: []config.Field{{Name: "Country", DisplayName: "Country"}},
+		Rules:  []config.Rule{{Name: "bad", When: config.RuleCondition{Field: "Country", Equals: "US"}, Then: config.RuleCheck{Field: "Country", Matches: "("}}},
+	}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected a rule with an invalid pattern to be rejected")
+	}
+}
+
+// TestRuleSeverity verifies a warn-level rule failure keeps the row in ProcessedData and
+// records a warning, while a reject-level failure still routes the row to MissingData
+func TestRuleSeverity(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Country", "displayName": "Country"},
+            {"name": "Postal_Code", "displayName": "Postal Code"},
+            {"name": "Phone", "displayName": "Phone"}
+        ],
+        "rules": [
+            {"name": "us-zip", "when": {"field": "Country", "equals": "US"}, "then": {"field": "Postal_Code", "matches": "^\\d{5}$"}, "severity": "reject"},
+            {"name": "us-phone", "when": {"field": "Country", "equals": "US"}, "then": {"field": "Phone", "matches": "^\\+1"}, "severity": "warn"}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client Code,Country,Postal Code,Phone\nC1,US,90210,+15551234\nC2,US,90210,5551234\nC3,US,9021A,5551234\n")
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Country": "Country", "Postal_Code": "Postal Code", "Phone": "Phone"}
+	uniqueID := "test_" + generateUniqueID()
+	opts := processOptions{includeWarnings: true}
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	for _, expected := range []string{"Successful Rows: 2", "Rows with Warnings: 1", "Row 3: Validation warnings - us-phone", "Row 4: Failed validation rules - us-zip"} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("Expected %q in the summary, got:\n%s", expected, summary)
+		}
+	}
+	processed, _ := os.ReadFile(outputPath)
+	if string(processed) != "Client_Code|Country|Postal_Code|Phone|_warnings\nC1|US|90210|+15551234|\nC2|US|90210|5551234|us-phone\n" {
+		t.Errorf("Unexpected processed output:\n%s", processed)
+	}
+	missing, _ := os.ReadFile(missingPath)
+	if string(missing) != "Client_Code|Country|Postal_Code|Phone|_errors\nC3|US|INVALID|5551234|us-zip\n" {
+		t.Errorf("Unexpected missing output:\n%s", missing)
+	}
+
+	invalid := &config.FieldConfig{
+		Fields: []config.Field{{Name: "Country", DisplayName: "Country"}},
+		Rules:  []config.Rule{{Name: "bad", When: config.RuleCondition{Field: "Country", Equals: "US"}, Then: config.RuleCheck{Field: "Country", Matches: "^US$"}, Severity: "fatal"}},
+	}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected a rule with an unknown severity to be rejected")
+	}
+}
+
+// parallelTestConfig has transforms and reject and warn rules, so that parallel
+// mapping exercises every per-row step
+const parallelTestConfig = `{
+    "fields": [
+        {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true, "transforms": ["trim", "upper"]},
+        {"name": "Country", "displayName": "Country", "transforms": ["collapseWhitespace"]},
+        {"name": "Postal_Code", "displayName": "Postal Code", "isMandatory": true}
+    ],
+    "rules": [
+        {"name": "us-zip", "when": {"field": "Country", "equals": "US"}, "then": {"field": "Postal_Code", "matches": "^\\d{5}$"}},
+        {"name": "gb-postcode", "when": {"field": "Country", "equals": "GB"}, "then": {"field": "Postal_Code", "matches": "^[A-Z]"}, "severity": "warn"}
+    ]
+}`
+
+// parallelTestInput returns a CSV of rows data rows mixing valid, missing, invalid and duplicate rows
+func parallelTestInput(rows int) string {
+	var sb strings.Builder
+	sb.WriteString("Client Code,Country,Postal Code\n")
+	countries := []string{"US", "GB", "FR", " US "}
+	for i := 0; i < rows; i++ {
+		postal := fmt.Sprintf("%05d", i%100000)
+		switch {
+		case i%7 == 0:
+			postal = ""
+		case i%11 == 0:
+			postal = "x" + postal
+		}
+		fmt.Fprintf(&sb, " c%d ,%s,%s\n", i%(rows-rows/10), countries[i%len(countries)], postal)
+	}
+	return sb.String()
+}
+
+// TestParallelRowMapping verifies mapping rows with several workers produces exactly the
+// outputs and summary of sequential mapping
+func TestParallelRowMapping(t *testing.T) {
+	useTempFieldConfig(t, parallelTestConfig)
+	inputPath := writeTempCSV(t, parallelTestInput(3000))
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Country": "Country", "Postal_Code": "Postal Code"}
+
+	run := func(workers int) (string, string, string) {
+		uniqueID := "test_" + generateUniqueID()
+		opts := processOptions{rowWorkers: workers, includeStats: true, includeWarnings: true, includeQualityScore: true, dedupeBy: []string{"Client_Code"}, trimCells: true}
+		summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, opts)
+		if err != nil {
+			t.Fatalf("Unexpected error with %d workers: %v", workers, err)
+		}
+		_, missingPath := outputFilePaths(uniqueID, "csv")
+		defer os.Remove(outputPath)
+		defer os.Remove(missingPath)
+		processed, _ := os.ReadFile(outputPath)
+		missing, _ := os.ReadFile(missingPath)
+		return summary, string(processed), string(missing)
+	}
+
+	summary, processed, missing := run(1)
+	if !strings.Contains(summary, "Rows with Warnings") || !strings.Contains(summary, "Duplicates Removed") {
+		t.Fatalf("Expected the input to produce warnings and duplicates, got summary:\n%s", summary)
+	}
+	for _, workers := range []int{2, 8} {
+		parallelSummary, parallelProcessed, parallelMissing := run(workers)
+		if parallelSummary != summary {
+			t.Errorf("Summary with %d workers differs from sequential:\n%s\nvs\n%s", workers, parallelSummary, summary)
+		}
+		if parallelProcessed != processed {
+			t.Errorf("Processed output with %d workers differs from sequential", workers)
+		}
+		if parallelMissing != missing {
+			t.Errorf("Missing output with %d workers differs from sequential", workers)
+		}
+	}
+}
+
+// BenchmarkRowWorkers compares sequential and parallel row mapping on a large file
+func BenchmarkRowWorkers(b *testing.B) {
+	useTempFieldConfig(b, parallelTestConfig)
+	inputPath := writeTempCSV(b, parallelTestInput(50000))
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Country": "Country", "Postal_Code": "Postal Code"}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for range b.N {
+				uniqueID := "bench_" + generateUniqueID()
+				_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{rowWorkers: workers})
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, missingPath := outputFilePaths(uniqueID, "csv")
+				os.Remove(outputPath)
+				os.Remove(missingPath)
+			}
+		})
+	}
+}
+
+// TestMarkdownCustomization verifies configured and requested column alignments, the custom title and summary toggle
+func TestMarkdownCustomization(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Amount", "displayName": "Amount", "markdownAlign": "right"},
+            {"name": "Status", "displayName": "Status", "markdownAlign": "left"},
+            {"name": "Notes", "displayName": "Notes"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	req := newAPIProcessRequest(t, "report.csv", "Client Code,Amount,Status,Notes\nC1,12.50,Open,n/a\n", map[string]string{
+		"mappings":        `{"Client_Code":"Client Code","Amount":"Amount","Status":"Status","Notes":"Notes"}`,
+		"outputFormat":    "markdown",
+		"markdownTitle":   "Q3 Accounts",
+		"markdownSummary": "false",
+		"markdownAlign":   `{"Status":"center"}`,
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	expected := "# Q3 Accounts\n\n## Processed Data\n\n" +
+		"| Client_Code | Amount | Status | Notes | \n" +
+		"| --- | ---: | :---: | --- |\n" +
+		"| C1 | 12.50 | Open | n/a | \n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, rr.Body.String())
+	}
+
+	req = newAPIProcessRequest(t, "report.csv", "Client Code\nC1\n", map[string]string{
+		"mappings":      `{"Client_Code":"Client Code"}`,
+		"outputFormat":  "markdown",
+		"markdownAlign": `{"Client_Code":"justify"}`,
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid alignment, got %d", rr.Code)
+	}
+}
+
+// TestHandleAPIConfigUI verifies the consolidated UI config includes type and validation metadata in field order
+func TestHandleAPIConfigUI(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "countries.json")
+	if err := os.WriteFile(table, []byte(`{"US":"United States"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	useTempFieldConfig(t, fmt.Sprintf(`{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Start_Date", "displayName": "Start Date", "type": "date"},
+            {"name": "Country", "displayName": "Country"},
+            {"name": "Country_Name", "displayName": "Country Name", "lookup": {"sourceField": "Country", "table": %q}},
+            {"name": "Postal_Code", "displayName": "Postal Code"}
+        ],
+        "rules": [
+            {"name": "us-zip", "when": {"field": "Country", "equals": "US"}, "then": {"field": "Postal_Code", "matches": "^\\d{5}$"}}
+        ]
+    }`, table))
+	auth.InitAPIKeys()
+
+	req := httptest.NewRequest("GET", "/api/v1/config/ui", nil)
+	req.Header.Set("X-API-Key", "test-api-key-1")
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIConfigUI).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response UIConfigResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if strings.Join(response.OrderedFields, ",") != "Client_Code,Start_Date,Country,Country_Name,Postal_Code" {
+		t.Errorf("Unexpected field order %v", response.OrderedFields)
+	}
+	if len(response.Fields) != 5 {
+		t.Fatalf("Expected 5 fields, got %d", len(response.Fields))
+	}
+	clientCode, startDate, countryName, postalCode := response.Fields[0], response.Fields[1], response.Fields[3], response.Fields[4]
+	if clientCode.Type != "text" || !clientCode.IsMandatory || clientCode.DisplayName != "Client Code" {
+		t.Errorf("Unexpected Client_Code metadata %+v", clientCode)
+	}
+	if startDate.Type != "date" || startDate.DateFormat != "2006-01-02" {
+		t.Errorf("Expected Start_Date to be a date field, got %+v", startDate)
+	}
+	if countryName.LookupSource != "Country" {
+		t.Errorf("Expected Country_Name lookup source, got %+v", countryName)
+	}
+	if strings.Join(postalCode.Rules, ",") != "us-zip" {
+		t.Errorf("Expected Postal_Code to list its rule, got %+v", postalCode)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/config/ui", nil)
+	req.Header.Set("X-API-Key", "test-api-key-1")
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIConfigUI).ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", rr.Code)
+	}
+}
+
+// memorySink is an OutputSink that keeps stored files in memory
+type memorySink struct {
+	objects map[string][]byte
+}
+
+func (m *memorySink) Store(ctx context.Context, localPath string) (storage.Location, error) {
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return storage.Location{}, err
+	}
+	key := "outputs/" + filepath.Base(localPath)
+	m.objects[key] = content
+	os.Remove(localPath)
+	return storage.Location{Key: key, URL: "https://storage.example/" + key}, nil
+}
+
+func (m *memorySink) Remote() bool { return true }
+
+// failingSink is a memorySink that fails to store anything once it holds limit objects
+type failingSink struct {
+	memorySink
+	limit int
+}
+
+func (f *failingSink) Store(ctx context.Context, localPath string) (storage.Location, error) {
+	if len(f.objects) >= f.limit {
+		return storage.Location{}, errors.New("bucket unavailable")
+	}
+	return f.memorySink.Store(ctx, localPath)
+}
+
+// TestStoreOutputsPartialFailure verifies a failed upload removes the outputs left on disk
+// and reports those already stored
+func TestStoreOutputsPartialFailure(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	sink := &failingSink{memorySink: memorySink{objects: make(map[string][]byte)}, limit: 1}
+	originalSink := outputSink
+	outputSink = sink
+	defer func() { outputSink = originalSink }()
+
+	req := newAPIProcessRequest(t, "remote.csv", "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n", map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat": "csv",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON error, got %q: %v", rr.Body.String(), err)
+	}
+	if response.Error != "Failed to store output file" || len(response.StoredOutputs) != 1 || response.StoredOutputs[0].Kind != "output" {
+		t.Fatalf("Expected the stored output to be reported, got %+v", response)
+	}
+	if _, ok := sink.objects[response.StoredOutputs[0].Key]; !ok {
+		t.Errorf("Expected %s to be in the sink", response.StoredOutputs[0].Key)
+	}
+	uniqueID := strings.TrimSuffix(strings.TrimPrefix(response.StoredOutputs[0].Key, "outputs/"), "_processed_data.csv")
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	for _, path := range []string{missingPath, summarySidecarPath(uniqueID)} {
+		if _, err := os.Stat(path); err == nil {
+			os.Remove(path)
+			t.Errorf("Expected %s to be removed after the failed upload", path)
+		}
+	}
+}
+
+// TestRemoteOutputSink verifies outputs are handed to the sink under the expected keys and removed from disk
+func TestRemoteOutputSink(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	sink := &memorySink{objects: make(map[string][]byte)}
+	originalSink := outputSink
+	outputSink = sink
+	defer func() { outputSink = originalSink }()
+
+	req := newAPIProcessRequest(t, "remote.csv", "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n", map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat": "csv",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response StoredOutputResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON response, got %q: %v", rr.Body.String(), err)
+	}
+	if len(response.Outputs) != 2 || response.Outputs[0].Kind != "output" || response.Outputs[1].Kind != "missing" {
+		t.Fatalf("Expected output and missing objects, got %+v", response.Outputs)
+	}
+	output := response.Outputs[0]
+	if !strings.HasPrefix(output.Key, "outputs/") || !strings.HasSuffix(output.Key, "_processed_data.csv") || output.URL != "https://storage.example/"+output.Key {
+		t.Errorf("Unexpected output location %+v", output)
+	}
+	if content := string(sink.objects[output.Key]); content != "Client_Code|LE_ID|Customer_ID|Customer_Name|Customer_Active|Account_ID|Account_Name|Account_Active\nC1||1001|||A1||\n" {
+		t.Errorf("Unexpected stored content %q", content)
+	}
+	if _, err := os.Stat(filepath.Join("./uploads", strings.TrimPrefix(output.Key, "outputs/"))); err == nil {
+		t.Error("Expected the local output to be removed")
+	}
+
+	// A retry with the same Idempotency-Key replays the stored locations without storing again
+	idempotencyKey := "remote-" + generateUniqueID()
+	var bodies []string
+	for range 2 {
+		req := newAPIProcessRequest(t, "remote.csv", "Client Code,Customer ID,Account Number\nC1,1001,A1\n", map[string]string{
+			"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+			"outputFormat": "csv",
+		})
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		bodies = append(bodies, rr.Body.String())
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("Expected the retry to replay the stored response, got %s and %s", bodies[0], bodies[1])
+	}
+	if len(sink.objects) != 4 {
+		t.Errorf("Expected the retry not to store the outputs again, got %d objects", len(sink.objects))
+	}
+}
+
+// TestS3SinkUpload verifies the S3 sink signs the upload, sends the content to the bucket key and presigns a URL
+func TestS3SinkUpload(t *testing.T) {
+	var gotPath, gotAuth, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotAuth, gotBody = r.URL.Path, r.Header.Get("Authorization"), string(body)
+	}))
+	defer server.Close()
+
+	localPath := filepath.Join(t.TempDir(), "abc_processed_data.csv")
+	if err := os.WriteFile(localPath, []byte("Client_Code\nC1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sink := &storage.S3Sink{Endpoint: server.URL, Bucket: "mapped", Region: "eu-west-1", AccessKeyID: "AKID", SecretAccessKey: "secret", Prefix: "runs/", URLExpiry: time.Minute}
+	location, err := sink.Store(context.Background(), localPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if gotPath != "/mapped/runs/abc_processed_data.csv" || gotBody != "Client_Code\nC1\n" {
+		t.Errorf("Unexpected upload to %s with body %q", gotPath, gotBody)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(gotAuth, "/eu-west-1/s3/aws4_request") {
+		t.Errorf("Unexpected Authorization header %q", gotAuth)
+	}
+	if location.Key != "runs/abc_processed_data.csv" || !strings.Contains(location.URL, "X-Amz-Expires=60") || !strings.Contains(location.URL, "X-Amz-Signature=") {
+		t.Errorf("Unexpected location %+v", location)
+	}
+	if _, err := os.Stat(localPath); err == nil {
+		t.Error("Expected the local file to be removed after upload")
+	}
+}
+
+// TestSummaryRowNumbers verifies reported row numbers match the spreadsheet, including per-sheet numbering for merged workbooks
+func TestSummaryRowNumbers(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Customer_ID": "Customer ID", "Account_ID": "Account Number"}
+	order := currentFieldConfig().GetOrderedFields()
+
+	// Spreadsheet row 1 is the header, so the row missing a Customer ID is row 4
+	inputPath := writeTempCSV(t, "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,1002,A2\nC3,,A3\nC4,1004,A4\n")
+	summary, _, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID(), processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(summary, "Row 4: Missing mandatory fields - Customer_ID\n") {
+		t.Errorf("Expected the bad row to be reported as row 4, got:\n%s", summary)
+	}
+
+	// The same row windowed by skipRows keeps its spreadsheet number
+	summary, _, err = processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID(), processOptions{skipRows: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(summary, "Row 4: Missing mandatory fields - Customer_ID\n") {
+		t.Errorf("Expected skipRows not to change the reported row, got:\n%s", summary)
+	}
+
+	workbook := excelize.NewFile()
+	workbook.SetSheetName("Sheet1", "North")
+	workbook.NewSheet("South")
+	for sheet, rows := range map[string][][]interface{}{
+		"North": {{"Client Code", "Customer ID", "Account Number"}, {"C1", "1001", "A1"}, {"C2", "1002", "A2"}},
+		"South": {{"Client Code", "Customer ID", "Account Number"}, {"C3", "1003", "A3"}, {"C4", "", "A4"}},
+	} {
+		for i, row := range rows {
+			cell, _ := excelize.CoordinatesToCellName(1, i+1)
+			workbook.SetSheetRow(sheet, cell, &row)
+		}
+	}
+	workbookPath := filepath.Join(t.TempDir(), "regions.xlsx")
+	if err := workbook.SaveAs(workbookPath); err != nil {
+		t.Fatal(err)
+	}
+	summary, _, err = processFileWithOptions(context.Background(), workbookPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID(), processOptions{mergeAllSheets: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(summary, `Row 3 of sheet "South": Missing mandatory fields - Customer_ID`) {
+		t.Errorf("Expected the bad row to be reported as row 3 of South, got:\n%s", summary)
+	}
+}
+
+// TestHandleAPIProcessPassthroughColumns verifies named source columns are appended as-is, with unknown columns left empty
+func TestHandleAPIProcessPassthroughColumns(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	for _, passthrough := range []string{`["Region","source note","Not There"]`, "Region, source note, Not There"} {
+		t.Run(passthrough, func(t *testing.T) {
+			req := newAPIProcessRequest(t, "passthrough.csv", "Client Code,Customer ID,Account Number,Region,Source Note\nC1,1001,A1,North,  keep me  \n", map[string]string{
+				"mappings":            `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+				"outputFormat":        "csv",
+				"outputMandatoryOnly": "true",
+				"includeQualityScore": "true",
+				"passthroughColumns":  passthrough,
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			expected := "Client_Code|Customer_ID|Account_ID|Region|source note|Not There|_quality\nC1|1001|A1|North|\"  keep me  \"||100\n"
+			if rr.Body.String() != expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", expected, rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleAPIProcessHeaderStyle verifies headerStyle=display heads output columns with display names while ndjson keeps names
+func TestHandleAPIProcessHeaderStyle(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Customer_ID", "displayName": "Customer Number", "isMandatory": true},
+            {"name": "Notes", "displayName": "Free-Text Notes"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	fileContent := "Client,Customer,Notes,Region\nC1,1001,,North\nC2,1002,,South\n"
+	testCases := []struct {
+		name     string
+		fields   map[string]string
+		expected string
+	}{
+		{"names by default", map[string]string{"outputFormat": "csv"}, "Client_Code|Customer_ID|Notes|Region\n"},
+		{"display names", map[string]string{"outputFormat": "csv", "headerStyle": "display"}, "Client Code|Customer Number|Free-Text Notes|Region\n"},
+		{"after dropped columns", map[string]string{"outputFormat": "csv", "headerStyle": "display", "dropEmptyColumns": "true", "outputScope": "processed"}, "Client Code|Customer Number|Region\n"},
+		{"ndjson keeps names", map[string]string{"outputFormat": "ndjson", "headerStyle": "display"}, `{"Client_Code":"C1","Customer_ID":"1001","Notes":"","Region":"North"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fields["mappings"] = `{"Client_Code":"Client","Customer_ID":"Customer","Notes":"Notes"}`
+			tc.fields["passthroughColumns"] = "Region"
+			req := newAPIProcessRequest(t, "headers.csv", fileContent, tc.fields)
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if !strings.HasPrefix(rr.Body.String(), tc.expected) {
+				t.Errorf("Expected output to start with %q, got:\n%s", tc.expected, rr.Body.String())
+			}
+		})
+	}
+
+	req := newAPIProcessRequest(t, "headers.csv", fileContent, map[string]string{"mappings": `{"Client_Code":"Client"}`, "headerStyle": "friendly"})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown header style, got %d", rr.Code)
+	}
+}
+
+// TestOutputHeaders verifies outputHeaders relabels field columns for one run in every format
+func TestOutputHeaders(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Amount", "displayName": "Amount Due", "type": "number"},
+            {"name": "Notes", "displayName": "Free-Text Notes"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	fileContent := "Client,Amount,Notes\nC1,5,n\n"
+	testCases := []struct {
+		name     string
+		fields   map[string]string
+		expected string
+	}{
+		{"csv", map[string]string{"outputFormat": "csv"}, "client_ref|Amount|Notes\n"},
+		{"over display names", map[string]string{"outputFormat": "csv", "headerStyle": "display"}, "client_ref|Amount Due|Free-Text Notes\n"},
+		{"ndjson", map[string]string{"outputFormat": "ndjson"}, `{"client_ref":"C1","Amount":"5","Notes":"n"}`},
+		{"sql keeps numbers bare", map[string]string{"outputFormat": "sql", "outputHeaders": `{"Amount Due":"total"}`}, `INSERT INTO "processed_data" ("Client_Code", "total", "Notes") VALUES` + "\n  ('C1', 5, 'n');"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fields["mappings"] = `{"Client_Code":"Client","Amount":"Amount","Notes":"Notes"}`
+			if tc.fields["outputHeaders"] == "" {
+				tc.fields["outputHeaders"] = `{"Client_Code":"client_ref"}`
+			}
+			req := newAPIProcessRequest(t, "headers.csv", fileContent, tc.fields)
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if !strings.HasPrefix(rr.Body.String(), tc.expected) {
+				t.Errorf("Expected output to start with %q, got:\n%s", tc.expected, rr.Body.String())
+			}
+		})
+	}
+	if names := currentFieldConfig().GetDisplayNames(); names["Client_Code"] != "Client Code" {
+		t.Errorf("Expected the field config to be unchanged, got %v", names)
+	}
+
+	for _, headers := range []string{`{"Unknown":"x"}`, `{"Client_Code":" "}`, `["client_ref"]`} {
+		req := newAPIProcessRequest(t, "headers.csv", fileContent, map[string]string{"mappings": `{"Client_Code":"Client"}`, "outputHeaders": headers})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for outputHeaders %s, got %d", headers, rr.Code)
+		}
+	}
+}
+
+// TestUnmappedColumnsPolicy verifies source columns no mapping uses are ignored, reported or passed through
+func TestUnmappedColumnsPolicy(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	inputPath := writeTempCSV(t, "Client Code,Customer ID,Region,Account Number,,Notes,Cust Ref\nC1,1001,North,A1,x,call back,R1\n")
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Customer_ID": "Customer ID", "Account_ID": "Account Number"}
+	order := []string{"Client_Code", "Customer_ID", "Account_ID"}
+
+	testCases := []struct {
+		policy          string
+		expectedOutput  string
+		expectedSummary string
+	}{
+		{unmappedColumnsIgnore, "Client_Code|Customer_ID|Account_ID|Cust Ref\nC1|1001|A1|R1\n", ""},
+		{unmappedColumnsReport, "Client_Code|Customer_ID|Account_ID|Cust Ref\nC1|1001|A1|R1\n", "Unmapped Columns: 2 (Region, Notes)\n"},
+		{unmappedColumnsPassthrough, "Client_Code|Customer_ID|Account_ID|Cust Ref|Region|Notes\nC1|1001|A1|R1|North|call back\n", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.policy, func(t *testing.T) {
+			uniqueID := "test_" + generateUniqueID()
+			opts := processOptions{outputScope: outputScopeProcessed, passthroughColumns: []string{"Cust Ref"}, unmappedColumns: tc.policy}
+			summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", uniqueID, opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer os.Remove(outputPath)
+
+			output, _ := os.ReadFile(outputPath)
+			if string(output) != tc.expectedOutput {
+				t.Errorf("Expected output:\n%s\ngot:\n%s", tc.expectedOutput, output)
+			}
+			if tc.expectedSummary != "" && !strings.Contains(summary, tc.expectedSummary) {
+				t.Errorf("Expected %q in summary:\n%s", tc.expectedSummary, summary)
+			}
+			if tc.expectedSummary == "" && strings.Contains(summary, "Unmapped Columns") {
+				t.Errorf("Expected no unmapped columns in summary:\n%s", summary)
+			}
+		})
+	}
+
+	if _, err := parseUnmappedColumnsPolicy("drop"); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
+
+// TestHandleAPIPreview verifies the preview endpoint returns the documented JSON shape
+func TestHandleAPIPreview(t *testing.T) {
+	auth.InitAPIKeys()
+
+	req := newAPIProcessRequest(t, "preview.csv", "Client Code,Customer ID,Region\nC1,1001,North\nC2,1002,\nC3,1003,South\n", map[string]string{"rows": "2"})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIPreview).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", ct)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	for _, key := range []string{"headers", "rows", "totalRows", "offset", "nextOffset"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("Expected key %q in response %s", key, rr.Body.String())
+		}
+	}
+	if len(raw) != 5 {
+		t.Errorf("Expected exactly 5 keys, got %s", rr.Body.String())
+	}
+
+	var preview PreviewResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &preview); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if strings.Join(preview.Headers, "|") != "Client Code|Customer ID|Region" {
+		t.Errorf("Unexpected headers %v", preview.Headers)
+	}
+	if preview.TotalRows != 3 || len(preview.Rows) != 2 {
+		t.Fatalf("Expected 2 of 3 rows, got %d of %d", len(preview.Rows), preview.TotalRows)
+	}
+	if strings.Join(preview.Rows[1], "|") != "C2|1002|" {
+		t.Errorf("Unexpected second row %q", preview.Rows[1])
+	}
+}
+
+// TestHandleAPIPreviewPaging verifies offset and limit page through the rows of a preview
+func TestHandleAPIColumns(t *testing.T) {
+	auth.InitAPIKeys()
+
+	columns := func(t *testing.T, filename, content string, fields map[string]string) (int, ColumnsResponse) {
+		t.Helper()
+		req := newAPIProcessRequest(t, filename, content, fields)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIColumns).ServeHTTP(rr, req)
+		var response ColumnsResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return rr.Code, response
+	}
+
+	code, response := columns(t, "columns.csv", "Client Code,Amount,,amount ,Amount (2),Amount\nC1,1,x,2,3,4\n", nil)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	expected := []ColumnInfo{
+		{Index: 0, Name: "Client Code", Normalized: "client code", UniqueName: "Client Code"},
+		{Index: 1, Name: "Amount", Normalized: "amount", UniqueName: "Amount"},
+		{Index: 2, Name: "", Normalized: "", UniqueName: "Column 3"},
+		{Index: 3, Name: "amount ", Normalized: "amount", UniqueName: "amount (3)", Duplicate: true},
+		{Index: 4, Name: "Amount (2)", Normalized: "amount (2)", UniqueName: "Amount (2)"},
+		{Index: 5, Name: "Amount", Normalized: "amount", UniqueName: "Amount (4)", Duplicate: true},
+	}
+	if !slices.Equal(response.Columns, expected) || response.Sheets != nil {
+		t.Errorf("Unexpected columns:\n%+v", response)
+	}
+
+	// Composite headers are combined as for processing
+	if _, response := columns(t, "columns.csv", ",Sales,\nClient,Q1,Q2\n", map[string]string{"headerRows": "2"}); len(response.Columns) != 3 || response.Columns[2].Name != "Sales Q2" {
+		t.Errorf("Expected composite column names, got %+v", response.Columns)
+	}
+
+	workbook := excelize.NewFile()
+	workbook.SetSheetRow("Sheet1", "A1", &[]string{"First"})
+	workbook.NewSheet("Accounts")
+	workbook.SetSheetRow("Accounts", "A1", &[]string{"Account Number", "Balance"})
+	workbook.SetSheetRow("Accounts", "A2", &[]string{"A1", "10"})
+	buffer, err := workbook.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, response = columns(t, "columns.xlsx", buffer.String(), map[string]string{"sheet": "Accounts"})
+	if code != http.StatusOK || response.Sheet != "Accounts" || !slices.Equal(response.Sheets, []string{"Sheet1", "Accounts"}) || len(response.Columns) != 2 || response.Columns[1].Name != "Balance" {
+		t.Errorf("Expected the Accounts sheet's columns, got %d %+v", code, response)
+	}
+	if code, _ := columns(t, "columns.xlsx", buffer.String(), map[string]string{"sheet": "Missing"}); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown sheet, got %d", code)
+	}
+}
+
+func TestHandleAPIPreviewPaging(t *testing.T) {
+	auth.InitAPIKeys()
+
+	var content strings.Builder
+	content.WriteString("Client Code,Customer ID\n")
+	for i := 1; i <= 25; i++ {
+		fmt.Fprintf(&content, "C%d,%d\n", i, 1000+i)
+	}
+
+	preview := func(t *testing.T, query string) (int, PreviewResponse) {
+		t.Helper()
+		req := newAPIProcessRequest(t, "paging.csv", content.String(), nil)
+		req.URL.RawQuery = query
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIPreview).ServeHTTP(rr, req)
+		var response PreviewResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return rr.Code, response
+	}
+
+	// Follow nextOffset through every page
+	var clients []string
+	query := "limit=10"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("Expected paging to end after 3 pages")
+		}
+		code, page := preview(t, query)
+		if code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %q, got %d", query, code)
+		}
+		if page.TotalRows != 25 {
+			t.Errorf("Expected 25 total rows, got %d", page.TotalRows)
+		}
+		for _, row := range page.Rows {
+			clients = append(clients, row[0])
+		}
+		if page.NextOffset == 0 {
+			break
+		}
+		query = fmt.Sprintf("offset=%d&limit=10", page.NextOffset)
+	}
+	if len(clients) != 25 || clients[0] != "C1" || clients[10] != "C11" || clients[24] != "C25" {
+		t.Errorf("Expected every row once in order, got %v", clients)
+	}
+
+	testCases := []struct {
+		name         string
+		query        string
+		expectedCode int
+		expectedRows []string
+		expectedNext int
+	}{
+		{"default page", "", http.StatusOK, []string{"C1", "C2", "C3", "C4", "C5", "C6", "C7", "C8", "C9", "C10"}, 10},
+		{"middle page", "offset=5&limit=2", http.StatusOK, []string{"C6", "C7"}, 7},
+		{"last partial page", "offset=23&limit=5", http.StatusOK, []string{"C24", "C25"}, 0},
+		{"offset past the end", "offset=40", http.StatusOK, nil, 0},
+		{"negative offset", "offset=-1", http.StatusBadRequest, nil, 0},
+		{"invalid limit", "limit=ten", http.StatusBadRequest, nil, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, page := preview(t, tc.query)
+			if code != tc.expectedCode {
+				t.Fatalf("Expected status %d, got %d", tc.expectedCode, code)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			var rows []string
+			for _, row := range page.Rows {
+				rows = append(rows, row[0])
+			}
+			if strings.Join(rows, ",") != strings.Join(tc.expectedRows, ",") || page.NextOffset != tc.expectedNext {
+				t.Errorf("Expected rows %v and nextOffset %d, got %v and %d", tc.expectedRows, tc.expectedNext, rows, page.NextOffset)
+			}
+		})
+	}
+
+	// PREVIEW_MAX_ROWS caps the limit a request asks for
+	originalMax := featureFlags.PreviewMaxRows
+	featureFlags.PreviewMaxRows = 3
+	defer func() { featureFlags.PreviewMaxRows = originalMax }()
+	if _, page := preview(t, "limit=50"); len(page.Rows) != 3 || page.NextOffset != 3 {
+		t.Errorf("Expected the limit capped to 3 rows, got %d rows and nextOffset %d", len(page.Rows), page.NextOffset)
+	}
+}
+
+// TestHandleAPISuggestMappings verifies headers are matched to fields ignoring case and spacing
+func TestHandleAPISuggestMappings(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	req := newAPIProcessRequest(t, "suggest.csv", "client code,CUSTOMER_ID,Region\nC1,1001,North\n", nil)
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPISuggestMappings).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response SuggestMappingsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Mappings["Client_Code"] != "client code" || response.Mappings["Customer_ID"] != "CUSTOMER_ID" {
+		t.Errorf("Unexpected mappings %v", response.Mappings)
+	}
+	if len(response.Suggestions) != len(currentFieldConfig().Fields) {
+		t.Errorf("Expected one suggestion per field, got %d", len(response.Suggestions))
+	}
+	if strings.Join(response.UnmappedColumns, "|") != "Region" {
+		t.Errorf("Expected Region unmapped, got %v", response.UnmappedColumns)
+	}
+	for _, name := range response.MissingMandatory {
+		if name == "Client_Code" || name == "Customer_ID" {
+			t.Errorf("Matched field %s reported as missing", name)
+		}
+	}
+}
+
+// TestDateRangeFilter verifies rows are kept only when their date falls within the window
+func TestDateRangeFilter(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Posted", "displayName": "Posted", "type": "date", "dateFormat": "02/01/2006"},
+            {"name": "Notes", "displayName": "Notes"}
+        ]
+    }`)
+
+	inputPath := filepath.Join(t.TempDir(), "dates.csv")
+	input := "Client Code,Posted,Notes\n" +
+		"C1,31/12/2023,before\n" +
+		"C2,01/01/2024,first day\n" +
+		"C3,2024-01-15,iso\n" +
+		"C4,31/01/2024,last day\n" +
+		"C5,01/02/2024,after\n" +
+		"C6,someday,junk\n" +
+		",20/01/2024,missing code\n"
+	if err := os.WriteFile(inputPath, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Posted": "Posted", "Notes": "Notes"}
+	order := currentFieldConfig().GetOrderedFields()
+	window := processOptions{
+		dateField: "Posted",
+		dateFrom:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		dateTo:    time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, reportExcluded := range []bool{false, true} {
+		t.Run(fmt.Sprintf("report=%v", reportExcluded), func(t *testing.T) {
+			uniqueID := "test_" + generateUniqueID()
+			defer removeOutputFiles(uniqueID, "csv")
+			opts := window
+			opts.dateReportExcluded = reportExcluded
+			summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", uniqueID, opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			content, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := "Client_Code|Posted|Notes\nC2|01/01/2024|first day\nC3|2024-01-15|iso\nC4|31/01/2024|last day\n"
+			if string(content) != expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", expected, content)
+			}
+
+			for _, want := range []string{
+				"Total Rows Processed: 4\n",
+				"Rows with Missing Data: 1\n",
+				"Date Filter: Posted from 2024-01-01 to 2024-01-31, 3 rows excluded\n",
+				"Row 7: Excluded - unparseable date \"someday\" in Posted\n",
+			} {
+				if !strings.Contains(summary, want) {
+					t.Errorf("Expected summary to contain %q, got:\n%s", want, summary)
+				}
+			}
+			if got := strings.Contains(summary, "Row 6: Excluded - date 2024-02-01 outside the requested range\n"); got != reportExcluded {
+				t.Errorf("Expected out-of-range rows listed=%v, got summary:\n%s", reportExcluded, summary)
+			}
+		})
+	}
+
+	t.Run("not a date field", func(t *testing.T) {
+		opts := window
+		opts.dateField = "Notes"
+		_, _, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID(), opts)
+		if !errors.Is(err, errInvalidDateFilter) {
+			t.Errorf("Expected errInvalidDateFilter, got %v", err)
+		}
+	})
+}
+
+// fullDiskWriter accepts a few bytes and then fails as a full disk would
+type fullDiskWriter struct {
+	*os.File
+	written int
+}
+
+func (w *fullDiskWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > 16 {
+		return 0, &os.PathError{Op: "write", Path: w.Name(), Err: syscall.ENOSPC}
+	}
+	w.written += len(p)
+	return w.File.Write(p)
+}
+
+// TestOutputDiskFull verifies a full disk while writing outputs returns 507 and removes partial files
+func TestOutputDiskFull(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	var created []string
+	originalCreate := createOutputFile
+	createOutputFile = func(path string) (io.WriteCloser, error) {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, path)
+		return &fullDiskWriter{File: file}, nil
+	}
+	defer func() { createOutputFile = originalCreate }()
+
+	for _, format := range []string{"csv", "xlsx", "markdown", "ndjson"} {
+		t.Run(format, func(t *testing.T) {
+			created = nil
+			req := newAPIProcessRequest(t, "full.csv", "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n", map[string]string{
+				"mappings":       `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+				"outputFormat":   format,
+				"summarySidecar": "true",
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusInsufficientStorage {
+				t.Fatalf("Expected status 507, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var response ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || !strings.HasPrefix(response.Error, "Insufficient storage") {
+				t.Errorf("Expected insufficient storage error, got %s", rr.Body.String())
+			}
+			if len(created) == 0 {
+				t.Fatal("Expected an output file to be attempted")
+			}
+			for _, path := range created {
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("Expected partial output %s to be removed", path)
+					os.Remove(path)
+				}
+			}
+		})
+	}
+
+	t.Run("other write error", func(t *testing.T) {
+		createOutputFile = func(path string) (io.WriteCloser, error) {
+			return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EACCES}
+		}
+		req := newAPIProcessRequest(t, "denied.csv", "Client Code,Customer ID,Account Number\nC1,1001,A1\n", map[string]string{
+			"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+			"outputFormat": "csv",
+		})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "Failed to write output file") {
+			t.Errorf("Expected 500 write failure, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+// TestFieldTransforms verifies configured transforms always apply and request transforms run after them
+func TestFieldTransforms(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true, "transforms": ["trim"]},
+            {"name": "Region", "displayName": "Region", "transforms": ["lower"]}
+        ]
+    }`)
+
+	inputPath := filepath.Join(t.TempDir(), "transforms.csv")
+	if err := os.WriteFile(inputPath, []byte("Client Code,Region\n  ab-1  ,North EAST\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Region": "Region"}
+	order := currentFieldConfig().GetOrderedFields()
+
+	testCases := []struct {
+		name       string
+		transforms string
+		expected   string
+	}{
+		{"config defaults", "", "ab-1|north east"},
+		{"request adds upper", `{"Client Code":["upper"]}`, "AB-1|north east"},
+		{"request replaces defaults", `{"Region":["none","upper"]}`, "ab-1|NORTH EAST"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transforms, err := parseFieldTransforms(tc.transforms)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			uniqueID := "test_" + generateUniqueID()
+			defer removeOutputFiles(uniqueID, "csv")
+			_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", uniqueID, processOptions{transforms: transforms})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			content, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			if len(lines) != 2 || lines[1] != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, lines)
+			}
+		})
+	}
+
+	if len(currentFieldConfig().Fields[0].Transforms) != 1 {
+		t.Errorf("Request transforms must not change the shared configuration, got %v", currentFieldConfig().Fields[0].Transforms)
+	}
+
+	for _, invalid := range []string{`{"Client_Code":["reverse"]}`, `{"Nope":["trim"]}`, `["trim"]`} {
+		if _, err := parseFieldTransforms(invalid); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+}
+
+// TestHandleAPIExplain verifies the per-field breakdown of a row that went to the missing data report
+func TestHandleAPIExplain(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true},
+            {"name": "Account_ID", "displayName": "Account ID", "isMandatory": true},
+            {"name": "Region", "displayName": "Region"},
+            {"name": "Notes", "displayName": "Notes"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	input := "Client Code,Customer ID,Region\nC1,1001,North\nC2,   ,\n"
+	mappings := `{"Client Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number","Region":"Region"}`
+
+	req := newAPIProcessRequest(t, "explain.csv", input, map[string]string{"mappings": mappings, "row": "3"})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIExplain).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response ExplainResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Row != 3 || response.Success {
+		t.Errorf("Expected failing row 3, got row %d success=%v", response.Row, response.Success)
+	}
+
+	expected := map[string]struct {
+		status  string
+		present bool
+		reason  string
+	}{
+		"Client_Code": {explainOK, true, "value present"},
+		"Customer_ID": {explainMissing, false, "mandatory field is empty in this row"},
+		"Account_ID":  {explainMissing, false, `mapped column "Account Number" is not in the file's headers`},
+		"Region":      {explainEmpty, false, "optional field is empty in this row"},
+		"Notes":       {explainSkipped, false, "optional field is not mapped"},
+	}
+	if len(response.Fields) != len(expected) {
+		t.Fatalf("Expected %d fields, got %d", len(expected), len(response.Fields))
+	}
+	for _, field := range response.Fields {
+		want := expected[field.Field]
+		if field.Status != want.status || field.Present != want.present || field.Reason != want.reason {
+			t.Errorf("Field %s: expected %+v, got %+v", field.Field, want, field)
+		}
+	}
+	if customer := response.Fields[1]; customer.RawValue != "   " || !customer.ColumnFound || customer.SourceColumn != "Customer ID" {
+		t.Errorf("Unexpected Customer_ID explanation %+v", customer)
+	}
+
+	for _, row := range []string{"1", "4", "x"} {
+		req := newAPIProcessRequest(t, "explain.csv", input, map[string]string{"mappings": mappings, "row": row})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIExplain).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Row %s: expected status 400, got %d: %s", row, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// gzipped compresses content for upload tests
+func gzipped(t *testing.T, content string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// TestHandleAPIProcessGzipInput verifies gzipped CSV and TSV uploads are decompressed transparently
+func TestHandleAPIProcessGzipInput(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+
+	testCases := []struct {
+		filename string
+		content  string
+	}{
+		{"input.csv.gz", gzipped(t, "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,1002,A2\n")},
+		{"input.tsv.gz", gzipped(t, "Client Code\tCustomer ID\tAccount Number\nC1\t1001\tA1\nC2\t1002\tA2\n")},
+		// Gzip content is recognised by its magic bytes even without a .gz extension
+		{"input.csv", gzipped(t, "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,1002,A2\n")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.filename, func(t *testing.T) {
+			req := newAPIProcessRequest(t, tc.filename, tc.content, map[string]string{
+				"mappings":            mappings,
+				"outputFormat":        "csv",
+				"outputMandatoryOnly": "true",
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			expected := "Client_Code|Customer_ID|Account_ID\nC1|1001|A1\nC2|1002|A2\n"
+			if rr.Body.String() != expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", expected, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("decompression bomb", func(t *testing.T) {
+		original := featureFlags.MaxInputBytes
+		featureFlags.MaxInputBytes = 1024
+		defer func() { featureFlags.MaxInputBytes = original }()
+
+		content := "Client Code,Customer ID,Account Number\n" + strings.Repeat("C1,1001,A1\n", 1000)
+		req := newAPIProcessRequest(t, "bomb.csv.gz", gzipped(t, content), map[string]string{"mappings": mappings, "outputFormat": "csv"})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413 for oversized decompressed input, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+// TestHandleAPIProcessRawBody verifies a file sent as the raw request body is processed,
+// with the mappings taken from the X-Mappings header or the mappings query parameter
+func TestHandleAPIProcessRawBody(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+	content := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,1002,A2\n"
+	expected := "Client_Code|Customer_ID|Account_ID\nC1|1001|A1\nC2|1002|A2\n"
+
+	testCases := []struct {
+		name        string
+		contentType string
+		query       url.Values
+		header      string
+		body        string
+	}{
+		{"mappings header", "text/csv", url.Values{}, mappings, content},
+		{"mappings query", "text/csv; charset=utf-8", url.Values{"mappings": {mappings}}, "", content},
+		{"gzip body", "application/gzip", url.Values{}, mappings, gzipped(t, content)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.query.Set("outputFormat", "csv")
+			tc.query.Set("outputMandatoryOnly", "true")
+			req := httptest.NewRequest("POST", "/api/v1/process?"+tc.query.Encode(), strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("X-API-Key", "test-api-key-1")
+			if tc.header != "" {
+				req.Header.Set("X-Mappings", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if rr.Body.String() != expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", expected, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("missing mappings", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/process", strings.NewReader(content))
+		req.Header.Set("Content-Type", "text/csv")
+		req.Header.Set("X-API-Key", "test-api-key-1")
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without mappings, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+// TestHandleAPIProcessStreamsTextOutput verifies text outputs are streamed to the response
+// without leaving the returned output on disk, while idempotent requests keep the file
+func TestHandleAPIProcessStreamsTextOutput(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	content := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n"
+	fields := map[string]string{
+		"mappings":            `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat":        "csv",
+		"outputMandatoryOnly": "true",
+	}
+
+	req := newAPIProcessRequest(t, "stream.csv", content, fields)
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if expected := "Client_Code|Customer_ID|Account_ID\nC1|1001|A1\n"; rr.Body.String() != expected {
+		t.Errorf("Expected streamed output:\n%s\ngot:\n%s", expected, rr.Body.String())
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/csv; charset=utf-8" {
+		t.Errorf("Expected text/csv content type, got %s", contentType)
+	}
+	if summary := rr.Header().Get("X-Processing-Summary"); !strings.Contains(summary, "Successful Rows: 1") {
+		t.Errorf("Expected the summary header to be set before the body, got %q", summary)
+	}
+
+	_, params, err := mime.ParseMediaType(rr.Header().Get("Content-Disposition"))
+	if err != nil || !strings.HasSuffix(params["filename"], "_processed_data.csv") {
+		t.Fatalf("Expected a processed data filename, got %q", rr.Header().Get("Content-Disposition"))
+	}
+	outputPath := filepath.Join("./uploads", params["filename"])
+	missingPath := strings.Replace(outputPath, "_processed_data", "_missing_data", 1)
+	defer os.Remove(missingPath)
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		os.Remove(outputPath)
+		t.Errorf("Expected no output file to remain for a streamed response, stat gave %v", err)
+	}
+	if _, err := os.Stat(missingPath); err != nil {
+		t.Errorf("Expected the missing data file to still be written: %v", err)
+	}
+
+	// A retried request must be replayable, so its output is still stored
+	req = newAPIProcessRequest(t, "stream.csv", content, fields)
+	req.Header.Set("Idempotency-Key", "stream-test")
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	_, params, _ = mime.ParseMediaType(rr.Header().Get("Content-Disposition"))
+	storedPath := filepath.Join("./uploads", params["filename"])
+	defer os.Remove(storedPath)
+	defer os.Remove(strings.Replace(storedPath, "_processed_data", "_missing_data", 1))
+	if _, err := os.Stat(storedPath); err != nil {
+		t.Errorf("Expected an idempotent request to keep its output file: %v", err)
+	}
+}
+
+// TestCoalesceMapping verifies a coalesce mapping takes the first non-empty candidate column
+// and a mandatory coalesce field is missing only when every candidate is empty
+func TestCoalesceMapping(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	content := "Client Code,Mobile,Home,Work,Account Number\n" +
+		"C1,,H1,W1,A1\n" +
+		"C2,M2,H2,,A2\n" +
+		"C3,  ,,W3,A3\n" +
+		"C4,,,,A4\n"
+	req := newAPIProcessRequest(t, "coalesce.csv", content, map[string]string{
+		"mappings":            `{"Client_Code":"Client Code","Customer_ID":{"coalesce":["Mobile","Home","Work"]},"Account_ID":"Account Number"}`,
+		"outputFormat":        "csv",
+		"outputMandatoryOnly": "true",
+		"summarySidecar":      "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	expected := "Client_Code|Customer_ID|Account_ID\nC1|H1|A1\nC2|M2|A2\nC3|W3|A3\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, rr.Body.String())
+	}
+	if summary := sidecarSummary(t, rr); !strings.Contains(summary, "Row 5: Missing mandatory fields - Customer_ID") {
+		t.Errorf("Expected row 5 to be missing Customer_ID, got summary %q", summary)
+	}
+
+	for _, mappings := range []string{
+		`{"Customer_ID":{"coalesce":[]}}`,
+		`{"Customer_ID":{"first":["Mobile"]}}`,
+		`{"Customer_ID":["Mobile","Home"]}`,
+	} {
+		req := newAPIProcessRequest(t, "coalesce.csv", content, map[string]string{"mappings": mappings, "outputFormat": "csv"})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for mappings %s, got %d: %s", mappings, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// TestJSONPathMapping verifies fields can be extracted from JSON held in a cell, with
+// malformed JSON and missing paths treated as empty values
+func TestJSONPathMapping(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	content := "Client Code,metadata,Account Number\n" +
+		`C1,"{""ids"":[1001,1002],""address"":{""city"":""Leeds""}}",A1` + "\n" +
+		`C2,"{""ids"":[""2001""]}",A2` + "\n" +
+		`C3,"{""ids"":[3001",A3` + "\n"
+	req := newAPIProcessRequest(t, "json.csv", content, map[string]string{
+		"mappings": `{"Client_Code":"Client Code",` +
+			`"Customer_ID":{"column":"metadata","path":"$.ids[0]"},` +
+			`"Customer_Name":{"column":"Metadata","path":"$['address'].city"},` +
+			`"Account_ID":"Account Number"}`,
+		"outputFormat":   "csv",
+		"summarySidecar": "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	expected := "Client_Code|LE_ID|Customer_ID|Customer_Name|Customer_Active|Account_ID|Account_Name|Account_Active\n" +
+		"C1||1001|Leeds||A1||\n" +
+		"C2||2001|||A2||\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, rr.Body.String())
+	}
+	if summary := sidecarSummary(t, rr); !strings.Contains(summary, "Row 4: Missing mandatory fields - Customer_ID") {
+		t.Errorf("Expected malformed JSON to leave Customer_ID missing, got summary %q", summary)
+	}
+
+	req = newAPIProcessRequest(t, "json.csv", content, map[string]string{
+		"mappings":     `{"Customer_ID":{"column":"metadata","path":"ids[0]"}}`,
+		"outputFormat": "csv",
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a JSON path without $, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The path is parsed once with the mappings rather than for every cell
+	mappings, err := parseFieldMappings(`{"Customer_Name":{"column":"metadata","path":"$['address'].ids[1]"}}`)
+	if err != nil || !slices.Equal(mappings["Customer_Name"][0].steps, []any{"address", "ids", 1}) {
+		t.Errorf("Expected the path steps to be parsed with the mapping, got %+v (%v)", mappings["Customer_Name"], err)
+	}
+}
+
+// TestArtifactManifest verifies the manifest lists the processed and missing files with
+// their formats, content types, sizes and download URLs
+func TestArtifactManifest(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	content := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\nC3,1003,A3\n"
+	req := newAPIProcessRequest(t, "manifest.csv", content, map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat": "csv",
+		"manifest":     "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	manifestFile := rr.Header().Get("X-Manifest-File")
+	if !strings.HasSuffix(manifestFile, "_manifest.json") {
+		t.Fatalf("Expected X-Manifest-File to name the manifest, got %q", manifestFile)
+	}
+	data, err := os.ReadFile(filepath.Join("./uploads", manifestFile))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Invalid manifest JSON: %v\n%s", err, data)
+	}
+	t.Cleanup(func() {
+		os.Remove(filepath.Join("./uploads", manifestFile))
+		for _, artifact := range manifest.Artifacts {
+			os.Remove(filepath.Join("./uploads", artifact.Filename))
+		}
+	})
+
+	if len(manifest.Artifacts) != 2 {
+		t.Fatalf("Expected the processed and missing files in the manifest, got %+v", manifest.Artifacts)
+	}
+	for i, kind := range []string{"output", "missing"} {
+		artifact := manifest.Artifacts[i]
+		if artifact.Kind != kind || artifact.Format != "csv" || artifact.ContentType != "text/csv; charset=utf-8" {
+			t.Errorf("Unexpected %s artifact: %+v", kind, artifact)
+		}
+		if artifact.URL != "/download?file="+artifact.Filename {
+			t.Errorf("Expected a download URL for %s, got %q", artifact.Filename, artifact.URL)
+		}
+		info, err := os.Stat(filepath.Join("./uploads", artifact.Filename))
+		if err != nil {
+			t.Fatalf("Manifest lists %s which does not exist: %v", artifact.Filename, err)
+		}
+		if artifact.Size != info.Size() {
+			t.Errorf("Expected size %d for %s, manifest says %d", info.Size(), artifact.Filename, artifact.Size)
+		}
+	}
+	if size := int64(rr.Body.Len()); manifest.Artifacts[0].Size != size {
+		t.Errorf("Expected the output size %d to match the response body, got %d", size, manifest.Artifacts[0].Size)
+	}
+	if !strings.Contains(manifest.Artifacts[1].Filename, "_missing_data.csv") || manifest.Artifacts[1].Size == 0 {
+		t.Errorf("Expected a non-empty missing data file, got %+v", manifest.Artifacts[1])
+	}
+}
+
+// TestAccentInsensitiveHeaders verifies accented headers match unaccented mappings and
+// suggestions, and that ACCENT_INSENSITIVE_HEADERS=false restores exact matching
+func TestAccentInsensitiveHeaders(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	if !featureFlags.AccentInsensitiveHeaders {
+		t.Fatal("Expected accent-insensitive header matching to be on by default")
+	}
+
+	content := "Clíent Códe,Número,Açcount Number\nC1,1001,A1\n"
+	fields := map[string]string{
+		"mappings":            `{"Client_Code":"Client Code","Customer_ID":"Numero","Account_ID":"Account Number"}`,
+		"outputFormat":        "csv",
+		"outputMandatoryOnly": "true",
+	}
+	req := newAPIProcessRequest(t, "accents.csv", content, fields)
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if expected := "Client_Code|Customer_ID|Account_ID\nC1|1001|A1\n"; rr.Body.String() != expected {
+		t.Errorf("Expected accented headers to match:\n%s\ngot (%d):\n%s", expected, rr.Code, rr.Body.String())
+	}
+
+	suggestions := suggestMappings([]string{"Clíent Códe", "Açcount ÍD"}, currentFieldConfig())
+	if suggestions.Mappings["Client_Code"] != "Clíent Códe" || suggestions.Mappings["Account_ID"] != "Açcount ÍD" {
+		t.Errorf("Expected accented headers to be suggested, got %v", suggestions.Mappings)
+	}
+
+	featureFlags.AccentInsensitiveHeaders = false
+	defer func() { featureFlags.AccentInsensitiveHeaders = true }()
+	req = newAPIProcessRequest(t, "accents.csv", content, fields)
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if !strings.Contains(rr.Header().Get("X-Processing-Summary"), "Rows with Missing Data: 1") {
+		t.Errorf("Expected exact matching to leave the row missing, got %d: %s", rr.Code, rr.Header().Get("X-Processing-Summary"))
+	}
+}
+
+// TestSanitizeUploadFilename verifies client filenames are reduced to safe stored names
+func TestSanitizeUploadFilename(t *testing.T) {
+	original := featureFlags.MaxUploadFilenameLength
+	featureFlags.MaxUploadFilenameLength = 20
+	defer func() { featureFlags.MaxUploadFilenameLength = original }()
+
+	testCases := []struct {
+		filename string
+		expected string
+	}{
+		{"report.csv", "report.csv"},
+		{"../../etc/passwd", "passwd"},
+		{`..\..\windows\win.ini.csv`, "win.ini.csv"},
+		{"..csv", "upload.csv"},
+		{"my report (final).xlsx", "my_report_final.xlsx"},
+		{"données.csv.gz", "donn_es.csv.gz"},
+		{"a_very_long_name_for_an_upload.tsv.gz", "a_very_long_n.tsv.gz"},
+		{"", "upload"},
+	}
+	for _, tc := range testCases {
+		if got := sanitizeUploadFilename(tc.filename); got != tc.expected {
+			t.Errorf("sanitizeUploadFilename(%q) = %q, expected %q", tc.filename, got, tc.expected)
+		}
+	}
+}
+
+// TestUploadStorageIsSafe verifies uploads with hostile or duplicate names are stored
+// inside ./uploads without overwriting each other
+func TestUploadStorageIsSafe(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	content := "Client Code,Customer ID,Account Number\nC1,1001,A1\n"
+
+	upload := func(filename string) (string, int) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreatePart(map[string][]string{
+			"Content-Disposition": {fmt.Sprintf(`form-data; name="file"; filename="%s"`, filename)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/preview", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		path, ok := receiveUpload(rr, req)
+		if ok {
+			t.Cleanup(func() { os.Remove(path) })
+		}
+		return path, rr.Code
+	}
+
+	if _, code := upload("../../etc/passwd"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for ../../etc/passwd, got %d", code)
+	}
+
+	absUploads, _ := filepath.Abs("./uploads")
+	var paths []string
+	for _, filename := range []string{`..\..\etc\passwd.csv`, "../../etc/passwd.csv", "../../etc/passwd.csv"} {
+		path, code := upload(filename)
+		if code != http.StatusOK {
+			t.Fatalf("Expected %q to be stored, got %d", filename, code)
+		}
+		absPath, _ := filepath.Abs(path)
+		if filepath.Dir(absPath) != absUploads {
+			t.Errorf("Expected %q to be stored in ./uploads, got %s", filename, path)
+		}
+		if !strings.HasSuffix(path, "_passwd.csv") {
+			t.Errorf("Expected the stored name of %q to end in _passwd.csv, got %s", filename, path)
+		}
+		paths = append(paths, path)
+	}
+	if paths[1] == paths[2] {
+		t.Errorf("Expected uploads with the same name to be stored separately, both went to %s", paths[1])
+	}
+
+	// A path that already exists is never reused
+	if file, _, err := createUploadFile("fixed", "passwd.csv"); err == nil {
+		file.Close()
+		defer os.Remove(file.Name())
+		if _, _, err := createUploadFile("fixed", "passwd.csv"); err == nil {
+			t.Errorf("Expected creating an existing upload path to fail")
+		}
+	} else {
+		t.Fatal(err)
+	}
+}
+
+// TestInputByteBudget verifies inputs over MAX_INPUT_BYTES are rejected with 413 and removed
+func TestInputByteBudget(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	original := featureFlags.MaxInputBytes
+	featureFlags.MaxInputBytes = 2048
+	defer func() { featureFlags.MaxInputBytes = original }()
+
+	rows := "Client Code,Customer ID,Account Number\n" + strings.Repeat("C1,1001,A1\n", 500)
+	f := excelize.NewFile()
+	for i := 1; i <= 500; i++ {
+		f.SetSheetRow("Sheet1", fmt.Sprintf("A%d", i), &[]interface{}{"C1", 1001, "A1"})
+	}
+	var xlsx bytes.Buffer
+	if err := f.Write(&xlsx); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		filename string
+		content  string
+	}{
+		{"budget.csv", rows},
+		{"budget.csv.gz", gzipped(t, rows)},
+		{"budget.xlsx", xlsx.String()},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.filename, func(t *testing.T) {
+			filename := generateUniqueID() + "_" + tc.filename
+			req := newAPIProcessRequest(t, filename, tc.content, map[string]string{
+				"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+				"outputFormat": "csv",
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("Expected status 413, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if !strings.Contains(rr.Body.String(), "2048 byte processing limit") {
+				t.Errorf("Expected the limit in the error, got %s", rr.Body.String())
+			}
+			if leftover, _ := filepath.Glob(filepath.Join("uploads", "*_"+filename)); len(leftover) > 0 {
+				t.Errorf("Expected the rejected upload to be removed, found %v", leftover)
+			}
+		})
+	}
+
+	// A part declaring the largest size after a small one must not wrap the total around,
+	// whether it is charged for an uploaded zip or an XLSX
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	small, _ := zw.Create("small.csv")
+	small.Write([]byte("Client Code\nC1\n"))
+	huge, err := zw.CreateRaw(&zip.FileHeader{Name: "huge.csv", Method: zip.Store, UncompressedSize64: math.MaxInt64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	huge.Write([]byte("Client Code\n"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zipInputs(reader); !errors.Is(err, errInputTooLarge) {
+		t.Errorf("Expected a zip declaring a huge entry to exceed the budget, got %v", err)
+	}
+	archivePath := writeTempCSV(t, archive.String())
+	if err := (&byteBudget{limit: featureFlags.MaxInputBytes}).chargeArchive(archivePath); !errors.Is(err, errInputTooLarge) {
+		t.Errorf("Expected an archive declaring a huge part to exceed the budget, got %v", err)
+	}
+
+	t.Setenv("MAX_INPUT_BYTES", "0")
+	if _, err := config.LoadFeatureFlags(); err == nil {
+		t.Error("Expected a non-positive MAX_INPUT_BYTES to be rejected")
+	}
+}
+
+// TestFieldOrderAttribute verifies explicit order attributes sort fields ahead of implicitly ordered ones
+func TestFieldOrderAttribute(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fields   string
+		expected string
+	}{
+		{"no order attributes", `
+            {"name": "A", "displayName": "A"},
+            {"name": "B", "displayName": "B"},
+            {"name": "C", "displayName": "C"}`, "A|B|C"},
+		{"all explicit", `
+            {"name": "A", "displayName": "A", "order": 3},
+            {"name": "B", "displayName": "B", "order": 1},
+            {"name": "C", "displayName": "C", "order": 2}`, "B|C|A"},
+		{"mixed explicit and implicit", `
+            {"name": "A", "displayName": "A"},
+            {"name": "B", "displayName": "B", "order": 2},
+            {"name": "C", "displayName": "C"},
+            {"name": "D", "displayName": "D", "order": 1}`, "D|B|A|C"},
+		{"ties keep array order", `
+            {"name": "A", "displayName": "A", "order": 5},
+            {"name": "B", "displayName": "B", "order": 1},
+            {"name": "C", "displayName": "C", "order": 5}`, "B|A|C"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var fc config.FieldConfig
+			if err := json.Unmarshal([]byte(`{"fields": [`+tc.fields+`]}`), &fc); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(fc.GetOrderedFields(), "|"); got != tc.expected {
+				t.Errorf("Expected order %s, got %s", tc.expected, got)
+			}
+		})
+	}
+
+	// Reordering through the API replaces the order attributes with the new array order
+	var fc config.FieldConfig
+	if err := json.Unmarshal([]byte(`{"fields": [{"name": "A", "displayName": "A", "order": 2}, {"name": "B", "displayName": "B", "order": 1}]}`), &fc); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.ReorderFields([]string{"A", "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(fc.GetOrderedFields(), "|"); got != "A|B" {
+		t.Errorf("Expected reorder to take effect, got %s", got)
+	}
+}
+
+// TestHandleAPIProcessExpectedHeaders verifies files are rejected unless their headers match expectedHeaders
+func TestHandleAPIProcessExpectedHeaders(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	input := "Client Code,Customer ID,Account Number\nC1,1001,A1\n"
+	testCases := []struct {
+		name     string
+		expected string
+		ordered  string
+		status   int
+		message  string
+	}{
+		{"matching in any order", `["account number","Client Code","Customer ID"]`, "", http.StatusOK, ""},
+		{"matching in order", "Client Code, Customer ID, Account Number", "true", http.StatusOK, ""},
+		{"missing column", `["Client Code","Customer ID","Account Number","Region"]`, "", http.StatusBadRequest, "File headers do not match the expected headers: missing columns: region"},
+		{"extra column", `["Client Code","Customer ID"]`, "", http.StatusBadRequest, "File headers do not match the expected headers: extra columns: account number"},
+		{"missing and extra", `["Client Code","Customer ID","Account ID"]`, "", http.StatusBadRequest, "missing columns: account id; extra columns: account number"},
+		{"out of order", `["Customer ID","Client Code","Account Number"]`, "true", http.StatusBadRequest, "columns are out of order"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newAPIProcessRequest(t, "schema.csv", input, map[string]string{
+				"mappings":               `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+				"outputFormat":           "csv",
+				"expectedHeaders":        tc.expected,
+				"expectedHeadersOrdered": tc.ordered,
+			})
+			rr := httptest.NewRecorder()
+			auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+			if rr.Code != tc.status {
+				t.Fatalf("Expected status %d, got %d: %s", tc.status, rr.Code, rr.Body.String())
+			}
+			if tc.message != "" && !strings.Contains(rr.Body.String(), tc.message) {
+				t.Errorf("Expected error containing %q, got %s", tc.message, rr.Body.String())
+			}
+		})
+	}
+}
+
+// sqlValue is one value parsed from an INSERT statement
+type sqlValue struct {
+	text   string
+	quoted bool
+}
+
+// parseSQLInserts parses the INSERT statements written by encodeSQLInserts, failing the
+// test on any syntax error. It returns the table and columns of each statement and all rows.
+func parseSQLInserts(t *testing.T, sql string) (tables []string, columns [][]string, rows [][]sqlValue) {
+	t.Helper()
+	pos := 0
+	expect := func(literal string) {
+		t.Helper()
+		if !strings.HasPrefix(sql[pos:], literal) {
+			t.Fatalf("Expected %q at offset %d, got %q", literal, pos, sql[pos:min(len(sql), pos+20)])
+		}
+		pos += len(literal)
+	}
+	// quoted reads a literal delimited by quote, where a doubled quote is an escaped one
+	quoted := func(quote byte) string {
+		t.Helper()
+		expect(string(quote))
+		var sb strings.Builder
+		for {
+			if pos >= len(sql) {
+				t.Fatalf("Unterminated %c-quoted literal", quote)
+			}
+			if sql[pos] == quote {
+				if pos+1 < len(sql) && sql[pos+1] == quote {
+					sb.WriteByte(quote)
+					pos += 2
+					continue
+				}
+				pos++
+				return sb.String()
+			}
+			sb.WriteByte(sql[pos])
+			pos++
+		}
+	}
+
+	for pos < len(sql) {
+		expect("INSERT INTO ")
+		table := quoted('"')
+		for strings.HasPrefix(sql[pos:], ".") {
+			pos++
+			table += "." + quoted('"')
+		}
+		tables = append(tables, table)
+		expect(" (")
+		var statementColumns []string
+		for {
+			statementColumns = append(statementColumns, quoted('"'))
+			if strings.HasPrefix(sql[pos:], ", ") {
+				pos += 2
+				continue
+			}
+			break
+		}
+		columns = append(columns, statementColumns)
+		expect(") VALUES\n")
+		for {
+			expect("  (")
+			var row []sqlValue
+			for {
+				if sql[pos] == '\'' {
+					row = append(row, sqlValue{text: quoted('\''), quoted: true})
+				} else {
+					end := strings.IndexAny(sql[pos:], ",)")
+					if end <= 0 {
+						t.Fatalf("Expected a value at offset %d", pos)
+					}
+					row = append(row, sqlValue{text: sql[pos : pos+end]})
+					pos += end
+				}
+				if strings.HasPrefix(sql[pos:], ", ") {
+					pos += 2
+					continue
+				}
+				break
+			}
+			if len(row) != len(statementColumns) {
+				t.Fatalf("Row has %d values for %d columns", len(row), len(statementColumns))
+			}
+			rows = append(rows, row)
+			expect(")")
+			if strings.HasPrefix(sql[pos:], ",\n") {
+				pos += 2
+				continue
+			}
+			break
+		}
+		expect(";\n")
+	}
+	return tables, columns, rows
+}
+
+// TestHandleAPIProcessSQL verifies sql output is valid batched INSERT syntax with escaped values
+func TestHandleAPIProcessSQL(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Amount", "displayName": "Amount", "type": "number"},
+            {"name": "Notes", "displayName": "Notes"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	input := "Client Code,Amount,Notes\nC1,12.5,\"O'Brien's \"\"order\"\"\"\nC2,n/a,plain\nC3,7,\n"
+	req := newAPIProcessRequest(t, "sql.csv", input, map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Amount":"Amount","Notes":"Notes"}`,
+		"outputFormat": "sql",
+		"sqlTable":     "staging.orders",
+		"sqlBatchSize": "2",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/sql" {
+		t.Errorf("Expected Content-Type application/sql, got %q", ct)
+	}
+
+	tables, columns, rows := parseSQLInserts(t, rr.Body.String())
+	if len(tables) != 2 || tables[0] != "staging.orders" {
+		t.Fatalf("Expected two batched statements into staging.orders, got %v", tables)
+	}
+	if strings.Join(columns[0], "|") != "Client_Code|Amount|Notes" {
+		t.Errorf("Unexpected columns %v", columns[0])
+	}
+	expected := [][]sqlValue{
+		{{"C1", true}, {"12.5", false}, {`O'Brien's "order"`, true}},
+		{{"C2", true}, {"n/a", true}, {"plain", true}},
+		{{"C3", true}, {"7", false}, {"", true}},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d", len(expected), len(rows))
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			if rows[i][j] != expected[i][j] {
+				t.Errorf("Row %d value %d: expected %+v, got %+v", i, j, expected[i][j], rows[i][j])
+			}
+		}
+	}
+	if !strings.Contains(rr.Body.String(), `'O''Brien''s "order"'`) {
+		t.Errorf("Expected single quotes to be doubled, got:\n%s", rr.Body.String())
+	}
+
+	req = newAPIProcessRequest(t, "sql.csv", input, map[string]string{
+		"mappings":     `{"Client_Code":"Client Code"}`,
+		"outputFormat": "sql",
+		"sqlTable":     "orders; DROP TABLE users",
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid table name to be rejected, got %d", rr.Code)
+	}
+}
+
+// TestSQLIdentifiers verifies snake sqlIdentifiers turns headers into valid bare identifiers,
+// suffixing collisions, and reports the renames
+func TestSQLIdentifiers(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client Code", "displayName": "Client", "isMandatory": true},
+            {"name": "Client-Code", "displayName": "Alternate Client"},
+            {"name": "Order", "displayName": "Order Reference"},
+            {"name": "2nd Amount", "displayName": "Second Amount", "type": "number"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	req := newAPIProcessRequest(t, "sql.csv", "Code,Alt,Order,Amount\nC1,X,O1,5\n", map[string]string{
+		"mappings":       `{"Client Code":"Code","Client-Code":"Alt","Order":"Order","2nd Amount":"Amount"}`,
+		"outputFormat":   "sql",
+		"sqlIdentifiers": "snake",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `INSERT INTO "processed_data" (client_code, client_code_2, order_, _2nd_amount) VALUES`+"\n  ('C1', 'X', 'O1', 5);") {
+		t.Errorf("Expected snake case columns, got:\n%s", body)
+	}
+	if !strings.HasPrefix(body, "-- Column \"Client Code\": client_code\n-- Column \"Client-Code\": client_code_2\n") {
+		t.Errorf("Expected comments mapping headers to columns, got:\n%s", body)
+	}
+	if summary := rr.Header().Get("X-Processing-Summary"); !strings.Contains(summary, "SQL Columns Renamed: 4") {
+		t.Errorf("Expected the renames in the summary, got %q", summary)
+	}
+	if got := describeSQLColumns([]string{"Client_Code", "Client Code"}, sqlColumnNames([]string{"Client_Code", "Client Code"}, sqlIdentifiersSnake, sqlDialectANSI)); got != "SQL Columns Renamed: 2 (Client_Code -> client_code, Client Code -> client_code_2)\n" {
+		t.Errorf("Unexpected rename summary %q", got)
+	}
+
+	req = newAPIProcessRequest(t, "sql.csv", "Code\nC1\n", map[string]string{
+		"mappings":       `{"Client Code":"Code"}`,
+		"outputFormat":   "sql",
+		"sqlIdentifiers": "camel",
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown sqlIdentifiers style to be rejected, got %d", rr.Code)
+	}
+}
+
+// TestSQLDialect verifies backslashes are kept in ANSI string literals and escaped, with
+// backtick-quoted identifiers, for MySQL
+func TestSQLDialect(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client Code", "displayName": "Client", "isMandatory": true}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	send := func(dialect string) *httptest.ResponseRecorder {
+		req := newAPIProcessRequest(t, "sql.csv", "Code\nO\\'Brien\n", map[string]string{
+			"mappings":     `{"Client Code":"Code"}`,
+			"outputFormat": "sql",
+			"sqlDialect":   dialect,
+		})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		return rr
+	}
+
+	for dialect, expected := range map[string]string{
+		"":      `INSERT INTO "processed_data" ("Client Code") VALUES` + "\n  ('O\\''Brien');",
+		"ansi":  `INSERT INTO "processed_data" ("Client Code") VALUES` + "\n  ('O\\''Brien');",
+		"mysql": "INSERT INTO `processed_data` (`Client Code`) VALUES\n  ('O\\\\''Brien');",
+	} {
+		rr := send(dialect)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for dialect %q, got %d: %s", dialect, rr.Code, rr.Body.String())
+		}
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected %s for dialect %q, got:\n%s", expected, dialect, rr.Body.String())
+		}
+	}
+
+	if rr := send("oracle"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown sqlDialect to be rejected, got %d", rr.Code)
+	}
+}
+
+// TestEmptyAsNull verifies empty values become JSON null and SQL NULL when emptyAsNull is set
+func TestEmptyAsNull(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Amount", "displayName": "Amount", "type": "number"},
+            {"name": "Notes", "displayName": "Notes"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	input := "Client Code,Amount,Notes\nC1,,\nC2,5,note\n"
+	send := func(format string) string {
+		t.Helper()
+		req := newAPIProcessRequest(t, "null.csv", input, map[string]string{
+			"mappings":     `{"Client_Code":"Client Code","Amount":"Amount","Notes":"Notes"}`,
+			"outputFormat": format,
+			"emptyAsNull":  "true",
+		})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		return rr.Body.String()
+	}
+
+	t.Run("ndjson", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(send("ndjson")), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Expected 2 lines, got %q", lines)
+		}
+		var first map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+			t.Fatalf("Invalid JSON %q: %v", lines[0], err)
+		}
+		if value, ok := first["Amount"]; !ok || value != nil {
+			t.Errorf("Expected Amount to be null, got %q", lines[0])
+		}
+		if lines[0] != `{"Client_Code":"C1","Amount":null,"Notes":null}` || lines[1] != `{"Client_Code":"C2","Amount":"5","Notes":"note"}` {
+			t.Errorf("Unexpected output %q", lines)
+		}
+	})
+
+	t.Run("sql", func(t *testing.T) {
+		output := send("sql")
+		_, _, rows := parseSQLInserts(t, output)
+		if len(rows) != 2 {
+			t.Fatalf("Expected 2 rows, got %d", len(rows))
+		}
+		if rows[0][1] != (sqlValue{"NULL", false}) || rows[0][2] != (sqlValue{"NULL", false}) || rows[1][1] != (sqlValue{"5", false}) {
+			t.Errorf("Expected empty values as NULL, got:\n%s", output)
+		}
+	})
+}
+
+// TestDedupeReport verifies duplicates are dropped and the report links each to the row it repeats
+func TestDedupeReport(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	input := "Client Code,Customer ID,Account Number\n" +
+		"C1,1001,A1\n" + // row 2, kept
+		"C2,1002,A2\n" + // row 3, kept
+		"C1,1001,A9\n" + // row 4, duplicate of row 2
+		"C3,,A3\n" + // row 5, missing data, never deduplicated
+		"C2,1002,A2\n" + // row 6, duplicate of row 3
+		"C1,1001,A1\n" // row 7, duplicate of row 2
+	req := newAPIProcessRequest(t, "dedupe.csv", input, map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat": "csv",
+		"dedupeBy":     "Client Code, Customer_ID",
+		"dedupeReport": "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "C1||1001|||A1") || !strings.HasPrefix(lines[2], "C2||1002|||A2") {
+		t.Errorf("Expected only the first occurrences, got %q", lines)
+	}
+	summary := rr.Header().Get("X-Processing-Summary")
+	if !strings.Contains(summary, "Duplicates Removed: 3") || !strings.Contains(summary, "Successful Rows: 2") {
+		t.Errorf("Unexpected summary:\n%s", summary)
+	}
+
+	reportFile := rr.Header().Get("X-Duplicates-File")
+	if reportFile == "" {
+		t.Fatal("Expected X-Duplicates-File header")
+	}
+	reportPath := filepath.Join("uploads", reportFile)
+	defer os.Remove(reportPath)
+	report, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Row|Key|Duplicate Of Row\n4|C1, 1001|2\n6|C2, 1002|3\n7|C1, 1001|2\n"
+	if string(report) != expected {
+		t.Errorf("Expected report:\n%s\ngot:\n%s", expected, report)
+	}
+
+	// With outputMandatoryOnly the key is found in the narrower rows
+	req = newAPIProcessRequest(t, "dedupe.csv", input, map[string]string{
+		"mappings":            `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat":        "csv",
+		"dedupeBy":            "Account_ID",
+		"outputMandatoryOnly": "true",
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if body := rr.Body.String(); body != "Client_Code|Customer_ID|Account_ID\nC1|1001|A1\nC2|1002|A2\nC1|1001|A9\n" {
+		t.Errorf("Expected duplicates by Account_ID removed, got:\n%s", body)
+	}
+
+	for _, fields := range []map[string]string{
+		{"dedupeBy": "Nope"},
+		{"dedupeReport": "true"},
+		// LE_ID is optional, so outputMandatoryOnly leaves it out of the output
+		{"dedupeBy": "LE_ID", "outputMandatoryOnly": "true"},
+	} {
+		fields["mappings"] = `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+		req := newAPIProcessRequest(t, "dedupe.csv", input, fields)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected %v to be rejected, got %d", fields, rr.Code)
+		}
+	}
+}
+
+func TestRetainInput(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	content := "Client Code,Customer ID,Account Number\nC1,1001,A1\n"
+	process := func(retain string) *httptest.ResponseRecorder {
+		fields := map[string]string{
+			"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+			"outputFormat": "csv",
+		}
+		if retain != "" {
+			fields["retainInput"] = retain
+		}
+		req := newAPIProcessRequest(t, "retained.csv", content, fields)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+	uploads := func() map[string]bool {
+		names := make(map[string]bool)
+		entries, _ := os.ReadDir("./uploads")
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), "_retained.csv") {
+				names[entry.Name()] = true
+			}
+		}
+		return names
+	}
+
+	before := uploads()
+	rr := process("true")
+	inputFile := rr.Header().Get("X-Input-File")
+	if !strings.HasSuffix(inputFile, "_retained.csv") {
+		t.Fatalf("Expected X-Input-File to name the upload, got %q", inputFile)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join("./uploads", inputFile)) })
+
+	download := httptest.NewRecorder()
+	handleDownload(download, httptest.NewRequest("GET", "/download?file="+inputFile, nil))
+	if download.Code != http.StatusOK || download.Body.String() != content {
+		t.Errorf("Expected the retained upload to be downloadable unchanged, got %d: %q", download.Code, download.Body.String())
+	}
+
+	rr = process("")
+	if got := rr.Header().Get("X-Input-File"); got != "" {
+		t.Errorf("Expected no X-Input-File without retainInput, got %q", got)
+	}
+	for name := range uploads() {
+		if !before[name] && name != inputFile {
+			t.Errorf("Expected the upload to be deleted without retainInput, found %s", name)
+		}
+	}
+}
+
+func TestFieldMissingMarkers(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true, "missingMarker": "NO_CLIENT"},
+            {"name": "Account_ID", "displayName": "Account ID", "isMandatory": true, "missingMarker": "NO_ACCOUNT", "missingReason": "ask finance"},
+            {"name": "Region", "displayName": "Region", "isMandatory": true},
+            {"name": "Notes", "displayName": "Notes"}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client,Account,Region,Notes\nC1,A1,EU,ok\n,,,\n")
+	fieldMappings := map[string]string{"Client_Code": "Client", "Account_ID": "Account", "Region": "Region", "Notes": "Notes"}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	missing, _ := os.ReadFile(missingPath)
+	if string(missing) != "Client_Code|Account_ID|Region|Notes\nNO_CLIENT|NO_ACCOUNT: ask finance|MISSING|MISSING\n" {
+		t.Errorf("Unexpected missing output:\n%s", missing)
+	}
+
+	invalid := &config.FieldConfig{Fields: []config.Field{{Name: "Region", DisplayName: "Region", MissingReason: "line one\nline two"}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected a multi-line missingReason to be rejected")
+	}
+}
+
+func TestMultiRowHeaders(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Sales_Q1", "displayName": "Sales Q1", "isMandatory": true},
+            {"name": "Sales_Q2", "displayName": "Sales Q2"},
+            {"name": "Returns_Q1", "displayName": "Returns Q1"}
+        ]
+    }`)
+
+	// "Sales" and "Returns" each span two columns, as merged cells read from a workbook
+	inputPath := writeTempCSV(t, ",Sales,,Returns\nClient,Q1,Q2,Q1\nC1,10,20,1\nC2,,30,2\n")
+	raw, info, err := readInputFile(context.Background(), inputPath, false, csvDialect{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, info := combineHeaderRows(raw, info, headerRowsOption{rows: 2})
+	if strings.Join(rows[0], "|") != "Client|Sales Q1|Sales Q2|Returns Q1" || len(rows) != 3 {
+		t.Fatalf("Expected composite headers above 2 data rows, got %q", rows)
+	}
+	if _, rowNumber := rowLocation(1, info); rowNumber != 3 {
+		t.Errorf("Expected the first data row to be row 3, got %d", rowNumber)
+	}
+	if combined, _ := combineHeaderRows(raw, inputInfo{}, headerRowsOption{rows: 2, separator: " / "}); combined[0][1] != "Sales / Q1" {
+		t.Errorf("Expected the custom separator, got %q", combined[0])
+	}
+
+	fieldMappings := map[string]string{"Client_Code": "Client", "Sales_Q1": "Sales Q1", "Sales_Q2": "sales q2", "Returns_Q1": "Returns Q1"}
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{headerRows: headerRowsOption{rows: 2}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	output, _ := os.ReadFile(outputPath)
+	if string(output) != "Client_Code|Sales_Q1|Sales_Q2|Returns_Q1\nC1|10|20|1\n" {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	if !strings.Contains(summary, "Row 4: Missing mandatory fields - Sales_Q1") {
+		t.Errorf("Expected the missing row numbered as in the file, got:\n%s", summary)
+	}
+}
+
+// TestUnitsRow verifies a units row below the header is kept out of the data, reported in
+// the summary and returned by /preview, with row numbers still those of the file
+func TestUnitsRow(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Sample", "displayName": "Sample", "isMandatory": true},
+            {"name": "Mass", "displayName": "Mass", "isMandatory": true},
+            {"name": "Temperature", "displayName": "Temperature"}
+        ]
+    }`)
+
+	content := "Sample,Mass,Temperature\n,kg,°C\nS1,1.5,20\nS2,,21\n"
+	inputPath := writeTempCSV(t, content)
+	fieldMappings := map[string]string{"Sample": "Sample", "Mass": "Mass", "Temperature": "Temperature"}
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{headerRows: headerRowsOption{units: true}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	output, _ := os.ReadFile(outputPath)
+	if string(output) != "Sample|Mass|Temperature\nS1|1.5|20\n" {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	if !strings.Contains(summary, "Total Rows Processed: 2\n") || !strings.Contains(summary, "Row 4: Missing mandatory fields - Mass") {
+		t.Errorf("Expected the units row left out of the data, got:\n%s", summary)
+	}
+	if !strings.Contains(summary, "Units: Mass (kg), Temperature (°C)\n") {
+		t.Errorf("Expected the units in the summary, got:\n%s", summary)
+	}
+
+	auth.InitAPIKeys()
+	req := newAPIProcessRequest(t, "units.csv", content, map[string]string{"unitsRow": "true"})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIPreview).ServeHTTP(rr, req)
+	var preview PreviewResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &preview); err != nil {
+		t.Fatalf("Failed to decode preview %q: %v", rr.Body.String(), err)
+	}
+	if strings.Join(preview.Units, "|") != "|kg|°C" || preview.TotalRows != 2 || preview.Rows[0][0] != "S1" {
+		t.Errorf("Expected the units apart from 2 data rows, got %+v", preview)
+	}
+}
+
+// TestXLSXMissingMarkerStyle verifies the missing markers of the MissingData sheet are
+// highlighted and its header is bold, while present values are left unstyled
+func TestXLSXMissingMarkerStyle(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	inputPath := writeTempCSV(t, "Client Code,Customer ID,Account Number\nC1,,A1\n")
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Customer_ID": "Customer ID", "Account_ID": "Account Number"}
+	order := []string{"Client_Code", "Customer_ID", "Account_ID"}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "xlsx", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Remove(outputPath)
+
+	workbook, err := excelize.OpenFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer workbook.Close()
+
+	if value, _ := workbook.GetCellValue("MissingData", "B2"); value != "MISSING" {
+		t.Fatalf("Expected the marker in B2, got %q", value)
+	}
+	markerIndex, _ := workbook.GetCellStyle("MissingData", "B2")
+	marker, err := workbook.GetStyle(markerIndex)
+	if err != nil || marker.Font == nil || !marker.Font.Bold || len(marker.Fill.Color) == 0 || marker.Fill.Color[0] != "FFC7CE" {
+		t.Errorf("Expected the marker cell to be highlighted, got style %d: %+v", markerIndex, marker)
+	}
+	if index, _ := workbook.GetCellStyle("MissingData", "A2"); index != 0 {
+		t.Errorf("Expected the present value unstyled, got style %d", index)
+	}
+	headerIndex, _ := workbook.GetCellStyle("MissingData", "C1")
+	if header, err := workbook.GetStyle(headerIndex); err != nil || header.Font == nil || !header.Font.Bold {
+		t.Errorf("Expected a bold header, got style %d", headerIndex)
+	}
+}
+
+func TestRequiredIf(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Country", "displayName": "Country", "transforms": ["upper"]},
+            {"name": "Tax_ID", "displayName": "Tax ID", "requiredIf": {"field": "Country", "equals": "US"}}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client,Country,Tax\nC1,US,T1\nC2, us ,\nC3,GB,\nC4,,\n")
+	fieldMappings := map[string]string{"Client_Code": "Client", "Country": "Country", "Tax_ID": "Tax"}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	output, _ := os.ReadFile(outputPath)
+	if string(output) != "Client_Code|Country|Tax_ID\nC1|US|T1\nC3|GB|\nC4||\n" {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	missing, _ := os.ReadFile(missingPath)
+	if string(missing) != "Client_Code|Country|Tax_ID\nC2|\" US \"|MISSING (required when Country is US)\n" {
+		t.Errorf("Unexpected missing output:\n%s", missing)
+	}
+
+	// Without a mapping the field is still required where the condition holds
+	delete(fieldMappings, "Tax_ID")
+	uniqueID = "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath = outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+	if !strings.Contains(summary, "Row 2: Missing mandatory fields - Tax_ID") || !strings.Contains(summary, "Row 3: Missing mandatory fields - Tax_ID") || strings.Contains(summary, "Row 4:") {
+		t.Errorf("Expected only the US rows to miss Tax_ID, got:\n%s", summary)
+	}
+
+	invalid := &config.FieldConfig{Fields: []config.Field{{Name: "Tax_ID", DisplayName: "Tax ID", RequiredIf: &config.RuleCondition{Field: "Country", Equals: "US"}}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected requiredIf on an unknown field to be rejected")
+	}
+}
+
+func TestNumericRanges(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Age", "displayName": "Age", "type": "number", "min": 0, "max": 120},
+            {"name": "Discount", "displayName": "Discount", "type": "number", "min": 0, "exclusiveMin": true, "max": 1, "rangeSeverity": "warn"}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client,Age,Discount\nC1,0,0.5\nC2,-1,1\nC3,121,\nC4,120,0\nC5,n/a,2\n")
+	fieldMappings := map[string]string{"Client_Code": "Client", "Age": "Age", "Discount": "Discount"}
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{includeWarnings: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	// In range values and the inclusive bounds pass; Discount only warns
+	output, _ := os.ReadFile(outputPath)
+	expectedOutput := "Client_Code|Age|Discount|_warnings\nC1|0|0.5|\nC4|120|0|Discount must be greater than 0\nC5|n/a|2|Discount must be at most 1\n"
+	if string(output) != expectedOutput {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expectedOutput, output)
+	}
+	missing, _ := os.ReadFile(missingPath)
+	expectedMissing := "Client_Code|Age|Discount|_errors\nC2|INVALID|1|Age must be at least 0\nC3|INVALID|MISSING|Age must be at most 120\n"
+	if string(missing) != expectedMissing {
+		t.Errorf("Expected missing output:\n%s\ngot:\n%s", expectedMissing, missing)
+	}
+	if !strings.Contains(summary, "Row 3: Failed validation rules - Age must be at least 0") {
+		t.Errorf("Expected the bound in the summary, got:\n%s", summary)
+	}
+
+	bound := 1.0
+	for _, invalid := range []config.Field{
+		{Name: "Age", DisplayName: "Age", Max: &bound},
+		{Name: "Age", DisplayName: "Age", Type: "number", Min: &bound, Max: &bound, ExclusiveMax: true},
+		{Name: "Age", DisplayName: "Age", Type: "number", ExclusiveMin: true},
+	} {
+		if err := (&config.FieldConfig{Fields: []config.Field{invalid}}).Validate(); err == nil {
+			t.Errorf("Expected range %+v to be rejected", invalid)
+		}
+	}
+}
+
+// TestUnmappedMandatoryRejected verifies a mandatory field without a mapping gets a 400
+// before processing unless allowUnmappedMandatory is set, which warns in the summary
+func TestUnmappedMandatoryRejected(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true},
+            {"name": "Region", "displayName": "Region", "isMandatory": true, "aliases": ["Area"]},
+            {"name": "Name", "displayName": "Name"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+	content := "ID,Area,Name\nC1,North,Ann\n"
+
+	req := newAPIProcessRequest(t, "unmapped.csv", content, map[string]string{"mappings": `{"Name":"Name"}`, "outputFormat": "csv"})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	// Region is left to its alias
+	expected := "Mandatory field Customer_ID has no mapping: map it, or set allowUnmappedMandatory to true"
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("Expected 400 %q, got %d: %s", expected, rr.Code, rr.Body.String())
+	}
+
+	// Mappings keyed by display name count
+	req = newAPIProcessRequest(t, "unmapped.csv", content, map[string]string{"mappings": `{"Customer ID":"ID"}`, "outputFormat": "csv"})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 with Customer_ID mapped, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = newAPIProcessRequest(t, "unmapped.csv", content, map[string]string{"mappings": `{"Name":"Name"}`, "outputFormat": "csv", "allowUnmappedMandatory": "true"})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected allowUnmappedMandatory to process the file, got %d: %s", rr.Code, rr.Body.String())
+	}
+	inputPath := writeTempCSV(t, content)
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(map[string]string{"Name": "Name"}), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+	if warning := "WARNING: mandatory field Customer_ID has no mapping, so every row is missing it\n"; !strings.HasPrefix(summary, warning) || strings.Count(summary, "WARNING") != 1 {
+		t.Errorf("Expected the summary to start with the one warning %q, got:\n%s", warning, summary)
+	}
+
+	// The web upload sends each mapping as its own form field
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("fileInput", "unmapped.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	writer.WriteField("mapping_Name", "Name")
+	writer.Close()
+	req = httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr = httptest.NewRecorder()
+	handleUpload(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("Expected 400 from the web upload, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// TestFieldReplace verifies a field's replace entries clean its values before validation
+func TestFieldReplace(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true, "replace": [{"pattern": "[^0-9]", "replacement": ""}], "allowedValues": ["00123", "45"]},
+            {"name": "Sort_Code", "displayName": "Sort Code", "replace": [{"pattern": "[\\s/.]+", "replacement": "-"}, {"pattern": "^(\\d{2})(\\d{2})(\\d{2})$", "replacement": "$1-$2-$3"}]}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "ID,Sort\nCUST-00123,12 34 56\nID 4 5,123456\nC-6,12/34.56\n")
+	fieldMappings := map[string]string{"Customer_ID": "ID", "Sort_Code": "Sort"}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	// Allowed values are checked against the digits left
+	output, _ := os.ReadFile(outputPath)
+	if expected := "Customer_ID|Sort_Code\n00123|12-34-56\n45|12-34-56\n"; string(output) != expected {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expected, output)
+	}
+	missing, _ := os.ReadFile(missingPath)
+	if expected := "Customer_ID|Sort_Code|_errors\nINVALID|12-34-56|Customer_ID must be one of 00123, 45\n"; string(missing) != expected {
+		t.Errorf("Expected missing output:\n%s\ngot:\n%s", expected, missing)
+	}
+
+	for _, replace := range [][]config.Replacement{{{Pattern: "[0-9"}}, {{Pattern: ""}}} {
+		invalid := &config.FieldConfig{Fields: []config.Field{{Name: "Customer_ID", DisplayName: "Customer ID", Replace: replace}}}
+		if err := invalid.Validate(); err == nil || !strings.Contains(err.Error(), "replace pattern") {
+			t.Errorf("Expected replace %v to be rejected, got %v", replace, err)
+		}
+	}
+}
+
+// TestMaxErrors verifies processing stops with a 422 and a partial summary, writing no
+// output, once more rows than maxErrors fail
+func TestMaxErrors(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true},
+            {"name": "Name", "displayName": "Name"}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	fileContent := "ID,Name\nC1,Ann\n,Bob\nC3,Cat\n,Dan\n,Eve\nC6,Fay\n,Gus\n"
+	mappings := `{"Customer_ID":"ID","Name":"Name"}`
+
+	req := newAPIProcessRequest(t, "budget.csv", fileContent, map[string]string{"mappings": mappings, "outputFormat": "csv", "maxErrors": "2"})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON error response, got %s", rr.Body.String())
+	}
+	if !strings.HasPrefix(response.Error, "Too many rows with missing or invalid data") {
+		t.Errorf("Expected the error budget in the error, got %q", response.Error)
+	}
+	// The third failing row, Row 6, uses up the budget; Row 7 and beyond are never looked at
+	for _, expected := range []string{
+		"Row 6: Missing mandatory fields - Customer_ID\n",
+		"Total Rows Processed: 5\nSuccessful Rows: 2\nRows with Missing Data: 3\n",
+		"Aborted: more than 2 rows with missing or invalid data (maxErrors); processing stopped at Row 6\n",
+	} {
+		if !strings.Contains(response.Summary, expected) {
+			t.Errorf("Expected the summary to contain %q, got:\n%s", expected, response.Summary)
+		}
+	}
+	if strings.Contains(response.Summary, "Row 8") {
+		t.Errorf("Expected rows after the abort to be left out, got:\n%s", response.Summary)
+	}
+
+	uniqueID := "test_" + generateUniqueID()
+	inputPath := writeTempCSV(t, fileContent)
+	fieldMappings := map[string]string{"Customer_ID": "ID", "Name": "Name"}
+	_, _, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{maxErrors: 2})
+	if !errors.Is(err, errErrorBudgetExceeded) {
+		t.Fatalf("Expected errErrorBudgetExceeded, got %v", err)
+	}
+	processedPath, missingPath := outputFilePaths(uniqueID, "csv")
+	for _, path := range []string{processedPath, missingPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			os.Remove(path)
+			t.Errorf("Expected no output at %s after the abort", path)
+		}
+	}
+
+	// Mapping stops with the row that uses up the budget, whether rows are mapped one at a
+	// time or in chunks by several workers
+	rows := make([][]string, 20*rowChunkSize)
+	for i := range rows {
+		rows[i] = []string{strconv.Itoa(i)}
+	}
+	for _, workers := range []int{1, 4} {
+		// Rows after the first chunk wait for the stop, so workers cannot race ahead
+		var mappedCount atomic.Int64
+		release := make(chan struct{})
+		mapRow := func(row []string) mappedRow {
+			if index, _ := strconv.Atoi(row[0]); index >= rowChunkSize {
+				<-release
+			}
+			mappedCount.Add(1)
+			return mappedRow{row: row}
+		}
+		routed := 0
+		err := mapRows(context.Background(), rows, workers, mapRow, func(offset int, mapped mappedRow) bool {
+			routed++
+			if offset < 2 {
+				return true
+			}
+			close(release)
+			return false
+		})
+		if err != nil || routed != 3 {
+			t.Errorf("Expected routing to stop after 3 rows with %d workers, got %d (%v)", workers, routed, err)
+		}
+		// One worker maps row by row; several finish only the chunks they had already taken,
+		// which is at most one each besides the first chunk
+		if workers == 1 && mappedCount.Load() != 3 {
+			t.Errorf("Expected only the 3 routed rows to be mapped, got %d", mappedCount.Load())
+		}
+		if mappedCount.Load() > int64((workers+1)*rowChunkSize) {
+			t.Errorf("Expected mapping to stop early with %d workers, got %d of %d rows mapped", workers, mappedCount.Load(), len(rows))
+		}
+	}
+
+	// A file within the budget is processed as usual
+	req = newAPIProcessRequest(t, "budget.csv", fileContent, map[string]string{"mappings": mappings, "outputFormat": "csv", "maxErrors": "4"})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 within the budget, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// TestUnifiedOutput verifies unifiedOutput writes every row to one output with its status and reason
+func TestUnifiedOutput(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Status", "displayName": "Status", "allowedValues": ["Active", "Pending"]}
+        ]
+    }`)
+	auth.InitAPIKeys()
+
+	fileContent := "Client,Status\nC1,Active\n,Active\nC2,Closed\nC1,Pending\n"
+	req := newAPIProcessRequest(t, "unified.csv", fileContent, map[string]string{
+		"mappings":      `{"Client_Code":"Client","Status":"Status"}`,
+		"outputFormat":  "csv",
+		"dedupeBy":      "Client_Code",
+		"unifiedOutput": "true",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	expected := "Client_Code|Status|_status|_reason\n" +
+		"C1|Active|OK|\n" +
+		"|Active|MISSING|Missing mandatory fields - Client_Code\n" +
+		"C2|Closed|INVALID|Failed validation rules - Status must be one of Active, Pending\n" +
+		"C1|Pending|DUPLICATE|Duplicate of Row 2\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected unified output:\n%s\ngot:\n%s", expected, rr.Body.String())
+	}
+
+	req = newAPIProcessRequest(t, "unified.csv", fileContent, map[string]string{
+		"mappings":      `{"Client_Code":"Client","Status":"Status"}`,
+		"unifiedOutput": "true",
+		"outputScope":   "missing",
+	})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected unifiedOutput with outputScope missing to be rejected, got %d", rr.Code)
+	}
+}
+
+// TestPhoneFormat verifies phone numbers are normalized per field and unparseable ones kept and flagged
+func TestPhoneFormat(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Phone", "displayName": "Phone", "phoneFormat": {"region": "US"}},
+            {"name": "Fax", "displayName": "Fax", "phoneFormat": {"region": "GB", "format": "digits", "severity": "warn"}}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client,Phone,Fax\nC1,(415) 555-2671,020 7946 0958\nC2,+44 20 7946 0958,+1 415.555.2671\nC3,1-415-555-2671,ext 12\nC4,555-12,\n")
+	fieldMappings := map[string]string{"Client_Code": "Client", "Phone": "Phone", "Fax": "Fax"}
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{includeWarnings: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	output, _ := os.ReadFile(outputPath)
+	expectedOutput := "Client_Code|Phone|Fax|_warnings\n" +
+		"C1|+14155552671|442079460958|\n" +
+		"C2|+442079460958|14155552671|\n" +
+		"C3|+14155552671|ext 12|Fax must be a valid phone number\n"
+	if string(output) != expectedOutput {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expectedOutput, output)
+	}
+	missing, _ := os.ReadFile(missingPath)
+	expectedMissing := "Client_Code|Phone|Fax|_errors\nC4|INVALID|MISSING|Phone must be a valid phone number\n"
+	if string(missing) != expectedMissing {
+		t.Errorf("Expected missing output:\n%s\ngot:\n%s", expectedMissing, missing)
+	}
+	if !strings.Contains(summary, "Row 5: Failed validation rules - Phone must be a valid phone number") {
+		t.Errorf("Expected the invalid phone number in the summary, got:\n%s", summary)
+	}
+
+	us := config.PhoneFormat{Region: "US"}
+	for value, expected := range map[string]string{"(415) 555-2671": "+14155552671", "011 44 20 7946 0958": "+442079460958", "(015) 555-2671": "(015) 555-2671", "415-555-2671 x3": "415-555-2671 x3"} {
+		if got, _ := us.Normalize(value); got != expected {
+			t.Errorf("Normalize(%q): expected %q, got %q", value, expected, got)
+		}
+	}
+
+	// National numbers must have a length in use behind their calling code, whether written
+	// nationally or internationally; calling codes without known lengths only get the E.164 limits
+	for _, tc := range []struct {
+		region, value string
+		expected      string
+		ok            bool
+	}{
+		{"GB", "07700 900123", "+447700900123", true},
+		{"GB", "0207 946 09581", "0207 946 09581", false},
+		{"FR", "01 23 45 67 89", "+33123456789", true},
+		{"FR", "01 23 45 67", "01 23 45 67", false},
+		{"US", "+44 20 7946", "+44 20 7946", false},
+		{"US", "+1 015 555 2671", "+1 015 555 2671", false},
+		{"US", "+998 90 123 4567", "+998901234567", true},
+	} {
+		if got, ok := (config.PhoneFormat{Region: tc.region}).Normalize(tc.value); got != tc.expected || ok != tc.ok {
+			t.Errorf("Normalize(%q) in %s: expected %q (%v), got %q (%v)", tc.value, tc.region, tc.expected, tc.ok, got, ok)
+		}
+	}
+
+	for _, invalid := range []config.PhoneFormat{{Region: "XX"}, {Region: "US", Format: "national"}, {Region: "US", Severity: "ignore"}} {
+		field := config.Field{Name: "Phone", DisplayName: "Phone", PhoneFormat: &invalid}
+		if err := (&config.FieldConfig{Fields: []config.Field{field}}).Validate(); err == nil {
+			t.Errorf("Expected phone format %+v to be rejected", invalid)
+		}
+	}
+}
+
+// TestSheetVisibility verifies activeSheet and hiddenSheets set the workbook's active sheet and hidden sheets
+func TestSheetVisibility(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	fileContent := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n"
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+	req := newAPIProcessRequest(t, "sheets.csv", fileContent, map[string]string{
+		"mappings":     mappings,
+		"outputFormat": "xlsx",
+		"activeSheet":  "missing",
+		"hiddenSheets": "processed",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	workbook, err := excelize.OpenReader(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open workbook: %v", err)
+	}
+	defer workbook.Close()
+	missingIndex, _ := workbook.GetSheetIndex("MissingData")
+	if active := workbook.GetActiveSheetIndex(); active != missingIndex {
+		t.Errorf("Expected MissingData (index %d) to be active, got index %d", missingIndex, active)
+	}
+	if visible, _ := workbook.GetSheetVisible("ProcessedData"); visible {
+		t.Error("Expected ProcessedData to be hidden")
+	}
+	if visible, _ := workbook.GetSheetVisible("MissingData"); !visible {
+		t.Error("Expected MissingData to stay visible")
+	}
+
+	for _, fields := range []map[string]string{
+		{"activeSheet": "summary"},
+		{"hiddenSheets": "processed"},
+		{"activeSheet": "missing", "hiddenSheets": "missing"},
+		{"activeSheet": "missing", "outputScope": "processed"},
+	} {
+		fields["mappings"] = mappings
+		req := newAPIProcessRequest(t, "sheets.csv", fileContent, fields)
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %v, got %d", fields, rr.Code)
+		}
+	}
+}
+
+// TestMandatoryFieldsList verifies that fields named in mandatoryFields are mandatory
+// without their own isMandatory flag
+func TestMandatoryFieldsList(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Customer_ID", "displayName": "Customer ID"},
+            {"name": "Email", "displayName": "Email", "isMandatory": true},
+            {"name": "Notes", "displayName": "Notes"}
+        ],
+        "mandatoryFields": ["Customer ID"]
+    }`)
+
+	if got := currentFieldConfig().GetMandatoryFieldNames(); !slices.Equal(got, []string{"Customer_ID", "Email"}) {
+		t.Errorf("Expected Customer_ID and Email to be mandatory, got %v", got)
+	}
+
+	inputPath := writeTempCSV(t, "ID,Email,Notes\nC1,a@example.com,\n,b@example.com,note\n")
+	fieldMappings := map[string]string{"Customer_ID": "ID", "Email": "Email", "Notes": "Notes"}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	output, _ := os.ReadFile(outputPath)
+	if expected := "Customer_ID|Email|Notes\nC1|a@example.com|\n"; string(output) != expected {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expected, output)
+	}
+	missing, _ := os.ReadFile(missingPath)
+	if !strings.Contains(string(missing), "MISSING|b@example.com|note") {
+		t.Errorf("Expected the row without a Customer ID in the missing output, got:\n%s", missing)
+	}
+
+	fields := []config.Field{{Name: "Customer_ID", DisplayName: "Customer ID"}}
+	unknown := &config.FieldConfig{Fields: fields, MandatoryFields: []string{"Account ID"}}
+	if err := unknown.Validate(); err == nil || !strings.Contains(err.Error(), `"Account ID"`) {
+		t.Errorf("Expected an unknown mandatoryFields entry to be rejected, got %v", err)
+	}
+}
+
+func TestAllowedValues(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Status", "displayName": "Status", "allowedValues": ["Active", "Inactive", "Pending"]},
+            {"name": "Tier", "displayName": "Tier", "allowedValues": ["Gold", "Silver"], "allowedValuesIgnoreCase": true, "allowedValuesSeverity": "warn"}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client,Status,Tier\nC1, Active ,GOLD\nC2,active,Silver\nC3,,Bronze\nC4,Closed,\n")
+	fieldMappings := map[string]string{"Client_Code": "Client", "Status": "Status", "Tier": "Tier"}
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{includeWarnings: true, trimCells: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	// Status matches case-sensitively and rejects; Tier ignores case and only warns
+	output, _ := os.ReadFile(outputPath)
+	expectedOutput := "Client_Code|Status|Tier|_warnings\nC1|Active|GOLD|\nC3||Bronze|Tier must be one of Gold, Silver\n"
+	if string(output) != expectedOutput {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expectedOutput, output)
+	}
+	missing, _ := os.ReadFile(missingPath)
+	expectedMissing := "Client_Code|Status|Tier|_errors\nC2|INVALID|Silver|Status must be one of Active, Inactive, Pending\nC4|INVALID|MISSING|Status must be one of Active, Inactive, Pending\n"
+	if string(missing) != expectedMissing {
+		t.Errorf("Expected missing output:\n%s\ngot:\n%s", expectedMissing, missing)
+	}
+	if !strings.Contains(summary, "Row 5: Failed validation rules - Status must be one of Active, Inactive, Pending") {
+		t.Errorf("Expected the allowed set in the summary, got:\n%s", summary)
+	}
+
+	for _, invalid := range []config.Field{
+		{Name: "Status", DisplayName: "Status", AllowedValues: []string{"Active", "Active"}},
+		{Name: "Status", DisplayName: "Status", AllowedValues: []string{"Active", "ACTIVE"}, AllowedValuesIgnoreCase: true},
+		{Name: "Status", DisplayName: "Status", AllowedValues: []string{" Active"}},
+		{Name: "Status", DisplayName: "Status", AllowedValuesSeverity: "warn"},
+	} {
+		if err := (&config.FieldConfig{Fields: []config.Field{invalid}}).Validate(); err == nil {
+			t.Errorf("Expected allowed values %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestFieldMask(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Account_ID", "displayName": "Account ID", "isMandatory": true, "mask": {"keepLast": 4}, "allowedValues": ["98761234", "55554321", "12"]},
+            {"name": "Tax_ID", "displayName": "Tax ID", "mask": {"keepFirst": 1, "keepLast": 1, "char": "#"}}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client,Account,Tax\nC1,98761234,AB-123\nC2,12,X\n,55554321,\nC4,11112222,\n")
+	fieldMappings := map[string]string{"Client_Code": "Client", "Account_ID": "Account", "Tax_ID": "Tax"}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	output, _ := os.ReadFile(outputPath)
+	if string(output) != "Client_Code|Account_ID|Tax_ID\nC1|****1234|A####3\nC2|**|#\n" {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	// Validation sees the real value, and markers are not masked
+	missing, _ := os.ReadFile(missingPath)
+	if string(missing) != "Client_Code|Account_ID|Tax_ID|_errors\nMISSING|****4321|MISSING|\nC4|INVALID|MISSING|Account_ID must be one of 98761234, 55554321, 12\n" {
+		t.Errorf("Unexpected missing output:\n%s", missing)
+	}
+
+	// The duplicates and groups reports and explain do not reveal masked values either
+	reportInput := writeTempCSV(t, "Client,Account,Tax\nC1,98761234,AB-123\nC2,98761234,X\nC3,55554321,Y\n")
+	reportID := "test_" + generateUniqueID()
+	reportOpts := processOptions{dedupeBy: []string{"Account_ID"}, dedupeReport: true, groupBy: "Account_ID"}
+	_, reportOutput, err := processFileWithOptions(context.Background(), reportInput, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", reportID, reportOpts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, reportMissing := outputFilePaths(reportID, "csv")
+	defer os.Remove(reportOutput)
+	defer os.Remove(reportMissing)
+	defer os.Remove(duplicatesReportPath(reportID))
+	defer os.Remove(groupReportPath(reportID))
+
+	duplicates, _ := os.ReadFile(duplicatesReportPath(reportID))
+	if !strings.Contains(string(duplicates), "****1234") || strings.Contains(string(duplicates), "98761234") {
+		t.Errorf("Expected the duplicate key to be masked, got:\n%s", duplicates)
+	}
+	groupsData, _ := os.ReadFile(groupReportPath(reportID))
+	var groupsReport GroupReport
+	if err := json.Unmarshal(groupsData, &groupsReport); err != nil {
+		t.Fatal(err)
+	}
+	var groupValues []string
+	for _, group := range groupsReport.Groups {
+		groupValues = append(groupValues, group.Value)
+	}
+	if !slices.Equal(groupValues, []string{"****4321", "****1234"}) {
+		t.Errorf("Expected masked group values, got %v", groupValues)
+	}
+
+	rows := [][]string{{"Client", "Account", "Tax"}, {"C1", "98761234", "AB-123"}}
+	explanation := explainRow(rows, 1, columnMappings(fieldMappings), currentFieldConfig(), inputInfo{})
+	for _, field := range explanation.Fields {
+		if field.Field == "Account_ID" && (field.RawValue != "****1234" || field.Value != "****1234" || field.Status != explainOK) {
+			t.Errorf("Expected explain to mask Account_ID, got %+v", field)
+		}
+	}
+
+	if err := (&config.FieldConfig{Fields: []config.Field{{Name: "Tax_ID", DisplayName: "Tax ID", Mask: &config.Mask{Char: "**"}}}}).Validate(); err == nil {
+		t.Error("Expected a multi-character mask char to be rejected")
+	}
+}
+
+func TestStripEnclosing(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true, "stripEnclosing": ["\"\""]},
+            {"name": "Account_Name", "displayName": "Account Name", "transforms": ["trim"], "stripEnclosing": ["[]"]}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Customer,Account\n\"\"\"1234\"\"\", [ABC] \n\"\"\"5678\",[DEF\n[9],\"\"\"GHI\"\"\"\n")
+	fieldMappings := map[string]string{"Customer_ID": "Customer", "Account_Name": "Account"}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	output, _ := os.ReadFile(outputPath)
+	if string(output) != "Customer_ID|Account_Name\n1234|ABC\n\"\"\"5678\"|[DEF\n[9]|\"\"\"GHI\"\"\"\n" {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+
+	invalid := &config.FieldConfig{Fields: []config.Field{{Name: "Region", DisplayName: "Region", StripEnclosing: []string{"("}}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected a stripEnclosing entry without a closing character to be rejected")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "countries.csv")
+	if err := os.WriteFile(table, []byte("code,value\nGB,United Kingdom\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true, "aliases": ["Cust No"]},
+            {"name": "Country_Code", "displayName": "Country Code"},
+            {"name": "Country_Name", "displayName": "Country Name", "lookup": {"sourceField": "Country_Code", "table": "`+table+`"}},
+            {"name": "Account_Name", "displayName": "Account Name"},
+            {"name": "Notes", "displayName": "Notes"}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "client code,Cust No,Primary Country,Backup Country,Comments\nC1,1001,GB,,hi\n")
+	fieldMappings := columnMappings(map[string]string{"Client_Code": "Client Code", "Notes": "Remarks"})
+	fieldMappings["Country_Code"] = fieldMapping{{column: "Primary Country"}, {column: "Backup Country"}}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, fieldMappings, currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{provenance: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+	defer os.Remove(provenancePath(uniqueID))
+
+	data, err := os.ReadFile(provenancePath(uniqueID))
+	if err != nil {
+		t.Fatalf("Expected a provenance record: %v", err)
+	}
+	var provenance Provenance
+	if err := json.Unmarshal(data, &provenance); err != nil {
+		t.Fatal(err)
+	}
+	expected := []FieldProvenance{
+		{Field: "Client_Code", Source: "column", Columns: []string{"client code"}, MatchedBy: "mapping"},
+		{Field: "Customer_ID", Source: "column", Columns: []string{"Cust No"}, MatchedBy: "alias"},
+		{Field: "Country_Code", Source: "column", Columns: []string{"Primary Country", "Backup Country"}, MatchedBy: "mapping"},
+		{Field: "Country_Name", Source: "computed", Columns: []string{"Primary Country", "Backup Country"}, SourceField: "Country_Code"},
+		{Field: "Account_Name", Source: "unmapped"},
+		{Field: "Notes", Source: "unmapped"},
+	}
+	got, _ := json.Marshal(provenance.Fields)
+	want, _ := json.Marshal(expected)
+	if string(got) != string(want) {
+		t.Errorf("Unexpected provenance:\n%s", data)
+	}
+}
+
+// TestGroupByReport verifies processed rows are counted per value of the groupBy field,
+// with the aggregate field summed and averaged per group
+func TestGroupByReport(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Status", "displayName": "Status"},
+            {"name": "Amount", "displayName": "Amount", "type": "number"}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Client Code,Status,Amount\nC1,Active,10\nC2,Closed,5\nC3,Active,20\n,Active,99\nC4,Active,n/a\nC5,,1\n")
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Status": "Status", "Amount": "Amount"}
+	order := currentFieldConfig().GetOrderedFields()
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", uniqueID, processOptions{groupBy: "Status", aggregate: "Amount"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+	defer os.Remove(groupReportPath(uniqueID))
+
+	if !strings.Contains(summary, "Groups: 3 (by Status)\n") {
+		t.Errorf("Expected the group count in the summary, got:\n%s", summary)
+	}
+	data, err := os.ReadFile(groupReportPath(uniqueID))
+	if err != nil {
+		t.Fatalf("Expected a groups report: %v", err)
+	}
+	var report GroupReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	sum := func(v float64) *float64 { return &v }
+	expected := GroupReport{GroupBy: "Status", Aggregate: "Amount", Groups: []GroupCount{
+		{Value: "Active", Rows: 3, Sum: sum(30), Avg: sum(15)},
+		{Value: "", Rows: 1, Sum: sum(1), Avg: sum(1)},
+		{Value: "Closed", Rows: 1, Sum: sum(5), Avg: sum(5)},
+	}}
+	got, _ := json.Marshal(report)
+	want, _ := json.Marshal(expected)
+	if string(got) != string(want) {
+		t.Errorf("Unexpected groups report:\n%s", data)
+	}
+
+	_, _, err = processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID(), processOptions{groupBy: "Region"})
+	if !errors.Is(err, errInvalidGroupBy) {
+		t.Errorf("Expected errInvalidGroupBy for an unknown field, got %v", err)
+	}
+
+	// outputMandatoryOnly leaves only Client_Code in the output, so Status cannot be grouped
+	// by and Client_Code is found in the shorter rows
+	_, _, err = processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", "test_"+generateUniqueID(), processOptions{groupBy: "Status", outputMandatoryOnly: true})
+	if !errors.Is(err, errInvalidGroupBy) {
+		t.Errorf("Expected errInvalidGroupBy for a field outputMandatoryOnly drops, got %v", err)
+	}
+	mandatoryID := "test_" + generateUniqueID()
+	mandatoryOutput, mandatoryMissing := outputFilePaths(mandatoryID, "csv")
+	defer os.Remove(mandatoryOutput)
+	defer os.Remove(mandatoryMissing)
+	defer os.Remove(groupReportPath(mandatoryID))
+	if _, _, err = processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), order, "csv", mandatoryID, processOptions{groupBy: "Client_Code", outputMandatoryOnly: true}); err != nil {
+		t.Fatalf("Unexpected error grouping the mandatory-only output: %v", err)
+	}
+	data, err = os.ReadFile(groupReportPath(mandatoryID))
+	if err != nil {
+		t.Fatalf("Expected a groups report: %v", err)
+	}
+	report = GroupReport{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.GroupBy != "Client_Code" || len(report.Groups) != 5 {
+		t.Errorf("Expected a group per client code, got:\n%s", data)
+	}
+}
+
+func TestEuropeanCSVDialect(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true},
+            {"name": "Amount", "displayName": "Amount", "type": "number", "outputNumberFormat": "%.2f"},
+            {"name": "Note", "displayName": "Note"}
+        ]
+    }`)
+
+	inputPath := writeTempCSV(t, "Customer;Amount;Note\n1001;1.234,56;\"a;b\"\n1002;-0,5;1.234,56\n1003;n/a;\n")
+	fieldMappings := map[string]string{"Customer_ID": "Customer", "Amount": "Amount", "Note": "Note"}
+	dialect, err := parseCSVDialect("european")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uniqueID := "test_" + generateUniqueID()
+	_, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{csvDialect: dialect})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	// Only the number field is converted; the text field keeps its value as written
+	output, _ := os.ReadFile(outputPath)
+	if string(output) != "Customer_ID|Amount|Note\n1001|1234.56|a;b\n1002|-0.50|1.234,56\n1003|n/a|\n" {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+
+	if _, err := parseCSVDialect("klingon"); err == nil {
+		t.Error("Expected an unknown dialect to be rejected")
+	}
+}
+
+// TestPostProcessCommand verifies POST_PROCESS_COMMAND rewrites the primary output and that
+// a failing, slow or oversized hook fails the request
+func TestPostProcessCommand(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	original := featureFlags
+	t.Cleanup(func() { featureFlags = original })
+	featureFlags.PostProcessTimeout = 5 * time.Second
+	featureFlags.PostProcessMaxBytes = 1 << 20
+
+	send := func(command string) *httptest.ResponseRecorder {
+		featureFlags.PostProcessCommand = command
+		req := newAPIProcessRequest(t, "hook.csv", "Client Code,Customer ID,Account Number\nC1,1001,A1\n", map[string]string{
+			"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+			"outputFormat": "csv",
+		})
+		req.Header.Set("X-API-Key", "test-api-key-1")
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The hook rewrites the file in place before it is returned
+	rr := send("sed -i s/C1/HOOKED/ {output}")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "HOOKED||1001|||A1||") {
+		t.Errorf("Expected the hook's changes in the output, got:\n%s", rr.Body.String())
+	}
+
+	rr = send("false")
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "Post-processing of the output failed") {
+		t.Errorf("Expected a failing hook to fail the request, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A hook running past POST_PROCESS_TIMEOUT is killed and fails the request
+	// sleep would reject the output path as an argument, so it is wrapped in a script
+	slowHook := filepath.Join(t.TempDir(), "slow-hook")
+	if err := os.WriteFile(slowHook, []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	featureFlags.PostProcessTimeout = 100 * time.Millisecond
+	started := time.Now()
+	rr = send(slowHook)
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "Post-processing of the output failed") {
+		t.Errorf("Expected a hook over POST_PROCESS_TIMEOUT to fail the request, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the hook to be killed after POST_PROCESS_TIMEOUT, took %s", elapsed)
+	}
+	outputPath := writeTempCSV(t, "Client_Code\nC1\n")
+	if err := runPostProcessCommand(context.Background(), outputPath); err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Expected the hook to time out, got %v", err)
+	}
+	featureFlags.PostProcessTimeout = 5 * time.Second
+
+	featureFlags.PostProcessMaxBytes = 10
+	if rr = send("true"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected an output over POST_PROCESS_MAX_BYTES to fail the request, got %d", rr.Code)
+	}
+}
+
+func TestDropEmptyColumns(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	// Customer_Name is only populated in a row with missing data, so it is empty in every
+	// processed row. LE_ID is optional but populated, and the unmapped optional fields are dropped too.
+	inputPath := writeTempCSV(t, "Client,LE,Customer,Name,Account\nC1,L1,1001,,A1\nC2,L2,1002,,A2\nC3,,,Bob,A3\n")
+	fieldMappings := map[string]string{"Client_Code": "Client", "LE_ID": "LE", "Customer_ID": "Customer", "Customer_Name": "Name", "Account_ID": "Account"}
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{dropEmptyColumns: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+
+	output, _ := os.ReadFile(outputPath)
+	if string(output) != "Client_Code|LE_ID|Customer_ID|Account_ID\nC1|L1|1001|A1\nC2|L2|1002|A2\n" {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	if !strings.Contains(summary, "Empty Columns Dropped: 4 (Customer_Name, Customer_Active, Account_Name, Account_Active)") {
+		t.Errorf("Expected the dropped columns in the summary, got:\n%s", summary)
+	}
+
+	// The missing data output keeps every column
+	missing, _ := os.ReadFile(missingPath)
+	if !strings.HasPrefix(string(missing), "Client_Code|LE_ID|Customer_ID|Customer_Name|Customer_Active|Account_ID|Account_Name|Account_Active\n") {
+		t.Errorf("Unexpected missing output:\n%s", missing)
+	}
+}
+
+// flakyReader fails with err on its first read
+type flakyReader struct {
+	err error
+}
+
+func (r flakyReader) Read([]byte) (int, error) { return 0, r.err }
+func (r flakyReader) Close() error             { return nil }
+
+func TestReadInputFileRetriesTransientErrors(t *testing.T) {
+	originalOpen, originalFlags := openInput, featureFlags
+	t.Cleanup(func() { openInput, featureFlags = originalOpen, originalFlags })
+	featureFlags.ReadRetries = 2
+	featureFlags.ReadRetryBackoff = time.Millisecond
+
+	// openWith simulates a file whose first reads, up to failures of them, fail with err
+	attempts := 0
+	openWith := func(failures int, err error) {
+		attempts = 0
+		openInput = func(string) (io.ReadCloser, error) {
+			attempts++
+			if attempts <= failures {
+				return flakyReader{err: err}, nil
+			}
+			return io.NopCloser(strings.NewReader("Client Code\nC1\n")), nil
+		}
+	}
+
+	openWith(2, fmt.Errorf("read: %w", syscall.EAGAIN))
+	rows, _, err := readInputFile(context.Background(), "flaky.csv", false, csvDialect{})
+	if err != nil || attempts != 3 || len(rows) != 2 {
+		t.Errorf("Expected the read to succeed on the third attempt, got %d attempts, %v rows and error %v", attempts, rows, err)
+	}
+
+	openWith(3, syscall.EINTR)
+	if _, _, err = readInputFile(context.Background(), "flaky.csv", false, csvDialect{}); err == nil || attempts != 3 {
+		t.Errorf("Expected the read to fail after 2 retries, got %d attempts and error %v", attempts, err)
+	}
+
+	openWith(1, os.ErrNotExist)
+	if _, _, err = readInputFile(context.Background(), "missing.csv", false, csvDialect{}); !errors.Is(err, errParseFile) || attempts != 1 {
+		t.Errorf("Expected a permanent error to fail without retrying, got %d attempts and error %v", attempts, err)
+	}
+}
+
+// zipOf builds a zip archive holding the given files, in order
+func zipOf(t *testing.T, files [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		entry, err := archive.Create(file[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(file[1]))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipUpload(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	fields := map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat": "csv",
+		"manifest":     "true",
+	}
+
+	upload := zipOf(t, [][2]string{
+		{"2024/january.csv", "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\n"},
+		{"2024/notes.txt", "not an input"},
+		{"__MACOSX/2024/._february.csv", "resource fork"},
+		{"february.csv", "Client Code,Customer ID,Account Number\nC3,1003,A3\n"},
+	})
+	req := newAPIProcessRequest(t, "batch.zip", string(upload), fields)
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/zip" {
+		t.Errorf("Expected a zip response, got %q", contentType)
+	}
+	if summary := rr.Header().Get("X-Processing-Summary"); !strings.Contains(summary, "Files Processed: 2") || !strings.Contains(summary, "Total Rows Processed: 3") {
+		t.Errorf("Expected the summary digest to total both files, got %q", summary)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("Response is not a zip: %v", err)
+	}
+	outputs := make(map[string]string)
+	for _, entry := range archive.File {
+		file, _ := entry.Open()
+		data, _ := io.ReadAll(file)
+		file.Close()
+		outputs[entry.Name] = string(data)
+	}
+	expected := map[string]string{
+		"2024/january.csv/processed_data.csv": "Client_Code|LE_ID|Customer_ID|Customer_Name|Customer_Active|Account_ID|Account_Name|Account_Active\nC1||1001|||A1||\n",
+		"february.csv/processed_data.csv":     "Client_Code|LE_ID|Customer_ID|Customer_Name|Customer_Active|Account_ID|Account_Name|Account_Active\nC3||1003|||A3||\n",
+	}
+	for name, content := range expected {
+		if outputs[name] != content {
+			t.Errorf("Expected %s to be\n%s\ngot\n%s", name, content, outputs[name])
+		}
+	}
+	if !strings.Contains(outputs["summary.txt"], "== february.csv ==") {
+		t.Errorf("Expected a summary.txt of both files in the archive, got %v", outputs)
+	}
+
+	data, err := os.ReadFile(filepath.Join("./uploads", rr.Header().Get("X-Manifest-File")))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Invalid manifest JSON: %v\n%s", err, data)
+	}
+	if len(manifest.Inputs) != 2 || manifest.Inputs[0].Filename != "2024/january.csv" || len(manifest.Inputs[0].Artifacts) != 2 || manifest.Inputs[1].Filename != "february.csv" {
+		t.Errorf("Expected the manifest to list both inputs with their outputs, got %+v", manifest.Inputs)
+	}
+	if len(manifest.Artifacts) != 1 || manifest.Artifacts[0].Kind != "archive" {
+		t.Errorf("Expected the manifest to list the archive, got %+v", manifest.Artifacts)
+	}
+
+	// An entry escaping the archive rejects the whole zip
+	unsafe := zipOf(t, [][2]string{{"ok.csv", "Client Code\nC1\n"}, {"../../etc/evil.csv", "Client Code\nC1\n"}})
+	req = newAPIProcessRequest(t, "unsafe.zip", string(unsafe), fields)
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "unsafe path") {
+		t.Errorf("Expected 400 for a zip-slip entry, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHeaderAliases(t *testing.T) {
+	useTempFieldConfig(t, `{
+        "fields": [
+            {"name": "Client_Code", "displayName": "Client Code", "isMandatory": true},
+            {"name": "Customer_ID", "displayName": "Customer ID", "isMandatory": true, "aliases": ["Cust ID", "Customer Number"]}
+        ]
+    }`)
+
+	// No mapping is given for Customer_ID, so its alias supplies one
+	inputPath := writeTempCSV(t, "Client Code,CUST_ID\nC1,1001\nC2,1002\n")
+	uniqueID := "test_" + generateUniqueID()
+	summary, outputPath, err := processFileWithOptions(context.Background(), inputPath, columnMappings(map[string]string{"Client_Code": "Client Code"}), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, missingPath := outputFilePaths(uniqueID, "csv")
+	defer os.Remove(outputPath)
+	defer os.Remove(missingPath)
+	if !strings.Contains(summary, "Successful Rows: 2") {
+		t.Errorf("Expected both rows to map through the alias, got:\n%s", summary)
+	}
+	processed, _ := os.ReadFile(outputPath)
+	if string(processed) != "Client_Code|Customer_ID\nC1|1001\nC2|1002\n" {
+		t.Errorf("Unexpected processed output:\n%s", processed)
+	}
+
+	// A mapped column that is not in the file also falls back to the alias
+	mappings := aliasMappings([]string{"Customer Number"}, columnMappings(map[string]string{"Customer_ID": "Customer ID"}), currentFieldConfig())
+	if mappings["Customer_ID"].String() != "Customer Number" {
+		t.Errorf("Expected the alias to replace a mapping to a missing column, got %v", mappings)
+	}
+
+	suggestions := suggestMappings([]string{"Client Code", "customer number"}, currentFieldConfig())
+	if got := suggestions.Suggestions[1]; got.Column != "customer number" || got.MatchedBy != "alias" {
+		t.Errorf("Expected Customer_ID to be suggested by alias, got %+v", got)
+	}
+	if got := suggestions.Suggestions[0]; got.MatchedBy != "name" {
+		t.Errorf("Expected Client_Code to be suggested by name, got %+v", got)
+	}
+
+	invalid := &config.FieldConfig{Fields: []config.Field{
+		{Name: "A", DisplayName: "A", Aliases: []string{"Code"}},
+		{Name: "B", DisplayName: "B", Aliases: []string{"code"}},
+	}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected an alias shared by two fields to be rejected")
+	}
+	// Aliases are matched ignoring punctuation, so these would match the same headers
+	invalid = &config.FieldConfig{Fields: []config.Field{
+		{Name: "A", DisplayName: "A", Aliases: []string{"Cust-ID"}},
+		{Name: "B", DisplayName: "B", Aliases: []string{"Cust ID"}},
+	}}
+	if err := invalid.Validate(); err == nil || !strings.Contains(err.Error(), "is also an alias of field") {
+		t.Errorf("Expected aliases differing only in punctuation to be rejected, got %v", err)
+	}
+	invalid = &config.FieldConfig{Fields: []config.Field{{Name: "A", DisplayName: "A", Aliases: []string{"--"}}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected an alias without letters or digits to be rejected")
+	}
+}
+
+// serverSentEvent is one event read from a text/event-stream body
+type serverSentEvent struct {
+	name string
+	data string
+}
+
+// readServerSentEvents splits a text/event-stream body into its events
+func readServerSentEvents(t *testing.T, body string) []serverSentEvent {
+	t.Helper()
+	var events []serverSentEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var event serverSentEvent
+		for _, line := range strings.Split(block, "\n") {
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event.name = name
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				event.data = data
+			} else {
+				t.Fatalf("Unexpected line %q in event stream", line)
+			}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestHandleAPIProcessStreamEvents(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	content := "Client Code,Customer ID,Account Number\nC1,1001,A1\nC2,,A2\nC3,1003,A3\n"
+	req := newAPIProcessRequest(t, "events.csv", content, map[string]string{
+		"mappings":     `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+		"outputFormat": "csv",
+	})
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcessStream).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", contentType)
+	}
+	if !rr.Flushed {
+		t.Error("Expected the events to be flushed")
+	}
+
+	events := readServerSentEvents(t, rr.Body.String())
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = event.name
+	}
+	if strings.Join(names, ",") != "rowError,progress,complete" {
+		t.Fatalf("Expected rowError, progress and complete events, got %v", names)
+	}
+
+	var failed missingRowDetail
+	if err := json.Unmarshal([]byte(events[0].data), &failed); err != nil || failed.Row != 3 || !slices.Equal(failed.MissingFields, []string{"Customer_ID"}) {
+		t.Errorf("Expected row 3 to fail on Customer_ID, got %s", events[0].data)
+	}
+	var progress ProgressEvent
+	if err := json.Unmarshal([]byte(events[1].data), &progress); err != nil || progress.RowsProcessed != 3 || progress.TotalRows != 3 {
+		t.Errorf("Expected all 3 rows to be reported processed, got %s", events[1].data)
+	}
+	var complete CompleteEvent
+	if err := json.Unmarshal([]byte(events[2].data), &complete); err != nil {
+		t.Fatalf("Invalid complete event %q: %v", events[2].data, err)
+	}
+	defer os.Remove(filepath.Join("./uploads", complete.OutputFilename))
+	defer os.Remove(filepath.Join("./uploads", strings.Replace(complete.OutputFilename, "processed_data", "missing_data", 1)))
+	if !strings.Contains(complete.Summary, "Successful Rows: 2") {
+		t.Errorf("Expected the summary in the complete event, got %q", complete.Summary)
+	}
+	download := httptest.NewRecorder()
+	handleDownload(download, httptest.NewRequest("GET", complete.DownloadURL, nil))
+	if download.Code != http.StatusOK || !strings.Contains(download.Body.String(), "C3||1003|||A3||") {
+		t.Errorf("Expected the download URL to serve the output, got %d: %s", download.Code, download.Body.String())
+	}
+
+	// Errors found before any event keep their status
+	req = newAPIProcessRequest(t, "events.csv", "Client Code\n", map[string]string{"mappings": `{"Client_Code":"Client Code"}`})
+	rr = httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPIProcessStream).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a file without data rows, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// flushRecorder notes how many records, counted by count, the body held at each flush
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	count     func(body string) int
+	flushedAt []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushedAt = append(r.flushedAt, r.count(r.Body.String()))
+	r.ResponseRecorder.Flush()
+}
+
+// TestStreamFlushRecords verifies flushRecords batches the flushes of streamed NDJSON and
+// Server-Sent Events without losing records, and always flushes the final event
+func TestStreamFlushRecords(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	var content strings.Builder
+	content.WriteString("Client Code,Customer ID,Account Number\n")
+	for i := 1; i <= 10; i++ {
+		customerID := ""
+		if i%2 == 0 {
+			customerID = strconv.Itoa(1000 + i)
+		}
+		fmt.Fprintf(&content, "C%d,%s,A%d\n", i, customerID, i)
+	}
+	mappings := `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`
+
+	req := newAPIProcessRequest(t, "batched.csv", content.String(), map[string]string{
+		"mappings":     mappings,
+		"outputFormat": "ndjson",
+		"outputScope":  "missing",
+		"flushRecords": "2",
+	})
+	rr := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), count: func(body string) int { return strings.Count(body, "\n") }}
+	auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if records := rr.count(rr.Body.String()); records != 5 {
+		t.Errorf("Expected all 5 missing records, got %d:\n%s", records, rr.Body.String())
+	}
+	if !slices.Equal(rr.flushedAt, []int{2, 4}) {
+		t.Errorf("Expected flushes after 2 and 4 records, got %v", rr.flushedAt)
+	}
+	os.Remove(filepath.Join("./uploads", strings.TrimSuffix(strings.TrimPrefix(rr.Header().Get("Content-Disposition"), `attachment; filename="`), `"`)))
+
+	req = newAPIProcessRequest(t, "batched.csv", content.String(), map[string]string{
+		"mappings":     mappings,
+		"outputFormat": "csv",
+		"flushRecords": "2",
+	})
+	rr = &flushRecorder{ResponseRecorder: httptest.NewRecorder(), count: func(body string) int { return strings.Count(body, "\n\n") }}
+	auth.RequireAPIKey(handleAPIProcessStream).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	events := readServerSentEvents(t, rr.Body.String())
+	if len(events) != 7 || events[6].name != "complete" {
+		t.Fatalf("Expected 5 rowError, a progress and a complete event, got %d events", len(events))
+	}
+	if !slices.Equal(rr.flushedAt, []int{2, 4, 6, 7}) {
+		t.Errorf("Expected flushes every 2 events and after the complete event, got %v", rr.flushedAt)
+	}
+	var complete CompleteEvent
+	if err := json.Unmarshal([]byte(events[6].data), &complete); err == nil {
+		os.Remove(filepath.Join("./uploads", complete.OutputFilename))
+		os.Remove(filepath.Join("./uploads", strings.Replace(complete.OutputFilename, "processed_data", "missing_data", 1)))
+	}
+}
+
+// cancelOnProgress cancels processing at the first progress event and records every event
+type cancelOnProgress struct {
+	cancel   context.CancelFunc
+	reported []int
+}
+
+func (c *cancelOnProgress) progress(done, total int) {
+	c.reported = append(c.reported, done)
+	c.cancel()
+}
+
+func (c *cancelOnProgress) rowFailed(missingRowDetail) {}
+
+func TestProgressEventsDuringMapping(t *testing.T) {
+	useTempFieldConfig(t, parallelTestConfig)
+	inputPath := writeTempCSV(t, parallelTestInput(3000))
+	fieldMappings := map[string]string{"Client_Code": "Client Code", "Country": "Country", "Postal_Code": "Postal Code"}
+
+	// Progress is reported while rows are mapped, so cancelling on the first event stops
+	// the rest of the file from being processed
+	for _, workers := range []int{1, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		events := &cancelOnProgress{cancel: cancel}
+		uniqueID := "test_" + generateUniqueID()
+		_, _, err := processFileWithOptions(ctx, inputPath, columnMappings(fieldMappings), currentFieldConfig().GetOrderedFields(), "csv", uniqueID, processOptions{rowWorkers: workers, events: events})
+		cancel()
+		outputPath, missingPath := outputFilePaths(uniqueID, "csv")
+		os.Remove(outputPath)
+		os.Remove(missingPath)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected processing with %d workers to be cancelled, got %v", workers, err)
+		}
+		if !slices.Equal(events.reported, []int{progressEventRows}) {
+			t.Errorf("Expected a single progress event at %d rows with %d workers, got %v", progressEventRows, workers, events.reported)
+		}
+	}
+}
+
+func TestInferColumnTypes(t *testing.T) {
+	rows := [][]string{
+		{"Amount", "Count", "Start Date", "Active", "Name", "Mostly Numbers", "Blank"},
+		{"12.50", "1", "2024-01-31", "yes", "Ann", "1", ""},
+		{"3", "2", "2024-02-29", "No", "Bob", "2", ""},
+		{"7.25", "", "2024-03-01", "TRUE", "Cy", "n/a", ""},
+	}
+	expected := []struct {
+		column, columnType string
+		confidence         float64
+	}{
+		{"Amount", "float", 1},
+		{"Count", "int", 1},
+		{"Start Date", "date", 1},
+		{"Active", "bool", 1},
+		{"Name", "string", 1},
+		{"Mostly Numbers", "string", 1},
+		{"Blank", "string", 0},
+	}
+	got := inferColumnTypes(rows, defaultSampleRows)
+	for i, want := range expected {
+		if got[i].Column != want.column || got[i].Type != want.columnType || got[i].Confidence != want.confidence {
+			t.Errorf("Expected %s to be %s with confidence %v, got %+v", want.column, want.columnType, want.confidence, got[i])
+		}
+	}
+	if got[1].Sampled != 2 {
+		t.Errorf("Expected empty values not to be sampled, got %+v", got[1])
+	}
+
+	// Only the first sampleRows data rows are looked at
+	if got := inferColumnTypes(rows, 2); got[5].Type != "int" {
+		t.Errorf("Expected Mostly Numbers to be int in the first two rows, got %+v", got[5])
+	}
+
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+	req := newAPIProcessRequest(t, "types.csv", "Client Code,Customer ID,Opened\nC1,1001,2024-01-31\nC2,1002,2024-02-01\n", nil)
+	rr := httptest.NewRecorder()
+	auth.RequireAPIKey(handleAPISuggestMappings).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response SuggestMappingsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.ColumnTypes) != 3 || response.ColumnTypes[1].Type != "int" || response.ColumnTypes[2].Type != "date" {
+		t.Errorf("Expected Customer ID to be int and Opened a date, got %+v", response.ColumnTypes)
+	}
+	for _, suggestion := range response.Suggestions {
+		if suggestion.Field == "Customer_ID" && suggestion.InferredType != "int" {
+			t.Errorf("Expected the Customer_ID suggestion to carry its column's type, got %+v", suggestion)
+		}
+	}
+}
+
+// sidecarSummary returns the full text summary from the summary sidecar named by a
+// process response
+func sidecarSummary(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	path := filepath.Join("./uploads", rr.Header().Get("X-Summary-File"))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the summary sidecar: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+	var report processingReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Invalid summary sidecar: %v", err)
+	}
+	return report.Summary
+}
+
+func TestSummaryHeaderDigest(t *testing.T) {
+	if err := InitConfig(); err != nil {
+		t.Fatalf("Failed to initialize config: %v", err)
+	}
+	auth.InitAPIKeys()
+
+	var content strings.Builder
+	content.WriteString("Client Code,Customer ID,Account Number\n")
+	for i := range 2000 {
+		fmt.Fprintf(&content, "C%d,,A%d\n", i, i)
+	}
+	content.WriteString("C2000,2000,A2000\n")
+	for _, outputFormat := range []string{"csv", "xlsx"} {
+		req := newAPIProcessRequest(t, "many-missing.csv", content.String(), map[string]string{
+			"mappings":       `{"Client_Code":"Client Code","Customer_ID":"Customer ID","Account_ID":"Account Number"}`,
+			"outputFormat":   outputFormat,
+			"summarySidecar": "true",
+		})
+		rr := httptest.NewRecorder()
+		auth.RequireAPIKey(handleAPIProcess).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		summary := rr.Header().Get("X-Processing-Summary")
+		if summary != "Total Rows Processed: 2001; Successful Rows: 1; Rows with Missing Data: 2000" {
+			t.Errorf("%s: expected a digest of the counts, got %q", outputFormat, summary)
+		}
+		for name, values := range rr.Header() {
+			for _, value := range values {
+				if strings.ContainsAny(value, "\r\n") {
+					t.Errorf("%s: header %s contains a line break: %q", outputFormat, name, value)
+				}
+			}
+		}
+		if full := sidecarSummary(t, rr); !strings.Contains(full, "Row 2001: Missing mandatory fields - Customer_ID") {
+			t.Errorf("%s: expected the full summary in the sidecar", outputFormat)
+		}
+	}
+
+	long := strings.Repeat("Some Count: 1\n", 100) + strings.Repeat("Another Count Label: 1\n", 100)
+	for i := range 100 {
+		long += fmt.Sprintf("Count Number %s: 1\n", strings.Repeat("X", i%26+1))
+	}
+	if digest := summaryDigest(long); len(digest) > maxSummaryDigestLength || !strings.HasPrefix(digest, "Some Count: 100; Another Count Label: 100") {
+		t.Errorf("Expected repeated counts to be added up within %d characters, got %d: %q", maxSummaryDigestLength, len(digest), digest)
+	}
+}
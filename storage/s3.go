@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Sink uploads outputs to an S3-compatible bucket using path-style requests signed with
+// AWS Signature Version 4, then removes the local copy
+type S3Sink struct {
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every object key, e.g. "excel-mapper/"
+	Prefix string
+	// URLExpiry is how long the returned download URLs stay valid
+	URLExpiry time.Duration
+	// Client is used for uploads; http.DefaultClient if nil
+	Client *http.Client
+}
+
+// Remote is true: stored files are removed from local disk
+func (s *S3Sink) Remote() bool { return true }
+
+// Store uploads the file, deletes the local copy and returns its key and a signed GET URL
+func (s *S3Sink) Store(ctx context.Context, localPath string) (Location, error) {
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return Location{}, fmt.Errorf("error reading output for upload: %w", err)
+	}
+	key := s.Prefix + filepath.Base(localPath)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(content))
+	if err != nil {
+		return Location{}, err
+	}
+	s.sign(req, content)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Location{}, fmt.Errorf("error uploading %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Location{}, fmt.Errorf("error uploading %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	os.Remove(localPath)
+	return Location{Key: key, URL: s.presignGet(key)}, nil
+}
+
+// objectURL returns the path-style URL of key
+func (s *S3Sink) objectURL(key string) string {
+	return s.Endpoint + "/" + uriEncode(s.Bucket, false) + "/" + uriEncode(key, false)
+}
+
+// credentialScope returns the date-bound scope of a signature
+func (s *S3Sink) credentialScope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+// sign adds Signature Version 4 headers for an upload of payload to req
+func (s *S3Sink) sign(req *http.Request, payload []byte) {
+	t := time.Now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), "", canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := s.credentialScope(t)
+	signature := s.signature(t, amzDate, scope, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// presignGet returns a query-signed GET URL for key valid for URLExpiry
+func (s *S3Sink) presignGet(key string) string {
+	t := time.Now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	scope := s.credentialScope(t)
+	objectURL, _ := url.Parse(s.objectURL(key))
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    s.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(s.URLExpiry.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	canonicalQuery := canonicalQueryString(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, objectURL.EscapedPath(), canonicalQuery, "host:" + objectURL.Host + "\n", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	signature := s.signature(t, amzDate, scope, canonicalRequest)
+	return objectURL.String() + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+// signature computes the Signature Version 4 signature of a canonical request
+func (s *S3Sink) signature(t time.Time, amzDate, scope, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQueryString encodes query parameters sorted by name as Signature Version 4 requires
+func canonicalQueryString(query map[string]string) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = uriEncode(name, true) + "=" + uriEncode(query[name], true)
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but RFC 3986 unreserved characters. Slashes are
+// kept unless encodeSlash is set.
+func uriEncode(value string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Location identifies a stored output file
+type Location struct {
+	// Key is the object key, or for local storage the file name under ./uploads
+	Key string
+	// URL is a signed download URL for remote storage; empty for local storage
+	URL string
+}
+
+// OutputSink stores a finished output file and reports where clients can fetch it
+type OutputSink interface {
+	Store(ctx context.Context, localPath string) (Location, error)
+	// Remote reports whether stored files leave local disk
+	Remote() bool
+}
+
+// LocalSink keeps outputs on local disk, where they are served by /download
+type LocalSink struct{}
+
+// Store leaves the file in place and returns its name
+func (LocalSink) Store(ctx context.Context, localPath string) (Location, error) {
+	return Location{Key: filepath.Base(localPath)}, nil
+}
+
+// Remote is false: local outputs stay on disk
+func (LocalSink) Remote() bool { return false }
+
+// DefaultURLExpiry is how long signed download URLs stay valid when S3_URL_EXPIRY is not set
+const DefaultURLExpiry = 15 * time.Minute
+
+// NewSinkFromEnv returns the sink selected by OUTPUT_SINK: "local" (the default) or "s3".
+// The S3 sink is configured by S3_ENDPOINT, S3_BUCKET, S3_REGION, S3_ACCESS_KEY_ID,
+// S3_SECRET_ACCESS_KEY and optionally S3_PREFIX and S3_URL_EXPIRY.
+func NewSinkFromEnv() (OutputSink, error) {
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("OUTPUT_SINK"))); kind {
+	case "", "local":
+		return LocalSink{}, nil
+	case "s3":
+		sink := &S3Sink{
+			Endpoint:        strings.TrimRight(os.Getenv("S3_ENDPOINT"), "/"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Region:          os.Getenv("S3_REGION"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Prefix:          os.Getenv("S3_PREFIX"),
+			URLExpiry:       DefaultURLExpiry,
+		}
+		if sink.Region == "" {
+			sink.Region = "us-east-1"
+		}
+		if value := strings.TrimSpace(os.Getenv("S3_URL_EXPIRY")); value != "" {
+			expiry, err := time.ParseDuration(value)
+			if err != nil || expiry <= 0 || expiry > 7*24*time.Hour {
+				return nil, fmt.Errorf("invalid S3_URL_EXPIRY value %q: must be a positive duration of at most 168h", value)
+			}
+			sink.URLExpiry = expiry
+		}
+		if sink.Endpoint == "" || sink.Bucket == "" || sink.AccessKeyID == "" || sink.SecretAccessKey == "" {
+			return nil, fmt.Errorf("OUTPUT_SINK=s3 requires S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+		}
+		return sink, nil
+	default:
+		return nil, fmt.Errorf("invalid OUTPUT_SINK %q: must be local or s3", kind)
+	}
+}
@@ -1,7 +1,7 @@
 // Global variables to store configuration
 let fieldConfig = {
     fields: [],
-    mandatoryFields: []
+    orderedFields: []
 };
 
 // Load configuration when the page loads
@@ -12,7 +12,7 @@ document.addEventListener('DOMContentLoaded', async () => {
 
 async function loadConfiguration() {
     try {
-        const response = await fetch('/config');
+        const response = await fetch('/config/ui');
         if (!response.ok) {
             throw new Error('Failed to load configuration');
         }
@@ -58,6 +58,11 @@ function showMappingUI(headers) {
     mappingContainer.innerHTML = '';
 
     fieldConfig.fields.forEach(field => {
+        // Lookup fields are filled from their source field and need no mapping
+        if (field.lookupSource) {
+            return;
+        }
+
         const div = document.createElement('div');
         div.classList.add('mb-3');
 
@@ -118,7 +123,10 @@ function handleSubmit(e) {
     })
     .then(response => {
         if (!response.ok) {
-            throw new Error('Network response was not ok');
+            // Surface validation errors such as header-only files to the user
+            return response.text().then(text => {
+                throw new Error(text.trim() || 'Network response was not ok');
+            });
         }
         // Check if response is JSON
         const contentType = response.headers.get('content-type');
@@ -141,7 +149,7 @@ function handleSubmit(e) {
     })
     .catch(error => {
         console.error('Error:', error);
-        alert('An error occurred during the upload. Please try again. Check console for details.');
+        alert('An error occurred during the upload: ' + error.message);
     });
 }
 
@@ -161,13 +169,14 @@ function handleUploadSummary(data) {
     summaryContent.textContent = formattedSummary;
 
     // Use actual filenames from server response
-    downloadProcessedLink.href = '/download?file=' + encodeURIComponent(data.outputFilename);
+    // Outputs kept in remote storage come with signed URLs instead
+    downloadProcessedLink.href = data.outputURL || '/download?file=' + encodeURIComponent(data.outputFilename);
     downloadProcessedLink.download = data.outputFilename;
     downloadProcessedLink.classList.remove('d-none');
 
     // Show missing data link if filename is provided
     if (data.missingFilename) {
-        downloadMissingLink.href = '/download?file=' + encodeURIComponent(data.missingFilename);
+        downloadMissingLink.href = data.missingURL || '/download?file=' + encodeURIComponent(data.missingFilename);
         downloadMissingLink.download = data.missingFilename;
         downloadMissingLink.classList.remove('d-none');
     } else {